@@ -0,0 +1,123 @@
+package aggregate
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/paulmach/orb"
+)
+
+// Cluster shapes
+const (
+	ModeGrid = "grid"
+	ModeHex  = "hex"
+)
+
+// Breakdown counts one layer's points in a cluster
+type Breakdown struct {
+	Total      int            `json:"total"`
+	ByStatus   map[string]int `json:"byStatus"`
+	ByPriority map[string]int `json:"byPriority,omitempty"`
+}
+
+func (b *Breakdown) add(status, priority string, n int) {
+	if b.ByStatus == nil {
+		b.ByStatus = map[string]int{}
+	}
+	b.Total += n
+	b.ByStatus[status] += n
+	if priority != "" {
+		if b.ByPriority == nil {
+			b.ByPriority = map[string]int{}
+		}
+		b.ByPriority[priority] += n
+	}
+}
+
+// Cluster is a group of nearby points placed at their mean position
+type Cluster struct {
+	ID            string     `json:"id"`
+	Lat           float64    `json:"lat"`
+	Lng           float64    `json:"lng"`
+	Count         int        `json:"count"`
+	Constructions *Breakdown `json:"constructions,omitempty"`
+	Reports       *Breakdown `json:"reports,omitempty"`
+
+	sumLat, sumLng float64
+}
+
+// Clusters groups the points in b into cells roughly radius pixels wide at
+// the given zoom, as squares (ModeGrid) or hexagons (ModeHex)
+func Clusters(b orb.Bound, zoom int, radius float64, mode string, f Filter) ([]*Cluster, error) {
+	cellLat, cellLng := cellDegrees(b, zoom, radius)
+
+	// Fetch at a quarter of the cluster size so means stay accurate and hexagon
+	// edges are resolved finely enough
+	buckets, err := fetchBuckets(b, orb.Point{}, cellLat/4, cellLng/4, f)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[string]*Cluster{}
+	for _, bk := range buckets {
+		x, y := bk.Lng/cellLng, bk.Lat/cellLat
+		var id string
+		if mode == ModeHex {
+			q, r := hexCell(x, y)
+			id = fmt.Sprintf("h:%d:%d:%d", zoom, q, r)
+		} else {
+			id = fmt.Sprintf("g:%d:%d:%d", zoom, int(math.Floor(x)), int(math.Floor(y)))
+		}
+
+		c := byID[id]
+		if c == nil {
+			c = &Cluster{ID: id}
+			byID[id] = c
+		}
+		c.Count += bk.Count
+		c.sumLat += bk.Lat * float64(bk.Count)
+		c.sumLng += bk.Lng * float64(bk.Count)
+		switch bk.Layer {
+		case LayerConstructions:
+			if c.Constructions == nil {
+				c.Constructions = &Breakdown{}
+			}
+			c.Constructions.add(bk.Status, "", bk.Count)
+		case LayerReports:
+			if c.Reports == nil {
+				c.Reports = &Breakdown{}
+			}
+			c.Reports.add(bk.Status, bk.Priority, bk.Count)
+		}
+	}
+
+	clusters := make([]*Cluster, 0, len(byID))
+	for _, c := range byID {
+		c.Lat = c.sumLat / float64(c.Count)
+		c.Lng = c.sumLng / float64(c.Count)
+		clusters = append(clusters, c)
+	}
+	sort.Slice(clusters, func(i, j int) bool { return clusters[i].Count > clusters[j].Count })
+	return clusters, nil
+}
+
+// hexCell returns the axial coordinates of the pointy-top hexagon of unit
+// width containing (x, y)
+func hexCell(x, y float64) (int, int) {
+	size := 1 / math.Sqrt(3)
+	q := (math.Sqrt(3)/3*x - y/3) / size
+	r := (2.0 / 3 * y) / size
+
+	// cube rounding
+	cx, cz := q, r
+	cy := -cx - cz
+	rx, ry, rz := math.Round(cx), math.Round(cy), math.Round(cz)
+	dx, dy, dz := math.Abs(rx-cx), math.Abs(ry-cy), math.Abs(rz-cz)
+	if dx > dy && dx > dz {
+		rx = -ry - rz
+	} else if dy <= dz {
+		rz = -rx - ry
+	}
+	return int(rx), int(rz)
+}
@@ -0,0 +1,78 @@
+package aggregate
+
+import (
+	"math"
+
+	"github.com/paulmach/orb"
+)
+
+// Heatmap is a density grid over a bounding box; Values[row][col] counts the
+// points in each cell, with row 0 at the southern edge
+type Heatmap struct {
+	BBox    [4]float64 `json:"bbox"` // minLng, minLat, maxLng, maxLat
+	Rows    int        `json:"rows"`
+	Cols    int        `json:"cols"`
+	CellLat float64    `json:"cellLat"`
+	CellLng float64    `json:"cellLng"`
+	Max     int        `json:"max"`
+	Total   int        `json:"total"`
+	Values  [][]int    `json:"values"`
+}
+
+// maxRows bounds the grid height for very tall boxes
+const maxRows = 512
+
+// BuildHeatmap counts points in a grid of at most cells columns over b
+func BuildHeatmap(b orb.Bound, cells int, f Filter) (*Heatmap, error) {
+	width := b.Max.Lon() - b.Min.Lon()
+	height := b.Max.Lat() - b.Min.Lat()
+	cellLng := width / float64(cells)
+	// keep cells roughly square on the ground
+	cellLat := cellLng * math.Cos(b.Center().Lat()*math.Pi/180)
+	rows := int(math.Ceil(height / cellLat))
+	if rows < 1 {
+		rows = 1
+	}
+	if rows > maxRows {
+		rows = maxRows
+		cellLat = height / float64(rows)
+	}
+
+	h := &Heatmap{
+		BBox:    [4]float64{b.Min.Lon(), b.Min.Lat(), b.Max.Lon(), b.Max.Lat()},
+		Rows:    rows,
+		Cols:    cells,
+		CellLat: cellLat,
+		CellLng: cellLng,
+		Values:  make([][]int, rows),
+	}
+	for i := range h.Values {
+		h.Values[i] = make([]int, cells)
+	}
+
+	// the database groups into a grid of half cells with the same origin, so
+	// each bucket lies wholly inside one cell
+	buckets, err := fetchBuckets(b, b.Min, cellLat/2, cellLng/2, f)
+	if err != nil {
+		return nil, err
+	}
+	for _, bk := range buckets {
+		row, col := bk.GY/2, bk.GX/2
+		// points on the northern or eastern edge belong to the last cell
+		if row == rows {
+			row--
+		}
+		if col == cells {
+			col--
+		}
+		if row < 0 || row >= rows || col < 0 || col >= cells {
+			continue
+		}
+		h.Values[row][col] += bk.Count
+		h.Total += bk.Count
+		if h.Values[row][col] > h.Max {
+			h.Max = h.Values[row][col]
+		}
+	}
+	return h, nil
+}
@@ -0,0 +1,341 @@
+package aggregate
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"time"
+
+	"backend/geo"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/paulmach/orb"
+	"gorm.io/gorm"
+)
+
+// HotspotParams configures a Getis-Ord Gi* hotspot analysis
+type HotspotParams struct {
+	From, To  time.Time
+	Bound     *orb.Bound // nil analyses the extent of the data
+	CellSize  float64    // metres
+	MinZScore float64    // 1.96 ≈ 95% confidence
+	Filter    Filter
+}
+
+// SourceCount is how many points of a hotspot came from one detection source
+type SourceCount struct {
+	Source string `json:"source"`
+	Count  int    `json:"count"`
+}
+
+// Hotspot is a connected group of grid cells with significantly high counts
+type Hotspot struct {
+	ID            int              `json:"id"`
+	Shape         orb.MultiPolygon `json:"-"`
+	Geometry      interface{}      `json:"geometry"` // GeoJSON multipolygon of the significant cells
+	Center        [2]float64       `json:"center"`   // lat, lng
+	Cells         int              `json:"cells"`
+	Count         int              `json:"count"`         // points in the window
+	PreviousCount int              `json:"previousCount"` // points in the preceding window of equal length
+	GrowthRate    *float64         `json:"growthRate"`    // (count - previous) / previous; null when previous is 0
+	MaxZScore     float64          `json:"maxZScore"`
+	TopSources    []SourceCount    `json:"topSources"`
+	sources       map[string]int
+}
+
+// Errors for analyses that cannot run with the given parameters
+var (
+	ErrEmptyWindow  = errors.New("time window is empty")
+	ErrAreaTooLarge = errors.New("area too large for the cell size; use a larger cell or a smaller bbox")
+)
+
+// maxCells bounds the analysis grid; larger areas use coarser cells
+const maxCells = 250000
+
+type cellKey struct{ x, y int }
+
+type cellStats struct {
+	current, previous int
+	sources           map[string]int
+}
+
+// hotspotRow is one database group: a cell, source and window
+type hotspotRow struct {
+	X       int `gorm:"column:gx"`
+	Y       int `gorm:"column:gy"`
+	Source  string
+	Current bool `gorm:"column:in_window"`
+	Count   int
+}
+
+// Hotspots runs a grid-based Getis-Ord Gi* analysis over constructions and
+// reports created in [From, To] and returns the significant clusters
+func Hotspots(p HotspotParams) ([]*Hotspot, error) {
+	if !p.To.After(p.From) {
+		return nil, ErrEmptyWindow
+	}
+	prevFrom := p.From.Add(-p.To.Sub(p.From))
+
+	bound := p.Bound
+	if bound == nil {
+		b, ok, err := dataExtent(prevFrom, p.To, p.Filter)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return []*Hotspot{}, nil
+		}
+		bound = &b
+	}
+
+	cellLat := p.CellSize / 110540.0
+	cellLng := p.CellSize / (111320.0 * math.Cos(bound.Center().Lat()*math.Pi/180))
+	minX, minY := int(math.Floor(bound.Min.Lon()/cellLng)), int(math.Floor(bound.Min.Lat()/cellLat))
+	maxX, maxY := int(math.Floor(bound.Max.Lon()/cellLng)), int(math.Floor(bound.Max.Lat()/cellLat))
+	if n := (maxX - minX + 1) * (maxY - minY + 1); n > maxCells {
+		return nil, ErrAreaTooLarge
+	}
+
+	rows, err := fetchHotspotRows(*bound, cellLat, cellLng, prevFrom, p.From, p.To, p.Filter)
+	if err != nil {
+		return nil, err
+	}
+	cells := map[cellKey]*cellStats{}
+	for _, r := range rows {
+		k := cellKey{r.X, r.Y}
+		c := cells[k]
+		if c == nil {
+			c = &cellStats{sources: map[string]int{}}
+			cells[k] = c
+		}
+		if r.Current {
+			c.current += r.Count
+			c.sources[r.Source] += r.Count
+		} else {
+			c.previous += r.Count
+		}
+	}
+
+	z := giStar(cells, minX, minY, maxX, maxY)
+
+	// flood fill significant cells into connected hotspots
+	visited := map[cellKey]bool{}
+	var hotspots []*Hotspot
+	for k, score := range z {
+		if score < p.MinZScore || visited[k] {
+			continue
+		}
+		h := &Hotspot{ID: len(hotspots) + 1, sources: map[string]int{}}
+		stack := []cellKey{k}
+		visited[k] = true
+		for len(stack) > 0 {
+			cur := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			h.Cells++
+			if z[cur] > h.MaxZScore {
+				h.MaxZScore = z[cur]
+			}
+			if c := cells[cur]; c != nil {
+				h.Count += c.current
+				h.PreviousCount += c.previous
+				for s, n := range c.sources {
+					h.sources[s] += n
+				}
+			}
+			h.Shape = append(h.Shape, cellPolygon(cur, cellLat, cellLng, *bound))
+
+			for dx := -1; dx <= 1; dx++ {
+				for dy := -1; dy <= 1; dy++ {
+					n := cellKey{cur.x + dx, cur.y + dy}
+					if score, ok := z[n]; ok && !visited[n] && score >= p.MinZScore {
+						visited[n] = true
+						stack = append(stack, n)
+					}
+				}
+			}
+		}
+		if h.Count == 0 {
+			continue // only the neighbours had points in the window
+		}
+
+		h.Geometry = map[string]interface{}{"type": "MultiPolygon", "coordinates": h.Shape}
+		center := h.Shape.Bound().Center()
+		h.Center = [2]float64{center.Lat(), center.Lon()}
+		if h.PreviousCount > 0 {
+			rate := float64(h.Count-h.PreviousCount) / float64(h.PreviousCount)
+			h.GrowthRate = &rate
+		}
+		for s, n := range h.sources {
+			h.TopSources = append(h.TopSources, SourceCount{Source: s, Count: n})
+		}
+		sort.Slice(h.TopSources, func(i, j int) bool { return h.TopSources[i].Count > h.TopSources[j].Count })
+		if len(h.TopSources) > 3 {
+			h.TopSources = h.TopSources[:3]
+		}
+		hotspots = append(hotspots, h)
+	}
+
+	sort.Slice(hotspots, func(i, j int) bool { return hotspots[i].MaxZScore > hotspots[j].MaxZScore })
+	for i, h := range hotspots {
+		h.ID = i + 1
+	}
+	return hotspots, nil
+}
+
+// giStar computes the Gi* z-score of every cell of the grid [minX, maxX] x
+// [minY, maxY] that has points nearby, using binary queen-contiguity weights
+// (the cell and its eight neighbours)
+func giStar(cells map[cellKey]*cellStats, minX, minY, maxX, maxY int) map[cellKey]float64 {
+	n := float64((maxX - minX + 1) * (maxY - minY + 1))
+	sum, sumSq := 0.0, 0.0
+	for _, c := range cells {
+		x := float64(c.current)
+		sum += x
+		sumSq += x * x
+	}
+	mean := sum / n
+	s := math.Sqrt(sumSq/n - mean*mean)
+
+	z := map[cellKey]float64{}
+	if s == 0 || n < 2 {
+		return z
+	}
+	for k := range cells {
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				nx, ny := k.x+dx, k.y+dy
+				if nx < minX || nx > maxX || ny < minY || ny > maxY {
+					continue // outside the analysed area
+				}
+				z[cellKey{nx, ny}] = 0
+			}
+		}
+	}
+	for k := range z {
+		local, w := 0.0, 0.0
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				nx, ny := k.x+dx, k.y+dy
+				if nx < minX || nx > maxX || ny < minY || ny > maxY {
+					continue
+				}
+				w++
+				if c := cells[cellKey{nx, ny}]; c != nil {
+					local += float64(c.current)
+				}
+			}
+		}
+		denom := s * math.Sqrt((n*w-w*w)/(n-1))
+		if denom == 0 {
+			delete(z, k)
+			continue
+		}
+		z[k] = (local - mean*w) / denom
+	}
+	return z
+}
+
+// fetchHotspotRows counts points per cell, source and window in the database
+func fetchHotspotRows(b orb.Bound, cellLat, cellLng float64, prevFrom, from, to time.Time, f Filter) ([]hotspotRow, error) {
+	var out []hotspotRow
+	scan := func(q *gorm.DB, latExpr, lngExpr, sourceExpr string) error {
+		var rows []hotspotRow
+		err := geo.WithinBound(q, latExpr, lngExpr, b).
+			Where("created_at BETWEEN ? AND ?", prevFrom, to).
+			Select("FLOOR("+lngExpr+" / ?) AS gx, FLOOR("+latExpr+" / ?) AS gy, "+sourceExpr+" AS source, created_at >= ? AS in_window, COUNT(*) AS count",
+				cellLng, cellLat, from).
+			Group("gx, gy, source, in_window").
+			Scan(&rows).Error
+		out = append(out, rows...)
+		return err
+	}
+
+	if f.wants(LayerConstructions) && len(f.Priorities) == 0 {
+		if err := scan(f.constructions(), "latitude", "longitude", "COALESCE(NULLIF(detection_source, ''), 'unknown')"); err != nil {
+			return nil, err
+		}
+	}
+	if f.wants(LayerReports) {
+		if err := scan(f.reports(), geo.ReportLatSQL, geo.ReportLngSQL, "'citizen_report'"); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// constructions queries the constructions the filter keeps
+func (f Filter) constructions() *gorm.DB {
+	q := utils.DB.Model(&models.Construction{})
+	if len(f.Statuses) > 0 {
+		q = q.Where("status IN ?", f.Statuses)
+	}
+	return q
+}
+
+// reports queries the reports the filter keeps
+func (f Filter) reports() *gorm.DB {
+	q := utils.DB.Model(&models.Report{})
+	if len(f.Statuses) > 0 {
+		q = q.Where("status IN ?", f.Statuses)
+	}
+	if len(f.Priorities) > 0 {
+		q = q.Where("priority IN ?", f.Priorities)
+	}
+	return q
+}
+
+// dataExtent returns the bounding box of the points the filter keeps in the
+// window
+func dataExtent(from, to time.Time, f Filter) (orb.Bound, bool, error) {
+	type extent struct {
+		MinLat, MinLng, MaxLat, MaxLng *float64
+	}
+	var b orb.Bound
+	found := false
+	merge := func(e extent) {
+		if e.MinLat == nil || e.MinLng == nil || e.MaxLat == nil || e.MaxLng == nil {
+			return
+		}
+		eb := orb.Bound{Min: orb.Point{*e.MinLng, *e.MinLat}, Max: orb.Point{*e.MaxLng, *e.MaxLat}}
+		if found {
+			b = b.Union(eb)
+		} else {
+			b, found = eb, true
+		}
+	}
+
+	if f.wants(LayerConstructions) && len(f.Priorities) == 0 {
+		var e extent
+		err := f.constructions().
+			Where("created_at BETWEEN ? AND ? AND NOT (latitude = 0 AND longitude = 0)", from, to).
+			Select("MIN(latitude) AS min_lat, MIN(longitude) AS min_lng, MAX(latitude) AS max_lat, MAX(longitude) AS max_lng").
+			Scan(&e).Error
+		if err != nil {
+			return b, false, err
+		}
+		merge(e)
+	}
+	if f.wants(LayerReports) {
+		var e extent
+		err := f.reports().
+			Where("created_at BETWEEN ? AND ?", from, to).
+			Select("MIN(" + geo.ReportLatSQL + ") AS min_lat, MIN(" + geo.ReportLngSQL + ") AS min_lng, MAX(" + geo.ReportLatSQL + ") AS max_lat, MAX(" + geo.ReportLngSQL + ") AS max_lng").
+			Scan(&e).Error
+		if err != nil {
+			return b, false, err
+		}
+		merge(e)
+	}
+	return b, found, nil
+}
+
+// cellPolygon returns the square of a grid cell, clipped to b
+func cellPolygon(k cellKey, cellLat, cellLng float64, b orb.Bound) orb.Polygon {
+	minLng, minLat := math.Max(float64(k.x)*cellLng, b.Min.Lon()), math.Max(float64(k.y)*cellLat, b.Min.Lat())
+	maxLng, maxLat := math.Min(float64(k.x+1)*cellLng, b.Max.Lon()), math.Min(float64(k.y+1)*cellLat, b.Max.Lat())
+	return orb.Polygon{{
+		{minLng, minLat}, {maxLng, minLat}, {maxLng, maxLat}, {minLng, maxLat}, {minLng, minLat},
+	}}
+}
@@ -0,0 +1,89 @@
+package aggregate
+
+import (
+	"math"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+func counts(values map[cellKey]int) map[cellKey]*cellStats {
+	cells := map[cellKey]*cellStats{}
+	for k, v := range values {
+		cells[k] = &cellStats{current: v}
+	}
+	return cells
+}
+
+func TestGiStarMatchesHandComputedScore(t *testing.T) {
+	// a 3x3 grid with 9 points in the middle cell: mean 1, s = √8. A corner
+	// has 4 neighbours (itself included) summing to 9, so
+	// z = (9 - 4) / (√8 · √((9·4 - 16) / 8)) = 5 / √20
+	z := giStar(counts(map[cellKey]int{{1, 1}: 9}), 0, 0, 2, 2)
+	want := 5 / math.Sqrt(20)
+	if got := z[cellKey{0, 0}]; math.Abs(got-want) > 1e-9 {
+		t.Errorf("corner z = %v, want %v", got, want)
+	}
+	// an edge cell has 6 neighbours: z = (9 - 6) / (√8 · √((54 - 36) / 8)) = 1 / √2
+	if got := z[cellKey{1, 0}]; math.Abs(got-1/math.Sqrt2) > 1e-9 {
+		t.Errorf("edge z = %v, want %v", got, 1/math.Sqrt2)
+	}
+	// the middle cell sees the whole grid, so its score is undefined
+	if _, ok := z[cellKey{1, 1}]; ok {
+		t.Errorf("middle cell scored %v, want no score", z[cellKey{1, 1}])
+	}
+}
+
+func TestGiStarFindsCluster(t *testing.T) {
+	values := map[cellKey]int{}
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 20; y++ {
+			values[cellKey{x, y}] = 1
+		}
+	}
+	for x := 9; x <= 11; x++ {
+		for y := 9; y <= 11; y++ {
+			values[cellKey{x, y}] = 20
+		}
+	}
+	z := giStar(counts(values), 0, 0, 19, 19)
+	if got := z[cellKey{10, 10}]; got < 1.96 {
+		t.Errorf("cluster centre z = %v, want significant", got)
+	}
+	if got := z[cellKey{2, 2}]; got >= 0 {
+		t.Errorf("background z = %v, want below zero", got)
+	}
+}
+
+func TestGiStarUniformHasNoScores(t *testing.T) {
+	values := map[cellKey]int{}
+	for x := 0; x < 5; x++ {
+		for y := 0; y < 5; y++ {
+			values[cellKey{x, y}] = 3
+		}
+	}
+	if z := giStar(counts(values), 0, 0, 4, 4); len(z) != 0 {
+		t.Errorf("uniform grid scored %d cells, want none", len(z))
+	}
+}
+
+func TestGiStarScoresOnlyCellsInsideGrid(t *testing.T) {
+	z := giStar(counts(map[cellKey]int{{0, 0}: 5, {4, 4}: 1}), 0, 0, 4, 4)
+	if len(z) == 0 {
+		t.Fatal("no cells scored")
+	}
+	for k := range z {
+		if k.x < 0 || k.x > 4 || k.y < 0 || k.y > 4 {
+			t.Errorf("cell %v outside the grid was scored", k)
+		}
+	}
+}
+
+func TestCellPolygonIsClippedToBound(t *testing.T) {
+	b := orb.Bound{Min: orb.Point{0.25, 0.5}, Max: orb.Point{10, 10}}
+	got := cellPolygon(cellKey{0, 0}, 1, 1, b)
+	want := orb.Ring{{0.25, 0.5}, {1, 0.5}, {1, 1}, {0.25, 1}, {0.25, 0.5}}
+	if len(got) != 1 || !got[0].Equal(want) {
+		t.Errorf("cell polygon = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,116 @@
+package aggregate
+
+import (
+	"math"
+
+	"backend/geo"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/paulmach/orb"
+	"gorm.io/gorm"
+)
+
+// Layers that can be aggregated
+const (
+	LayerConstructions = "constructions"
+	LayerReports       = "reports"
+)
+
+// Filter restricts the points that are counted
+type Filter struct {
+	Layers     []string // defaults to constructions and reports
+	Statuses   []string
+	Priorities []string // reports only
+}
+
+func (f Filter) wants(layer string) bool {
+	if len(f.Layers) == 0 {
+		return true
+	}
+	for _, l := range f.Layers {
+		if l == layer {
+			return true
+		}
+	}
+	return false
+}
+
+// bucket is a pre-aggregated group of points returned by the database: all
+// points of one layer, status and priority within one fine grid cell
+type bucket struct {
+	Layer    string
+	Status   string
+	Priority string
+	GY, GX   int // cell row and column counted from the grid origin
+	Count    int
+	Lat      float64
+	Lng      float64
+}
+
+// fetchBuckets groups the points inside b into cells of the given size in
+// degrees, with cell edges on the lines through origin, so the database never
+// returns more rows than there are cells
+func fetchBuckets(b orb.Bound, origin orb.Point, cellLat, cellLng float64, f Filter) ([]bucket, error) {
+	var out []bucket
+
+	if f.wants(LayerConstructions) && len(f.Priorities) == 0 {
+		q := geo.WithinBound(utils.DB.Model(&models.Construction{}), "latitude", "longitude", b)
+		if len(f.Statuses) > 0 {
+			q = q.Where("status IN ?", f.Statuses)
+		}
+		rows, err := scanBuckets(q, "latitude", "longitude", false, origin, cellLat, cellLng)
+		if err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			rows[i].Layer = LayerConstructions
+		}
+		out = append(out, rows...)
+	}
+
+	if f.wants(LayerReports) {
+		q := geo.WithinBound(utils.DB.Model(&models.Report{}), geo.ReportLatSQL, geo.ReportLngSQL, b)
+		if len(f.Statuses) > 0 {
+			q = q.Where("status IN ?", f.Statuses)
+		}
+		if len(f.Priorities) > 0 {
+			q = q.Where("priority IN ?", f.Priorities)
+		}
+		rows, err := scanBuckets(q, geo.ReportLatSQL, geo.ReportLngSQL, true, origin, cellLat, cellLng)
+		if err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			rows[i].Layer = LayerReports
+		}
+		out = append(out, rows...)
+	}
+	return out, nil
+}
+
+// scanBuckets groups q by fine cell and status, and by priority when the
+// table has one
+func scanBuckets(q *gorm.DB, latExpr, lngExpr string, withPriority bool, origin orb.Point, cellLat, cellLng float64) ([]bucket, error) {
+	priority, group := "'' AS priority", "gy, gx, status"
+	if withPriority {
+		priority, group = "priority", group+", priority"
+	}
+
+	var rows []bucket
+	err := q.Select(
+		"FLOOR(("+latExpr+" - ?) / ?) AS gy, FLOOR(("+lngExpr+" - ?) / ?) AS gx, status, "+priority+", "+
+			"COUNT(*) AS count, AVG("+latExpr+") AS lat, AVG("+lngExpr+") AS lng",
+		origin.Lat(), cellLat, origin.Lon(), cellLng,
+	).Group(group).Scan(&rows).Error
+	return rows, err
+}
+
+// cellDegrees converts a size in screen pixels at a zoom level to degrees of
+// longitude and latitude around the centre of b
+func cellDegrees(b orb.Bound, zoom int, pixels float64) (float64, float64) {
+	worldPixels := 256 * math.Pow(2, float64(zoom))
+	lng := pixels * 360 / worldPixels
+	lat := lng * math.Cos(b.Center().Lat()*math.Pi/180)
+	return lat, lng
+}
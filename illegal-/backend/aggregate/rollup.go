@@ -0,0 +1,87 @@
+package aggregate
+
+import (
+	"time"
+
+	"backend/models"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// Rollup metrics
+const (
+	MetricReports       = "reports"       // reports submitted
+	MetricConstructions = "constructions" // constructions recorded
+	MetricDetections    = "detections"    // automatic detections
+	MetricComplaints    = "complaints"    // complaints filed
+)
+
+// rollupSources maps each metric to its table; tables without a ward_id
+// column are counted under ward 0
+var rollupSources = []struct {
+	metric, table string
+	byWard        bool
+}{
+	{MetricReports, "reports", true},
+	{MetricConstructions, "constructions", true},
+	{MetricDetections, "detections", false},
+	{MetricComplaints, "complaints", false},
+}
+
+// Rollup recomputes the daily counts for the local calendar day containing
+// day, replacing any earlier rollup of it
+func Rollup(day time.Time) error {
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	end := start.AddDate(0, 0, 1)
+
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("day = ?", start).Delete(&models.DailyStat{}).Error; err != nil {
+			return err
+		}
+		var stats []models.DailyStat
+		for _, src := range rollupSources {
+			ward := "0"
+			if src.byWard {
+				ward = "COALESCE(ward_id, 0)"
+			}
+			var rows []struct {
+				WardID uint
+				Count  int
+			}
+			q := tx.Table(src.table).Select(ward+" AS ward_id, COUNT(*) AS count").
+				Where("created_at >= ? AND created_at < ?", start, end)
+			if src.table == "complaints" {
+				q = q.Where("deleted_at IS NULL")
+			}
+			if src.byWard {
+				q = q.Group(ward)
+			}
+			if err := q.Scan(&rows).Error; err != nil {
+				return err
+			}
+			for _, row := range rows {
+				if row.Count == 0 {
+					continue
+				}
+				stats = append(stats, models.DailyStat{Day: start, Metric: src.metric, WardID: row.WardID, Count: row.Count})
+			}
+		}
+		if len(stats) == 0 {
+			return nil
+		}
+		return tx.Create(&stats).Error
+	})
+}
+
+// DailyStats returns rolled-up counts of one metric between two YYYY-MM-DD
+// days inclusive, optionally for a single ward
+func DailyStats(metric, from, to string, wardID *uint) ([]models.DailyStat, error) {
+	q := utils.DB.Where("metric = ? AND day BETWEEN ? AND ?", metric, from, to).Order("day, ward_id")
+	if wardID != nil {
+		q = q.Where("ward_id = ?", *wardID)
+	}
+	var stats []models.DailyStat
+	err := q.Find(&stats).Error
+	return stats, err
+}
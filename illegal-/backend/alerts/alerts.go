@@ -0,0 +1,264 @@
+// Package alerts raises alerts from domain events. Admins configure rules,
+// each a set of conditions on one type of event; when an event meets all of
+// a rule's conditions an alert is raised, or joined to the open alert with
+// the same dedup key.
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"backend/jobs"
+	"backend/models"
+	"backend/utils"
+
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Alert statuses; only open alerts take further matches
+const (
+	StatusOpen     = "open"
+	StatusResolved = "resolved"
+)
+
+// Severities, from least to most urgent
+var Severities = []string{"low", "medium", "high", "critical"}
+
+// ValidSeverity reports whether s is one of Severities
+func ValidSeverity(s string) bool {
+	return severityRank(s) >= 0
+}
+
+func severityRank(s string) int {
+	for i, v := range Severities {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// Evaluate checks an event against every enabled rule for its type and
+// raises the alerts they call for. It returns the alerts raised or updated.
+func Evaluate(eventType string, id uint) ([]models.Alert, error) {
+	e, err := load(eventType, id)
+	if err != nil || e == nil {
+		return nil, err
+	}
+	var rules []models.AlertRule
+	if err := utils.DB.Where("event = ? AND enabled = ?", eventType, true).Order("id").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+
+	var raised []models.Alert
+	for _, rule := range rules {
+		conditions, err := ParseConditions(rule.Event, rule.Conditions)
+		if err != nil {
+			continue // rules are validated when saved; a condition type may have gone since
+		}
+		matches, err := matchAll(e, conditions)
+		if err != nil {
+			return raised, err
+		}
+		if matches == nil {
+			continue
+		}
+		alert, err := raise(rule, e, matches)
+		if err != nil {
+			return raised, err
+		}
+		raised = append(raised, *alert)
+	}
+	return raised, nil
+}
+
+// matchAll returns the matches of every condition, or nil when one fails
+func matchAll(e *Event, conditions []Condition) ([]Match, error) {
+	matches := make([]Match, 0, len(conditions))
+	for _, c := range conditions {
+		m, err := ConditionTypes[c.Type].Match(e, c)
+		if err != nil || m == nil {
+			return nil, err
+		}
+		matches = append(matches, *m)
+	}
+	return matches, nil
+}
+
+// dedupKey identifies what an alert is about: the group a count matched,
+// or else the event's own entity
+func dedupKey(rule models.AlertRule, e *Event, matches []Match) string {
+	for _, m := range matches {
+		if m.Group != "" {
+			return fmt.Sprintf("rule:%d:%s", rule.ID, m.Group)
+		}
+	}
+	return fmt.Sprintf("rule:%d:%s:%d", rule.ID, e.Type, e.ID)
+}
+
+// raise creates the alert for a rule match, or adds the event to the open
+// alert with the same dedup key. A resolved alert gives up its key, so a
+// new match opens a new alert.
+func raise(rule models.AlertRule, e *Event, matches []Match) (*models.Alert, error) {
+	key := dedupKey(rule, e, matches)
+	sources := []Source{e.source()}
+	reasons := make([]string, 0, len(matches))
+	for _, m := range matches {
+		sources = append(sources, m.Extra...)
+		reasons = append(reasons, m.Reason)
+	}
+
+	var alert models.Alert
+	err := utils.DB.Transaction(func(tx *gorm.DB) error {
+		// deleted alerts keep their key in the unique index
+		err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Where("dedup_key = ?", key).Limit(1).Find(&alert).Error
+		if err != nil {
+			return err
+		}
+		if alert.ID != 0 && alert.Status == StatusOpen && !alert.DeletedAt.Valid {
+			var known []Source
+			json.Unmarshal(alert.Sources, &known)
+			merged, err := json.Marshal(mergeSources(known, sources))
+			if err != nil {
+				return err
+			}
+			alert.Sources = datatypes.JSON(merged)
+			alert.Description = describe(rule, e, reasons)
+			if severityRank(rule.Severity) > severityRank(alert.Severity) {
+				alert.Severity = rule.Severity
+			}
+			if err := tx.Save(&alert).Error; err != nil {
+				return err
+			}
+			// the new match is news to everyone who read the alert
+			return markUnread(tx, alert.ID)
+		}
+		if alert.ID != 0 {
+			if err := tx.Unscoped().Model(&alert).Update("dedup_key", nil).Error; err != nil {
+				return err
+			}
+		}
+
+		data, err := json.Marshal(sources)
+		if err != nil {
+			return err
+		}
+		recipients, err := ParseRecipients(rule.Recipients)
+		if err != nil {
+			return err
+		}
+		alert = models.Alert{
+			Title:       rule.Name,
+			Description: describe(rule, e, reasons),
+			Location:    e.Location,
+			Status:      StatusOpen,
+			Severity:    rule.Severity,
+			RuleID:      &rule.ID,
+			SourceType:  e.Type,
+			SourceID:    &e.ID,
+			Sources:     datatypes.JSON(data),
+			DedupKey:    &key,
+			Recipients:  recipients,
+		}
+		return tx.Create(&alert).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &alert, nil
+}
+
+// describe explains why an event matched a rule
+func describe(rule models.AlertRule, e *Event, reasons []string) string {
+	text := e.Title + ": " + strings.Join(reasons, "; ")
+	if rule.Description != "" {
+		text = rule.Description + "\n" + text
+	}
+	return text
+}
+
+// mergeSources adds the sources not listed yet
+func mergeSources(known, add []Source) []Source {
+	seen := map[Source]bool{}
+	for _, s := range known {
+		seen[s] = true
+	}
+	for _, s := range add {
+		if !seen[s] {
+			seen[s] = true
+			known = append(known, s)
+		}
+	}
+	return known
+}
+
+// ErrUnknownEvent rejects a rule for an event type that is not raised
+var ErrUnknownEvent = errors.New("event must be report, complaint or detection")
+
+// Validate checks a rule before it is saved
+func Validate(rule *models.AlertRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return errors.New("name is required")
+	}
+	if !ValidEvent(rule.Event) {
+		return ErrUnknownEvent
+	}
+	if rule.Severity == "" {
+		rule.Severity = "medium"
+	}
+	if !ValidSeverity(rule.Severity) {
+		return errors.New("severity must be one of " + strings.Join(Severities, ", "))
+	}
+	if _, err := ParseRecipients(rule.Recipients); err != nil {
+		return err
+	}
+	_, err := ParseConditions(rule.Event, rule.Conditions)
+	return err
+}
+
+// ParseRecipients decodes and checks the recipients of a rule
+func ParseRecipients(data []byte) ([]models.AlertRecipient, error) {
+	var recipients []models.AlertRecipient
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	if err := json.Unmarshal(data, &recipients); err != nil {
+		return nil, errors.New("recipients must be a JSON array of {user_id} or {role}")
+	}
+	return recipients, ValidateRecipients(recipients)
+}
+
+// JobEvaluate evaluates the rules for one event
+const JobEvaluate = "alerts.evaluate" // payload {"event": "report", "id": n}
+
+// Enqueue queues the evaluation of an event, within tx when it is not nil
+func Enqueue(tx *gorm.DB, eventType string, id uint) error {
+	payload := map[string]interface{}{"event": eventType, "id": id}
+	var err error
+	if tx != nil {
+		_, err = jobs.EnqueueTx(tx, JobEvaluate, payload)
+	} else {
+		_, err = jobs.Enqueue(JobEvaluate, payload)
+	}
+	return err
+}
+
+func init() {
+	jobs.Register(JobEvaluate, func(ctx context.Context, job *models.Job) error {
+		var p struct {
+			Event string `json:"event"`
+			ID    uint   `json:"id"`
+		}
+		if err := jobs.Decode(job, &p); err != nil {
+			return err
+		}
+		_, err := Evaluate(p.Event, p.ID)
+		return err
+	})
+}
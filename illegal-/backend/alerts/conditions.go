@@ -0,0 +1,330 @@
+package alerts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"backend/geo"
+	"backend/models"
+	"backend/utils"
+)
+
+// Condition is one test of a rule, stored as JSON. Which fields apply
+// depends on the type:
+//
+//	{"type": "priority", "values": ["high"]}                      report priority is one of values
+//	{"type": "near_layer", "kind": "river_buffer", "meters": 200} inside or within meters of a layer feature, optionally the one called name
+//	{"type": "confidence", "min": 0.9}                            detection confidence is at least min
+//	{"type": "label", "values": ["building"]}                     detection label is one of values
+//	{"type": "count", "min": 3, "days": 7, "per": "property"}     at least min events of the same type on the parcel, ward or construction within days
+type Condition struct {
+	Type   string   `json:"type"`
+	Values []string `json:"values,omitempty"`
+	Kind   string   `json:"kind,omitempty"`
+	Name   string   `json:"name,omitempty"`
+	Meters float64  `json:"meters,omitempty"`
+	Min    float64  `json:"min,omitempty"`
+	Days   float64  `json:"days,omitempty"`
+	Per    string   `json:"per,omitempty"`
+}
+
+// Match is a condition that held
+type Match struct {
+	Reason string // for the alert description
+	Group  string // set when the match is about a group of events, e.g. "property:5"; alerts are deduplicated per group
+	Extra  []Source
+}
+
+// ConditionType evaluates one type of condition. Match returns nil when the
+// condition does not hold.
+type ConditionType struct {
+	Events   []string // event types the condition applies to
+	Validate func(event string, c Condition) error
+	Match    func(e *Event, c Condition) (*Match, error)
+}
+
+// ConditionTypes are the condition types rules may use, by name
+var ConditionTypes = map[string]ConditionType{
+	"priority":   {Events: []string{EventReport}, Validate: needValues, Match: matchPriority},
+	"near_layer": {Events: []string{EventReport, EventComplaint, EventDetection}, Validate: validateNearLayer, Match: matchNearLayer},
+	"confidence": {Events: []string{EventDetection}, Validate: validateConfidence, Match: matchConfidence},
+	"label":      {Events: []string{EventDetection}, Validate: needValues, Match: matchLabel},
+	"count":      {Events: []string{EventReport, EventComplaint, EventDetection}, Validate: validateCount, Match: matchCount},
+}
+
+// RegisterCondition adds a condition type
+func RegisterCondition(name string, t ConditionType) {
+	ConditionTypes[name] = t
+}
+
+func needValues(event string, c Condition) error {
+	if len(c.Values) == 0 {
+		return errors.New("values must list at least one value")
+	}
+	return nil
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPriority(e *Event, c Condition) (*Match, error) {
+	if !contains(c.Values, e.Priority) {
+		return nil, nil
+	}
+	return &Match{Reason: e.Priority + " priority"}, nil
+}
+
+func validateNearLayer(event string, c Condition) error {
+	if !geo.ValidKind(c.Kind) {
+		return errors.New("kind must be a layer kind: ward, zone, river_buffer or heritage")
+	}
+	if c.Meters < 0 {
+		return errors.New("meters must not be negative")
+	}
+	return nil
+}
+
+func matchNearLayer(e *Event, c Condition) (*Match, error) {
+	if !e.HasPosition {
+		return nil, nil
+	}
+	shapes, err := geo.ShapesNear(c.Kind, e.Lat, e.Lng, c.Meters)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range shapes {
+		if c.Name != "" && !strings.EqualFold(s.Name, c.Name) {
+			continue
+		}
+		label := strings.ReplaceAll(c.Kind, "_", " ")
+		if s.Name != "" {
+			label += " " + s.Name
+		}
+		if geo.Contains(s.Geom, e.Lat, e.Lng) {
+			return &Match{Reason: "inside " + label}, nil
+		}
+		if d := geo.DistanceToBoundary(s.Geom, e.Lat, e.Lng); d <= c.Meters {
+			return &Match{Reason: fmt.Sprintf("%.0f m from %s", d, label)}, nil
+		}
+	}
+	return nil, nil
+}
+
+func validateConfidence(event string, c Condition) error {
+	if c.Min <= 0 || c.Min > 1 {
+		return errors.New("min must be a confidence between 0 and 1")
+	}
+	return nil
+}
+
+func matchConfidence(e *Event, c Condition) (*Match, error) {
+	if e.Confidence < c.Min {
+		return nil, nil
+	}
+	return &Match{Reason: fmt.Sprintf("confidence %.2f", e.Confidence)}, nil
+}
+
+func matchLabel(e *Event, c Condition) (*Match, error) {
+	if !contains(c.Values, e.Label) {
+		return nil, nil
+	}
+	return &Match{Reason: "labelled " + e.Label}, nil
+}
+
+func validateCount(event string, c Condition) error {
+	if c.Min < 2 {
+		return errors.New("min must be at least 2")
+	}
+	if c.Days <= 0 {
+		return errors.New("days must be positive")
+	}
+	switch c.Per {
+	case "property", "ward":
+		return nil
+	case "construction":
+		if event == EventReport {
+			return errors.New("reports are not linked to constructions; use per property or ward")
+		}
+		return nil
+	}
+	return errors.New("per must be property, ward or construction")
+}
+
+// matchCount counts the events of the same type as e, e included, on the
+// same parcel, ward or construction in the days before e
+func matchCount(e *Event, c Condition) (*Match, error) {
+	since := e.CreatedAt.Add(-time.Duration(c.Days * float64(24*time.Hour)))
+	var group, place string
+	var ids []uint
+	var err error
+	switch c.Per {
+	case "property":
+		p, perr := e.Property()
+		if perr != nil || p == nil {
+			return nil, perr
+		}
+		group, place = fmt.Sprintf("property:%d", p.ID), "parcel #"+fmt.Sprint(p.ID)
+		ids, err = onProperty(e, p, since)
+	case "ward":
+		if e.WardID == nil {
+			return nil, nil
+		}
+		group, place = fmt.Sprintf("ward:%d", *e.WardID), "ward #"+fmt.Sprint(*e.WardID)
+		ids, err = inWard(e, *e.WardID, since)
+	case "construction":
+		if e.ConstructionID == nil {
+			return nil, nil
+		}
+		group, place = fmt.Sprintf("construction:%d", *e.ConstructionID), "construction #"+fmt.Sprint(*e.ConstructionID)
+		ids, err = onConstruction(e, *e.ConstructionID, since)
+	}
+	if err != nil || float64(len(ids)) < c.Min {
+		return nil, err
+	}
+	m := &Match{
+		Reason: fmt.Sprintf("%d %ss on %s within %g days", len(ids), e.Type, place, c.Days),
+		Group:  group,
+	}
+	for _, id := range ids {
+		if id != e.ID {
+			m.Extra = append(m.Extra, Source{Type: e.Type, ID: id})
+		}
+	}
+	return m, nil
+}
+
+// onProperty lists the events of e's type on parcel p since a time
+func onProperty(e *Event, p *models.Property, since time.Time) ([]uint, error) {
+	var ids []uint
+	if e.Type == EventComplaint {
+		err := utils.DB.Model(&models.Complaint{}).
+			Joins("JOIN constructions ON constructions.id = complaints.construction_id").
+			Where("constructions.property_id = ? AND complaints.created_at BETWEEN ? AND ?", p.ID, since, e.CreatedAt).
+			Pluck("complaints.id", &ids).Error
+		return ids, err
+	}
+
+	g, err := geo.DecodeGeometry(p.Boundary)
+	if err != nil {
+		return nil, nil
+	}
+	b := g.Bound()
+	var points []struct {
+		ID       uint
+		Lat, Lng float64
+	}
+	switch e.Type {
+	case EventReport:
+		q := utils.DB.Model(&models.Report{}).
+			Select("id, "+geo.ReportLatSQL+" AS lat, "+geo.ReportLngSQL+" AS lng").
+			Where("created_at BETWEEN ? AND ?", since, e.CreatedAt)
+		err = geo.WithinBound(q, geo.ReportLatSQL, geo.ReportLngSQL, b).Scan(&points).Error
+	case EventDetection:
+		q := utils.DB.Model(&models.Detection{}).
+			Select("id, latitude AS lat, longitude AS lng").
+			Where("status <> ? AND created_at BETWEEN ? AND ?", "dismissed", since, e.CreatedAt)
+		err = geo.WithinBound(q, "latitude", "longitude", b).Scan(&points).Error
+	}
+	for _, pt := range points {
+		if geo.Contains(g, pt.Lat, pt.Lng) {
+			ids = append(ids, pt.ID)
+		}
+	}
+	return ids, err
+}
+
+// inWard lists the events of e's type in a ward since a time
+func inWard(e *Event, wardID uint, since time.Time) ([]uint, error) {
+	var ids []uint
+	var err error
+	switch e.Type {
+	case EventReport:
+		err = utils.DB.Model(&models.Report{}).
+			Where("ward_id = ? AND created_at BETWEEN ? AND ?", wardID, since, e.CreatedAt).
+			Pluck("id", &ids).Error
+	case EventComplaint:
+		err = utils.DB.Model(&models.Complaint{}).
+			Joins("JOIN constructions ON constructions.id = complaints.construction_id").
+			Where("constructions.ward_id = ? AND complaints.created_at BETWEEN ? AND ?", wardID, since, e.CreatedAt).
+			Pluck("complaints.id", &ids).Error
+	case EventDetection:
+		// detections carry no ward, so those in the ward's box are checked
+		// against its boundary
+		var ward models.LayerFeature
+		if err := utils.DB.Where("id = ?", wardID).Limit(1).Find(&ward).Error; err != nil || ward.ID == 0 {
+			return nil, err
+		}
+		g, gerr := geo.DecodeGeometry(ward.Geometry)
+		if gerr != nil {
+			return nil, nil
+		}
+		var points []struct {
+			ID       uint
+			Lat, Lng float64
+		}
+		q := utils.DB.Model(&models.Detection{}).
+			Select("id, latitude AS lat, longitude AS lng").
+			Where("status <> ? AND created_at BETWEEN ? AND ?", "dismissed", since, e.CreatedAt)
+		err = geo.WithinBound(q, "latitude", "longitude", g.Bound()).Scan(&points).Error
+		for _, pt := range points {
+			if geo.Contains(g, pt.Lat, pt.Lng) {
+				ids = append(ids, pt.ID)
+			}
+		}
+	}
+	return ids, err
+}
+
+// onConstruction lists the complaints or detections about a construction
+// since a time; reports are not linked to constructions
+func onConstruction(e *Event, constructionID uint, since time.Time) ([]uint, error) {
+	var ids []uint
+	var err error
+	switch e.Type {
+	case EventComplaint:
+		err = utils.DB.Model(&models.Complaint{}).
+			Where("construction_id = ? AND created_at BETWEEN ? AND ?", constructionID, since, e.CreatedAt).
+			Pluck("id", &ids).Error
+	case EventDetection:
+		err = utils.DB.Model(&models.Detection{}).
+			Where("construction_id = ? AND created_at BETWEEN ? AND ?", constructionID, since, e.CreatedAt).
+			Pluck("id", &ids).Error
+	}
+	return ids, err
+}
+
+// ParseConditions decodes and checks the conditions of a rule for an event
+func ParseConditions(event string, data []byte) ([]Condition, error) {
+	var conditions []Condition
+	if len(data) == 0 || string(data) == "null" {
+		return nil, errors.New("conditions must list at least one condition")
+	}
+	if err := json.Unmarshal(data, &conditions); err != nil {
+		return nil, errors.New("conditions must be a JSON array of conditions")
+	}
+	if len(conditions) == 0 {
+		return nil, errors.New("conditions must list at least one condition")
+	}
+	for i, c := range conditions {
+		t, ok := ConditionTypes[c.Type]
+		if !ok {
+			return nil, fmt.Errorf("condition %d: unknown type %q", i+1, c.Type)
+		}
+		if !contains(t.Events, event) {
+			return nil, fmt.Errorf("condition %d: %s does not apply to %s events", i+1, c.Type, event)
+		}
+		if err := t.Validate(event, c); err != nil {
+			return nil, fmt.Errorf("condition %d: %v", i+1, err)
+		}
+	}
+	return conditions, nil
+}
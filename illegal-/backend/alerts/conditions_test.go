@@ -0,0 +1,115 @@
+package alerts
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"backend/models"
+)
+
+func TestSimpleConditionsMatch(t *testing.T) {
+	report := &Event{Type: EventReport, ID: 1, Priority: "High"}
+	detection := &Event{Type: EventDetection, ID: 2, Confidence: 0.9, Label: "building"}
+
+	for _, tc := range []struct {
+		name  string
+		event *Event
+		cond  Condition
+		want  string // reason, or "" for no match
+	}{
+		{"priority listed", report, Condition{Type: "priority", Values: []string{"high", "medium"}}, "High priority"},
+		{"priority not listed", report, Condition{Type: "priority", Values: []string{"low"}}, ""},
+		{"confidence at min", detection, Condition{Type: "confidence", Min: 0.9}, "confidence 0.90"},
+		{"confidence below min", detection, Condition{Type: "confidence", Min: 0.95}, ""},
+		{"label listed", detection, Condition{Type: "label", Values: []string{"Building"}}, "labelled building"},
+		{"label not listed", detection, Condition{Type: "label", Values: []string{"road"}}, ""},
+		{"near layer without position", report, Condition{Type: "near_layer", Kind: "river_buffer", Meters: 100}, ""},
+		{"count per ward without ward", report, Condition{Type: "count", Min: 2, Days: 7, Per: "ward"}, ""},
+		{"count per construction without one", detection, Condition{Type: "count", Min: 2, Days: 7, Per: "construction"}, ""},
+	} {
+		m, err := ConditionTypes[tc.cond.Type].Match(tc.event, tc.cond)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		switch {
+		case tc.want == "" && m != nil:
+			t.Errorf("%s: matched with %q, want no match", tc.name, m.Reason)
+		case tc.want != "" && m == nil:
+			t.Errorf("%s: no match, want %q", tc.name, tc.want)
+		case tc.want != "" && m.Reason != tc.want:
+			t.Errorf("%s: reason %q, want %q", tc.name, m.Reason, tc.want)
+		}
+	}
+}
+
+func TestMatchAllNeedsEveryCondition(t *testing.T) {
+	e := &Event{Type: EventDetection, ID: 2, Confidence: 0.8, Label: "building"}
+	label := Condition{Type: "label", Values: []string{"building"}}
+
+	matches, err := matchAll(e, []Condition{label, {Type: "confidence", Min: 0.5}})
+	if err != nil || len(matches) != 2 {
+		t.Fatalf("all conditions hold: matches = %v, %v; want 2", matches, err)
+	}
+	matches, err = matchAll(e, []Condition{label, {Type: "confidence", Min: 0.9}})
+	if err != nil || matches != nil {
+		t.Errorf("one condition fails: matches = %v, %v; want none", matches, err)
+	}
+}
+
+func TestParseConditions(t *testing.T) {
+	valid := `[{"type": "priority", "values": ["high"]}, {"type": "count", "min": 3, "days": 7, "per": "ward"}]`
+	conditions, err := ParseConditions(EventReport, []byte(valid))
+	if err != nil || len(conditions) != 2 || conditions[1].Per != "ward" {
+		t.Fatalf("ParseConditions = %+v, %v", conditions, err)
+	}
+
+	for _, tc := range []struct {
+		event, data, want string
+	}{
+		{EventReport, ``, "at least one condition"},
+		{EventReport, `[]`, "at least one condition"},
+		{EventReport, `{"type": "priority"}`, "JSON array"},
+		{EventReport, `[{"type": "weather"}]`, `unknown type "weather"`},
+		{EventComplaint, `[{"type": "priority", "values": ["high"]}]`, "does not apply to complaint"},
+		{EventReport, `[{"type": "priority"}]`, "values must list"},
+		{EventDetection, `[{"type": "confidence", "min": 1.5}]`, "between 0 and 1"},
+		{EventReport, `[{"type": "near_layer", "kind": "lake"}]`, "kind must be a layer kind"},
+		{EventReport, `[{"type": "near_layer", "kind": "ward", "meters": -1}]`, "must not be negative"},
+		{EventReport, `[{"type": "count", "min": 1, "days": 7, "per": "ward"}]`, "at least 2"},
+		{EventReport, `[{"type": "count", "min": 2, "days": 0, "per": "ward"}]`, "days must be positive"},
+		{EventReport, `[{"type": "count", "min": 2, "days": 7, "per": "construction"}]`, "not linked to constructions"},
+		{EventComplaint, `[{"type": "count", "min": 2, "days": 7, "per": "street"}]`, "per must be"},
+	} {
+		_, err := ParseConditions(tc.event, []byte(tc.data))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s %s: err = %v, want %q", tc.event, tc.data, err, tc.want)
+		}
+	}
+}
+
+func TestDedupKeyPrefersGroup(t *testing.T) {
+	rule := models.AlertRule{ID: 4}
+	e := &Event{Type: EventComplaint, ID: 9, CreatedAt: time.Now()}
+	if got := dedupKey(rule, e, []Match{{Reason: "a"}}); got != "rule:4:complaint:9" {
+		t.Errorf("key without group = %q", got)
+	}
+	if got := dedupKey(rule, e, []Match{{Reason: "a"}, {Reason: "b", Group: "ward:3"}}); got != "rule:4:ward:3" {
+		t.Errorf("key with group = %q", got)
+	}
+}
+
+func TestMergeSourcesKeepsOrderWithoutDuplicates(t *testing.T) {
+	known := []Source{{EventReport, 1}, {EventReport, 2}}
+	got := mergeSources(known, []Source{{EventReport, 2}, {EventReport, 3}, {EventReport, 3}})
+	want := []Source{{EventReport, 1}, {EventReport, 2}, {EventReport, 3}}
+	if len(got) != len(want) {
+		t.Fatalf("merged = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("merged = %v, want %v", got, want)
+		}
+	}
+}
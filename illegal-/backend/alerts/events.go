@@ -0,0 +1,168 @@
+package alerts
+
+import (
+	"fmt"
+	"time"
+
+	"backend/geo"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/paulmach/orb"
+)
+
+// Event types rules are evaluated on
+const (
+	EventReport    = "report"    // a report was submitted and located
+	EventComplaint = "complaint" // a complaint was filed about a construction
+	EventDetection = "detection" // a candidate detection was stored
+)
+
+// ValidEvent reports whether event is one rules can be written for
+func ValidEvent(event string) bool {
+	switch event {
+	case EventReport, EventComplaint, EventDetection:
+		return true
+	}
+	return false
+}
+
+// Event is the entity an event is about, with what conditions look at
+type Event struct {
+	Type      string
+	ID        uint
+	CreatedAt time.Time
+	Title     string // e.g. "Report #12", for alert descriptions
+	Location  string
+
+	Lat, Lng    float64
+	HasPosition bool
+
+	Priority       string  // reports
+	Confidence     float64 // detections
+	Label          string  // detections
+	WardID         *uint
+	ConstructionID *uint // complaints and linked detections
+
+	property      *models.Property
+	propertyKnown bool
+}
+
+// Source is an entity an alert links to
+type Source struct {
+	Type string `json:"type"`
+	ID   uint   `json:"id"`
+}
+
+func (e *Event) source() Source {
+	return Source{Type: e.Type, ID: e.ID}
+}
+
+// load reads the entity of an event; a deleted entity yields nil
+func load(eventType string, id uint) (*Event, error) {
+	switch eventType {
+	case EventReport:
+		var r models.Report
+		if err := utils.DB.Where("id = ?", id).Limit(1).Find(&r).Error; err != nil || r.ID == 0 {
+			return nil, err
+		}
+		e := &Event{
+			Type:      EventReport,
+			ID:        r.ID,
+			CreatedAt: r.CreatedAt,
+			Title:     fmt.Sprintf("Report #%d", r.ID),
+			Location:  r.Location,
+			Priority:  r.Priority,
+			WardID:    r.WardID,
+		}
+		e.Lat, e.Lng, e.HasPosition = r.LatLng()
+		return e, nil
+
+	case EventComplaint:
+		var c models.Complaint
+		if err := utils.DB.Where("id = ?", id).Limit(1).Find(&c).Error; err != nil || c.ID == 0 {
+			return nil, err
+		}
+		e := &Event{
+			Type:      EventComplaint,
+			ID:        c.ID,
+			CreatedAt: c.CreatedAt,
+			Title:     fmt.Sprintf("Complaint #%d", c.ID),
+			Location:  c.Location,
+		}
+		var construction models.Construction
+		if c.ConstructionID != 0 {
+			if err := utils.DB.Where("id = ?", c.ConstructionID).Limit(1).Find(&construction).Error; err != nil {
+				return nil, err
+			}
+		}
+		if construction.ID != 0 {
+			e.ConstructionID = &construction.ID
+			e.WardID = construction.WardID
+			e.Lat, e.Lng, e.HasPosition = construction.Latitude, construction.Longitude, true
+			if e.Location == "" {
+				e.Location = construction.Location
+			}
+			if construction.PropertyID != 0 {
+				if err := e.loadProperty(construction.PropertyID); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return e, nil
+
+	case EventDetection:
+		var d models.Detection
+		if err := utils.DB.Where("id = ?", id).Limit(1).Find(&d).Error; err != nil || d.ID == 0 {
+			return nil, err
+		}
+		return &Event{
+			Type:           EventDetection,
+			ID:             d.ID,
+			CreatedAt:      d.CreatedAt,
+			Title:          fmt.Sprintf("Detection #%d (%s)", d.ID, d.Source),
+			Location:       fmt.Sprintf("%.5f, %.5f", d.Latitude, d.Longitude),
+			Lat:            d.Latitude,
+			Lng:            d.Longitude,
+			HasPosition:    true,
+			Confidence:     d.Confidence,
+			Label:          d.Label,
+			WardID:         geo.WardAt(d.Latitude, d.Longitude),
+			ConstructionID: d.ConstructionID,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown event type %q", eventType)
+}
+
+func (e *Event) loadProperty(id uint) error {
+	var p models.Property
+	if err := utils.DB.Where("id = ?", id).Limit(1).Find(&p).Error; err != nil {
+		return err
+	}
+	if p.ID != 0 {
+		e.property = &p
+	}
+	e.propertyKnown = true
+	return nil
+}
+
+// Property returns the parcel the event is on: the parcel of a complaint's
+// construction, or else the parcel whose boundary contains the position
+func (e *Event) Property() (*models.Property, error) {
+	if e.propertyKnown || !e.HasPosition {
+		return e.property, nil
+	}
+	e.propertyKnown = true
+	var candidates []models.Property
+	b := orb.Point{e.Lng, e.Lat}.Bound()
+	if err := geo.IntersectsBound(utils.DB, "properties", b).Order("id").Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	for _, p := range candidates {
+		if g, err := geo.DecodeGeometry(p.Boundary); err == nil && geo.Contains(g, e.Lat, e.Lng) {
+			e.property = &p
+			break
+		}
+	}
+	return e.property, nil
+}
@@ -0,0 +1,177 @@
+package alerts
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"backend/models"
+	"backend/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MigrateReadFlags moves the shared is_read flag alerts had before read
+// state was kept per user into AlertState, marking each alert read for
+// every officer and admin, and then drops the column. It does nothing once
+// the column is gone.
+func MigrateReadFlags(db *gorm.DB) error {
+	if !db.Migrator().HasColumn("alerts", "is_read") {
+		return nil
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(`INSERT INTO alert_states (alert_id, user_id, read_at, updated_at)
+			SELECT alerts.id, users.id, alerts.updated_at, NOW()
+			FROM alerts CROSS JOIN users
+			WHERE alerts.is_read AND LOWER(users.role) IN ?
+			ON CONFLICT (alert_id, user_id) DO UPDATE
+				SET read_at = COALESCE(alert_states.read_at, excluded.read_at)`, recipientRoles).Error
+		if err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn("alerts", "is_read")
+	})
+}
+
+// Roles alerts can be addressed to
+var recipientRoles = []string{"officer", "admin"}
+
+// ValidateRecipients checks that each recipient names either a user or one
+// of the officer and admin roles
+func ValidateRecipients(recipients []models.AlertRecipient) error {
+	for i := range recipients {
+		r := &recipients[i]
+		r.ID, r.AlertID = 0, 0
+		r.Role = strings.ToLower(strings.TrimSpace(r.Role))
+		if (r.UserID == nil) == (r.Role == "") {
+			return errors.New("each recipient needs either a user_id or a role")
+		}
+		if r.Role != "" && !contains(recipientRoles, r.Role) {
+			return errors.New("recipient role must be officer or admin")
+		}
+	}
+	return nil
+}
+
+// Visible restricts q, a query on alerts, to those addressed to user.
+// Alerts without recipients go to every officer and admin, an assigned
+// alert always reaches its assignee, and admins see every alert.
+func Visible(q *gorm.DB, user *models.User) *gorm.DB {
+	if strings.EqualFold(user.Role, "admin") {
+		return q
+	}
+	return q.Where(`(alerts.assignee_id = ?
+		OR NOT EXISTS (SELECT 1 FROM alert_recipients ar WHERE ar.alert_id = alerts.id)
+		OR EXISTS (SELECT 1 FROM alert_recipients ar WHERE ar.alert_id = alerts.id AND (ar.user_id = ? OR ar.role = ?)))`,
+		user.ID, user.ID, strings.ToLower(user.Role))
+}
+
+// Unread restricts q, a query on alerts, to those the user has not read
+func Unread(q *gorm.DB, userID uint) *gorm.DB {
+	return q.Where("NOT EXISTS (SELECT 1 FROM alert_states s WHERE s.alert_id = alerts.id AND s.user_id = ? AND s.read_at IS NOT NULL)", userID)
+}
+
+// NotSnoozed restricts q, a query on alerts, to those the user has not
+// snoozed past now
+func NotSnoozed(q *gorm.DB, userID uint, now time.Time) *gorm.DB {
+	return q.Where("NOT EXISTS (SELECT 1 FROM alert_states s WHERE s.alert_id = alerts.id AND s.user_id = ? AND s.snoozed_until > ?)", userID, now)
+}
+
+// FillState sets the per-user fields of alerts from the user's state
+func FillState(alerts []models.Alert, userID uint) error {
+	if len(alerts) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(alerts))
+	for i, a := range alerts {
+		ids[i] = a.ID
+	}
+	var states []models.AlertState
+	if err := utils.DB.Where("user_id = ? AND alert_id IN ?", userID, ids).Find(&states).Error; err != nil {
+		return err
+	}
+	byAlert := map[uint]models.AlertState{}
+	for _, s := range states {
+		byAlert[s.AlertID] = s
+	}
+	for i := range alerts {
+		s := byAlert[alerts[i].ID]
+		alerts[i].ReadAt, alerts[i].AcknowledgedAt, alerts[i].SnoozedUntil = s.ReadAt, s.AcknowledgedAt, s.SnoozedUntil
+		alerts[i].IsRead = s.ReadAt != nil
+	}
+	return nil
+}
+
+// setState upserts the user's state of the given alerts, assigning the
+// given columns. Values may be expressions on the existing row
+// (alert_states.col) or on the proposed one (excluded.col).
+func setState(userID uint, alertIDs []uint, row models.AlertState, set map[string]interface{}) error {
+	if len(alertIDs) == 0 {
+		return nil
+	}
+	rows := make([]models.AlertState, len(alertIDs))
+	for i, id := range alertIDs {
+		rows[i] = row
+		rows[i].AlertID, rows[i].UserID = id, userID
+	}
+	set["updated_at"] = gorm.Expr("excluded.updated_at")
+	return utils.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "alert_id"}, {Name: "user_id"}},
+		DoUpdates: clause.Assignments(set),
+	}).CreateInBatches(&rows, 500).Error
+}
+
+// MarkRead marks alerts read by the user, keeping earlier read times
+func MarkRead(userID uint, alertIDs ...uint) error {
+	now := time.Now()
+	return setState(userID, alertIDs, models.AlertState{ReadAt: &now}, map[string]interface{}{
+		"read_at": gorm.Expr("COALESCE(alert_states.read_at, excluded.read_at)"),
+	})
+}
+
+// Acknowledge records that the user has seen to an alert, which also
+// marks it read
+func Acknowledge(userID, alertID uint) error {
+	now := time.Now()
+	return setState(userID, []uint{alertID}, models.AlertState{ReadAt: &now, AcknowledgedAt: &now}, map[string]interface{}{
+		"read_at":         gorm.Expr("COALESCE(alert_states.read_at, excluded.read_at)"),
+		"acknowledged_at": gorm.Expr("COALESCE(alert_states.acknowledged_at, excluded.acknowledged_at)"),
+	})
+}
+
+// Snooze hides an alert from the user until a time; nil wakes it
+func Snooze(userID, alertID uint, until *time.Time) error {
+	return setState(userID, []uint{alertID}, models.AlertState{SnoozedUntil: until}, map[string]interface{}{
+		"snoozed_until": gorm.Expr("excluded.snoozed_until"),
+	})
+}
+
+// markUnread makes an alert unread again, for the given users or for all
+func markUnread(tx *gorm.DB, alertID uint, userIDs ...uint) error {
+	q := tx.Model(&models.AlertState{}).Where("alert_id = ?", alertID)
+	if len(userIDs) > 0 {
+		q = q.Where("user_id IN ?", userIDs)
+	}
+	return q.Update("read_at", nil).Error
+}
+
+// Assign hands an alert to an officer, or takes it back with nil. The
+// assignee sees it as unread.
+func Assign(alert *models.Alert, assigneeID *uint) error {
+	var assignedAt *time.Time
+	if assigneeID != nil {
+		now := time.Now()
+		assignedAt = &now
+	}
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(alert).Updates(map[string]interface{}{
+			"assignee_id": assigneeID,
+			"assigned_at": assignedAt,
+		}).Error
+		if err != nil || assigneeID == nil {
+			return err
+		}
+		return markUnread(tx, alert.ID, *assigneeID)
+	})
+}
@@ -0,0 +1,399 @@
+package change
+
+import (
+	"math"
+	"math/bits"
+	"math/rand"
+	"sort"
+)
+
+// Alignment methods
+const (
+	AlignNone     = "none"
+	AlignFeatures = "features"
+)
+
+// Alignment describes how the before image was registered onto the after
+// image before differencing
+type Alignment struct {
+	Method      string     `json:"method"`      // features, or none when alignment was off or failed
+	Keypoints   [2]int     `json:"keypoints"`   // corners found in the before and after images
+	Matches     int        `json:"matches"`     // descriptor matches passing the ratio and cross checks
+	Inliers     int        `json:"inliers"`     // matches consistent with the estimated transform
+	InlierRatio float64    `json:"inlierRatio"` // inliers / matches
+	RMSE        float64    `json:"rmse"`        // inlier residual in pixels at comparison size
+	Overlap     float64    `json:"overlap"`     // share of the after image covered by the warped before image
+	Quality     float64    `json:"quality"`     // 0-1 summary: inlier ratio × overlap, 0 when not aligned
+	Transform   [6]float64 `json:"transform"`   // affine map from after to before pixels: x' = t0·x + t1·y + t2, y' = t3·x + t4·y + t5
+}
+
+// Tuning for feature alignment
+const (
+	maxKeypoints   = 500
+	patchRadius    = 15 // descriptor and orientation patch radius
+	harrisK        = 0.04
+	matchRatio     = 0.8 // Lowe ratio test
+	ransacRounds   = 1000
+	inlierDistance = 3.0 // pixels
+	minInliers     = 10
+	minInlierRatio = 0.25
+)
+
+type keypoint struct {
+	x, y     int
+	response float64
+	desc     [4]uint64 // 256-bit steered BRIEF
+}
+
+// align estimates the affine transform from after to before pixels and warps
+// the before image into the after frame. It returns the warped image, the
+// pixels it covers and the alignment report; when alignment fails the
+// original image is returned with Method none.
+func align(a, b []float64, w, h int) ([]float64, []bool, Alignment) {
+	report := Alignment{Method: AlignNone}
+	identity := func() ([]float64, []bool, Alignment) {
+		valid := make([]bool, w*h)
+		for i := range valid {
+			valid[i] = true
+		}
+		report.Transform = [6]float64{1, 0, 0, 0, 1, 0}
+		report.Overlap = 1
+		return a, valid, report
+	}
+
+	sa, sb := boxTable(a, w, h), boxTable(b, w, h)
+	ka, kb := keypoints(a, sa, w, h), keypoints(b, sb, w, h)
+	report.Keypoints = [2]int{len(ka), len(kb)}
+
+	matches := matchKeypoints(kb, ka)
+	report.Matches = len(matches)
+	if len(matches) < minInliers {
+		return identity()
+	}
+
+	t, inliers := ransacAffine(matches)
+	report.Inliers = len(inliers)
+	report.InlierRatio = float64(len(inliers)) / float64(len(matches))
+	if len(inliers) < minInliers || report.InlierRatio < minInlierRatio || !plausible(t) {
+		return identity()
+	}
+	// refine on all inliers by least squares
+	if t, _ = solveAffine(inliers); !plausible(t) {
+		return identity()
+	}
+	var sq float64
+	for _, m := range inliers {
+		dx, dy := apply(t, m[0], m[1])
+		sq += (dx-m[2])*(dx-m[2]) + (dy-m[3])*(dy-m[3])
+	}
+	report.RMSE = math.Sqrt(sq / float64(len(inliers)))
+
+	warped := make([]float64, w*h)
+	valid := make([]bool, w*h)
+	covered := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx, sy := apply(t, float64(x), float64(y))
+			i := y*w + x
+			if v, ok := bilinear(a, w, h, sx, sy); ok {
+				warped[i], valid[i] = v, true
+				covered++
+			} else {
+				warped[i] = b[i] // outside the before image: treat as unchanged
+			}
+		}
+	}
+
+	report.Method = AlignFeatures
+	report.Transform = t
+	report.Overlap = float64(covered) / float64(w*h)
+	report.Quality = report.InlierRatio * report.Overlap
+	return warped, valid, report
+}
+
+// boxTable is the summed-area table of an image, used for smoothed samples
+func boxTable(img []float64, w, h int) []float64 {
+	return integral(w, h, func(i int) float64 { return img[i] })
+}
+
+// smooth returns the 5×5 box mean around (x, y), clipped at the edges
+func smooth(s []float64, w, h, x, y int) float64 {
+	x0, y0 := max(0, x-2), max(0, y-2)
+	x1, y1 := min(w, x+3), min(h, y+3)
+	return boxSum(s, w, x0, y0, x1, y1) / float64((x1-x0)*(y1-y0))
+}
+
+// keypoints finds Harris corners spread over the image and describes them
+func keypoints(img, table []float64, w, h int) []keypoint {
+	// Sobel gradients and their products
+	ixx := make([]float64, w*h)
+	iyy := make([]float64, w*h)
+	ixy := make([]float64, w*h)
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			p := func(dx, dy int) float64 { return img[(y+dy)*w+x+dx] }
+			gx := (p(1, -1) + 2*p(1, 0) + p(1, 1)) - (p(-1, -1) + 2*p(-1, 0) + p(-1, 1))
+			gy := (p(-1, 1) + 2*p(0, 1) + p(1, 1)) - (p(-1, -1) + 2*p(0, -1) + p(1, -1))
+			i := y*w + x
+			ixx[i], iyy[i], ixy[i] = gx*gx, gy*gy, gx*gy
+		}
+	}
+	sxx := integral(w, h, func(i int) float64 { return ixx[i] })
+	syy := integral(w, h, func(i int) float64 { return iyy[i] })
+	sxy := integral(w, h, func(i int) float64 { return ixy[i] })
+
+	border := patchRadius + 3
+	response := make([]float64, w*h)
+	maxResponse := 0.0
+	for y := border; y < h-border; y++ {
+		for x := border; x < w-border; x++ {
+			a := boxSum(sxx, w, x-2, y-2, x+3, y+3)
+			b := boxSum(syy, w, x-2, y-2, x+3, y+3)
+			c := boxSum(sxy, w, x-2, y-2, x+3, y+3)
+			r := a*b - c*c - harrisK*(a+b)*(a+b)
+			response[y*w+x] = r
+			maxResponse = math.Max(maxResponse, r)
+		}
+	}
+	if maxResponse <= 0 {
+		return nil
+	}
+
+	// local maxima above 1% of the strongest corner
+	var candidates []keypoint
+	for y := border; y < h-border; y++ {
+		for x := border; x < w-border; x++ {
+			r := response[y*w+x]
+			if r < 0.01*maxResponse {
+				continue
+			}
+			peak := true
+			for dy := -2; dy <= 2 && peak; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					if (dx != 0 || dy != 0) && response[(y+dy)*w+x+dx] >= r {
+						peak = false
+						break
+					}
+				}
+			}
+			if peak {
+				candidates = append(candidates, keypoint{x: x, y: y, response: r})
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].response > candidates[j].response })
+
+	// cap corners per cell of an 8×8 grid so strong texture in one corner of
+	// the image cannot crowd out everything else
+	const grid = 8
+	perCell := 2 * maxKeypoints / (grid * grid)
+	counts := make([]int, grid*grid)
+	var out []keypoint
+	for _, k := range candidates {
+		cell := (k.y*grid/h)*grid + k.x*grid/w
+		if counts[cell] >= perCell {
+			continue
+		}
+		counts[cell]++
+		k.desc = describe(table, w, h, k.x, k.y)
+		out = append(out, k)
+		if len(out) == maxKeypoints {
+			break
+		}
+	}
+	return out
+}
+
+// briefPairs are the sample point pairs of the descriptor, fixed so that
+// descriptors from different runs are comparable
+var briefPairs = func() [256][4]float64 {
+	var pairs [256][4]float64
+	rng := rand.New(rand.NewSource(1))
+	sigma := float64(patchRadius) / 2
+	for i := range pairs {
+		for j := range pairs[i] {
+			v := rng.NormFloat64() * sigma
+			pairs[i][j] = math.Max(-patchRadius+2, math.Min(patchRadius-2, v))
+		}
+	}
+	return pairs
+}()
+
+// describe computes a steered BRIEF descriptor: the patch orientation comes
+// from its intensity centroid, and the sample pattern is rotated to match so
+// that rotated captures still match
+func describe(table []float64, w, h, x, y int) [4]uint64 {
+	var m10, m01 float64
+	for dy := -patchRadius; dy <= patchRadius; dy += 2 {
+		for dx := -patchRadius; dx <= patchRadius; dx += 2 {
+			if dx*dx+dy*dy > patchRadius*patchRadius {
+				continue
+			}
+			v := smooth(table, w, h, x+dx, y+dy)
+			m10 += float64(dx) * v
+			m01 += float64(dy) * v
+		}
+	}
+	angle := math.Atan2(m01, m10)
+	sin, cos := math.Sincos(angle)
+
+	var desc [4]uint64
+	for i, p := range briefPairs {
+		x1 := x + int(math.Round(cos*p[0]-sin*p[1]))
+		y1 := y + int(math.Round(sin*p[0]+cos*p[1]))
+		x2 := x + int(math.Round(cos*p[2]-sin*p[3]))
+		y2 := y + int(math.Round(sin*p[2]+cos*p[3]))
+		if smooth(table, w, h, x1, y1) < smooth(table, w, h, x2, y2) {
+			desc[i/64] |= 1 << (i % 64)
+		}
+	}
+	return desc
+}
+
+func hamming(a, b [4]uint64) int {
+	return bits.OnesCount64(a[0]^b[0]) + bits.OnesCount64(a[1]^b[1]) +
+		bits.OnesCount64(a[2]^b[2]) + bits.OnesCount64(a[3]^b[3])
+}
+
+// matchKeypoints pairs corners of the after image (from) with corners of the
+// before image (to), keeping distinctive mutual best matches. Each match is
+// {fromX, fromY, toX, toY}.
+func matchKeypoints(from, to []keypoint) [][4]float64 {
+	best := func(k keypoint, set []keypoint) (int, int, int) {
+		bi, bd, second := -1, math.MaxInt, math.MaxInt
+		for i, c := range set {
+			d := hamming(k.desc, c.desc)
+			if d < bd {
+				bi, bd, second = i, d, bd
+			} else if d < second {
+				second = d
+			}
+		}
+		return bi, bd, second
+	}
+
+	var matches [][4]float64
+	for i, k := range from {
+		j, d, second := best(k, to)
+		if j < 0 || float64(d) > matchRatio*float64(second) {
+			continue
+		}
+		if back, _, _ := best(to[j], from); back != i {
+			continue
+		}
+		matches = append(matches, [4]float64{float64(k.x), float64(k.y), float64(to[j].x), float64(to[j].y)})
+	}
+	return matches
+}
+
+// ransacAffine finds the affine transform supported by the most matches
+func ransacAffine(matches [][4]float64) ([6]float64, [][4]float64) {
+	rng := rand.New(rand.NewSource(1))
+	var bestT [6]float64
+	var bestInliers [][4]float64
+	for round := 0; round < ransacRounds; round++ {
+		i, j, k := rng.Intn(len(matches)), rng.Intn(len(matches)), rng.Intn(len(matches))
+		if i == j || j == k || i == k {
+			continue
+		}
+		t, ok := solveAffine([][4]float64{matches[i], matches[j], matches[k]})
+		if !ok {
+			continue
+		}
+		var inliers [][4]float64
+		for _, m := range matches {
+			x, y := apply(t, m[0], m[1])
+			if math.Hypot(x-m[2], y-m[3]) <= inlierDistance {
+				inliers = append(inliers, m)
+			}
+		}
+		if len(inliers) > len(bestInliers) {
+			bestT, bestInliers = t, inliers
+		}
+	}
+	return bestT, bestInliers
+}
+
+// solveAffine solves the normal equations for x' and y' separately; with
+// three matches this is the exact transform
+func solveAffine(matches [][4]float64) ([6]float64, bool) {
+	var m [3][3]float64
+	var bx, by [3]float64
+	for _, p := range matches {
+		v := [3]float64{p[0], p[1], 1}
+		for r := 0; r < 3; r++ {
+			for c := 0; c < 3; c++ {
+				m[r][c] += v[r] * v[c]
+			}
+			bx[r] += v[r] * p[2]
+			by[r] += v[r] * p[3]
+		}
+	}
+	px, ok := solve3(m, bx)
+	if !ok {
+		return [6]float64{}, false
+	}
+	py, _ := solve3(m, by)
+	return [6]float64{px[0], px[1], px[2], py[0], py[1], py[2]}, true
+}
+
+// solve3 solves m·x = b by Cramer's rule
+func solve3(m [3][3]float64, b [3]float64) ([3]float64, bool) {
+	det := func(m [3][3]float64) float64 {
+		return m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+			m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+			m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	}
+	d := det(m)
+	if math.Abs(d) < 1e-9 {
+		return [3]float64{}, false
+	}
+	var x [3]float64
+	for c := 0; c < 3; c++ {
+		mc := m
+		for r := 0; r < 3; r++ {
+			mc[r][c] = b[r]
+		}
+		x[c] = det(mc) / d
+	}
+	return x, true
+}
+
+func apply(t [6]float64, x, y float64) (float64, float64) {
+	return t[0]*x + t[1]*y + t[2], t[3]*x + t[4]*y + t[5]
+}
+
+// Limits on the transform between two captures of one site
+const (
+	maxScale = 2.0 // along either axis, or its inverse
+	maxShear = 0.2 // cosine of the angle between the transformed axes
+)
+
+// plausible rejects transforms that mirror the image, scale either axis by
+// more than maxScale or skew it by more than maxShear, which only arise
+// from bad matches between captures of one site
+func plausible(t [6]float64) bool {
+	if t[0]*t[4]-t[1]*t[3] <= 0 {
+		return false
+	}
+	sx, sy := math.Hypot(t[0], t[3]), math.Hypot(t[1], t[4])
+	for _, s := range []float64{sx, sy} {
+		if s < 1/maxScale || s > maxScale {
+			return false
+		}
+	}
+	return math.Abs(t[0]*t[1]+t[3]*t[4])/(sx*sy) <= maxShear
+}
+
+// bilinear samples img at a fractional position
+func bilinear(img []float64, w, h int, x, y float64) (float64, bool) {
+	if x < 0 || y < 0 || x > float64(w-1) || y > float64(h-1) {
+		return 0, false
+	}
+	x0, y0 := int(x), int(y)
+	x1, y1 := min(x0+1, w-1), min(y0+1, h-1)
+	fx, fy := x-float64(x0), y-float64(y0)
+	top := img[y0*w+x0]*(1-fx) + img[y0*w+x1]*fx
+	bottom := img[y1*w+x0]*(1-fx) + img[y1*w+x1]*fx
+	return top*(1-fy) + bottom*fy, true
+}
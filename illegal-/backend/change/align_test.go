@@ -0,0 +1,145 @@
+package change
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// texture draws random bright and dark rectangles, giving plenty of corners
+func texture(w, h int) []float64 {
+	rng := rand.New(rand.NewSource(7))
+	img := make([]float64, w*h)
+	for i := range img {
+		img[i] = 128
+	}
+	for n := 0; n < 120; n++ {
+		x0, y0 := rng.Intn(w), rng.Intn(h)
+		x1, y1 := min(w, x0+4+rng.Intn(20)), min(h, y0+4+rng.Intn(20))
+		v := float64(rng.Intn(256))
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				img[y*w+x] = v
+			}
+		}
+	}
+	return img
+}
+
+// shift moves img by (dx, dy), filling uncovered pixels with grey
+func shift(img []float64, w, h, dx, dy int) []float64 {
+	out := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx, sy := x-dx, y-dy
+			if sx < 0 || sy < 0 || sx >= w || sy >= h {
+				out[y*w+x] = 128
+				continue
+			}
+			out[y*w+x] = img[sy*w+sx]
+		}
+	}
+	return out
+}
+
+func TestAlignRecoversTranslation(t *testing.T) {
+	const w, h = 200, 200
+	before := texture(w, h)
+	after := shift(before, w, h, 6, -4)
+
+	_, _, report := align(before, after, w, h)
+	if report.Method != AlignFeatures {
+		t.Fatalf("method = %s (matches %d, inliers %d), want features", report.Method, report.Matches, report.Inliers)
+	}
+	// after pixel (x, y) shows before pixel (x-6, y+4)
+	want := [6]float64{1, 0, -6, 0, 1, 4}
+	for i, v := range report.Transform {
+		if math.Abs(v-want[i]) > 0.1 {
+			t.Fatalf("transform = %v, want %v", report.Transform, want)
+		}
+	}
+	if report.RMSE > 1 {
+		t.Errorf("rmse = %v, want below a pixel", report.RMSE)
+	}
+}
+
+func TestAlignFallsBackOnUnrelatedImages(t *testing.T) {
+	const w, h = 200, 200
+	flat := make([]float64, w*h)
+	for i := range flat {
+		flat[i] = 90
+	}
+	_, valid, report := align(flat, texture(w, h), w, h)
+	if report.Method != AlignNone || report.Transform != [6]float64{1, 0, 0, 0, 1, 0} {
+		t.Errorf("report = %+v, want identity with method none", report)
+	}
+	for _, v := range valid {
+		if !v {
+			t.Fatal("identity alignment left pixels uncovered")
+		}
+	}
+}
+
+func TestDescriptorMatchesItself(t *testing.T) {
+	const w, h = 200, 200
+	img := texture(w, h)
+	table := boxTable(img, w, h)
+	kp := keypoints(img, table, w, h)
+	if len(kp) < minInliers {
+		t.Fatalf("found %d keypoints", len(kp))
+	}
+	for _, k := range kp {
+		if d := hamming(k.desc, describe(table, w, h, k.x, k.y)); d != 0 {
+			t.Fatalf("descriptor of (%d, %d) differs by %d bits", k.x, k.y, d)
+		}
+	}
+	matches := matchKeypoints(kp, kp)
+	for _, m := range matches {
+		if m[0] != m[2] || m[1] != m[3] {
+			t.Errorf("keypoint (%v, %v) matched (%v, %v)", m[0], m[1], m[2], m[3])
+		}
+	}
+}
+
+func TestRansacIgnoresOutliers(t *testing.T) {
+	want := [6]float64{0.98, -0.17, 12, 0.17, 0.98, -5}
+	rng := rand.New(rand.NewSource(3))
+	var matches [][4]float64
+	for i := 0; i < 60; i++ {
+		x, y := rng.Float64()*300, rng.Float64()*300
+		tx, ty := apply(want, x, y)
+		matches = append(matches, [4]float64{x, y, tx, ty})
+	}
+	for i := 0; i < 30; i++ {
+		matches = append(matches, [4]float64{rng.Float64() * 300, rng.Float64() * 300, rng.Float64() * 300, rng.Float64() * 300})
+	}
+
+	got, inliers := ransacAffine(matches)
+	if len(inliers) < 60 {
+		t.Errorf("inliers = %d, want at least 60", len(inliers))
+	}
+	for i := range got {
+		if math.Abs(got[i]-want[i]) > 1e-6 {
+			t.Fatalf("transform = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestPlausible(t *testing.T) {
+	for name, c := range map[string]struct {
+		t    [6]float64
+		want bool
+	}{
+		"identity":       {[6]float64{1, 0, 5, 0, 1, -3}, true},
+		"rotation":       {[6]float64{math.Cos(0.3), -math.Sin(0.3), 0, math.Sin(0.3), math.Cos(0.3), 0}, true},
+		"slight scale":   {[6]float64{1.2, 0, 0, 0, 1.1, 0}, true},
+		"mirror":         {[6]float64{-1, 0, 0, 0, 1, 0}, false},
+		"shrink":         {[6]float64{0.4, 0, 0, 0, 0.4, 0}, false},
+		"stretch x only": {[6]float64{3, 0, 0, 0, 0.5, 0}, false},
+		"shear":          {[6]float64{1, 0.6, 0, 0, 1, 0}, false},
+	} {
+		if got := plausible(c.t); got != c.want {
+			t.Errorf("%s: plausible = %v, want %v", name, got, c.want)
+		}
+	}
+}
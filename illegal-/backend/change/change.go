@@ -0,0 +1,258 @@
+// Package change detects differences between two images of the same scene,
+// such as satellite or drone captures of a site taken on different dates
+package change
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif" // register decoders for Decode
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+
+	"backend/media"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Verdicts summarising a comparison, matching the thresholds of the old
+// comparison script
+const (
+	VerdictIdentical = "identical"
+	VerdictSimilar   = "similar"
+	VerdictDifferent = "different"
+)
+
+// Options tune a comparison; zero values use the defaults
+type Options struct {
+	Size           int     // longer side both images are scaled to (default 512)
+	Window         int     // SSIM window width in pixels, odd (default 7)
+	PixelThreshold float64 // mean grey-level difference that marks a pixel changed (default 40)
+	Align          bool    // register the before image onto the after image first
+}
+
+func (o Options) withDefaults() Options {
+	if o.Size <= 0 {
+		o.Size = 512
+	}
+	if o.Window <= 1 {
+		o.Window = 7
+	}
+	if o.Window%2 == 0 {
+		o.Window++
+	}
+	if o.PixelThreshold <= 0 {
+		o.PixelThreshold = 40
+	}
+	return o
+}
+
+// Result is the outcome of comparing two images
+type Result struct {
+	Score        float64 `json:"score"`        // mean structural similarity, 1 for identical images
+	ChangedRatio float64 `json:"changedRatio"` // share of pixels in the difference mask
+	Verdict      string  `json:"verdict"`
+	Width        int     `json:"width"`
+	Height       int     `json:"height"`
+
+	Alignment *Alignment `json:"alignment,omitempty"` // set when Options.Align is on
+
+	Heatmap image.Image `json:"-"` // dissimilarity colour ramp over the after image
+	Mask    image.Image `json:"-"` // changed pixels in red on a transparent background
+}
+
+// Decode reads a JPEG, PNG, GIF or WebP image. The size is read from the
+// header first, so images over media.MaxImagePixels are never decoded.
+func Decode(r io.Reader) (image.Image, error) {
+	var header bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(r, &header))
+	if err != nil {
+		return nil, errors.New("unsupported or corrupt image")
+	}
+	if err := media.CheckPixels(cfg.Width, cfg.Height); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(io.MultiReader(&header, r))
+	if err != nil {
+		return nil, errors.New("unsupported or corrupt image")
+	}
+	return img, nil
+}
+
+// Compare scales both images to the after image's aspect ratio, converts them
+// to greyscale, optionally aligns them and computes the SSIM map and
+// pixel-difference mask. Pixels the aligned before image does not cover are
+// left out of the score and the mask.
+func Compare(before, after image.Image, opt Options) (*Result, error) {
+	opt = opt.withDefaults()
+	ab := after.Bounds()
+	if ab.Dx() == 0 || ab.Dy() == 0 || before.Bounds().Dx() == 0 || before.Bounds().Dy() == 0 {
+		return nil, errors.New("image is empty")
+	}
+
+	w, h := opt.Size, opt.Size
+	if ab.Dx() > ab.Dy() {
+		h = int(math.Max(1, math.Round(float64(opt.Size)*float64(ab.Dy())/float64(ab.Dx()))))
+	} else {
+		w = int(math.Max(1, math.Round(float64(opt.Size)*float64(ab.Dx())/float64(ab.Dy()))))
+	}
+	if w < opt.Window || h < opt.Window {
+		return nil, errors.New("image is too small to compare")
+	}
+
+	a := grey(before, w, h)
+	b := grey(after, w, h)
+	var valid []bool
+	var alignment *Alignment
+	if opt.Align {
+		var report Alignment
+		a, valid, report = align(a, b, w, h)
+		alignment = &report
+	}
+	ssim, meanA, meanB := ssimMap(a, b, w, h, opt.Window)
+
+	// the mean score ignores the border where the window is clipped
+	pad := opt.Window / 2
+	var sum float64
+	n := 0
+	for y := pad; y < h-pad; y++ {
+		for x := pad; x < w-pad; x++ {
+			if valid == nil || valid[y*w+x] {
+				sum += ssim[y*w+x]
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return nil, errors.New("images do not overlap after alignment")
+	}
+
+	res := &Result{Score: sum / float64(n), Width: w, Height: h, Alignment: alignment}
+	switch {
+	case res.Score >= 0.9999:
+		res.Verdict = VerdictIdentical
+	case res.Score > 0.8:
+		res.Verdict = VerdictSimilar
+	default:
+		res.Verdict = VerdictDifferent
+	}
+
+	heatmap := image.NewNRGBA(image.Rect(0, 0, w, h))
+	mask := image.NewNRGBA(image.Rect(0, 0, w, h))
+	changed, covered := 0, 0
+	for i := range ssim {
+		x, y := i%w, i/w
+		if valid != nil && !valid[i] {
+			heatmap.SetNRGBA(x, y, blend(b[i], color.NRGBA{}, 0))
+			continue
+		}
+		covered++
+		d := math.Max(0, math.Min(1, 1-ssim[i]))
+		heatmap.SetNRGBA(x, y, blend(b[i], ramp(d), 0.75*d))
+		// compare window means rather than raw pixels so sensor noise and
+		// slight misalignment do not speckle the mask
+		if math.Abs(meanA[i]-meanB[i]) > opt.PixelThreshold {
+			mask.SetNRGBA(x, y, color.NRGBA{R: 255, A: 200})
+			changed++
+		}
+	}
+	res.ChangedRatio = float64(changed) / float64(covered)
+	res.Heatmap, res.Mask = heatmap, mask
+	return res, nil
+}
+
+// grey scales img to w×h and returns its luma (ITU-R 601) as 0-255 floats
+func grey(img image.Image, w, h int) []float64 {
+	scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, img.Bounds(), draw.Src, nil)
+	out := make([]float64, w*h)
+	for i := range out {
+		p := scaled.Pix[i*4 : i*4+3]
+		out[i] = 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+	}
+	return out
+}
+
+// SSIM stabilising constants for 8-bit data
+const (
+	c1 = (0.01 * 255) * (0.01 * 255)
+	c2 = (0.03 * 255) * (0.03 * 255)
+)
+
+// ssimMap computes the local SSIM of every pixel over a window×window box,
+// clipped at the edges, using summed-area tables. It also returns the window
+// means of both images.
+func ssimMap(a, b []float64, w, h, window int) ([]float64, []float64, []float64) {
+	sa := integral(w, h, func(i int) float64 { return a[i] })
+	sb := integral(w, h, func(i int) float64 { return b[i] })
+	saa := integral(w, h, func(i int) float64 { return a[i] * a[i] })
+	sbb := integral(w, h, func(i int) float64 { return b[i] * b[i] })
+	sab := integral(w, h, func(i int) float64 { return a[i] * b[i] })
+
+	ssim := make([]float64, w*h)
+	meanA := make([]float64, w*h)
+	meanB := make([]float64, w*h)
+	pad := window / 2
+	for y := 0; y < h; y++ {
+		y0, y1 := max(0, y-pad), min(h, y+pad+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := max(0, x-pad), min(w, x+pad+1)
+			n := float64((x1 - x0) * (y1 - y0))
+
+			ua := boxSum(sa, w, x0, y0, x1, y1) / n
+			ub := boxSum(sb, w, x0, y0, x1, y1) / n
+			// sample (co)variances, as scikit-image computes them
+			norm := n / (n - 1)
+			va := (boxSum(saa, w, x0, y0, x1, y1)/n - ua*ua) * norm
+			vb := (boxSum(sbb, w, x0, y0, x1, y1)/n - ub*ub) * norm
+			cov := (boxSum(sab, w, x0, y0, x1, y1)/n - ua*ub) * norm
+
+			i := y*w + x
+			ssim[i] = ((2*ua*ub + c1) * (2*cov + c2)) / ((ua*ua + ub*ub + c1) * (va + vb + c2))
+			meanA[i], meanB[i] = ua, ub
+		}
+	}
+	return ssim, meanA, meanB
+}
+
+// integral builds the (w+1)×(h+1) summed-area table of value over a w×h grid
+func integral(w, h int, value func(i int) float64) []float64 {
+	s := make([]float64, (w+1)*(h+1))
+	for y := 0; y < h; y++ {
+		row := 0.0
+		for x := 0; x < w; x++ {
+			row += value(y*w + x)
+			s[(y+1)*(w+1)+x+1] = s[y*(w+1)+x+1] + row
+		}
+	}
+	return s
+}
+
+// boxSum reads the sum over [x0,x1)×[y0,y1) from a summed-area table
+func boxSum(s []float64, w, x0, y0, x1, y1 int) float64 {
+	stride := w + 1
+	return s[y1*stride+x1] - s[y0*stride+x1] - s[y1*stride+x0] + s[y0*stride+x0]
+}
+
+// ramp maps 0..1 to blue, green, yellow, red
+func ramp(d float64) color.NRGBA {
+	stops := []color.NRGBA{{0, 0, 255, 255}, {0, 200, 0, 255}, {255, 220, 0, 255}, {255, 0, 0, 255}}
+	pos := d * float64(len(stops)-1)
+	i := int(pos)
+	if i >= len(stops)-1 {
+		return stops[len(stops)-1]
+	}
+	t := pos - float64(i)
+	lerp := func(p, q uint8) uint8 { return uint8(float64(p) + (float64(q)-float64(p))*t) }
+	return color.NRGBA{lerp(stops[i].R, stops[i+1].R), lerp(stops[i].G, stops[i+1].G), lerp(stops[i].B, stops[i+1].B), 255}
+}
+
+// blend lays c with opacity alpha over a grey level
+func blend(grey float64, c color.NRGBA, alpha float64) color.NRGBA {
+	mix := func(v uint8) uint8 { return uint8(grey*(1-alpha) + float64(v)*alpha) }
+	return color.NRGBA{mix(c.R), mix(c.G), mix(c.B), 255}
+}
@@ -0,0 +1,120 @@
+package change
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// greyImage wraps 0-255 values as a w×h image
+func greyImage(v []float64, w, h int) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for i, p := range v {
+		img.Pix[i] = uint8(p)
+	}
+	return img
+}
+
+func TestCompareIdentical(t *testing.T) {
+	const w, h = 96, 64
+	img := greyImage(texture(w, h), w, h)
+	res, err := Compare(img, img, Options{Size: w})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(res.Score-1) > 1e-9 || res.Verdict != VerdictIdentical {
+		t.Errorf("identical pair = score %v, verdict %s; want 1, identical", res.Score, res.Verdict)
+	}
+	if res.ChangedRatio != 0 {
+		t.Errorf("identical pair changed ratio = %v, want 0", res.ChangedRatio)
+	}
+	if res.Width != w || res.Height != h {
+		t.Errorf("compared at %d×%d, want %d×%d", res.Width, res.Height, w, h)
+	}
+}
+
+func TestCompareFindsChangedSquare(t *testing.T) {
+	const w, h, side = 96, 96, 24
+	before := make([]float64, w*h)
+	for i := range before {
+		before[i] = 60
+	}
+	after := append([]float64(nil), before...)
+	for y := 36; y < 36+side; y++ {
+		for x := 36; x < 36+side; x++ {
+			after[y*w+x] = 220
+		}
+	}
+
+	res, err := Compare(greyImage(before, w, h), greyImage(after, w, h), Options{Size: w})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Verdict == VerdictIdentical {
+		t.Errorf("changed pair verdict = %s (score %v)", res.Verdict, res.Score)
+	}
+	mask := res.Mask.(*image.NRGBA)
+	if mask.NRGBAAt(48, 48).A == 0 {
+		t.Error("centre of the changed square is not in the mask")
+	}
+	if mask.NRGBAAt(5, 5).A != 0 || mask.NRGBAAt(90, 90).A != 0 {
+		t.Error("unchanged corners are in the mask")
+	}
+	// window means blur the square's edge by at most half a window
+	pad := 7 / 2
+	lo := float64((side-2*pad)*(side-2*pad)) / (w * h)
+	hi := float64((side+2*pad)*(side+2*pad)) / (w * h)
+	if res.ChangedRatio < lo || res.ChangedRatio > hi {
+		t.Errorf("changed ratio = %v, want between %v and %v", res.ChangedRatio, lo, hi)
+	}
+}
+
+// bruteSSIM computes the SSIM at (x, y) directly over the clipped window
+func bruteSSIM(a, b []float64, w, h, window, x, y int) float64 {
+	pad := window / 2
+	var pa, pb []float64
+	for yy := max(0, y-pad); yy < min(h, y+pad+1); yy++ {
+		for xx := max(0, x-pad); xx < min(w, x+pad+1); xx++ {
+			pa = append(pa, a[yy*w+xx])
+			pb = append(pb, b[yy*w+xx])
+		}
+	}
+	n := float64(len(pa))
+	var ua, ub float64
+	for i := range pa {
+		ua += pa[i]
+		ub += pb[i]
+	}
+	ua, ub = ua/n, ub/n
+	var va, vb, cov float64
+	for i := range pa {
+		va += (pa[i] - ua) * (pa[i] - ua)
+		vb += (pb[i] - ub) * (pb[i] - ub)
+		cov += (pa[i] - ua) * (pb[i] - ub)
+	}
+	va, vb, cov = va/(n-1), vb/(n-1), cov/(n-1)
+	return ((2*ua*ub + c1) * (2*cov + c2)) / ((ua*ua + ub*ub + c1) * (va + vb + c2))
+}
+
+func TestSSIMMapMatchesBruteForce(t *testing.T) {
+	const w, h, window = 11, 8, 5
+	rng := rand.New(rand.NewSource(3))
+	a, b := make([]float64, w*h), make([]float64, w*h)
+	for i := range a {
+		a[i] = float64(rng.Intn(256))
+		b[i] = math.Min(255, a[i]*0.7+float64(rng.Intn(80)))
+	}
+	ssim, meanA, _ := ssimMap(a, b, w, h, window)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			want := bruteSSIM(a, b, w, h, window, x, y)
+			if got := ssim[y*w+x]; math.Abs(got-want) > 1e-9 {
+				t.Fatalf("ssim at (%d, %d) = %v, want %v", x, y, got, want)
+			}
+		}
+	}
+	if math.Abs(meanA[0]-(a[0]+a[1]+a[2]+a[w]+a[w+1]+a[w+2]+a[2*w]+a[2*w+1]+a[2*w+2])/9) > 1e-9 {
+		t.Errorf("corner window mean = %v", meanA[0])
+	}
+}
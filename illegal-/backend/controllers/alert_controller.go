@@ -1,55 +1,307 @@
 package controllers
 
 import (
+	"backend/alerts"
 	"backend/models"
 	"backend/utils"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 	"github.com/gorilla/mux"
 )
 
+// GetAlerts returns the alerts addressed to the calling officer or admin,
+// newest first, with the caller's own read, acknowledged and snoozed state.
+// Snoozed alerts are left out unless ?snoozed=true. Filters: ?unread=true,
+// ?status=, ?assigned=me|none|<user id>.
 func GetAlerts(w http.ResponseWriter, r *http.Request) {
-	var alerts []models.Alert
-	utils.DB.Find(&alerts)
-	json.NewEncoder(w).Encode(alerts)
+	user, ok := requireOfficer(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	q := alerts.Visible(utils.DB.Model(&models.Alert{}), user)
+	if query.Get("snoozed") != "true" {
+		q = alerts.NotSnoozed(q, user.ID, time.Now())
+	}
+	if query.Get("unread") == "true" {
+		q = alerts.Unread(q, user.ID)
+	}
+	if status := query.Get("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	switch assigned := query.Get("assigned"); assigned {
+	case "":
+	case "me":
+		q = q.Where("assignee_id = ?", user.ID)
+	case "none":
+		q = q.Where("assignee_id IS NULL")
+	default:
+		id, err := strconv.Atoi(assigned)
+		if err != nil {
+			writeJSONError(w, "assigned must be me, none or a user id", http.StatusBadRequest)
+			return
+		}
+		q = q.Where("assignee_id = ?", id)
+	}
+
+	var list []models.Alert
+	if err := q.Preload("Recipients").Order("created_at desc").Find(&list).Error; err != nil {
+		writeJSONError(w, "failed to fetch alerts", http.StatusInternalServerError)
+		return
+	}
+	if err := alerts.FillState(list, user.ID); err != nil {
+		writeJSONError(w, "failed to fetch alerts", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// findVisibleAlert loads the alert named in the path if it is addressed to
+// user
+func findVisibleAlert(w http.ResponseWriter, r *http.Request, user *models.User) (*models.Alert, bool) {
+	var alert models.Alert
+	err := alerts.Visible(utils.DB.Model(&models.Alert{}), user).Preload("Recipients").
+		Where("alerts.id = ?", mux.Vars(r)["id"]).Limit(1).Find(&alert).Error
+	if err != nil {
+		writeJSONError(w, "failed to fetch alert", http.StatusInternalServerError)
+		return nil, false
+	}
+	if alert.ID == 0 {
+		http.Error(w, "Alert not found", http.StatusNotFound)
+		return nil, false
+	}
+	return &alert, true
 }
 
+// writeAlert answers with an alert as user sees it
+func writeAlert(w http.ResponseWriter, alert *models.Alert, user *models.User) {
+	list := []models.Alert{*alert}
+	if err := alerts.FillState(list, user.ID); err != nil {
+		writeJSONError(w, "failed to fetch alert", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list[0])
+}
+
+// CreateAlert lets an officer raise an alert by hand; rules raise the rest
 func CreateAlert(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
 	var alert models.Alert
-	json.NewDecoder(r.Body).Decode(&alert)
-	utils.DB.Create(&alert)
+	if err := json.NewDecoder(r.Body).Decode(&alert); err != nil {
+		writeJSONError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	alert.ID, alert.RuleID, alert.DedupKey, alert.AssigneeID, alert.AssignedAt = 0, nil, nil, nil, nil
+	if err := alerts.ValidateRecipients(alert.Recipients); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	alert.Title = strings.TrimSpace(alert.Title)
+	if alert.Title == "" {
+		writeJSONError(w, "title is required", http.StatusBadRequest)
+		return
+	}
+	if alert.Severity == "" {
+		alert.Severity = "medium"
+	}
+	if !alerts.ValidSeverity(alert.Severity) {
+		writeJSONError(w, "severity must be one of "+strings.Join(alerts.Severities, ", "), http.StatusBadRequest)
+		return
+	}
+	alert.Status = alerts.StatusOpen
+	if err := utils.DB.Create(&alert).Error; err != nil {
+		writeJSONError(w, "failed to save alert", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(alert)
 }
 
-func MarkAlertRead(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
-
-	var alert models.Alert
-	if err := utils.DB.First(&alert, id).Error; err != nil {
-		http.Error(w, "Alert not found", http.StatusNotFound)
+// UpdateAlertStatus opens or resolves an alert with {"status": "open" |
+// "resolved"}. Rule matches join an alert only while it is open.
+func UpdateAlertStatus(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireOfficer(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSONError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.Status != alerts.StatusOpen && payload.Status != alerts.StatusResolved {
+		writeJSONError(w, "status must be open or resolved", http.StatusBadRequest)
+		return
+	}
+	alert, ok := findVisibleAlert(w, r, user)
+	if !ok {
 		return
 	}
+	// a resolved alert keeps its key until a new match takes it over, so
+	// reopening it before then lets matches join it again
+	if err := utils.DB.Model(alert).Update("status", payload.Status).Error; err != nil {
+		writeJSONError(w, "failed to update alert", http.StatusInternalServerError)
+		return
+	}
+	writeAlert(w, alert, user)
+}
 
-	alert.IsRead = true
-	utils.DB.Save(&alert)
+// MarkAlertRead marks an alert read for the caller only
+func MarkAlertRead(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireOfficer(w, r)
+	if !ok {
+		return
+	}
+	alert, ok := findVisibleAlert(w, r, user)
+	if !ok {
+		return
+	}
+	if err := alerts.MarkRead(user.ID, alert.ID); err != nil {
+		writeJSONError(w, "failed to update alert", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Alert marked as read"})
 }
 
+// MarkAllAlertsRead marks every alert addressed to the caller read for the
+// caller; other users' state is untouched
 func MarkAllAlertsRead(w http.ResponseWriter, r *http.Request) {
-	utils.DB.Model(&models.Alert{}).Update("is_read", true)
+	user, ok := requireOfficer(w, r)
+	if !ok {
+		return
+	}
+	var ids []uint
+	q := alerts.Unread(alerts.Visible(utils.DB.Model(&models.Alert{}), user), user.ID)
+	if err := q.Pluck("alerts.id", &ids).Error; err != nil {
+		writeJSONError(w, "failed to fetch alerts", http.StatusInternalServerError)
+		return
+	}
+	if err := alerts.MarkRead(user.ID, ids...); err != nil {
+		writeJSONError(w, "failed to update alerts", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "All alerts marked as read"})
 }
 
+// GetUnreadAlertsCount counts the alerts addressed to the calling officer
+// or admin that the caller has neither read nor snoozed
 func GetUnreadAlertsCount(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireOfficer(w, r)
+	if !ok {
+		return
+	}
 	var count int64
-	utils.DB.Model(&models.Alert{}).Where("is_read = ?", false).Count(&count)
+	q := alerts.Visible(utils.DB.Model(&models.Alert{}), user)
+	q = alerts.NotSnoozed(alerts.Unread(q, user.ID), user.ID, time.Now())
+	if err := q.Count(&count).Error; err != nil {
+		writeJSONError(w, "failed to count alerts", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]int64{"count": count})
 }
+
+// AcknowledgeAlert records that the caller has seen to an alert
+func AcknowledgeAlert(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireOfficer(w, r)
+	if !ok {
+		return
+	}
+	alert, ok := findVisibleAlert(w, r, user)
+	if !ok {
+		return
+	}
+	if err := alerts.Acknowledge(user.ID, alert.ID); err != nil {
+		writeJSONError(w, "failed to update alert", http.StatusInternalServerError)
+		return
+	}
+	writeAlert(w, alert, user)
+}
+
+// SnoozeAlert hides an alert from the caller until {"until": RFC 3339 time}
+// or for {"minutes": n}; an empty body wakes it again
+func SnoozeAlert(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireOfficer(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		Until   *time.Time `json:"until"`
+		Minutes int        `json:"minutes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && !errors.Is(err, io.EOF) {
+		writeJSONError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	until := payload.Until
+	if payload.Minutes > 0 {
+		t := time.Now().Add(time.Duration(payload.Minutes) * time.Minute)
+		until = &t
+	}
+	if until != nil && !until.After(time.Now()) {
+		writeJSONError(w, "until must be in the future", http.StatusBadRequest)
+		return
+	}
+	alert, ok := findVisibleAlert(w, r, user)
+	if !ok {
+		return
+	}
+	if err := alerts.Snooze(user.ID, alert.ID, until); err != nil {
+		writeJSONError(w, "failed to update alert", http.StatusInternalServerError)
+		return
+	}
+	writeAlert(w, alert, user)
+}
+
+// AssignAlert hands an alert to an officer with {"assignee_id": n}, or
+// takes it back with {"assignee_id": null}
+func AssignAlert(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireOfficer(w, r)
+	if !ok {
+		return
+	}
+	var payload struct {
+		AssigneeID *uint `json:"assignee_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSONError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.AssigneeID != nil {
+		var assignee models.User
+		if err := utils.DB.Where("id = ?", *payload.AssigneeID).Limit(1).Find(&assignee).Error; err != nil {
+			writeJSONError(w, "failed to fetch user", http.StatusInternalServerError)
+			return
+		}
+		if assignee.ID == 0 || (!strings.EqualFold(assignee.Role, "officer") && !strings.EqualFold(assignee.Role, "admin")) {
+			writeJSONError(w, "assignee must be an officer", http.StatusBadRequest)
+			return
+		}
+	}
+	alert, ok := findVisibleAlert(w, r, user)
+	if !ok {
+		return
+	}
+	if err := alerts.Assign(alert, payload.AssigneeID); err != nil {
+		writeJSONError(w, "failed to assign alert", http.StatusInternalServerError)
+		return
+	}
+	writeAlert(w, alert, user)
+}
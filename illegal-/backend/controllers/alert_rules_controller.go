@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"backend/alerts"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/gorilla/mux"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// alertRuleRequest is the body of rule creation and updates; enabled
+// defaults to true
+type alertRuleRequest struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Event       string          `json:"event"`
+	Conditions  json.RawMessage `json:"conditions"`
+	Severity    string          `json:"severity"`
+	Recipients  json.RawMessage `json:"recipients"`
+	Enabled     *bool           `json:"enabled"`
+}
+
+func (req alertRuleRequest) apply(rule *models.AlertRule) {
+	rule.Name, rule.Description, rule.Event = req.Name, req.Description, req.Event
+	rule.Conditions = datatypes.JSON(req.Conditions)
+	rule.Severity = req.Severity
+	rule.Recipients = datatypes.JSON(req.Recipients)
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+}
+
+// GetAlertRules lists the alert rules, with the condition types available
+func GetAlertRules(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	var rules []models.AlertRule
+	if err := utils.DB.Order("id").Find(&rules).Error; err != nil {
+		writeJSONError(w, "failed to fetch alert rules", http.StatusInternalServerError)
+		return
+	}
+	types := map[string][]string{}
+	for name, t := range alerts.ConditionTypes {
+		types[name] = t.Events
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"rules":           rules,
+		"condition_types": types, // name -> events it applies to
+		"severities":      alerts.Severities,
+	})
+}
+
+// CreateAlertRule adds a rule evaluated on every later event of its type
+func CreateAlertRule(w http.ResponseWriter, r *http.Request) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	var req alertRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	rule := models.AlertRule{CreatedBy: &admin.ID}
+	req.apply(&rule)
+	if err := alerts.Validate(&rule); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := utils.DB.Create(&rule).Error; err != nil {
+		writeJSONError(w, "failed to save alert rule", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rule)
+}
+
+// UpdateAlertRule replaces a rule; alerts it already raised are kept
+func UpdateAlertRule(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	rule, ok := findAlertRule(w, r)
+	if !ok {
+		return
+	}
+	var req alertRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	req.apply(rule)
+	if err := alerts.Validate(rule); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := utils.DB.Save(rule).Error; err != nil {
+		writeJSONError(w, "failed to save alert rule", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rule)
+}
+
+// DeleteAlertRule removes a rule; alerts it raised are kept
+func DeleteAlertRule(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	rule, ok := findAlertRule(w, r)
+	if !ok {
+		return
+	}
+	if err := utils.DB.Delete(rule).Error; err != nil {
+		writeJSONError(w, "failed to delete alert rule", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func findAlertRule(w http.ResponseWriter, r *http.Request) (*models.AlertRule, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeJSONError(w, "invalid id", http.StatusBadRequest)
+		return nil, false
+	}
+	var rule models.AlertRule
+	err = utils.DB.First(&rule, id).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		writeJSONError(w, "alert rule not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		writeJSONError(w, "failed to fetch alert rule", http.StatusInternalServerError)
+		return nil, false
+	}
+	return &rule, true
+}
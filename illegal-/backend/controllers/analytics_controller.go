@@ -3,7 +3,11 @@ package controllers
 import (
     "encoding/json"
     "net/http"
+    "sort"
+    "strconv"
     "time"
+    "backend/aggregate"
+    "backend/geo"
     "backend/models"
     "backend/utils"
 )
@@ -123,28 +127,98 @@ func GetEncroachmentsByRegion(w http.ResponseWriter, r *http.Request) {
     var constructions []models.Construction
     utils.DB.Find(&constructions)
 
-    // For now, group by location (assuming location is the region)
-    regionCounts := make(map[string]int)
+    // Group by the imported ward each construction falls in
+    wardCounts := make(map[uint]int)
+    unassigned := 0
+    var unassignedLat, unassignedLng float64
     for _, construction := range constructions {
-        regionCounts[construction.Location]++
+        if construction.WardID == nil {
+            unassigned++
+            unassignedLat += construction.Latitude
+            unassignedLng += construction.Longitude
+            continue
+        }
+        wardCounts[*construction.WardID]++
+    }
+
+    wardIDs := make([]uint, 0, len(wardCounts))
+    for id := range wardCounts {
+        wardIDs = append(wardIDs, id)
+    }
+    var wards []models.LayerFeature
+    if len(wardIDs) > 0 {
+        utils.DB.Where("id IN ?", wardIDs).Find(&wards)
     }
 
-    var regions []RegionData
-    for region, count := range regionCounts {
+    regions := []RegionData{}
+    for _, ward := range wards {
         regionData := RegionData{
-            Region: region,
-            Count:  count,
-            Coordinates: struct {
-                Lat float64 `json:"lat"`
-                Lng float64 `json:"lng"`
-            }{
-                Lat: 0.0, // Default coordinates
-                Lng: 0.0,
-            },
+            Region: ward.Name,
+            Count:  wardCounts[ward.ID],
+        }
+        if g, err := geo.DecodeGeometry(ward.Geometry); err == nil {
+            regionData.Coordinates.Lat, regionData.Coordinates.Lng = geo.Centroid(g)
         }
         regions = append(regions, regionData)
     }
+    sort.Slice(regions, func(i, j int) bool { return regions[i].Count > regions[j].Count })
+
+    if unassigned > 0 {
+        regionData := RegionData{Region: "Unassigned", Count: unassigned}
+        regionData.Coordinates.Lat = unassignedLat / float64(unassigned)
+        regionData.Coordinates.Lng = unassignedLng / float64(unassigned)
+        regions = append(regions, regionData)
+    }
 
     w.Header().Set("Content-Type", "application/json")
     json.NewEncoder(w).Encode(regions)
-} 
\ No newline at end of file
+}
+
+// GetDailyStats returns the rolled-up daily counts of ?metric= (reports,
+// constructions, detections or complaints) between ?from= and ?to=
+// (YYYY-MM-DD, default the last 30 days), optionally for one ?ward_id=
+func GetDailyStats(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    metric := q.Get("metric")
+    switch metric {
+    case aggregate.MetricReports, aggregate.MetricConstructions, aggregate.MetricDetections, aggregate.MetricComplaints:
+    default:
+        writeJSONError(w, "metric must be reports, constructions, detections or complaints", http.StatusBadRequest)
+        return
+    }
+
+    to := time.Now()
+    from := to.AddDate(0, 0, -30)
+    var err error
+    if v := q.Get("from"); v != "" {
+        if from, err = time.Parse("2006-01-02", v); err != nil {
+            writeJSONError(w, "from must be YYYY-MM-DD", http.StatusBadRequest)
+            return
+        }
+    }
+    if v := q.Get("to"); v != "" {
+        if to, err = time.Parse("2006-01-02", v); err != nil {
+            writeJSONError(w, "to must be YYYY-MM-DD", http.StatusBadRequest)
+            return
+        }
+    }
+
+    var wardID *uint
+    if v := q.Get("ward_id"); v != "" {
+        id, err := strconv.Atoi(v)
+        if err != nil || id < 0 {
+            writeJSONError(w, "invalid ward_id", http.StatusBadRequest)
+            return
+        }
+        ward := uint(id)
+        wardID = &ward
+    }
+
+    stats, err := aggregate.DailyStats(metric, from.Format("2006-01-02"), to.Format("2006-01-02"), wardID)
+    if err != nil {
+        writeJSONError(w, "failed to fetch daily stats", http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(stats)
+}
@@ -4,6 +4,7 @@ import (
 	"backend/models"
 	"backend/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
@@ -133,3 +134,57 @@ func VerifyToken(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]bool{"valid": true})
 }
+
+// currentUser resolves the caller from the Bearer token in the Authorization header
+func currentUser(r *http.Request) (*models.User, error) {
+	authHeader := r.Header.Get("Authorization")
+	if len(authHeader) < 7 || authHeader[:7] != "Bearer " {
+		return nil, errors.New("missing bearer token")
+	}
+
+	claims := jwt.MapClaims{}
+	token, err := jwt.ParseWithClaims(authHeader[7:], claims, func(token *jwt.Token) (interface{}, error) {
+		return jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	id, ok := claims["user_id"].(float64)
+	if !ok {
+		return nil, errors.New("token has no user")
+	}
+	var user models.User
+	if err := utils.DB.First(&user, uint(id)).Error; err != nil {
+		return nil, errors.New("user not found")
+	}
+	return &user, nil
+}
+
+// requireOfficer writes an error and returns false unless the caller is an officer or admin
+func requireOfficer(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	if !strings.EqualFold(user.Role, "officer") && !strings.EqualFold(user.Role, "admin") {
+		writeJSONError(w, "Only officers can perform this action", http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
+
+// requireAdmin writes an error and returns false unless the caller is an admin
+func requireAdmin(w http.ResponseWriter, r *http.Request) (*models.User, bool) {
+	user, err := currentUser(r)
+	if err != nil {
+		writeJSONError(w, "Unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+	if !strings.EqualFold(user.Role, "admin") {
+		writeJSONError(w, "Only admins can perform this action", http.StatusForbidden)
+		return nil, false
+	}
+	return user, true
+}
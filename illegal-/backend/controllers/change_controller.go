@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+
+	"backend/change"
+	"backend/imagery"
+	"backend/models"
+	"backend/utils"
+)
+
+// CompareImages runs change detection between a before and an after image.
+// Each image is either a multipart file (before, after) or the URL of an
+// earlier upload or remote image (before_url, after_url). With
+// construction_id the heatmap and score are stored on that construction.
+// The before image is aligned to the after image first unless align=false.
+func CompareImages(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		writeJSONError(w, "failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	var construction *models.Construction
+	if idStr := r.FormValue("construction_id"); idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			writeJSONError(w, "invalid construction_id", http.StatusBadRequest)
+			return
+		}
+		construction = &models.Construction{}
+		if err := utils.DB.First(construction, id).Error; err != nil {
+			writeJSONError(w, "construction not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	before, err := formImage(r, "before")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after, err := formImage(r, "after")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// alignment is on unless the caller knows the images are registered
+	opt := change.Options{Align: r.FormValue("align") != "false"}
+	if v := r.FormValue("threshold"); v != "" {
+		if opt.PixelThreshold, err = strconv.ParseFloat(v, 64); err != nil || opt.PixelThreshold <= 0 || opt.PixelThreshold > 255 {
+			writeJSONError(w, "threshold must be between 0 and 255", http.StatusBadRequest)
+			return
+		}
+	}
+
+	res, err := change.Compare(before, after, opt)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	heatmapURL, err := imagery.SavePNG(res.Heatmap, imagery.ComparisonsDir)
+	if err != nil {
+		writeJSONError(w, "failed to store heatmap", http.StatusInternalServerError)
+		return
+	}
+	maskURL, err := imagery.SavePNG(res.Mask, imagery.ComparisonsDir)
+	if err != nil {
+		writeJSONError(w, "failed to store mask", http.StatusInternalServerError)
+		return
+	}
+
+	if construction != nil {
+		score := res.Score
+		err := utils.DB.Model(construction).Updates(map[string]interface{}{
+			"comparison_image_url": heatmapURL,
+			"change_score":         &score,
+		}).Error
+		if err != nil {
+			writeJSONError(w, "failed to update construction", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		*change.Result
+		HeatmapURL models.BlobRef `json:"heatmapUrl"`
+		MaskURL    models.BlobRef `json:"maskUrl"`
+	}{res, heatmapURL, maskURL})
+}
+
+// formImage decodes the named multipart file, or the image at the URL in the
+// <name>_url field
+func formImage(r *http.Request, name string) (image.Image, error) {
+	if src, _, err := r.FormFile(name); err == nil {
+		defer src.Close()
+		img, err := change.Decode(src)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		return img, nil
+	}
+
+	url := r.FormValue(name + "_url")
+	if url == "" {
+		return nil, fmt.Errorf("%s or %s_url is required", name, name)
+	}
+	img, err := imagery.Open(url)
+	if err != nil {
+		return nil, fmt.Errorf("%s_url: %v", name, err)
+	}
+	return img, nil
+}
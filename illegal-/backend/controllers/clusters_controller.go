@@ -0,0 +1,194 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/aggregate"
+
+	"github.com/paulmach/orb"
+)
+
+// parseBBox reads ?bbox=minLng,minLat,maxLng,maxLat
+func parseBBox(r *http.Request) (orb.Bound, error) {
+	parts := strings.Split(r.URL.Query().Get("bbox"), ",")
+	if len(parts) != 4 {
+		return orb.Bound{}, errors.New("bbox must be minLng,minLat,maxLng,maxLat")
+	}
+	var v [4]float64
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return orb.Bound{}, errors.New("bbox must be minLng,minLat,maxLng,maxLat")
+		}
+		v[i] = f
+	}
+	if v[0] >= v[2] || v[1] >= v[3] || v[1] < -90 || v[3] > 90 || v[0] < -180 || v[2] > 180 {
+		return orb.Bound{}, errors.New("bbox is out of range or empty")
+	}
+	return orb.Bound{Min: orb.Point{v[0], v[1]}, Max: orb.Point{v[2], v[3]}}, nil
+}
+
+// aggregateFilter reads the shared ?layers=, ?status= and ?priority= filters
+func aggregateFilter(r *http.Request) aggregate.Filter {
+	return aggregate.Filter{
+		Layers:     splitList(r, "layers"),
+		Statuses:   splitList(r, "status"),
+		Priorities: splitList(r, "priority"),
+	}
+}
+
+// GetMapClusters returns clustered counts of constructions and reports for
+// ?bbox= at ?zoom=. Optional: ?mode=grid|hex, ?radius= cluster size in pixels.
+func GetMapClusters(w http.ResponseWriter, r *http.Request) {
+	bound, err := parseBBox(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	zoom, err := strconv.Atoi(r.URL.Query().Get("zoom"))
+	if err != nil || zoom < 0 || zoom > 22 {
+		writeJSONError(w, "zoom must be between 0 and 22", http.StatusBadRequest)
+		return
+	}
+
+	mode := r.URL.Query().Get("mode")
+	if mode == "" {
+		mode = aggregate.ModeGrid
+	}
+	if mode != aggregate.ModeGrid && mode != aggregate.ModeHex {
+		writeJSONError(w, "mode must be grid or hex", http.StatusBadRequest)
+		return
+	}
+
+	radius := 60.0
+	if v := r.URL.Query().Get("radius"); v != "" {
+		if radius, err = strconv.ParseFloat(v, 64); err != nil || radius < 10 || radius > 512 {
+			writeJSONError(w, "radius must be between 10 and 512 pixels", http.StatusBadRequest)
+			return
+		}
+	}
+
+	clusters, err := aggregate.Clusters(bound, zoom, radius, mode, aggregateFilter(r))
+	if err != nil {
+		writeJSONError(w, "failed to cluster points", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"zoom":     zoom,
+		"mode":     mode,
+		"clusters": clusters,
+	})
+}
+
+// GetHeatmap returns a density grid over ?bbox= with ?cells= columns
+// (default 64) for the analytics page
+func GetHeatmap(w http.ResponseWriter, r *http.Request) {
+	bound, err := parseBBox(r)
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cells := 64
+	if v := r.URL.Query().Get("cells"); v != "" {
+		if cells, err = strconv.Atoi(v); err != nil || cells < 1 || cells > 512 {
+			writeJSONError(w, "cells must be between 1 and 512", http.StatusBadRequest)
+			return
+		}
+	}
+
+	heatmap, err := aggregate.BuildHeatmap(bound, cells, aggregateFilter(r))
+	if err != nil {
+		writeJSONError(w, "failed to build heatmap", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(heatmap)
+}
+
+// GetHotspots runs a Getis-Ord Gi* hotspot analysis over constructions and
+// reports. The window is ?from=&to= (YYYY-MM-DD) or ?period=7d|30d|90d|1y
+// (default 30d) ending now; growth is measured against the window before it.
+// Optional: ?bbox=, ?cell= cell size in metres (default 250), ?z= minimum
+// z-score (default 1.96), plus the ?layers=, ?status= and ?priority= filters.
+func GetHotspots(w http.ResponseWriter, r *http.Request) {
+	params := aggregate.HotspotParams{
+		To:        time.Now(),
+		CellSize:  250,
+		MinZScore: 1.96,
+		Filter:    aggregateFilter(r),
+	}
+	q := r.URL.Query()
+
+	days := map[string]int{"7d": 7, "30d": 30, "90d": 90, "1y": 365}[q.Get("period")]
+	if days == 0 {
+		days = 30
+	}
+	params.From = params.To.AddDate(0, 0, -days)
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			writeJSONError(w, "from must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		params.From = t
+	}
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			writeJSONError(w, "to must be YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+		params.To = t.AddDate(0, 0, 1) // inclusive of the whole day
+	}
+
+	if q.Get("bbox") != "" {
+		bound, err := parseBBox(r)
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		params.Bound = &bound
+	}
+	if v := q.Get("cell"); v != "" {
+		cell, err := strconv.ParseFloat(v, 64)
+		if err != nil || cell < 10 || cell > 10000 {
+			writeJSONError(w, "cell must be between 10 and 10000 metres", http.StatusBadRequest)
+			return
+		}
+		params.CellSize = cell
+	}
+	if v := q.Get("z"); v != "" {
+		z, err := strconv.ParseFloat(v, 64)
+		if err != nil || z <= 0 {
+			writeJSONError(w, "z must be a positive number", http.StatusBadRequest)
+			return
+		}
+		params.MinZScore = z
+	}
+
+	hotspots, err := aggregate.Hotspots(params)
+	if errors.Is(err, aggregate.ErrEmptyWindow) || errors.Is(err, aggregate.ErrAreaTooLarge) {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "failed to detect hotspots", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"from":     params.From,
+		"to":       params.To,
+		"cellSize": params.CellSize,
+		"hotspots": hotspots,
+	})
+}
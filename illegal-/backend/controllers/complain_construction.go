@@ -4,6 +4,8 @@ package controllers
 import (
     "net/http"
     "encoding/json"
+    "log"
+    "backend/alerts"
     "backend/models"
     "backend/utils"
 )
@@ -17,7 +19,13 @@ func GetComplaints(w http.ResponseWriter, r *http.Request) {
 func CreateComplaint(w http.ResponseWriter, r *http.Request) {
     var complaint models.Complaint
     json.NewDecoder(r.Body).Decode(&complaint)
-    utils.DB.Create(&complaint)
+    if err := utils.DB.Create(&complaint).Error; err != nil {
+        http.Error(w, "failed to save complaint", http.StatusInternalServerError)
+        return
+    }
+    if err := alerts.Enqueue(nil, alerts.EventComplaint, complaint.ID); err != nil {
+        log.Printf("alerts: queueing complaint %d: %v", complaint.ID, err)
+    }
     w.WriteHeader(http.StatusCreated)
     json.NewEncoder(w).Encode(complaint)
 }
\ No newline at end of file
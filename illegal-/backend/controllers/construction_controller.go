@@ -5,8 +5,10 @@ import (
     "encoding/json"
     "strconv"
     "github.com/gorilla/mux"
+    "backend/geocode"
     "backend/models"
     "backend/utils"
+    "backend/zoning"
 )
 
 func GetConstructions(w http.ResponseWriter, r *http.Request) {
@@ -26,6 +28,16 @@ func GetConstruction(w http.ResponseWriter, r *http.Request) {
 func CreateConstruction(w http.ResponseWriter, r *http.Request) {
     var construction models.Construction
     json.NewDecoder(r.Body).Decode(&construction)
+    if err := geocode.ApplyConstruction(&construction); err != nil {
+        http.Error(w, "failed to geocode construction", http.StatusInternalServerError)
+        return
+    }
+    // Status comes from the zoning rules; officers override it separately
+    construction.StatusOverridden = false
+    if err := zoning.Apply(&construction); err != nil {
+        http.Error(w, "failed to evaluate zoning rules", http.StatusInternalServerError)
+        return
+    }
     utils.DB.Create(&construction)
     w.WriteHeader(http.StatusCreated)
     json.NewEncoder(w).Encode(construction)
@@ -36,7 +48,17 @@ func UpdateConstruction(w http.ResponseWriter, r *http.Request) {
     var construction models.Construction
     id, _ := strconv.Atoi(params["id"])
     utils.DB.First(&construction, id)
+    status, overridden := construction.Status, construction.StatusOverridden
     json.NewDecoder(r.Body).Decode(&construction)
+    construction.Status, construction.StatusOverridden = status, overridden
+    if err := geocode.ApplyConstruction(&construction); err != nil {
+        http.Error(w, "failed to geocode construction", http.StatusInternalServerError)
+        return
+    }
+    if err := zoning.Apply(&construction); err != nil {
+        http.Error(w, "failed to evaluate zoning rules", http.StatusInternalServerError)
+        return
+    }
     utils.DB.Save(&construction)
     json.NewEncoder(w).Encode(construction)
 }
@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"encoding/json"
+	"image"
+	"net/http"
+	"strconv"
+
+	"backend/change"
+	"backend/detect"
+	"backend/imagery"
+	"backend/models"
+	"backend/scenes"
+	"backend/utils"
+)
+
+// GetDetectors lists the registered detection models
+func GetDetectors(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detect.Names())
+}
+
+// RunDetectors runs one model (?detector=) or all of them over an image and
+// returns the detections stored. The image is either a scene tile
+// (?tile_id=) or a multipart file covering ?bbox=minLng,minLat,maxLng,maxLat
+// with north up. When a model fails, the detections stored before the
+// failure are returned along with the error.
+func RunDetectors(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	var detectors []detect.Detector
+	if name := r.URL.Query().Get("detector"); name != "" {
+		d, ok := detect.Get(name)
+		if !ok {
+			writeJSONError(w, "unknown detector", http.StatusBadRequest)
+			return
+		}
+		detectors = append(detectors, d)
+	} else {
+		for _, name := range detect.Names() {
+			d, _ := detect.Get(name)
+			detectors = append(detectors, d)
+		}
+	}
+	if len(detectors) == 0 {
+		writeJSONError(w, "no detectors are configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var opt detect.Options
+	var img image.Image
+	if v := r.URL.Query().Get("tile_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, "invalid tile_id", http.StatusBadRequest)
+			return
+		}
+		var tile models.SceneTile
+		if err := utils.DB.First(&tile, id).Error; err != nil {
+			writeJSONError(w, "tile not found", http.StatusNotFound)
+			return
+		}
+		if img, err = imagery.Open(string(tile.ImageURL)); err != nil {
+			writeJSONError(w, "failed to read tile image", http.StatusInternalServerError)
+			return
+		}
+		opt = detect.Options{Bound: scenes.CellBound(tile.GX, tile.GY), SceneID: &tile.SceneID, TileID: &tile.ID}
+	} else {
+		bound, err := parseBBox(r)
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		src, _, err := r.FormFile("file")
+		if err != nil {
+			writeJSONError(w, "file or tile_id is required", http.StatusBadRequest)
+			return
+		}
+		// the pixel count is checked before decoding
+		img, err = change.Decode(src)
+		src.Close()
+		if err != nil {
+			writeJSONError(w, "file: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		opt = detect.Options{Bound: bound}
+	}
+
+	detections := []models.Detection{}
+	for _, d := range detectors {
+		found, err := detect.Run(r.Context(), d, img, opt)
+		detections = append(detections, found...)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":      d.Name() + ": " + err.Error(),
+				"detections": detections,
+			})
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detections)
+}
@@ -0,0 +1,123 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"backend/dedup"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// GetReportDuplicates is the officers' review queue of reports whose photos
+// match an earlier report, filtered by ?status= (default pending).
+// ?limit= defaults to 100.
+func GetReportDuplicates(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			writeJSONError(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = dedup.StatusPending
+	}
+
+	var rows []models.ReportDuplicate
+	if err := utils.DB.Where("status = ?", status).Order("id desc").Limit(limit).Find(&rows).Error; err != nil {
+		writeJSONError(w, "failed to fetch duplicates", http.StatusInternalServerError)
+		return
+	}
+
+	// load both sides of every pair so the queue can show the photos
+	ids := []uint{}
+	for _, d := range rows {
+		ids = append(ids, d.ReportID, d.DuplicateOfID)
+	}
+	var reports []models.Report
+	if len(ids) > 0 {
+		if err := utils.DB.Where("id IN ?", ids).Find(&reports).Error; err != nil {
+			writeJSONError(w, "failed to fetch reports", http.StatusInternalServerError)
+			return
+		}
+	}
+	byID := map[uint]*models.Report{}
+	for i := range reports {
+		byID[reports[i].ID] = &reports[i]
+	}
+
+	type entry struct {
+		models.ReportDuplicate
+		Report      *models.Report `json:"report"`
+		DuplicateOf *models.Report `json:"duplicate_of"`
+	}
+	out := make([]entry, len(rows))
+	for i, d := range rows {
+		out[i] = entry{d, byID[d.ReportID], byID[d.DuplicateOfID]}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// ReviewReportDuplicate confirms or dismisses a possible duplicate with
+// {"status": "confirmed" | "dismissed"}
+func ReviewReportDuplicate(w http.ResponseWriter, r *http.Request) {
+	officer, ok := requireOfficer(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeJSONError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var payload struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSONError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	d, err := dedup.Review(uint(id), payload.Status, officer.ID)
+	switch {
+	case errors.Is(err, dedup.ErrInvalidStatus):
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		writeJSONError(w, "duplicate not found", http.StatusNotFound)
+		return
+	case err != nil:
+		writeJSONError(w, "failed to review duplicate", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d)
+}
+
+// markDuplicates fills in which earlier reports each report may duplicate
+func markDuplicates(reports []models.Report) {
+	ids := make([]uint, len(reports))
+	for i, r := range reports {
+		ids[i] = r.ID
+	}
+	dups, err := dedup.DuplicateOf(ids)
+	if err != nil {
+		return
+	}
+	for i := range reports {
+		reports[i].PossibleDuplicateOf = dups[reports[i].ID]
+	}
+}
@@ -20,8 +20,8 @@ type Encroachment struct {
     Confidence        float64 `json:"confidence"`
     Status            string  `json:"status"`
     Area              float64 `json:"area"`
-    SatelliteImageUrl string  `json:"satelliteImageUrl,omitempty"`
-    ComparisonImageUrl string `json:"comparisonImageUrl,omitempty"`
+    SatelliteImageUrl models.BlobRef  `json:"satelliteImageUrl,omitempty"`
+    ComparisonImageUrl models.BlobRef `json:"comparisonImageUrl,omitempty"`
 }
 
 func GetEncroachments(w http.ResponseWriter, r *http.Request) {
@@ -48,6 +48,8 @@ func GetEncroachments(w http.ResponseWriter, r *http.Request) {
             Confidence: 0.85, // Default confidence
             Status:     "new",
             Area:       100.0, // Default area
+            SatelliteImageUrl: construction.SatelliteImageURL,
+            ComparisonImageUrl: construction.ComparisonImageURL,
         }
         encroachments = append(encroachments, encroachment)
     }
@@ -80,6 +82,8 @@ func GetEncroachment(w http.ResponseWriter, r *http.Request) {
         Confidence: 0.85,
         Status:     "new",
         Area:       100.0,
+        SatelliteImageUrl: construction.SatelliteImageURL,
+        ComparisonImageUrl: construction.ComparisonImageURL,
     }
 
     w.Header().Set("Content-Type", "application/json")
@@ -149,6 +153,8 @@ func GetEncroachmentsByArea(w http.ResponseWriter, r *http.Request) {
             Confidence: 0.85,
             Status:     "new",
             Area:       100.0,
+            SatelliteImageUrl: construction.SatelliteImageURL,
+            ComparisonImageUrl: construction.ComparisonImageURL,
         }
         encroachments = append(encroachments, encroachment)
     }
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"backend/models"
+	"backend/storage"
+	"backend/utils"
+)
+
+// ServeFile serves stored files under /uploads/, with range requests for
+// large media. A request needs either a signed URL, as handed out in API
+// responses, or a caller allowed to see the record owning the file:
+// officers see every file, anyone else only the published renditions of
+// reports.
+func ServeFile(w http.ResponseWriter, r *http.Request) {
+	key, err := storage.KeyOf(r.URL.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch err := storage.VerifySigned(key, r.URL.Query()); {
+	case err == nil:
+	case errors.Is(err, storage.ErrUnsigned):
+		if !canSeeFile(r, key) {
+			http.Error(w, "not allowed to view this file", http.StatusForbidden)
+			return
+		}
+	default:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// signed URLs change as they are re-signed, so caching is left to the
+	// browser rather than shared caches
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	storage.Serve(w, r, key, "")
+}
+
+// canSeeFile reports whether the caller may fetch key without a signature
+func canSeeFile(r *http.Request, key string) bool {
+	if isOfficer(r) {
+		return true
+	}
+	if storage.Private(key) {
+		return false
+	}
+	// reports are public, and so are their published renditions
+	contains := func(rendition string) string {
+		match, _ := json.Marshal([]map[string]map[string]string{{rendition: {"key": key}}})
+		return string(match)
+	}
+	var n int64
+	err := utils.DB.Model(&models.Report{}).
+		Where("jsonb_typeof(images) = 'array'").
+		Where("images @> ? OR images @> ? OR images @> ?", contains("public"), contains("medium"), contains("thumbnail")).
+		Count(&n).Error
+	return err == nil && n > 0
+}
@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"backend/geocode"
+	"backend/jobs"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// ImportGazetteer handles multipart/form-data upload of address points as a
+// CSV file or an OSM XML extract (.osm). Fields: file and source, the name
+// the points are stored under; re-importing a source replaces it. Stored
+// reports and constructions without a street are geocoded afterwards by a
+// background job, or all of them when a source was replaced.
+func ImportGazetteer(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		writeJSONError(w, "failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	source := strings.TrimSpace(r.FormValue("source"))
+	if source == "" {
+		writeJSONError(w, "source is required", http.StatusBadRequest)
+		return
+	}
+
+	src, fh, err := r.FormFile("file")
+	if err != nil {
+		writeJSONError(w, "file is required", http.StatusBadRequest)
+		return
+	}
+	defer src.Close()
+
+	var entries []models.GazetteerEntry
+	var skipped int
+	switch strings.ToLower(filepath.Ext(fh.Filename)) {
+	case ".csv":
+		entries, skipped, err = geocode.ParseCSV(src)
+	case ".osm", ".xml":
+		entries, skipped, err = geocode.ParseOSM(src)
+	default:
+		writeJSONError(w, "file must be .csv or an OSM XML extract (.osm)", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(entries) == 0 {
+		writeJSONError(w, "file contains no address points", http.StatusBadRequest)
+		return
+	}
+
+	var existing int64
+	if err := utils.DB.Model(&models.GazetteerEntry{}).Where("source = ?", source).Count(&existing).Error; err != nil {
+		writeJSONError(w, "failed to save address points", http.StatusInternalServerError)
+		return
+	}
+	if err := geocode.Save(source, entries); err != nil {
+		writeJSONError(w, "failed to save address points", http.StatusInternalServerError)
+		return
+	}
+	// addresses taken from the replaced points may have changed
+	job, err := jobs.Enqueue(geocode.JobBackfill, map[string]bool{"all": existing > 0})
+	if err != nil {
+		writeJSONError(w, "address points saved but geocoding could not be queued", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"source":     source,
+		"imported":   len(entries),
+		"skipped":    skipped,
+		"geocodeJob": job,
+	})
+}
+
+// GetGazetteerSources lists imported sources with their address point counts
+func GetGazetteerSources(w http.ResponseWriter, r *http.Request) {
+	type sourceCount struct {
+		Source string `json:"source"`
+		Count  int    `json:"count"`
+	}
+	var sources []sourceCount
+	err := utils.DB.Model(&models.GazetteerEntry{}).
+		Select("source, COUNT(*) AS count").
+		Group("source").Order("source").
+		Scan(&sources).Error
+	if err != nil {
+		http.Error(w, "failed to fetch gazetteer", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sources)
+}
+
+// DeleteGazetteerSource removes the address points of one source and queues
+// geocoding of every report and construction, so none keeps an address taken
+// from the removed points
+func DeleteGazetteerSource(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	source := mux.Vars(r)["source"]
+	res := utils.DB.Where("source = ?", source).Delete(&models.GazetteerEntry{})
+	if res.Error != nil {
+		http.Error(w, "failed to delete source", http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		http.Error(w, "source not found", http.StatusNotFound)
+		return
+	}
+	job, err := jobs.Enqueue(geocode.JobBackfill, map[string]bool{"all": true})
+	writeQueued(w, job, err)
+}
+
+// ReverseGeocode returns the nearest address to ?lat=&lng=
+func ReverseGeocode(w http.ResponseWriter, r *http.Request) {
+	lat, errLat := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+	lng, errLng := strconv.ParseFloat(r.URL.Query().Get("lng"), 64)
+	if errLat != nil || errLng != nil || lat < -90 || lat > 90 || lng < -180 || lng > 180 {
+		http.Error(w, "lat and lng are required", http.StatusBadRequest)
+		return
+	}
+
+	res, err := geocode.Reverse(lat, lng)
+	if err != nil {
+		http.Error(w, "failed to geocode", http.StatusInternalServerError)
+		return
+	}
+	if res == nil {
+		http.Error(w, "no address found near this location", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// ForwardGeocode returns the best matching address point for ?q=
+func ForwardGeocode(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		http.Error(w, "q is required", http.StatusBadRequest)
+		return
+	}
+
+	res, err := geocode.Forward(q)
+	if err != nil {
+		http.Error(w, "failed to geocode", http.StatusInternalServerError)
+		return
+	}
+	if res == nil {
+		http.Error(w, "no matching address found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// BackfillGeocoding queues geocoding of stored reports and constructions
+// without a street, or all of them with ?all=true
+func BackfillGeocoding(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	all := r.URL.Query().Get("all") == "true"
+	job, err := jobs.Enqueue(geocode.JobBackfill, map[string]bool{"all": all})
+	writeQueued(w, job, err)
+}
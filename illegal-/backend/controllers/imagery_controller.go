@@ -0,0 +1,241 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/change"
+	"backend/geo"
+	"backend/imagery"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// captureImageTypes are the file types change.Decode reads
+var captureImageTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+// CreateCapture handles multipart/form-data upload or registration of an
+// imagery capture. Fields: kind, captured_at, construction_id or area_id,
+// optional source and notes, and either a file or an image_url pointing at
+// an earlier upload or a provider URL. Files too large for one request are
+// sent as resumable uploads and finalized into a capture instead. The
+// capture is paired with the previous capture of the same kind and the
+// change is scored in the background.
+func CreateCapture(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		writeJSONError(w, "failed to parse form", http.StatusBadRequest)
+		return
+	}
+	capture, msg := captureFromForm(r)
+	if msg != "" {
+		writeJSONError(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if src, fh, err := r.FormFile("file"); err == nil {
+		_, err = change.Decode(src)
+		src.Close()
+		if err != nil {
+			writeJSONError(w, "file is not a supported image", http.StatusBadRequest)
+			return
+		}
+
+		if capture.ImageURL, err = storeUpload(r.Context(), fh, "imagery", captureImageTypes); err != nil {
+			writeJSONError(w, "failed to store upload", http.StatusInternalServerError)
+			return
+		}
+	} else {
+		capture.ImageURL = models.BlobRef(strings.TrimSpace(r.FormValue("image_url")))
+		if capture.ImageURL == "" {
+			writeJSONError(w, "file or image_url is required", http.StatusBadRequest)
+			return
+		}
+		if _, err := imagery.Open(string(capture.ImageURL)); err != nil {
+			writeJSONError(w, "image_url: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	job, err := imagery.AddCapture(&capture)
+	if err != nil {
+		writeJSONError(w, "failed to save capture", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"capture":       capture,
+		"comparisonJob": job, // scores the pair with the previous capture
+	})
+}
+
+// captureFromForm reads the fields describing a capture, other than its
+// image, returning a message for the client when one is invalid
+func captureFromForm(r *http.Request) (models.ImageryCapture, string) {
+	capture := models.ImageryCapture{
+		Kind:   strings.TrimSpace(r.FormValue("kind")),
+		Source: strings.TrimSpace(r.FormValue("source")),
+		Notes:  strings.TrimSpace(r.FormValue("notes")),
+	}
+	if !imagery.ValidKind(capture.Kind) {
+		return capture, "kind must be one of satellite, drone, field_photo"
+	}
+	capturedAt, err := parsePermitDate(r.FormValue("captured_at"))
+	if err != nil {
+		return capture, "captured_at must be a date (YYYY-MM-DD) or RFC 3339 timestamp"
+	}
+	capture.CapturedAt = capturedAt
+	if user, err := currentUser(r); err == nil {
+		capture.UploadedBy = &user.ID
+	}
+
+	if v := r.FormValue("construction_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || utils.DB.First(&models.Construction{}, id).Error != nil {
+			return capture, "construction not found"
+		}
+		cid := uint(id)
+		capture.ConstructionID = &cid
+	}
+	if v := r.FormValue("area_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || utils.DB.First(&models.AreaOfInterest{}, id).Error != nil {
+			return capture, "area of interest not found"
+		}
+		aid := uint(id)
+		capture.AreaID = &aid
+	}
+	if (capture.ConstructionID == nil) == (capture.AreaID == nil) {
+		return capture, "exactly one of construction_id or area_id is required"
+	}
+	return capture, ""
+}
+
+// GetCapture returns a capture with its comparisons
+func GetCapture(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var capture models.ImageryCapture
+	if err := utils.DB.First(&capture, id).Error; err != nil {
+		http.Error(w, "capture not found", http.StatusNotFound)
+		return
+	}
+	var comparisons []models.ImageryComparison
+	utils.DB.Where("before_id = ? OR after_id = ?", capture.ID, capture.ID).Order("id").Find(&comparisons)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"capture":     capture,
+		"comparisons": comparisons,
+	})
+}
+
+// DeleteCapture removes a capture and re-pairs its neighbours
+func DeleteCapture(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var capture models.ImageryCapture
+	if err := utils.DB.First(&capture, id).Error; err != nil {
+		http.Error(w, "capture not found", http.StatusNotFound)
+		return
+	}
+	if err := imagery.DeleteCapture(capture); err != nil {
+		http.Error(w, "failed to delete capture", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetConstructionImagery returns the capture timeline of a construction
+func GetConstructionImagery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	timeline, err := imagery.Timeline("construction_id", uint(id))
+	if err != nil {
+		http.Error(w, "failed to fetch imagery", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timeline)
+}
+
+// GetAreas lists areas of interest
+func GetAreas(w http.ResponseWriter, r *http.Request) {
+	var areas []models.AreaOfInterest
+	if err := utils.DB.Order("name").Find(&areas).Error; err != nil {
+		http.Error(w, "failed to fetch areas", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(areas)
+}
+
+// CreateArea adds an area of interest from a name and a GeoJSON polygon
+func CreateArea(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	var area models.AreaOfInterest
+	if err := json.NewDecoder(r.Body).Decode(&area); err != nil {
+		writeJSONError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	area.Name = strings.TrimSpace(area.Name)
+	if area.Name == "" {
+		writeJSONError(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	g, err := geo.DecodeGeometry(area.Boundary)
+	if err != nil || !geo.Polygonal(g) {
+		writeJSONError(w, "boundary must be a GeoJSON Polygon or MultiPolygon", http.StatusBadRequest)
+		return
+	}
+	b := g.Bound()
+	area.MinLat, area.MinLng, area.MaxLat, area.MaxLng = b.Min.Lat(), b.Min.Lon(), b.Max.Lat(), b.Max.Lon()
+
+	if err := utils.DB.Create(&area).Error; err != nil {
+		writeJSONError(w, "failed to create area", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(area)
+}
+
+// GetAreaImagery returns the capture timeline of an area of interest
+func GetAreaImagery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	timeline, err := imagery.Timeline("area_id", uint(id))
+	if err != nil {
+		http.Error(w, "failed to fetch imagery", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(timeline)
+}
@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"backend/jobs"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// writeQueued answers 202 Accepted for work handed to the job queue, or 500
+// when it could not be queued
+func writeQueued(w http.ResponseWriter, job *models.Job, err error) {
+	if err != nil {
+		writeJSONError(w, "failed to queue job", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{"job": job})
+}
+
+// GetJobs lists background jobs, newest first, optionally filtered by
+// ?status= and ?type=. ?limit= defaults to 100.
+func GetJobs(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	limit := 100
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 1000 {
+			http.Error(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	q := utils.DB.Order("id desc").Limit(limit)
+	if status := r.URL.Query().Get("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if jobType := r.URL.Query().Get("type"); jobType != "" {
+		q = q.Where("type = ?", jobType)
+	}
+
+	var list []models.Job
+	if err := q.Find(&list).Error; err != nil {
+		http.Error(w, "failed to fetch jobs", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetJobStats counts jobs by type and status
+func GetJobStats(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	stats, err := jobs.Stats()
+	if err != nil {
+		http.Error(w, "failed to count jobs", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// GetJob returns one job, so admins can follow work they queued
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var job models.Job
+	if err := utils.DB.First(&job, id).Error; err != nil {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// RequeueJob puts a dead or finished job back in the queue
+func RequeueJob(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeJSONError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	if err := utils.DB.First(&models.Job{}, id).Error; err != nil {
+		writeJSONError(w, "job not found", http.StatusNotFound)
+		return
+	}
+
+	job, err := jobs.Requeue(uint(id))
+	if errors.Is(err, jobs.ErrNotRequeueable) {
+		writeJSONError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	writeQueued(w, job, err)
+}
@@ -0,0 +1,239 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"backend/geo"
+	"backend/jobs"
+	"backend/models"
+	"backend/utils"
+	"backend/zoning"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// ImportLayer handles multipart/form-data upload of a GeoJSON file or a zipped
+// Shapefile. Fields: file, name, kind and an optional name_field naming the
+// attribute that holds each feature's display name. Ward assignment or
+// zoning evaluation is queued once the layer is saved.
+func ImportLayer(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	if err := r.ParseMultipartForm(64 << 20); err != nil {
+		writeJSONError(w, "failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	kind := strings.TrimSpace(r.FormValue("kind"))
+	nameField := strings.TrimSpace(r.FormValue("name_field"))
+	if name == "" {
+		writeJSONError(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if !geo.ValidKind(kind) {
+		writeJSONError(w, "kind must be one of ward, zone, river_buffer, heritage", http.StatusBadRequest)
+		return
+	}
+
+	src, fh, err := r.FormFile("file")
+	if err != nil {
+		writeJSONError(w, "file is required", http.StatusBadRequest)
+		return
+	}
+	defer src.Close()
+
+	var features []geo.Feature
+	var skipped int
+	switch strings.ToLower(filepath.Ext(fh.Filename)) {
+	case ".geojson", ".json":
+		data, err := io.ReadAll(src)
+		if err != nil {
+			writeJSONError(w, "failed to read file", http.StatusBadRequest)
+			return
+		}
+		features, skipped, err = geo.ParseGeoJSON(data, nameField)
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case ".zip":
+		// the shapefile reader needs random access, so spool the upload to disk
+		tmp, err := os.CreateTemp("", "layer-*.zip")
+		if err != nil {
+			writeJSONError(w, "failed to store upload", http.StatusInternalServerError)
+			return
+		}
+		defer os.Remove(tmp.Name())
+		_, err = io.Copy(tmp, src)
+		tmp.Close()
+		if err != nil {
+			writeJSONError(w, "failed to store upload", http.StatusInternalServerError)
+			return
+		}
+		features, skipped, err = geo.ParseShapefileZip(tmp.Name(), nameField)
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		writeJSONError(w, "file must be .geojson, .json or a zipped shapefile (.zip)", http.StatusBadRequest)
+		return
+	}
+
+	if len(features) == 0 {
+		writeJSONError(w, "file contains no polygon features", http.StatusBadRequest)
+		return
+	}
+
+	layer, err := geo.SaveLayer(name, kind, filepath.Base(fh.Filename), features)
+	if err != nil {
+		writeJSONError(w, "failed to save layer", http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"layer":    layer,
+		"imported": len(features),
+		"skipped":  skipped,
+	}
+	job, err := layerChanged(kind)
+	if err != nil {
+		writeJSONError(w, "layer saved but re-evaluation could not be queued", http.StatusInternalServerError)
+		return
+	}
+	resp["job"] = job
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// GetLayers lists imported layers, optionally filtered by ?kind=
+func GetLayers(w http.ResponseWriter, r *http.Request) {
+	q := utils.DB.Order("name")
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		q = q.Where("kind = ?", kind)
+	}
+
+	var layers []models.Layer
+	if err := q.Find(&layers).Error; err != nil {
+		http.Error(w, "failed to fetch layers", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(layers)
+}
+
+// GetLayerFeatures returns a layer's features as a GeoJSON FeatureCollection
+func GetLayerFeatures(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var layer models.Layer
+	if err := utils.DB.First(&layer, id).Error; err != nil {
+		http.Error(w, "layer not found", http.StatusNotFound)
+		return
+	}
+
+	var rows []models.LayerFeature
+	if err := utils.DB.Where("layer_id = ?", layer.ID).Order("id").Find(&rows).Error; err != nil {
+		http.Error(w, "failed to fetch features", http.StatusInternalServerError)
+		return
+	}
+
+	type feature struct {
+		Type       string          `json:"type"`
+		ID         uint            `json:"id"`
+		Geometry   json.RawMessage `json:"geometry"`
+		Properties json.RawMessage `json:"properties"`
+	}
+	collection := struct {
+		Type     string    `json:"type"`
+		Name     string    `json:"name"`
+		Kind     string    `json:"kind"`
+		Features []feature `json:"features"`
+	}{Type: "FeatureCollection", Name: layer.Name, Kind: layer.Kind, Features: []feature{}}
+
+	for _, row := range rows {
+		props := map[string]interface{}{}
+		_ = json.Unmarshal(row.Properties, &props)
+		props["name"] = row.Name
+		props["layer_id"] = row.LayerID
+		propsJSON, _ := json.Marshal(props)
+		collection.Features = append(collection.Features, feature{
+			Type:       "Feature",
+			ID:         row.ID,
+			Geometry:   json.RawMessage(row.Geometry),
+			Properties: propsJSON,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	json.NewEncoder(w).Encode(collection)
+}
+
+// DeleteLayer removes a layer and its features
+func DeleteLayer(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var layer models.Layer
+	if err := utils.DB.First(&layer, id).Error; err != nil {
+		http.Error(w, "layer not found", http.StatusNotFound)
+		return
+	}
+
+	err = utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("layer_id = ?", layer.ID).Delete(&models.LayerFeature{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&layer).Error
+	})
+	if err != nil {
+		http.Error(w, "failed to delete layer", http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := layerChanged(layer.Kind); err != nil {
+		http.Error(w, "layer deleted but re-evaluation could not be queued", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// layerChanged queues the work that depends on layers of a kind: ward
+// assignment for wards, zoning evaluation for everything else
+func layerChanged(kind string) (*models.Job, error) {
+	if kind == geo.KindWard {
+		return jobs.Enqueue(geo.JobAssignWards, nil)
+	}
+	return jobs.Enqueue(zoning.JobEvaluateAll, nil)
+}
+
+// AssignWards queues a recomputation of the ward of every construction and
+// report
+func AssignWards(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	job, err := jobs.Enqueue(geo.JobAssignWards, nil)
+	writeQueued(w, job, err)
+}
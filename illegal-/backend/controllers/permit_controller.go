@@ -0,0 +1,426 @@
+package controllers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/geo"
+	"backend/jobs"
+	"backend/models"
+	"backend/storage"
+	"backend/utils"
+	"backend/zoning"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gorilla/mux"
+	"gorm.io/datatypes"
+)
+
+// GetPermits lists permits, optionally filtered by ?property_id= and ?status=
+func GetPermits(w http.ResponseWriter, r *http.Request) {
+	q := utils.DB.Order("valid_from desc")
+	if propertyID := r.URL.Query().Get("property_id"); propertyID != "" {
+		q = q.Where("property_id = ?", propertyID)
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+
+	var permits []models.Permit
+	if err := q.Find(&permits).Error; err != nil {
+		http.Error(w, "failed to fetch permits", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(permits)
+}
+
+// GetPermit returns a permit with the constructions currently matched to it
+func GetPermit(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var permit models.Permit
+	if err := utils.DB.First(&permit, id).Error; err != nil {
+		http.Error(w, "permit not found", http.StatusNotFound)
+		return
+	}
+	var constructions []models.Construction
+	utils.DB.Where("permit_id = ?", permit.ID).Find(&constructions)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"permit":        permit,
+		"constructions": constructions,
+	})
+}
+
+// validatePermit checks required fields and normalises the footprint
+func validatePermit(p *models.Permit) error {
+	p.Number = strings.TrimSpace(p.Number)
+	if p.Number == "" {
+		return errors.New("number is required")
+	}
+	if p.PropertyID == 0 {
+		return errors.New("property_id is required")
+	}
+	if p.ValidFrom.IsZero() || p.ValidUntil.IsZero() {
+		return errors.New("valid_from and valid_until are required")
+	}
+	if p.ValidUntil.Before(p.ValidFrom) {
+		return errors.New("valid_until is before valid_from")
+	}
+	if p.Status == "" {
+		p.Status = "active"
+	}
+	if p.Status != "active" && p.Status != "revoked" {
+		return errors.New("status must be active or revoked")
+	}
+	if len(p.Footprint) > 0 && string(p.Footprint) != "null" {
+		g, err := geo.DecodeGeometry(p.Footprint)
+		if err != nil || !geo.Polygonal(g) {
+			return errors.New("footprint must be a GeoJSON Polygon or MultiPolygon")
+		}
+		if p.FootprintArea == 0 {
+			p.FootprintArea = geo.Area(g)
+		}
+	} else {
+		p.Footprint = nil
+	}
+	zoning.SetPermitBound(p)
+	return nil
+}
+
+// CreatePermit registers a permit and re-checks the parcel's constructions
+func CreatePermit(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	var permit models.Permit
+	if err := json.NewDecoder(r.Body).Decode(&permit); err != nil {
+		writeJSONError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	permit.ID = 0
+	if err := validatePermit(&permit); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := utils.DB.Create(&permit).Error; err != nil {
+		writeJSONError(w, "failed to create permit", http.StatusInternalServerError)
+		return
+	}
+	reevaluateProperty(permit.PropertyID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(permit)
+}
+
+// UpdatePermit replaces a permit's fields
+func UpdatePermit(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeJSONError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var permit models.Permit
+	if err := utils.DB.First(&permit, id).Error; err != nil {
+		writeJSONError(w, "permit not found", http.StatusNotFound)
+		return
+	}
+	previousProperty := permit.PropertyID
+	if err := json.NewDecoder(r.Body).Decode(&permit); err != nil {
+		writeJSONError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	permit.ID = uint(id)
+	if err := validatePermit(&permit); err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := utils.DB.Save(&permit).Error; err != nil {
+		writeJSONError(w, "failed to update permit", http.StatusInternalServerError)
+		return
+	}
+	reevaluateProperty(permit.PropertyID)
+	if previousProperty != permit.PropertyID {
+		reevaluateProperty(previousProperty)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(permit)
+}
+
+// DeletePermit removes a permit and re-checks the parcel's constructions
+func DeletePermit(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var permit models.Permit
+	if err := utils.DB.First(&permit, id).Error; err != nil {
+		http.Error(w, "permit not found", http.StatusNotFound)
+		return
+	}
+	if err := utils.DB.Delete(&permit).Error; err != nil {
+		http.Error(w, "failed to delete permit", http.StatusInternalServerError)
+		return
+	}
+	reevaluateProperty(permit.PropertyID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// reevaluateProperty re-checks a parcel's constructions after a permit
+// change. The change is already saved, so a failure is logged; the next
+// full evaluation picks the parcel up.
+func reevaluateProperty(propertyID uint) {
+	if _, err := zoning.EvaluateProperty(propertyID); err != nil {
+		log.Printf("zoning: re-evaluating parcel %d: %v", propertyID, err)
+	}
+}
+
+// UploadPermitAttachments handles multipart/form-data upload of scanned
+// permit documents under the "files" field
+func UploadPermitAttachments(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeJSONError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var permit models.Permit
+	if err := utils.DB.First(&permit, id).Error; err != nil {
+		writeJSONError(w, "permit not found", http.StatusNotFound)
+		return
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		writeJSONError(w, "failed to parse form", http.StatusBadRequest)
+		return
+	}
+
+	for _, fh := range r.MultipartForm.File["files"] {
+		ref, err := storeUpload(r.Context(), fh, "permits", permitAttachmentTypes)
+		if errors.Is(err, errFileType) {
+			writeJSONError(w, fh.Filename+": attachments must be PDF documents or images", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			writeJSONError(w, "failed to store "+fh.Filename, http.StatusInternalServerError)
+			return
+		}
+		permit.Attachments = append(permit.Attachments, ref)
+	}
+	if err := utils.DB.Save(&permit).Error; err != nil {
+		writeJSONError(w, "failed to update permit", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(permit)
+}
+
+// permitAttachmentTypes are the file types accepted as permit documents
+var permitAttachmentTypes = []string{"application/pdf", "image/jpeg", "image/png", "image/gif", "image/webp", "image/tiff"}
+
+// errFileType rejects an upload whose content is not of an allowed type
+var errFileType = errors.New("file type is not allowed")
+
+// storeUpload saves an uploaded multipart file in the blob store under
+// prefix. Its type is detected from the content and must be one of
+// allowed; the file is stored under that type's extension, whatever name
+// it was sent with.
+func storeUpload(ctx context.Context, fh *multipart.FileHeader, prefix string, allowed []string) (models.BlobRef, error) {
+	src, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	detected, err := mimetype.DetectReader(src)
+	if err != nil {
+		return "", err
+	}
+	mime, _, _ := strings.Cut(detected.String(), ";")
+	if !slices.Contains(allowed, mime) {
+		return "", errFileType
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	key, err := storage.Save(ctx, prefix, src, detected.Extension())
+	return models.BlobRef(key), err
+}
+
+// permitCSVColumns are the recognised CSV headers; number, property_id,
+// valid_from and valid_until are required
+var permitCSVColumns = []string{"number", "property_id", "footprint_area", "floors", "valid_from", "valid_until", "status", "footprint"}
+
+// maxPermitCSV limits the size of an imported permit CSV
+const maxPermitCSV = 32 << 20
+
+// parsePermitDate accepts plain dates and RFC 3339 timestamps
+func parsePermitDate(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// ImportPermitsCSV handles multipart/form-data upload of a CSV file under the
+// "file" field. Rows are matched on permit number: existing permits are
+// updated and new ones created. Invalid rows are reported and skipped.
+// Constructions are re-evaluated afterwards by a queued job.
+func ImportPermitsCSV(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxPermitCSV)
+	src, _, err := r.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeJSONError(w, fmt.Sprintf("request is larger than %d MB", maxPermitCSV>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "file is required", http.StatusBadRequest)
+		return
+	}
+	defer src.Close()
+
+	reader := csv.NewReader(src)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		writeJSONError(w, "failed to read CSV header", http.StatusBadRequest)
+		return
+	}
+	col := map[string]int{}
+	for i, h := range header {
+		col[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, required := range []string{"number", "property_id", "valid_from", "valid_until"} {
+		if _, ok := col[required]; !ok {
+			writeJSONError(w, "missing column "+required+"; expected "+strings.Join(permitCSVColumns, ","), http.StatusBadRequest)
+			return
+		}
+	}
+	field := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+
+	type rowError struct {
+		Line  int    `json:"line"`
+		Error string `json:"error"`
+	}
+	created, updated := 0, 0
+	rowErrors := []rowError{}
+
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, rowError{line, err.Error()})
+			continue
+		}
+
+		var permit models.Permit
+		utils.DB.Where("number = ?", field(row, "number")).FirstOrInit(&permit)
+		exists := permit.ID != 0
+
+		permit.Number = field(row, "number")
+		propertyID, err := strconv.ParseUint(field(row, "property_id"), 10, 64)
+		if err != nil {
+			rowErrors = append(rowErrors, rowError{line, "invalid property_id"})
+			continue
+		}
+		permit.PropertyID = uint(propertyID)
+		if permit.ValidFrom, err = parsePermitDate(field(row, "valid_from")); err != nil {
+			rowErrors = append(rowErrors, rowError{line, "invalid valid_from"})
+			continue
+		}
+		if permit.ValidUntil, err = parsePermitDate(field(row, "valid_until")); err != nil {
+			rowErrors = append(rowErrors, rowError{line, "invalid valid_until"})
+			continue
+		}
+		if v := field(row, "floors"); v != "" {
+			if permit.Floors, err = strconv.Atoi(v); err != nil {
+				rowErrors = append(rowErrors, rowError{line, "invalid floors"})
+				continue
+			}
+		}
+		if v := field(row, "footprint_area"); v != "" {
+			if permit.FootprintArea, err = strconv.ParseFloat(v, 64); err != nil {
+				rowErrors = append(rowErrors, rowError{line, "invalid footprint_area"})
+				continue
+			}
+		}
+		if v := field(row, "status"); v != "" {
+			permit.Status = strings.ToLower(v)
+		}
+		if v := field(row, "footprint"); v != "" {
+			permit.Footprint = datatypes.JSON(v)
+		}
+		if err := validatePermit(&permit); err != nil {
+			rowErrors = append(rowErrors, rowError{line, err.Error()})
+			continue
+		}
+
+		if err := utils.DB.Save(&permit).Error; err != nil {
+			rowErrors = append(rowErrors, rowError{line, "failed to save permit"})
+			continue
+		}
+		if exists {
+			updated++
+		} else {
+			created++
+		}
+	}
+
+	job, err := jobs.Enqueue(zoning.JobEvaluateAll, nil)
+	if err != nil {
+		writeJSONError(w, "permits imported but zoning evaluation could not be queued", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"created": created,
+		"updated": updated,
+		"errors":  rowErrors,
+		"job":     job,
+	})
+}
@@ -5,6 +5,7 @@ import (
     "net/http"
     "encoding/json"
     
+    "backend/geo"
     "backend/models"
     "backend/utils"
 )
@@ -18,6 +19,20 @@ func GetProperties(w http.ResponseWriter, r *http.Request) {
 func CreateProperty(w http.ResponseWriter, r *http.Request) {
     var property models.Property
     json.NewDecoder(r.Body).Decode(&property)
+    if len(property.Boundary) > 0 && string(property.Boundary) != "null" {
+        g, err := geo.DecodeGeometry(property.Boundary)
+        if err != nil || !geo.Polygonal(g) {
+            http.Error(w, "boundary must be a GeoJSON Polygon or MultiPolygon", http.StatusBadRequest)
+            return
+        }
+        // Bounding box columns let map tiles find parcels without PostGIS
+        b := g.Bound()
+        minLat, minLng, maxLat, maxLng := b.Min.Lat(), b.Min.Lon(), b.Max.Lat(), b.Max.Lon()
+        property.MinLat, property.MinLng, property.MaxLat, property.MaxLng = &minLat, &minLng, &maxLat, &maxLng
+        if property.Area == 0 {
+            property.Area = geo.Area(g)
+        }
+    }
     utils.DB.Create(&property)
     w.WriteHeader(http.StatusCreated)
     json.NewEncoder(w).Encode(property)
@@ -1,20 +1,31 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
-	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"backend/dedup"
+	"backend/geo"
+	"backend/geocode"
+	"backend/jobs"
+	"backend/media"
 	"backend/models"
+	"backend/storage"
+	"backend/uploads"
 	"backend/utils"
 
 	"github.com/gorilla/mux"
 	"gorm.io/datatypes"
+	"gorm.io/gorm"
 )
 
 // GetReports returns all reports
@@ -24,6 +35,12 @@ func GetReports(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to fetch reports", http.StatusInternalServerError)
 		return
 	}
+	markDuplicates(reports)
+	if !isOfficer(r) {
+		for i := range reports {
+			hidePhotoLocations(&reports[i])
+		}
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(reports)
 }
@@ -43,14 +60,228 @@ func GetReport(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "report not found", http.StatusNotFound)
 		return
 	}
+	reports := []models.Report{report}
+	markDuplicates(reports)
+	report = reports[0]
+	if !isOfficer(r) {
+		hidePhotoLocations(&report)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(report)
 }
 
-// CreateReport handles multipart/form-data report creation and saves image files to ./uploads
+// isOfficer reports whether the request carries an officer's or admin's token
+func isOfficer(r *http.Request) bool {
+	user, err := currentUser(r)
+	return err == nil && (strings.EqualFold(user.Role, "officer") || strings.EqualFold(user.Role, "admin"))
+}
+
+// hidePhotoLocations drops the GPS positions read from a report's photos,
+// which may reveal where the reporter lives; the report's own coordinates
+// stay public
+func hidePhotoLocations(report *models.Report) {
+	for i, img := range report.Images {
+		if img.Metadata != nil {
+			meta := *img.Metadata
+			meta.Lat, meta.Lng = nil, nil
+			report.Images[i].Metadata = &meta
+		}
+	}
+}
+
+// GetReportVideoURL gives officers a signed URL to play a report's video
+// from; it supports range requests, so players can seek and stream
+func GetReportVideoURL(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeJSONError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	index, err := strconv.Atoi(vars["index"])
+	if err != nil {
+		writeJSONError(w, "invalid video index", http.StatusBadRequest)
+		return
+	}
+
+	var report models.Report
+	if err := utils.DB.First(&report, id).Error; err != nil {
+		writeJSONError(w, "report not found", http.StatusNotFound)
+		return
+	}
+	if index < 0 || index >= len(report.Videos) {
+		writeJSONError(w, "video not found", http.StatusNotFound)
+		return
+	}
+	key, err := storage.KeyOf(string(report.Videos[index].File.URL))
+	if err != nil {
+		writeJSONError(w, "video not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"url":        storage.SignedURL(key, storage.URLExpiry),
+		"mime":       report.Videos[index].MIME,
+		"expires_in": int(storage.URLExpiry.Seconds()),
+	})
+}
+
+// GetReportImageOriginal serves an uploaded image as it was received,
+// metadata included, to officers
+func GetReportImageOriginal(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeJSONError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	index, err := strconv.Atoi(vars["index"])
+	if err != nil {
+		writeJSONError(w, "invalid image index", http.StatusBadRequest)
+		return
+	}
+
+	var report models.Report
+	if err := utils.DB.First(&report, id).Error; err != nil {
+		writeJSONError(w, "report not found", http.StatusNotFound)
+		return
+	}
+	if index < 0 || index >= len(report.Images) {
+		writeJSONError(w, "image not found", http.StatusNotFound)
+		return
+	}
+	img := report.Images[index]
+	key, err := storage.KeyOf(string(img.Original.URL))
+	if err != nil {
+		writeJSONError(w, "image not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, no-store")
+	storage.Serve(w, r, key, img.MIME)
+}
+
+// Report upload limits; each image is also bounded by media.MaxImageBytes
+const (
+	maxReportImages  = 10
+	maxReportVideos  = 3
+	maxReportRequest = 64 << 20 // whole multipart body; videos arrive as resumable uploads
+)
+
+// maxPhotoDistance is how far in metres a photo's GPS position may be from
+// the claimed coordinates before the report is flagged
+const maxPhotoDistance = 500.0
+
+// applyPhotoLocation fills missing coordinates from the first photo with a
+// GPS position, or compares claimed coordinates against every photo's
+func applyPhotoLocation(report *models.Report) {
+	lat, lng, claimed := report.LatLng()
+	for _, img := range report.Images {
+		if !img.Metadata.HasLocation() {
+			continue
+		}
+		plat, plng := *img.Metadata.Lat, *img.Metadata.Lng
+		if !claimed {
+			coords, _ := json.Marshal(map[string]float64{"lat": plat, "lng": plng})
+			report.Coordinates = datatypes.JSON(coords)
+			report.PhotoLocated = true
+			return
+		}
+		d := geo.Distance(lat, lng, plat, plng)
+		if report.PhotoDistance == nil || d > *report.PhotoDistance {
+			report.PhotoDistance = &d
+		}
+	}
+	report.LocationMismatch = report.PhotoDistance != nil && *report.PhotoDistance > maxPhotoDistance
+}
+
+// rejectedFile explains why one uploaded file was refused
+type rejectedFile struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// readImages validates uploaded images, returning the accepted ones and an
+// error for every rejected file
+func readImages(files []*multipart.FileHeader) ([]*media.Image, []rejectedFile) {
+	var images []*media.Image
+	var rejected []rejectedFile
+	for _, fh := range files {
+		img, err := readImage(fh)
+		if err != nil {
+			rejected = append(rejected, rejectedFile{File: filepath.Base(fh.Filename), Error: err.Error()})
+			continue
+		}
+		images = append(images, img)
+	}
+	return images, rejected
+}
+
+// readVideos validates finished uploads attached as videos, returning the
+// accepted ones and an error for every rejected upload
+func readVideos(ctx context.Context, uploadIDs []string) ([]models.EvidenceVideo, []rejectedFile) {
+	var videos []models.EvidenceVideo
+	var rejected []rejectedFile
+	for _, id := range uploadIDs {
+		u, err := uploads.Completed(ctx, id)
+		if err != nil {
+			rejected = append(rejected, rejectedFile{File: id, Error: err.Error()})
+			continue
+		}
+		v, err := readVideo(ctx, u)
+		if err != nil {
+			rejected = append(rejected, rejectedFile{File: u.Filename, Error: err.Error()})
+			continue
+		}
+		videos = append(videos, media.NewEvidenceVideo(v, u.Key, u.Length))
+	}
+	return videos, rejected
+}
+
+func readVideo(ctx context.Context, u *models.Upload) (*media.Video, error) {
+	src, err := storage.Open(ctx, u.Key)
+	if err != nil {
+		return nil, errors.New("could not read file")
+	}
+	defer src.Close()
+	ra, ok := src.(io.ReaderAt)
+	if !ok {
+		return nil, errors.New("could not read file")
+	}
+	return media.ProbeVideo(ra, u.Length)
+}
+
+func readImage(fh *multipart.FileHeader) (*media.Image, error) {
+	if fh.Size > media.MaxImageBytes {
+		return nil, fmt.Errorf("file is larger than %d MB", media.MaxImageBytes>>20)
+	}
+	src, err := fh.Open()
+	if err != nil {
+		return nil, errors.New("could not read file")
+	}
+	defer src.Close()
+	return media.ReadImage(src)
+}
+
+// CreateReport handles multipart/form-data report creation. Images are
+// validated, stored under generated names and rejected as a whole with an
+// error per refused file. Videos are sent beforehand as resumable uploads
+// and attached by upload id in the "videos" field. Photo GPS fills in
+// missing coordinates and flags reports whose photos were taken far from
+// the claimed location.
 func CreateReport(w http.ResponseWriter, r *http.Request) {
-	// limit parsing size (e.g. 32MB)
+	r.Body = http.MaxBytesReader(w, r.Body, maxReportRequest)
 	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, fmt.Sprintf("request is larger than %d MB", maxReportRequest>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "failed to parse form", http.StatusBadRequest)
 		return
 	}
@@ -60,33 +291,44 @@ func CreateReport(w http.ResponseWriter, r *http.Request) {
 	priority := r.FormValue("priority")
 	coordStr := r.FormValue("coordinates") // optional JSON string
 
-	// collect image URLs
-	imageURLs := []string{}
-	uploadsDir := "uploads"
-	_ = os.MkdirAll(uploadsDir, 0755)
-
-	if r.MultipartForm != nil && r.MultipartForm.File != nil {
-		files := r.MultipartForm.File["images"]
-		for _, fh := range files {
-			src, err := fh.Open()
-			if err != nil {
-				continue
-			}
-			defer src.Close()
-
-			// create unique filename
-			name := fmt.Sprintf("%d_%s", time.Now().UnixNano(), filepath.Base(fh.Filename))
-			dstPath := filepath.Join(uploadsDir, name)
-			dst, err := os.Create(dstPath)
-			if err != nil {
-				continue
-			}
-			_, _ = io.Copy(dst, src)
-			_ = dst.Close()
+	files := r.MultipartForm.File["images"]
+	if len(files) > maxReportImages {
+		writeJSONError(w, fmt.Sprintf("at most %d images can be attached", maxReportImages), http.StatusBadRequest)
+		return
+	}
+	videoIDs := r.MultipartForm.Value["videos"]
+	if len(videoIDs) > maxReportVideos {
+		writeJSONError(w, fmt.Sprintf("at most %d videos can be attached", maxReportVideos), http.StatusBadRequest)
+		return
+	}
+	accepted, rejectedImages := readImages(files)
+	videos, rejectedVideos := readVideos(r.Context(), videoIDs)
+	if rejected := append(rejectedImages, rejectedVideos...); len(rejected) > 0 {
+		msg := "some images were rejected"
+		switch {
+		case len(rejectedImages) == 0:
+			msg = "some videos were rejected"
+		case len(rejectedVideos) > 0:
+			msg = "some images and videos were rejected"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": msg,
+			"files": rejected,
+		})
+		return
+	}
 
-			// store URL relative to server root
-			imageURLs = append(imageURLs, "/uploads/"+name)
+	// store originals privately; the public renditions are generated afterwards
+	images := models.EvidenceImages{}
+	for _, img := range accepted {
+		key, err := storage.SaveBytes(r.Context(), storage.PrivatePrefix+"reports", img.Data, img.Ext)
+		if err != nil {
+			http.Error(w, "failed to store images", http.StatusInternalServerError)
+			return
 		}
+		images = append(images, media.NewEvidenceImage(img, key))
 	}
 
 	coordsJSON := datatypes.JSON([]byte("null"))
@@ -94,20 +336,46 @@ func CreateReport(w http.ResponseWriter, r *http.Request) {
 		coordsJSON = datatypes.JSON([]byte(coordStr))
 	}
 
-	imagesJSON, _ := json.Marshal(imageURLs)
-
 	report := models.Report{
 		Location:    location,
 		Description: description,
 		Priority:    priority,
 		Coordinates: coordsJSON,
-		Images:      datatypes.JSON(imagesJSON),
+		Images:      images,
+		Videos:      videos,
 		Status:      "pending",
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
+	applyPhotoLocation(&report)
 
-	if err := utils.DB.Create(&report).Error; err != nil {
+	// geocoding and resizing run in the background so neither holds up
+	// the submission
+	err := utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&report).Error; err != nil {
+			return err
+		}
+		payload := map[string]uint{"report_id": report.ID}
+		if _, err := jobs.EnqueueTx(tx, geocode.JobReport, payload); err != nil {
+			return err
+		}
+		if len(images) > 0 {
+			if _, err := jobs.EnqueueTx(tx, media.JobReportImages, payload); err != nil {
+				return err
+			}
+		}
+		if len(videos) > 0 {
+			if _, err := jobs.EnqueueTx(tx, media.JobReportVideos, payload); err != nil {
+				return err
+			}
+		}
+		return uploads.Finish(tx, videoIDs...)
+	})
+	if errors.Is(err, uploads.ErrNotFound) {
+		writeJSONError(w, "a video was attached by another request", http.StatusConflict)
+		return
+	}
+	if err != nil {
 		http.Error(w, "failed to create report", http.StatusInternalServerError)
 		return
 	}
@@ -166,5 +434,9 @@ func DeleteReport(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "failed to delete report", http.StatusInternalServerError)
 		return
 	}
+	if err := dedup.Forget(uint(id)); err != nil {
+		http.Error(w, "failed to delete report", http.StatusInternalServerError)
+		return
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
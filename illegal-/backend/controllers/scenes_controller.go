@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"backend/detect"
+	"backend/jobs"
+	"backend/models"
+	"backend/scenes"
+	"backend/utils"
+
+	"github.com/gorilla/mux"
+)
+
+// GetScenes lists ingested scenes, newest capture first
+func GetScenes(w http.ResponseWriter, r *http.Request) {
+	var list []models.Scene
+	if err := utils.DB.Order("captured_at desc, id desc").Find(&list).Error; err != nil {
+		http.Error(w, "failed to fetch scenes", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetScene returns a scene with its tiles and detections
+func GetScene(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var scene models.Scene
+	if err := utils.DB.First(&scene, id).Error; err != nil {
+		http.Error(w, "scene not found", http.StatusNotFound)
+		return
+	}
+	var tiles []models.SceneTile
+	utils.DB.Where("scene_id = ?", scene.ID).Order("gy, gx").Find(&tiles)
+	var detections []models.Detection
+	utils.DB.Where("scene_id = ?", scene.ID).Order("confidence desc").Find(&detections)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"scene":      scene,
+		"tiles":      tiles,
+		"detections": detections,
+	})
+}
+
+// ScanScenes queues ingestion of new files in the watched directory without
+// waiting for the next scheduled scan
+func ScanScenes(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	job, err := jobs.Enqueue(scenes.JobScan, nil)
+	writeQueued(w, job, err)
+}
+
+// GetDetections lists detections, optionally filtered by ?status= and
+// ?source=, most confident first
+func GetDetections(w http.ResponseWriter, r *http.Request) {
+	q := utils.DB.Order("confidence desc, id desc")
+	if status := r.URL.Query().Get("status"); status != "" {
+		q = q.Where("status = ?", status)
+	}
+	if source := r.URL.Query().Get("source"); source != "" {
+		q = q.Where("source = ?", source)
+	}
+
+	var detections []models.Detection
+	if err := q.Find(&detections).Error; err != nil {
+		http.Error(w, "failed to fetch detections", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detections)
+}
+
+// ConfirmDetection turns a candidate detection into a construction
+func ConfirmDetection(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	detection, ok := candidateDetection(w, r)
+	if !ok {
+		return
+	}
+
+	construction, err := detect.Confirm(&detection)
+	if errors.Is(err, detect.ErrReviewed) {
+		writeJSONError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "failed to confirm detection", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"detection":    detection,
+		"construction": construction,
+	})
+}
+
+// DismissDetection marks a candidate detection as a false positive
+func DismissDetection(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	detection, ok := candidateDetection(w, r)
+	if !ok {
+		return
+	}
+	res := utils.DB.Model(&detection).Where("status = ?", "candidate").Update("status", "dismissed")
+	if res.Error != nil {
+		writeJSONError(w, "failed to dismiss detection", http.StatusInternalServerError)
+		return
+	}
+	if res.RowsAffected == 0 {
+		writeJSONError(w, detect.ErrReviewed.Error(), http.StatusConflict)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detection)
+}
+
+// candidateDetection loads the {id} detection, writing an error unless it is
+// still awaiting review
+func candidateDetection(w http.ResponseWriter, r *http.Request) (models.Detection, bool) {
+	var detection models.Detection
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeJSONError(w, "invalid id", http.StatusBadRequest)
+		return detection, false
+	}
+	if err := utils.DB.First(&detection, id).Error; err != nil {
+		writeJSONError(w, "detection not found", http.StatusNotFound)
+		return detection, false
+	}
+	if detection.Status != "candidate" {
+		writeJSONError(w, "detection has already been reviewed", http.StatusConflict)
+		return detection, false
+	}
+	return detection, true
+}
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"backend/schedule"
+
+	"github.com/gorilla/mux"
+)
+
+// GetScheduledTasks lists the recurring tasks with their schedule, pause
+// state, next run and last run
+func GetScheduledTasks(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	list, err := schedule.List()
+	if err != nil {
+		writeJSONError(w, "failed to fetch scheduled tasks", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(list)
+}
+
+// GetScheduledTask returns one task with its run history, newest first.
+// ?limit= defaults to 50.
+func GetScheduledTask(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	name := mux.Vars(r)["name"]
+	status, err := schedule.Describe(name)
+	if err != nil {
+		writeJSONError(w, "task not found", http.StatusNotFound)
+		return
+	}
+
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 || limit > 1000 {
+			writeJSONError(w, "limit must be between 1 and 1000", http.StatusBadRequest)
+			return
+		}
+	}
+	runs, err := schedule.History(name, limit)
+	if err != nil {
+		writeJSONError(w, "failed to fetch run history", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"task": status,
+		"runs": runs,
+	})
+}
+
+// PauseScheduledTask stops the scheduled runs of a task on every replica
+func PauseScheduledTask(w http.ResponseWriter, r *http.Request) {
+	setTaskPaused(w, r, true)
+}
+
+// ResumeScheduledTask restarts the scheduled runs of a paused task
+func ResumeScheduledTask(w http.ResponseWriter, r *http.Request) {
+	setTaskPaused(w, r, false)
+}
+
+func setTaskPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	admin, ok := requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if _, found := schedule.Get(name); !found {
+		writeJSONError(w, "task not found", http.StatusNotFound)
+		return
+	}
+	if err := schedule.SetPaused(name, paused, &admin.ID); err != nil {
+		writeJSONError(w, "failed to update task", http.StatusInternalServerError)
+		return
+	}
+	status, err := schedule.Describe(name)
+	if err != nil {
+		writeJSONError(w, "failed to fetch task", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// RunScheduledTask queues an immediate run of a task, even while paused
+func RunScheduledTask(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireAdmin(w, r); !ok {
+		return
+	}
+	name := mux.Vars(r)["name"]
+	if _, found := schedule.Get(name); !found {
+		writeJSONError(w, "task not found", http.StatusNotFound)
+		return
+	}
+	job, err := schedule.Trigger(name)
+	writeQueued(w, job, err)
+}
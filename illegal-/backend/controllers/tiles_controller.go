@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"backend/tiles"
+
+	"github.com/gorilla/mux"
+	"github.com/paulmach/orb/maptile"
+)
+
+// splitList reads a comma separated query parameter
+func splitList(r *http.Request, name string) []string {
+	var out []string
+	for _, v := range strings.Split(r.URL.Query().Get(name), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// GetTile serves /tiles/{layer}/{z}/{x}/{y}.mvt as a gzipped Mapbox Vector
+// Tile. ?status= and ?priority= take comma separated values to filter on.
+func GetTile(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	layer := vars["layer"]
+	if !tiles.Known(layer) {
+		http.Error(w, "unknown layer, expected one of "+strings.Join(tiles.Layers(), ", "), http.StatusNotFound)
+		return
+	}
+
+	z, errZ := strconv.ParseUint(vars["z"], 10, 32)
+	x, errX := strconv.ParseUint(vars["x"], 10, 32)
+	y, errY := strconv.ParseUint(vars["y"], 10, 32)
+	if errZ != nil || errX != nil || errY != nil || z > 22 {
+		http.Error(w, "invalid tile coordinates", http.StatusBadRequest)
+		return
+	}
+	tile := maptile.New(uint32(x), uint32(y), maptile.Zoom(z))
+	if !tile.Valid() {
+		http.Error(w, "invalid tile coordinates", http.StatusBadRequest)
+		return
+	}
+
+	filter := tiles.Filter{
+		Statuses:   splitList(r, "status"),
+		Priorities: splitList(r, "priority"),
+	}
+
+	etag, err := tiles.ETag(layer, tile, filter)
+	if err != nil {
+		http.Error(w, "failed to build tile", http.StatusInternalServerError)
+		return
+	}
+	// tiles are rendered compressed; the few clients that do not take gzip
+	// get them inflated, under an ETag of their own so shared caches never
+	// hand one encoding to a client asking for the other
+	gzipped := acceptsGzip(r)
+	if gzipped {
+		etag = strings.TrimSuffix(etag, `"`) + `-gz"`
+	}
+	w.Header().Set("Vary", "Accept-Encoding")
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	if match := r.Header.Get("If-None-Match"); match != "" && match == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	data, err := tiles.Render(layer, tile, filter)
+	if err != nil {
+		http.Error(w, "failed to build tile", http.StatusInternalServerError)
+		return
+	}
+
+	if gzipped {
+		w.Header().Set("Content-Encoding", "gzip")
+	} else if data, err = gunzip(data); err != nil {
+		http.Error(w, "failed to build tile", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") && strings.TrimSpace(coding) != "*" {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
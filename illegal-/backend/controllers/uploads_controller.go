@@ -0,0 +1,451 @@
+package controllers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"backend/change"
+	"backend/imagery"
+	"backend/jobs"
+	"backend/media"
+	"backend/models"
+	"backend/storage"
+	"backend/uploads"
+	"backend/utils"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// tusVersion is the version of the tus resumable upload protocol spoken by
+// the upload endpoints, and tusExtensions the extensions supported
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,creation-with-upload,checksum,termination,expiration"
+)
+
+// tusChecksumMismatch is the tus status for a chunk failing its checksum
+const tusChecksumMismatch = 460
+
+// tusRequest sets the protocol headers and refuses clients speaking another
+// version of it. The capabilities usually answered to OPTIONS are sent with
+// every response, since preflight requests never reach the router.
+func tusRequest(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(uploads.MaxLength, 10))
+	w.Header().Set("Tus-Checksum-Algorithm", strings.Join(uploads.Algorithms(), ","))
+	if v := r.Header.Get("Tus-Resumable"); v != "" && v != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// writeUploadError maps upload errors to tus status codes
+func writeUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, uploads.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, uploads.ErrOffset), errors.Is(err, uploads.ErrComplete):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, uploads.ErrTooLarge):
+		http.Error(w, fmt.Sprintf("uploads are limited to %d MB", uploads.MaxLength>>20), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, uploads.ErrChecksum):
+		http.Error(w, err.Error(), tusChecksumMismatch)
+	case errors.Is(err, uploads.ErrAlgorithm):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, uploads.ErrUserLimit):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	case errors.Is(err, uploads.ErrFull):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, "failed to store upload", http.StatusInternalServerError)
+	}
+}
+
+// parseUploadMetadata decodes the Upload-Metadata header: comma-separated
+// keys, each followed by a space and its base64 value
+func parseUploadMetadata(header string) map[string]string {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			continue
+		}
+		meta[key] = string(decoded)
+	}
+	return meta
+}
+
+// chunkChecksum reads the optional Upload-Checksum header of a chunk
+func chunkChecksum(w http.ResponseWriter, r *http.Request) (*uploads.Checksum, bool) {
+	header := r.Header.Get("Upload-Checksum")
+	if header == "" {
+		return nil, true
+	}
+	sum, err := uploads.ParseChecksum(header)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return sum, true
+}
+
+// CreateUpload starts a resumable upload of Upload-Length bytes for the
+// logged-in user. The file name and type may be given as filename and
+// filetype in Upload-Metadata, and the first chunk may come in the same
+// request.
+func CreateUpload(w http.ResponseWriter, r *http.Request) {
+	if !tusRequest(w, r) {
+		return
+	}
+	user, err := currentUser(r)
+	if err != nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Upload-Length must be a positive number of bytes", http.StatusBadRequest)
+		return
+	}
+	meta := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	withChunk := r.Header.Get("Content-Type") == "application/offset+octet-stream"
+	sum, ok := chunkChecksum(w, r)
+	if !ok {
+		return
+	}
+
+	u, err := uploads.Create(meta["filename"], meta["filetype"], length, user.ID)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/uploads/"+u.ID)
+	if withChunk {
+		// the upload exists even if its first chunk fails; the client
+		// resumes it from the offset it asks for
+		if written, err := uploads.Write(r.Context(), u.ID, 0, r.Body, sum); err == nil {
+			u = written
+		} else if !errors.Is(err, uploads.ErrChecksum) {
+			writeUploadError(w, err)
+			return
+		}
+	}
+	uploadHeaders(w, u)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(u)
+}
+
+// uploadHeaders reports how far an upload has got
+func uploadHeaders(w http.ResponseWriter, u *models.Upload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	if u.Status != uploads.StatusComplete {
+		w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// HeadUpload tells a client where to resume an upload
+func HeadUpload(w http.ResponseWriter, r *http.Request) {
+	if !tusRequest(w, r) {
+		return
+	}
+	u, err := uploads.Get(mux.Vars(r)["id"])
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	uploadHeaders(w, u)
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetUpload returns an upload's progress as JSON
+func GetUpload(w http.ResponseWriter, r *http.Request) {
+	u, err := uploads.Get(mux.Vars(r)["id"])
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	uploadHeaders(w, u)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u)
+}
+
+// PatchUpload appends the request body at Upload-Offset. A chunk with an
+// Upload-Checksum that does not match is discarded and answered with 460.
+func PatchUpload(w http.ResponseWriter, r *http.Request) {
+	if !tusRequest(w, r) {
+		return
+	}
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Upload-Offset is required", http.StatusBadRequest)
+		return
+	}
+	sum, ok := chunkChecksum(w, r)
+	if !ok {
+		return
+	}
+
+	u, err := uploads.Write(r.Context(), mux.Vars(r)["id"], offset, r.Body, sum)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	uploadHeaders(w, u)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUpload abandons an upload and the chunks received so far
+func DeleteUpload(w http.ResponseWriter, r *http.Request) {
+	if !tusRequest(w, r) {
+		return
+	}
+	if err := uploads.Terminate(r.Context(), mux.Vars(r)["id"]); err != nil {
+		writeUploadError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// FinalizeUpload attaches a completed upload to a record, after which the
+// upload itself is gone. With report_id the file is added to the report's
+// evidence as an image or a video, which officers alone may do. With
+// construction_id or area_id it becomes a new imagery capture, taking the
+// same kind, captured_at, source and notes fields as CreateCapture.
+func FinalizeUpload(w http.ResponseWriter, r *http.Request) {
+	u, err := uploads.Completed(r.Context(), mux.Vars(r)["id"])
+	if errors.Is(err, uploads.ErrIncomplete) {
+		writeJSONError(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "upload not found", http.StatusNotFound)
+		return
+	}
+
+	if r.FormValue("report_id") != "" {
+		attachToReport(w, r, u)
+		return
+	}
+	attachToCapture(w, r, u)
+}
+
+// attachToReport adds a completed upload to a report as a photo or a video
+func attachToReport(w http.ResponseWriter, r *http.Request, u *models.Upload) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	id, err := strconv.Atoi(r.FormValue("report_id"))
+	if err != nil || utils.DB.First(&models.Report{}, id).Error != nil {
+		writeJSONError(w, "report not found", http.StatusNotFound)
+		return
+	}
+
+	isVideo, err := uploadIsVideo(r.Context(), u)
+	if err != nil {
+		writeJSONError(w, "failed to read upload", http.StatusInternalServerError)
+		return
+	}
+	var image *models.EvidenceImage
+	var video *models.EvidenceVideo
+	if isVideo {
+		v, err := readVideo(r.Context(), u)
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		e := media.NewEvidenceVideo(v, u.Key, u.Length)
+		video = &e
+	} else {
+		img, err := readUploadedImage(r.Context(), u)
+		if err != nil {
+			writeJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		key, err := storage.SaveBytes(r.Context(), storage.PrivatePrefix+"reports", img.Data, img.Ext)
+		if err != nil {
+			writeJSONError(w, "failed to store file", http.StatusInternalServerError)
+			return
+		}
+		e := media.NewEvidenceImage(img, key)
+		image = &e
+	}
+
+	var report models.Report
+	var tooMany error
+	err = utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&report, id).Error; err != nil {
+			return err
+		}
+		job := media.JobReportImages
+		if video != nil {
+			if len(report.Videos) >= maxReportVideos {
+				tooMany = fmt.Errorf("at most %d videos can be attached", maxReportVideos)
+				return tooMany
+			}
+			report.Videos = append(report.Videos, *video)
+			job = media.JobReportVideos
+		} else {
+			photos := 0
+			for _, e := range report.Images {
+				if e.Video == nil {
+					photos++
+				}
+			}
+			if photos >= maxReportImages {
+				tooMany = fmt.Errorf("at most %d images can be uploaded", maxReportImages)
+				return tooMany
+			}
+			report.Images = append(report.Images, *image)
+		}
+		err := tx.Model(&models.Report{}).Where("id = ?", report.ID).Updates(map[string]interface{}{
+			"images": report.Images,
+			"videos": report.Videos,
+		}).Error
+		if err != nil {
+			return err
+		}
+		if _, err := jobs.EnqueueTx(tx, job, map[string]uint{"report_id": report.ID}); err != nil {
+			return err
+		}
+		return uploads.Finish(tx, u.ID)
+	})
+	if tooMany != nil {
+		writeJSONError(w, tooMany.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, uploads.ErrNotFound) {
+		writeJSONError(w, "upload was attached by another request", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "failed to attach file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// uploadIsVideo sniffs whether a completed upload holds a video
+func uploadIsVideo(ctx context.Context, u *models.Upload) (bool, error) {
+	src, err := storage.Open(ctx, u.Key)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+	mime, err := mimetype.DetectReader(src)
+	if err != nil {
+		return false, err
+	}
+	return strings.HasPrefix(mime.String(), "video/"), nil
+}
+
+// readUploadedImage reads a completed upload as a report photo
+func readUploadedImage(ctx context.Context, u *models.Upload) (*media.Image, error) {
+	src, err := storage.Open(ctx, u.Key)
+	if err != nil {
+		return nil, errors.New("could not read file")
+	}
+	defer src.Close()
+	return media.ReadImage(src)
+}
+
+// attachToCapture stores a completed upload as a new imagery capture, which
+// officers alone may do. The image size is checked from its header before
+// it is decoded, and the file is copied out of the private upload area so
+// capture images are stored like those uploaded directly. The upload is
+// taken in the transaction adding the capture, so two requests cannot both
+// attach it.
+func attachToCapture(w http.ResponseWriter, r *http.Request, u *models.Upload) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	capture, msg := captureFromForm(r)
+	if msg != "" {
+		writeJSONError(w, msg, http.StatusBadRequest)
+		return
+	}
+	if msg := checkUploadedImage(r.Context(), u); msg != "" {
+		writeJSONError(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	src, err := storage.Open(r.Context(), u.Key)
+	if err != nil {
+		writeJSONError(w, "failed to read upload", http.StatusInternalServerError)
+		return
+	}
+	key, err := storage.Save(r.Context(), "imagery", src, filepath.Ext(u.Key))
+	src.Close()
+	if err != nil {
+		writeJSONError(w, "failed to store upload", http.StatusInternalServerError)
+		return
+	}
+	capture.ImageURL = models.BlobRef(key)
+
+	err = utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := uploads.Finish(tx, u.ID); err != nil {
+			return err
+		}
+		return imagery.InsertCapture(tx, &capture)
+	})
+	if errors.Is(err, uploads.ErrNotFound) {
+		writeJSONError(w, "upload was attached by another request", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		writeJSONError(w, "failed to save capture", http.StatusInternalServerError)
+		return
+	}
+	job, err := imagery.PairCapture(&capture)
+	if err != nil {
+		log.Printf("imagery: pairing capture %d: %v", capture.ID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"capture":       capture,
+		"comparisonJob": job,
+	})
+}
+
+// checkUploadedImage decodes an uploaded image; change.Decode reads its size
+// from the header first and refuses images over media.MaxImagePixels
+func checkUploadedImage(ctx context.Context, u *models.Upload) string {
+	src, err := storage.Open(ctx, u.Key)
+	if err != nil {
+		return "failed to read upload"
+	}
+	defer src.Close()
+	if _, err := change.Decode(src); err != nil {
+		return err.Error()
+	}
+	return ""
+}
@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/jobs"
+	"backend/models"
+	"backend/utils"
+	"backend/zoning"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+)
+
+// EvaluateConstruction re-runs the zoning rules for one construction
+func EvaluateConstruction(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var construction models.Construction
+	if err := utils.DB.First(&construction, id).Error; err != nil {
+		http.Error(w, "construction not found", http.StatusNotFound)
+		return
+	}
+	if err := zoning.Apply(&construction); err != nil {
+		http.Error(w, "failed to evaluate zoning rules", http.StatusInternalServerError)
+		return
+	}
+	if err := utils.DB.Save(&construction).Error; err != nil {
+		http.Error(w, "failed to update construction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(construction)
+}
+
+// EvaluateAllConstructions re-runs the zoning rules for every construction,
+// e.g. after new zoning layers have been imported. The run is queued as a
+// background job.
+func EvaluateAllConstructions(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOfficer(w, r); !ok {
+		return
+	}
+	job, err := jobs.Enqueue(zoning.JobEvaluateAll, nil)
+	writeQueued(w, job, err)
+}
+
+// OverrideConstructionStatus lets an officer set the status by hand. A
+// justification is mandatory and every override is kept as history.
+func OverrideConstructionStatus(w http.ResponseWriter, r *http.Request) {
+	officer, ok := requireOfficer(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeJSONError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var payload struct {
+		Status        string `json:"status"`
+		Justification string `json:"justification"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		writeJSONError(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if payload.Status != zoning.StatusLegal && payload.Status != zoning.StatusIllegal {
+		writeJSONError(w, "status must be legal or illegal", http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(payload.Justification) == "" {
+		writeJSONError(w, "justification is required", http.StatusBadRequest)
+		return
+	}
+
+	var construction models.Construction
+	if err := utils.DB.First(&construction, id).Error; err != nil {
+		writeJSONError(w, "construction not found", http.StatusNotFound)
+		return
+	}
+
+	override := models.StatusOverride{
+		ConstructionID: construction.ID,
+		OfficerID:      officer.ID,
+		PreviousStatus: construction.Status,
+		Status:         payload.Status,
+		Justification:  strings.TrimSpace(payload.Justification),
+		CreatedAt:      time.Now(),
+	}
+	construction.Status = payload.Status
+	construction.StatusOverridden = true
+
+	err = utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&override).Error; err != nil {
+			return err
+		}
+		return tx.Save(&construction).Error
+	})
+	if err != nil {
+		writeJSONError(w, "failed to override status", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(construction)
+}
+
+// ClearConstructionOverride hands the status back to the zoning rules
+func ClearConstructionOverride(w http.ResponseWriter, r *http.Request) {
+	officer, ok := requireOfficer(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		writeJSONError(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var payload struct {
+		Justification string `json:"justification"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&payload)
+
+	var construction models.Construction
+	if err := utils.DB.First(&construction, id).Error; err != nil {
+		writeJSONError(w, "construction not found", http.StatusNotFound)
+		return
+	}
+	if !construction.StatusOverridden {
+		writeJSONError(w, "construction status is not overridden", http.StatusConflict)
+		return
+	}
+
+	override := models.StatusOverride{
+		ConstructionID: construction.ID,
+		OfficerID:      officer.ID,
+		PreviousStatus: construction.Status,
+		Justification:  strings.TrimSpace(payload.Justification),
+		CreatedAt:      time.Now(),
+	}
+	construction.StatusOverridden = false
+	if err := zoning.Apply(&construction); err != nil {
+		writeJSONError(w, "failed to evaluate zoning rules", http.StatusInternalServerError)
+		return
+	}
+
+	err = utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&override).Error; err != nil {
+			return err
+		}
+		return tx.Save(&construction).Error
+	})
+	if err != nil {
+		writeJSONError(w, "failed to clear override", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(construction)
+}
+
+// GetConstructionOverrides returns the override history of a construction
+func GetConstructionOverrides(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+
+	var overrides []models.StatusOverride
+	if err := utils.DB.Where("construction_id = ?", id).Order("created_at desc").Find(&overrides).Error; err != nil {
+		http.Error(w, "failed to fetch overrides", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(overrides)
+}
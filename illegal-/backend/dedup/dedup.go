@@ -0,0 +1,179 @@
+// Package dedup finds reports whose photos were already submitted with
+// another report and keeps the matches for officers to review
+package dedup
+
+import (
+	"context"
+	"errors"
+	"math/bits"
+	"time"
+
+	"backend/geo"
+	"backend/jobs"
+	"backend/models"
+	"backend/utils"
+
+	"gorm.io/gorm/clause"
+)
+
+// Review statuses of a possible duplicate
+const (
+	StatusPending   = "pending"
+	StatusConfirmed = "confirmed"
+	StatusDismissed = "dismissed"
+)
+
+// Photos match when both hashes differ in at most this many of 64 bits.
+// pHash tolerates rescaling and recompression; dHash guards against
+// unrelated photos that happen to share their low frequencies.
+var (
+	MaxPHashDistance = 10
+	MaxDHashDistance = 16
+)
+
+// Photos are only compared with those of reports within SearchRadius metres
+// or whose photos were hashed within SearchWindow of the report's, which
+// keeps each check from reading every hash ever stored
+var (
+	SearchRadius = 2000.0
+	SearchWindow = 30 * 24 * time.Hour
+)
+
+// Distance is the number of bits in which two hashes differ
+func Distance(a, b int64) int {
+	return bits.OnesCount64(uint64(a ^ b))
+}
+
+// Check compares the photos of a report with those of nearby and recent
+// reports and records new matches as pending duplicates. The later report
+// of a pair is always the duplicate; matches already reviewed are kept as
+// they are.
+func Check(reportID uint) ([]models.ReportDuplicate, error) {
+	var own []models.ImageHash
+	if err := utils.DB.Where("report_id = ?", reportID).Find(&own).Error; err != nil {
+		return nil, err
+	}
+	if len(own) == 0 {
+		return nil, nil
+	}
+	others, err := candidates(reportID, own[0].CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the closest pair of photos per other report
+	best := map[uint]models.ReportDuplicate{}
+	for _, o := range others {
+		for _, h := range own {
+			dp, dd := Distance(h.PHash, o.PHash), Distance(h.DHash, o.DHash)
+			if dp > MaxPHashDistance || dd > MaxDHashDistance {
+				continue
+			}
+			if prev, ok := best[o.ReportID]; ok && prev.PHashDistance+prev.DHashDistance <= dp+dd {
+				continue
+			}
+			d := models.ReportDuplicate{
+				ReportID:            reportID,
+				DuplicateOfID:       o.ReportID,
+				ImageIndex:          h.ImageIndex,
+				DuplicateImageIndex: o.ImageIndex,
+				PHashDistance:       dp,
+				DHashDistance:       dd,
+				Status:              StatusPending,
+			}
+			if o.ReportID > reportID {
+				d.ReportID, d.DuplicateOfID = o.ReportID, reportID
+				d.ImageIndex, d.DuplicateImageIndex = o.ImageIndex, h.ImageIndex
+			}
+			best[o.ReportID] = d
+		}
+	}
+
+	found := make([]models.ReportDuplicate, 0, len(best))
+	for _, d := range best {
+		found = append(found, d)
+	}
+	if len(found) == 0 {
+		return found, nil
+	}
+	err = utils.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&found).Error
+	return found, err
+}
+
+// candidates returns the photo hashes of other reports hashed within
+// SearchWindow of hashedAt or located within SearchRadius of the report
+func candidates(reportID uint, hashedAt time.Time) ([]models.ImageHash, error) {
+	var report models.Report
+	if err := utils.DB.Select("id, coordinates").Where("id = ?", reportID).Limit(1).Find(&report).Error; err != nil {
+		return nil, err
+	}
+	scope := utils.DB.Where("created_at BETWEEN ? AND ?", hashedAt.Add(-SearchWindow), hashedAt.Add(SearchWindow))
+	if lat, lng, ok := report.LatLng(); ok {
+		near := geo.WithinBound(utils.DB.Model(&models.Report{}).Select("id"),
+			geo.ReportLatSQL, geo.ReportLngSQL, geo.BoundAround(lat, lng, SearchRadius))
+		scope = scope.Or("report_id IN (?)", near)
+	}
+	var others []models.ImageHash
+	err := utils.DB.Where("report_id <> ?", reportID).Where(scope).Find(&others).Error
+	return others, err
+}
+
+// DuplicateOf returns, for each of the given reports, the earlier reports it
+// may duplicate, leaving out dismissed matches
+func DuplicateOf(reportIDs []uint) (map[uint][]uint, error) {
+	out := map[uint][]uint{}
+	if len(reportIDs) == 0 {
+		return out, nil
+	}
+	var rows []models.ReportDuplicate
+	err := utils.DB.Where("report_id IN ? AND status <> ?", reportIDs, StatusDismissed).
+		Order("duplicate_of_id").Find(&rows).Error
+	for _, d := range rows {
+		out[d.ReportID] = append(out[d.ReportID], d.DuplicateOfID)
+	}
+	return out, err
+}
+
+// Forget removes a deleted report's hashes and matches
+func Forget(reportID uint) error {
+	if err := utils.DB.Where("report_id = ?", reportID).Delete(&models.ImageHash{}).Error; err != nil {
+		return err
+	}
+	return utils.DB.Where("report_id = ? OR duplicate_of_id = ?", reportID, reportID).Delete(&models.ReportDuplicate{}).Error
+}
+
+// ErrInvalidStatus rejects a review that neither confirms nor dismisses
+var ErrInvalidStatus = errors.New("status must be confirmed or dismissed")
+
+// Review records an officer's decision on a possible duplicate
+func Review(id uint, status string, officerID uint) (*models.ReportDuplicate, error) {
+	if status != StatusConfirmed && status != StatusDismissed {
+		return nil, ErrInvalidStatus
+	}
+	var d models.ReportDuplicate
+	if err := utils.DB.First(&d, id).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	d.Status, d.ReviewedBy, d.ReviewedAt = status, &officerID, &now
+	if err := utils.DB.Save(&d).Error; err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// JobReport checks a report whose photo hashes were just stored
+const JobReport = "dedup.report" // payload {"report_id": n}
+
+func init() {
+	jobs.Register(JobReport, func(ctx context.Context, job *models.Job) error {
+		var p struct {
+			ReportID uint `json:"report_id"`
+		}
+		if err := jobs.Decode(job, &p); err != nil {
+			return err
+		}
+		_, err := Check(p.ReportID)
+		return err
+	})
+}
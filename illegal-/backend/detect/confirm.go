@@ -0,0 +1,67 @@
+package detect
+
+import (
+	"errors"
+	"fmt"
+
+	"backend/geocode"
+	"backend/models"
+	"backend/utils"
+	"backend/zoning"
+
+	"gorm.io/gorm"
+)
+
+// AutoConfirm is the confidence at or above which model detections become
+// constructions without review; 0 sends everything to the review queue
+var AutoConfirm = 0.0
+
+// ErrReviewed is returned when a detection is no longer a candidate
+var ErrReviewed = errors.New("detection has already been reviewed")
+
+// Confirm turns a candidate detection into a construction, geocoded and
+// evaluated like any other, with the detector as its DetectionSource. The
+// detection is claimed with a conditional update, so of two concurrent
+// confirmations only one creates a construction and the other gets ErrReviewed.
+func Confirm(d *models.Detection) (*models.Construction, error) {
+	construction := &models.Construction{
+		Latitude:           d.Latitude,
+		Longitude:          d.Longitude,
+		Footprint:          d.Footprint,
+		DetectionSource:    d.Source,
+		ComparisonImageURL: d.HeatmapURL,
+		ChangeScore:        d.Score,
+	}
+	if err := geocode.ApplyConstruction(construction); err != nil {
+		return nil, fmt.Errorf("geocoding: %v", err)
+	}
+	construction.Location = construction.Street
+	if construction.Location == "" {
+		construction.Location = fmt.Sprintf("%.6f, %.6f", construction.Latitude, construction.Longitude)
+	}
+	if err := zoning.Apply(construction); err != nil {
+		return nil, fmt.Errorf("zoning: %v", err)
+	}
+
+	err := utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(construction).Error; err != nil {
+			return err
+		}
+		res := tx.Model(&models.Detection{}).
+			Where("id = ? AND status = ?", d.ID, "candidate").
+			Updates(map[string]interface{}{"status": "confirmed", "construction_id": construction.ID})
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return ErrReviewed
+		}
+		d.Status = "confirmed"
+		d.ConstructionID = &construction.ID
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return construction, nil
+}
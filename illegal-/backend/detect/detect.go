@@ -0,0 +1,224 @@
+// Package detect runs building-footprint models over imagery and records
+// what they find as candidate detections
+package detect
+
+import (
+	"context"
+	"errors"
+	"image"
+	"sort"
+	"sync"
+
+	"backend/alerts"
+	"backend/geo"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+)
+
+// Footprint is one object found by a detector, in pixel coordinates of the
+// input image with y pointing down
+type Footprint struct {
+	Polygon    [][2]float64 `json:"polygon"` // outer ring, closed or not
+	Confidence float64      `json:"confidence"`
+	Label      string       `json:"label"`
+}
+
+// Detector finds building footprints in an image
+type Detector interface {
+	// Name identifies the model; it becomes the DetectionSource of
+	// constructions created from its detections
+	Name() string
+	Detect(ctx context.Context, img image.Image) ([]Footprint, error)
+}
+
+var (
+	mu        sync.RWMutex
+	detectors = map[string]Detector{}
+)
+
+// Register makes a detector available by name
+func Register(d Detector) {
+	mu.Lock()
+	defer mu.Unlock()
+	detectors[d.Name()] = d
+}
+
+// Get returns the named detector
+func Get(name string) (Detector, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	d, ok := detectors[name]
+	return d, ok
+}
+
+// Names lists the registered detectors
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(detectors))
+	for name := range detectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MinConfidence drops footprints the model is unsure about
+var MinConfidence = 0.5
+
+// Options describe where an image lies on the ground and which scene it
+// came from, if any
+type Options struct {
+	Bound   orb.Bound // ground covered by the image, north up
+	SceneID *uint
+	TileID  *uint
+}
+
+// Run detects footprints in img, projects them onto the ground and stores
+// the ones not already covering a known construction as candidate
+// detections, confirming those at or above AutoConfirm straight away. A
+// footprint over an earlier detection by the same model, e.g. when a tile is
+// run again, refreshes that detection while it is a candidate and is
+// dropped once it has been reviewed. On error the detections stored so far
+// are returned with it.
+func Run(ctx context.Context, d Detector, img image.Image, opt Options) ([]models.Detection, error) {
+	if b := opt.Bound; b.Max.Lon() <= b.Min.Lon() || b.Max.Lat() <= b.Min.Lat() {
+		return nil, errors.New("image bound is required")
+	}
+	found, err := d.Detect(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+
+	size := img.Bounds().Size()
+	var stored []models.Detection
+	for _, f := range found {
+		if f.Confidence < MinConfidence || len(f.Polygon) < 3 {
+			continue
+		}
+		poly := project(f.Polygon, size, opt.Bound)
+		lat, lng := geo.Centroid(poly)
+
+		known, err := coversConstruction(poly)
+		if err != nil {
+			return stored, err
+		}
+		if known {
+			continue
+		}
+		earlier, err := earlierDetection(d.Name(), poly)
+		if err != nil {
+			return stored, err
+		}
+		if earlier != nil && earlier.Status != "candidate" {
+			continue
+		}
+
+		footprint, err := geojson.NewGeometry(poly).MarshalJSON()
+		if err != nil {
+			return stored, err
+		}
+		det := models.Detection{
+			Source:      d.Name(),
+			SceneID:     opt.SceneID,
+			TileID:      opt.TileID,
+			Label:       f.Label,
+			Footprint:   footprint,
+			Latitude:    lat,
+			Longitude:   lng,
+			Confidence:  f.Confidence,
+			ChangedArea: geo.Area(poly),
+			Status:      "candidate",
+		}
+		if earlier != nil {
+			det.ID, det.CreatedAt = earlier.ID, earlier.CreatedAt
+			if err := utils.DB.Save(&det).Error; err != nil {
+				return stored, err
+			}
+		} else {
+			if err := utils.DB.Create(&det).Error; err != nil {
+				return stored, err
+			}
+			if err := alerts.Enqueue(nil, alerts.EventDetection, det.ID); err != nil {
+				return stored, err
+			}
+		}
+		if AutoConfirm > 0 && det.Confidence >= AutoConfirm {
+			if _, err := Confirm(&det); err != nil && !errors.Is(err, ErrReviewed) {
+				return stored, err
+			}
+		}
+		stored = append(stored, det)
+	}
+	return stored, nil
+}
+
+// earlierDetection returns a detection by the named model whose position
+// lies inside the footprint, or nil
+func earlierDetection(source string, poly orb.Polygon) (*models.Detection, error) {
+	var candidates []models.Detection
+	q := geo.WithinBound(utils.DB.Where("source = ?", source), "latitude", "longitude", poly.Bound())
+	if err := q.Order("id").Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+	for i, c := range candidates {
+		if geo.Contains(poly, c.Latitude, c.Longitude) {
+			return &candidates[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// project maps a pixel ring onto the ground bound of the image
+func project(ring [][2]float64, size image.Point, b orb.Bound) orb.Polygon {
+	out := make(orb.Ring, 0, len(ring)+1)
+	for _, p := range ring {
+		lng := b.Min.Lon() + p[0]/float64(size.X)*(b.Max.Lon()-b.Min.Lon())
+		lat := b.Max.Lat() - p[1]/float64(size.Y)*(b.Max.Lat()-b.Min.Lat())
+		out = append(out, orb.Point{lng, lat})
+	}
+	if !out.Closed() {
+		out = append(out, out[0])
+	}
+	// pixel rings with y down come out clockwise; GeoJSON wants outer rings
+	// counter-clockwise
+	if out.Orientation() == orb.CW {
+		out.Reverse()
+	}
+	return orb.Polygon{out}
+}
+
+// coversConstruction reports whether a construction is already recorded
+// inside the footprint
+func coversConstruction(poly orb.Polygon) (bool, error) {
+	var constructions []models.Construction
+	q := geo.WithinBound(utils.DB.Select("id, latitude, longitude"), "latitude", "longitude", poly.Bound())
+	if err := q.Find(&constructions).Error; err != nil {
+		return false, err
+	}
+	for _, c := range constructions {
+		if geo.Contains(poly, c.Latitude, c.Longitude) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// RunAll runs every registered detector over img, returning the detections
+// stored and the first error encountered
+func RunAll(ctx context.Context, img image.Image, opt Options) ([]models.Detection, error) {
+	var all []models.Detection
+	var firstErr error
+	for _, name := range Names() {
+		d, _ := Get(name)
+		found, err := Run(ctx, d, img, opt)
+		if err != nil && firstErr == nil {
+			firstErr = errors.New(name + ": " + err.Error())
+		}
+		all = append(all, found...)
+	}
+	return all, firstErr
+}
@@ -0,0 +1,135 @@
+package detect
+
+import (
+	"context"
+	"image"
+	"math"
+	"os"
+	"testing"
+
+	"backend/alerts"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/paulmach/orb"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// fakeDetector returns the same footprints for every image
+type fakeDetector struct {
+	name  string
+	found []Footprint
+}
+
+func (f fakeDetector) Name() string { return f.name }
+
+func (f fakeDetector) Detect(ctx context.Context, img image.Image) ([]Footprint, error) {
+	return f.found, nil
+}
+
+func TestProjectFlipsRowsAndWindsCounterClockwise(t *testing.T) {
+	b := orb.Bound{Min: orb.Point{20, 10}, Max: orb.Point{21, 11}}
+	size := image.Pt(100, 200)
+	// clockwise on screen: top-left, top-right, bottom-right, bottom-left
+	poly := project([][2]float64{{0, 0}, {50, 0}, {50, 100}, {0, 100}}, size, b)
+
+	ring := poly[0]
+	if !ring.Closed() || len(ring) != 5 {
+		t.Fatalf("ring = %v, want 4 corners and the closing point", ring)
+	}
+	if ring.Orientation() != orb.CCW {
+		t.Errorf("ring is not counter-clockwise: %v", ring)
+	}
+	want := orb.Bound{Min: orb.Point{20, 10.5}, Max: orb.Point{20.5, 11}}
+	got := poly.Bound()
+	if math.Abs(got.Min.Lon()-want.Min.Lon()) > 1e-9 || math.Abs(got.Min.Lat()-want.Min.Lat()) > 1e-9 ||
+		math.Abs(got.Max.Lon()-want.Max.Lon()) > 1e-9 || math.Abs(got.Max.Lat()-want.Max.Lat()) > 1e-9 {
+		t.Errorf("projected bound = %v, want the north-west quarter %v", got, want)
+	}
+}
+
+func TestRunSkipsWeakFootprints(t *testing.T) {
+	defer func(db *gorm.DB) { utils.DB = db }(utils.DB)
+	utils.DB = nil // any query would panic
+	d := fakeDetector{name: "weak", found: []Footprint{
+		{Polygon: [][2]float64{{0, 0}, {10, 0}, {10, 10}}, Confidence: MinConfidence / 2},
+		{Polygon: [][2]float64{{0, 0}, {10, 0}}, Confidence: 1},
+	}}
+	img := image.NewGray(image.Rect(0, 0, 100, 100))
+	if _, err := Run(context.Background(), d, img, Options{}); err == nil {
+		t.Error("run without an image bound succeeded")
+	}
+	stored, err := Run(context.Background(), d, img, Options{Bound: orb.Bound{Min: orb.Point{20, 10}, Max: orb.Point{21, 11}}})
+	if err != nil || len(stored) != 0 {
+		t.Errorf("weak and degenerate footprints stored %v, %v", stored, err)
+	}
+}
+
+// testDB connects to TEST_DATABASE_URL, skipping the test when it is unset.
+// Detections are made by a detector named after the test and removed
+// afterwards, with the alert evaluations they queued.
+func testDB(t *testing.T) string {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Detection{}, &models.Construction{}, &models.Job{}); err != nil {
+		t.Fatal(err)
+	}
+	prev := utils.DB
+	utils.DB = db
+	source := "test." + t.Name()
+	clean := func() {
+		var ids []uint
+		db.Model(&models.Detection{}).Where("source = ?", source).Pluck("id", &ids)
+		for _, id := range ids {
+			db.Where("type = ? AND payload->>'event' = ? AND (payload->>'id')::int = ?", alerts.JobEvaluate, alerts.EventDetection, id).Delete(&models.Job{})
+		}
+		db.Where("source = ?", source).Delete(&models.Detection{})
+	}
+	clean()
+	t.Cleanup(func() {
+		clean()
+		utils.DB = prev
+	})
+	return source
+}
+
+func TestRunRefreshesCandidatesAndSkipsReviewed(t *testing.T) {
+	source := testDB(t)
+	// a patch of the Southern Ocean no construction will ever be recorded in
+	opt := Options{Bound: orb.Bound{Min: orb.Point{-150, -70}, Max: orb.Point{-149.999, -69.999}}}
+	img := image.NewGray(image.Rect(0, 0, 100, 100))
+	square := [][2]float64{{40, 40}, {60, 40}, {60, 60}, {40, 60}}
+	d := fakeDetector{name: source, found: []Footprint{{Polygon: square, Confidence: 0.6, Label: "building"}}}
+
+	first, err := Run(context.Background(), d, img, opt)
+	if err != nil || len(first) != 1 {
+		t.Fatalf("first run = %v, %v; want one detection", first, err)
+	}
+
+	d.found[0].Confidence = 0.8
+	again, err := Run(context.Background(), d, img, opt)
+	if err != nil || len(again) != 1 {
+		t.Fatalf("second run = %v, %v; want one detection", again, err)
+	}
+	if again[0].ID != first[0].ID || again[0].Confidence != 0.8 {
+		t.Errorf("second run stored #%d at %v, want #%d refreshed to 0.8", again[0].ID, again[0].Confidence, first[0].ID)
+	}
+
+	utils.DB.Model(&models.Detection{}).Where("id = ?", first[0].ID).Update("status", "dismissed")
+	reviewed, err := Run(context.Background(), d, img, opt)
+	if err != nil || len(reviewed) != 0 {
+		t.Errorf("run over a dismissed detection = %v, %v; want nothing stored", reviewed, err)
+	}
+	var n int64
+	utils.DB.Model(&models.Detection{}).Where("source = ?", source).Count(&n)
+	if n != 1 {
+		t.Errorf("%d detections stored, want the one", n)
+	}
+}
@@ -0,0 +1,66 @@
+package detect
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ProcessDetector runs an external model for every image. The image is
+// written to the command's stdin as PNG and the command must print
+//
+//	{"detections": [{"polygon": [[x, y], ...], "confidence": 0.93, "label": "building"}]}
+//
+// on stdout, with polygons in pixel coordinates. Any runtime can sit behind
+// it, e.g. a Python script wrapping an ONNX Runtime CPU session.
+type ProcessDetector struct {
+	ModelName string
+	Command   []string
+	Timeout   time.Duration // per image, default 2 minutes
+}
+
+// Name implements Detector
+func (p ProcessDetector) Name() string {
+	return p.ModelName
+}
+
+// Detect implements Detector
+func (p ProcessDetector) Detect(ctx context.Context, img image.Image) ([]Footprint, error) {
+	if len(p.Command) == 0 {
+		return nil, fmt.Errorf("detector %s has no command", p.ModelName)
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var in, out, stderr bytes.Buffer
+	if err := png.Encode(&in, img); err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = &in, &out, &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 500 {
+			msg = msg[len(msg)-500:]
+		}
+		return nil, fmt.Errorf("detector %s failed: %v: %s", p.ModelName, err, msg)
+	}
+
+	var resp struct {
+		Detections []Footprint `json:"detections"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("detector %s printed invalid JSON: %v", p.ModelName, err)
+	}
+	return resp.Detections, nil
+}
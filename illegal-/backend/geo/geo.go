@@ -0,0 +1,174 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/paulmach/orb"
+	orbgeo "github.com/paulmach/orb/geo"
+	"github.com/paulmach/orb/planar"
+)
+
+// Layer kinds understood by the importer
+const (
+	KindWard        = "ward"
+	KindZone        = "zone"
+	KindRiverBuffer = "river_buffer"
+	KindHeritage    = "heritage"
+)
+
+// ValidKind reports whether kind is one of the supported layer kinds
+func ValidKind(kind string) bool {
+	switch kind {
+	case KindWard, KindZone, KindRiverBuffer, KindHeritage:
+		return true
+	}
+	return false
+}
+
+// Feature is a single boundary read from an import file
+type Feature struct {
+	Name       string
+	Properties map[string]interface{}
+	Geometry   orb.Geometry
+}
+
+// nameKeys are the attribute names tried (case-insensitively) when a feature
+// name is not given explicitly
+var nameKeys = []string{"name", "ward_name", "ward", "zone_name", "zone", "label", "id"}
+
+// featureName picks a display name for a feature from its attributes
+func featureName(props map[string]interface{}, nameField string, index int) string {
+	keys := nameKeys
+	if nameField != "" {
+		keys = []string{nameField}
+	}
+	for _, key := range keys {
+		for k, v := range props {
+			if !strings.EqualFold(k, key) || v == nil {
+				continue
+			}
+			if s := strings.TrimSpace(fmt.Sprint(v)); s != "" {
+				return s
+			}
+		}
+	}
+	return fmt.Sprintf("Feature %d", index+1)
+}
+
+// polygonal keeps only area geometries; points and lines cannot contain anything
+func polygonal(g orb.Geometry) orb.Geometry {
+	switch v := g.(type) {
+	case orb.Polygon, orb.MultiPolygon:
+		return v
+	case orb.Collection:
+		var mp orb.MultiPolygon
+		for _, part := range v {
+			switch p := polygonal(part).(type) {
+			case orb.Polygon:
+				mp = append(mp, p)
+			case orb.MultiPolygon:
+				mp = append(mp, p...)
+			}
+		}
+		if len(mp) > 0 {
+			return mp
+		}
+	}
+	return nil
+}
+
+// Polygonal reports whether g is a polygon or multipolygon
+func Polygonal(g orb.Geometry) bool {
+	switch g.(type) {
+	case orb.Polygon, orb.MultiPolygon:
+		return true
+	}
+	return false
+}
+
+// Contains reports whether the polygon or multipolygon g contains the point.
+// Coordinates follow GeoJSON order, so the point is built as (lng, lat).
+func Contains(g orb.Geometry, lat, lng float64) bool {
+	pt := orb.Point{lng, lat}
+	switch v := g.(type) {
+	case orb.Polygon:
+		return planar.PolygonContains(v, pt)
+	case orb.MultiPolygon:
+		return planar.MultiPolygonContains(v, pt)
+	}
+	return false
+}
+
+// Centroid returns the area-weighted centre of g as lat, lng
+func Centroid(g orb.Geometry) (float64, float64) {
+	c, _ := planar.CentroidArea(g)
+	return c.Lat(), c.Lon()
+}
+
+// Approximate metres per degree, good enough for municipal distances
+const (
+	metersPerDegreeLat = 110540.0
+	metersPerDegreeLng = 111320.0
+)
+
+// BoundAround returns a box extending meters in every direction from the point
+func BoundAround(lat, lng, meters float64) orb.Bound {
+	dLat := meters / metersPerDegreeLat
+	dLng := meters / (metersPerDegreeLng * math.Cos(lat*math.Pi/180))
+	return orb.Bound{
+		Min: orb.Point{lng - dLng, lat - dLat},
+		Max: orb.Point{lng + dLng, lat + dLat},
+	}
+}
+
+// Distance returns the distance in metres between two points, using a local
+// flat projection
+func Distance(lat1, lng1, lat2, lng2 float64) float64 {
+	kx := metersPerDegreeLng * math.Cos((lat1+lat2)/2*math.Pi/180)
+	return math.Hypot((lng2-lng1)*kx, (lat2-lat1)*metersPerDegreeLat)
+}
+
+// DistanceToBoundary returns the distance in metres from the point to the
+// nearest edge of the polygon or multipolygon g, using a local flat projection
+func DistanceToBoundary(g orb.Geometry, lat, lng float64) float64 {
+	var rings []orb.Ring
+	switch v := g.(type) {
+	case orb.Polygon:
+		rings = v
+	case orb.MultiPolygon:
+		for _, p := range v {
+			rings = append(rings, p...)
+		}
+	}
+
+	kx := metersPerDegreeLng * math.Cos(lat*math.Pi/180)
+	ky := metersPerDegreeLat
+	best := math.Inf(1)
+	for _, ring := range rings {
+		for i := 0; i+1 < len(ring); i++ {
+			ax, ay := (ring[i][0]-lng)*kx, (ring[i][1]-lat)*ky
+			bx, by := (ring[i+1][0]-lng)*kx, (ring[i+1][1]-lat)*ky
+			if d := segmentDistance(ax, ay, bx, by); d < best {
+				best = d
+			}
+		}
+	}
+	return best
+}
+
+// segmentDistance is the distance from the origin to the segment a-b
+func segmentDistance(ax, ay, bx, by float64) float64 {
+	dx, dy := bx-ax, by-ay
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l))
+	}
+	return math.Hypot(ax+t*dx, ay+t*dy)
+}
+
+// Area returns the area of g in square metres
+func Area(g orb.Geometry) float64 {
+	return orbgeo.Area(g)
+}
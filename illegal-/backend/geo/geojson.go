@@ -0,0 +1,64 @@
+package geo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/paulmach/orb/geojson"
+)
+
+// ParseGeoJSON reads polygon features from a FeatureCollection, a single
+// Feature or a bare geometry. Non-polygon features are skipped and counted.
+func ParseGeoJSON(data []byte, nameField string) ([]Feature, int, error) {
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return nil, 0, fmt.Errorf("invalid GeoJSON: %w", err)
+	}
+
+	var src []*geojson.Feature
+	switch head.Type {
+	case "FeatureCollection":
+		fc, err := geojson.UnmarshalFeatureCollection(data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid feature collection: %w", err)
+		}
+		src = fc.Features
+	case "Feature":
+		f, err := geojson.UnmarshalFeature(data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid feature: %w", err)
+		}
+		src = []*geojson.Feature{f}
+	case "":
+		return nil, 0, errors.New("GeoJSON object has no type")
+	default:
+		g, err := geojson.UnmarshalGeometry(data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid geometry: %w", err)
+		}
+		src = []*geojson.Feature{geojson.NewFeature(g.Geometry())}
+	}
+
+	var features []Feature
+	skipped := 0
+	for i, f := range src {
+		g := polygonal(f.Geometry)
+		if g == nil {
+			skipped++
+			continue
+		}
+		props := map[string]interface{}(f.Properties)
+		if props == nil {
+			props = map[string]interface{}{}
+		}
+		features = append(features, Feature{
+			Name:       featureName(props, nameField, i),
+			Properties: props,
+			Geometry:   g,
+		})
+	}
+	return features, skipped, nil
+}
@@ -0,0 +1,265 @@
+package geo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"backend/jobs"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Shape is a stored layer feature with its geometry decoded
+type Shape struct {
+	models.LayerFeature
+	Kind string // kind of the layer the feature belongs to
+	Geom orb.Geometry
+}
+
+// DecodeGeometry parses a stored GeoJSON geometry
+func DecodeGeometry(data datatypes.JSON) (orb.Geometry, error) {
+	g, err := geojson.UnmarshalGeometry(data)
+	if err != nil {
+		return nil, err
+	}
+	return g.Geometry(), nil
+}
+
+// SaveLayer creates the named layer, or replaces the features of an existing
+// layer with the same name, in a single transaction
+func SaveLayer(name, kind, source string, features []Feature) (models.Layer, error) {
+	var layer models.Layer
+	err := utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("name = ?", name).FirstOrInit(&layer).Error; err != nil {
+			return err
+		}
+		layer.Name = name
+		layer.Kind = kind
+		layer.SourceFile = source
+		layer.FeatureCount = len(features)
+		if err := tx.Save(&layer).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("layer_id = ?", layer.ID).Delete(&models.LayerFeature{}).Error; err != nil {
+			return err
+		}
+
+		rows := make([]models.LayerFeature, 0, len(features))
+		for _, f := range features {
+			geom, err := geojson.NewGeometry(f.Geometry).MarshalJSON()
+			if err != nil {
+				return err
+			}
+			props, err := json.Marshal(f.Properties)
+			if err != nil {
+				return err
+			}
+			b := f.Geometry.Bound()
+			rows = append(rows, models.LayerFeature{
+				LayerID:    layer.ID,
+				Name:       f.Name,
+				Properties: datatypes.JSON(props),
+				Geometry:   datatypes.JSON(geom),
+				MinLat:     b.Min.Lat(),
+				MinLng:     b.Min.Lon(),
+				MaxLat:     b.Max.Lat(),
+				MaxLng:     b.Max.Lon(),
+			})
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(&rows, 200).Error
+	})
+	return layer, err
+}
+
+// LoadShapes returns the decoded features of every layer of the given kind,
+// optionally restricted to those whose bounding box intersects bound
+func LoadShapes(kind string, bound *orb.Bound) ([]Shape, error) {
+	q := utils.DB.Model(&models.LayerFeature{}).
+		Joins("JOIN layers ON layers.id = layer_features.layer_id").
+		Where("layers.kind = ?", kind).
+		Order("layers.id, layer_features.id")
+	if bound != nil {
+		q = IntersectsBound(q, "layer_features", *bound)
+	}
+
+	var rows []models.LayerFeature
+	if err := q.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	shapes := make([]Shape, 0, len(rows))
+	for _, row := range rows {
+		g, err := DecodeGeometry(row.Geometry)
+		if err != nil {
+			continue
+		}
+		shapes = append(shapes, Shape{LayerFeature: row, Kind: kind, Geom: g})
+	}
+	return shapes, nil
+}
+
+// FeaturesAt returns all features of the given kind that contain the point
+func FeaturesAt(kind string, lat, lng float64) ([]Shape, error) {
+	b := orb.Point{lng, lat}.Bound()
+	shapes, err := LoadShapes(kind, &b)
+	if err != nil {
+		return nil, err
+	}
+	var hits []Shape
+	for _, s := range shapes {
+		if Contains(s.Geom, lat, lng) {
+			hits = append(hits, s)
+		}
+	}
+	return hits, nil
+}
+
+// ShapesNear returns all features of the given kind whose bounding box lies
+// within meters of the point
+func ShapesNear(kind string, lat, lng, meters float64) ([]Shape, error) {
+	b := BoundAround(lat, lng, meters)
+	return LoadShapes(kind, &b)
+}
+
+// WardAt returns the id of the first ward feature containing the point, or
+// nil when the point lies outside every imported ward
+func WardAt(lat, lng float64) *uint {
+	if lat == 0 && lng == 0 {
+		return nil
+	}
+	hits, err := FeaturesAt(KindWard, lat, lng)
+	if err != nil || len(hits) == 0 {
+		return nil
+	}
+	id := hits[0].ID
+	return &id
+}
+
+// wardFor finds the containing ward among preloaded shapes
+func wardFor(wards []Shape, lat, lng float64) *uint {
+	if lat == 0 && lng == 0 {
+		return nil
+	}
+	for _, w := range wards {
+		if lat < w.MinLat || lat > w.MaxLat || lng < w.MinLng || lng > w.MaxLng {
+			continue
+		}
+		if Contains(w.Geom, lat, lng) {
+			id := w.ID
+			return &id
+		}
+	}
+	return nil
+}
+
+// AssignWards recomputes the ward of every construction and report. It is run
+// after a ward layer is imported, replaced or deleted. Rows are updated in
+// one transaction, grouped by ward, so a failure leaves the old wards.
+func AssignWards() (int, int, error) {
+	wards, err := LoadShapes(KindWard, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var constructions []models.Construction
+	if err := utils.DB.Select("id", "latitude", "longitude").Find(&constructions).Error; err != nil {
+		return 0, 0, err
+	}
+	constructionWards := map[uint][]uint{} // ward -> constructions, 0 for none
+	assignedConstructions := 0
+	for _, c := range constructions {
+		ward := wardFor(wards, c.Latitude, c.Longitude)
+		if ward != nil {
+			assignedConstructions++
+			constructionWards[*ward] = append(constructionWards[*ward], c.ID)
+		} else {
+			constructionWards[0] = append(constructionWards[0], c.ID)
+		}
+	}
+
+	var reports []models.Report
+	if err := utils.DB.Find(&reports).Error; err != nil {
+		return 0, 0, err
+	}
+	reportWards := map[uint][]uint{}
+	assignedReports := 0
+	for _, r := range reports {
+		var ward *uint
+		if lat, lng, ok := r.LatLng(); ok {
+			ward = wardFor(wards, lat, lng)
+		}
+		if ward != nil {
+			assignedReports++
+			reportWards[*ward] = append(reportWards[*ward], r.ID)
+		} else {
+			reportWards[0] = append(reportWards[0], r.ID)
+		}
+	}
+
+	err = utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := setWards(tx, &models.Construction{}, constructionWards); err != nil {
+			return err
+		}
+		return setWards(tx, &models.Report{}, reportWards)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return assignedConstructions, assignedReports, nil
+}
+
+// setWards sets ward_id on the rows of model listed under each ward, with
+// ward 0 standing for none
+func setWards(tx *gorm.DB, model interface{}, byWard map[uint][]uint) error {
+	for ward, ids := range byWard {
+		var value *uint
+		if ward != 0 {
+			w := ward
+			value = &w
+		}
+		for start := 0; start < len(ids); start += 1000 {
+			end := start + 1000
+			if end > len(ids) {
+				end = len(ids)
+			}
+			if err := tx.Model(model).Where("id IN ?", ids[start:end]).Update("ward_id", value).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// JobAssignWards runs AssignWards in the background
+const JobAssignWards = "geo.assign_wards"
+
+func init() {
+	jobs.Register(JobAssignWards, func(ctx context.Context, job *models.Job) error {
+		_, _, err := AssignWards()
+		return err
+	})
+}
+
+// Prop returns a feature attribute as a trimmed string
+func (s Shape) Prop(key string) string {
+	var props map[string]interface{}
+	if json.Unmarshal(s.Properties, &props) != nil {
+		return ""
+	}
+	for k, v := range props {
+		if strings.EqualFold(k, key) && v != nil {
+			return strings.TrimSpace(fmt.Sprint(v))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,32 @@
+package geo
+
+import (
+	"github.com/paulmach/orb"
+	"gorm.io/gorm"
+)
+
+// SQL expressions reading the optional { lat, lng } report coordinates; they
+// yield NULL instead of failing when the JSON holds something else
+const (
+	ReportLatSQL = "(CASE WHEN jsonb_typeof(coordinates->'lat') = 'number' THEN (coordinates->>'lat')::float END)"
+	ReportLngSQL = "(CASE WHEN jsonb_typeof(coordinates->'lng') = 'number' THEN (coordinates->>'lng')::float END)"
+)
+
+// CreateIndexes adds the expression index that lets WithinBound on
+// ReportLatSQL/ReportLngSQL avoid scanning every report
+func CreateIndexes(db *gorm.DB) error {
+	return db.Exec("CREATE INDEX IF NOT EXISTS idx_reports_position ON reports (" + ReportLatSQL + ", " + ReportLngSQL + ")").Error
+}
+
+// WithinBound restricts q to rows whose point columns fall inside b
+func WithinBound(q *gorm.DB, latCol, lngCol string, b orb.Bound) *gorm.DB {
+	return q.Where(latCol+" BETWEEN ? AND ? AND "+lngCol+" BETWEEN ? AND ?",
+		b.Min.Lat(), b.Max.Lat(), b.Min.Lon(), b.Max.Lon())
+}
+
+// IntersectsBound restricts q to rows whose min_lat/min_lng/max_lat/max_lng
+// bounding box columns (prefixed by table) overlap b
+func IntersectsBound(q *gorm.DB, table string, b orb.Bound) *gorm.DB {
+	return q.Where(table+".min_lat <= ? AND "+table+".max_lat >= ? AND "+table+".min_lng <= ? AND "+table+".max_lng >= ?",
+		b.Max.Lat(), b.Min.Lat(), b.Max.Lon(), b.Min.Lon())
+}
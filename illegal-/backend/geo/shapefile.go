@@ -0,0 +1,100 @@
+package geo
+
+import (
+	"fmt"
+
+	"github.com/jonas-p/go-shp"
+	"github.com/paulmach/orb"
+)
+
+// ParseShapefileZip reads polygon features from a zipped Shapefile (.shp with
+// optional .dbf attributes). Coordinates are expected in WGS84 longitude and
+// latitude; the .prj file is not interpreted.
+func ParseShapefileZip(path string, nameField string) ([]Feature, int, error) {
+	zr, err := shp.OpenZip(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid shapefile archive: %w", err)
+	}
+	defer zr.Close()
+
+	fields := zr.Fields()
+
+	var features []Feature
+	skipped := 0
+	for zr.Next() {
+		n, shape := zr.Shape()
+
+		var parts []int32
+		var points []shp.Point
+		switch s := shape.(type) {
+		case *shp.Polygon:
+			parts, points = s.Parts, s.Points
+		case *shp.PolygonZ:
+			parts, points = s.Parts, s.Points
+		case *shp.PolygonM:
+			parts, points = s.Parts, s.Points
+		default:
+			skipped++
+			continue
+		}
+
+		g := shapeToMultiPolygon(parts, points)
+		if len(g) == 0 {
+			skipped++
+			continue
+		}
+
+		props := make(map[string]interface{}, len(fields))
+		for i, f := range fields {
+			props[f.String()] = zr.Attribute(i)
+		}
+
+		var geom orb.Geometry = g
+		if len(g) == 1 {
+			geom = g[0]
+		}
+		features = append(features, Feature{
+			Name:       featureName(props, nameField, n),
+			Properties: props,
+			Geometry:   geom,
+		})
+	}
+	if err := zr.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read shapefile: %w", err)
+	}
+	return features, skipped, nil
+}
+
+// shapeToMultiPolygon splits shapefile parts into polygons. Shapefiles store
+// outer rings clockwise and holes counter-clockwise; the result uses the
+// GeoJSON convention (outer counter-clockwise) instead.
+func shapeToMultiPolygon(parts []int32, points []shp.Point) orb.MultiPolygon {
+	var mp orb.MultiPolygon
+	for i := range parts {
+		start := int(parts[i])
+		end := len(points)
+		if i+1 < len(parts) {
+			end = int(parts[i+1])
+		}
+		if start < 0 || end > len(points) || end-start < 4 {
+			continue
+		}
+
+		ring := make(orb.Ring, 0, end-start)
+		for _, p := range points[start:end] {
+			ring = append(ring, orb.Point{p.X, p.Y})
+		}
+
+		if ring.Orientation() == orb.CW || len(mp) == 0 {
+			if ring.Orientation() == orb.CW {
+				ring.Reverse()
+			}
+			mp = append(mp, orb.Polygon{ring})
+			continue
+		}
+		ring.Reverse()
+		last := len(mp) - 1
+		mp[last] = append(mp[last], ring)
+	}
+	return mp
+}
@@ -0,0 +1,366 @@
+package geocode
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"backend/alerts"
+	"backend/geo"
+	"backend/jobs"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/paulmach/orb"
+	orbgeo "github.com/paulmach/orb/geo"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// MaxReverseDistance is how far in metres the nearest address point may be
+// from a location before reverse geocoding gives up
+var MaxReverseDistance = 150.0
+
+// MinForwardScore is the share of an address point's words that must appear
+// in a free-text address for a forward match
+var MinForwardScore = 0.6
+
+// maxCandidates bounds the address points scored for one forward lookup
+const maxCandidates = 2000
+
+// searchVector is the expression the full-text index covers; queries must
+// repeat it exactly for the index to be used
+const searchVector = "to_tsvector('simple', search_text)"
+
+// CreateIndexes adds the full-text index forward lookups use, replacing the
+// B-tree index earlier releases kept on search_text
+func CreateIndexes(db *gorm.DB) error {
+	if err := db.Exec("DROP INDEX IF EXISTS idx_gazetteer_entries_search_text").Error; err != nil {
+		return err
+	}
+	return db.Exec("CREATE INDEX IF NOT EXISTS idx_gazetteer_search ON gazetteer_entries USING GIN (" + searchVector + ")").Error
+}
+
+// Result is a geocoded address
+type Result struct {
+	EntryID     uint    `json:"entryId"`
+	HouseNumber string  `json:"houseNumber"`
+	Street      string  `json:"street"`
+	Locality    string  `json:"locality"`
+	Postcode    string  `json:"postcode"`
+	Ward        string  `json:"ward"`
+	WardID      *uint   `json:"wardId"`
+	Lat         float64 `json:"lat"`
+	Lng         float64 `json:"lng"`
+	Distance    float64 `json:"distance,omitempty"` // metres from the queried point (reverse)
+	Score       float64 `json:"score,omitempty"`    // share of matched words (forward)
+}
+
+func newResult(e models.GazetteerEntry) *Result {
+	return &Result{
+		EntryID:     e.ID,
+		HouseNumber: e.HouseNumber,
+		Street:      e.Street,
+		Locality:    e.Locality,
+		Postcode:    e.Postcode,
+		Ward:        e.Ward,
+		Lat:         e.Latitude,
+		Lng:         e.Longitude,
+	}
+}
+
+// resolveWard sets the ward from the ward layer containing (lat, lng), or
+// failing that from a ward feature named like the gazetteer's ward
+func (res *Result) resolveWard(lat, lng float64) error {
+	hits, err := geo.FeaturesAt(geo.KindWard, lat, lng)
+	if err != nil {
+		return err
+	}
+	if len(hits) > 0 {
+		id := hits[0].ID
+		res.WardID, res.Ward = &id, hits[0].Name
+		return nil
+	}
+	if res.Ward == "" {
+		return nil
+	}
+	var feature models.LayerFeature
+	err = utils.DB.Joins("JOIN layers ON layers.id = layer_features.layer_id").
+		Where("layers.kind = ? AND LOWER(layer_features.name) = LOWER(?)", geo.KindWard, res.Ward).
+		Limit(1).Find(&feature).Error
+	if err != nil {
+		return err
+	}
+	if feature.ID != 0 {
+		res.WardID, res.Ward = &feature.ID, feature.Name
+	}
+	return nil
+}
+
+// Reverse returns the address point nearest to (lat, lng), or nil when none
+// lies within MaxReverseDistance
+func Reverse(lat, lng float64) (*Result, error) {
+	if lat == 0 && lng == 0 {
+		return nil, nil
+	}
+	var entries []models.GazetteerEntry
+	q := geo.WithinBound(utils.DB.Model(&models.GazetteerEntry{}), "latitude", "longitude", geo.BoundAround(lat, lng, MaxReverseDistance))
+	if err := q.Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	var best *models.GazetteerEntry
+	bestDistance := MaxReverseDistance
+	for i, e := range entries {
+		d := orbgeo.Distance(orb.Point{lng, lat}, orb.Point{e.Longitude, e.Latitude})
+		if d <= bestDistance {
+			best, bestDistance = &entries[i], d
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+
+	res := newResult(*best)
+	res.Distance = bestDistance
+	if err := res.resolveWard(lat, lng); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Forward matches a free-text address against the gazetteer, or returns nil
+// when no address point scores at least MinForwardScore
+func Forward(address string) (*Result, error) {
+	words := tokens(address)
+	query := map[string]bool{}
+	var keys []string
+	for _, w := range words {
+		if fillers[w] || query[w] {
+			continue
+		}
+		query[w] = true
+		if !isNumber(w) && len(w) > 2 {
+			keys = append(keys, w)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	// the longest words are usually the rarest, so they narrow the scan most
+	sort.SliceStable(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+	if len(keys) > 3 {
+		keys = keys[:3]
+	}
+	terms := make([]string, len(keys))
+	args := make([]interface{}, len(keys))
+	for i, k := range keys {
+		terms[i] = "plainto_tsquery('simple', ?)"
+		args[i] = k
+	}
+
+	var entries []models.GazetteerEntry
+	match := searchVector + " @@ (" + strings.Join(terms, " || ") + ")"
+	if err := utils.DB.Where(match, args...).Limit(maxCandidates).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+
+	var best *models.GazetteerEntry
+	bestScore := 0.0
+	for i, e := range entries {
+		if s := score(e, query); s > bestScore {
+			best, bestScore = &entries[i], s
+		}
+	}
+	if best == nil || bestScore < MinForwardScore {
+		return nil, nil
+	}
+
+	res := newResult(*best)
+	res.Score = bestScore
+	if err := res.resolveWard(best.Latitude, best.Longitude); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// score is the share of an entry's words found in the query; an entry whose
+// street is not fully named in the query never matches
+func score(e models.GazetteerEntry, query map[string]bool) float64 {
+	for _, w := range tokens(e.Street) {
+		if !query[w] {
+			return 0
+		}
+	}
+	words := strings.Fields(e.SearchText)
+	if len(words) == 0 {
+		return 0
+	}
+	matched := 0
+	for _, w := range words {
+		if query[w] {
+			matched++
+		}
+	}
+	return float64(matched) / float64(len(words))
+}
+
+func isNumber(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// ApplyReport fills a report's street, locality and ward from its
+// coordinates, or its coordinates from its address when it has none
+func ApplyReport(r *models.Report) error {
+	r.Street, r.Locality = "", ""
+	lat, lng, located := r.LatLng()
+	var res *Result
+	var err error
+	if located {
+		res, err = Reverse(lat, lng)
+	} else if strings.TrimSpace(r.Location) != "" {
+		res, err = Forward(r.Location)
+	}
+	if err != nil {
+		return err
+	}
+	if res == nil {
+		if located {
+			r.WardID = geo.WardAt(lat, lng)
+		}
+		return nil
+	}
+	if !located {
+		coords, _ := json.Marshal(map[string]float64{"lat": res.Lat, "lng": res.Lng})
+		r.Coordinates = datatypes.JSON(coords)
+		r.Geocoded = true
+	}
+	// the lookup resolved the ward along with the address
+	r.Street, r.Locality, r.WardID = res.Street, res.Locality, res.WardID
+	return nil
+}
+
+// ApplyConstruction fills a construction's street, locality and ward from
+// its position
+func ApplyConstruction(c *models.Construction) error {
+	c.Street, c.Locality = "", ""
+	res, err := Reverse(c.Latitude, c.Longitude)
+	if err != nil {
+		return err
+	}
+	if res == nil {
+		c.WardID = geo.WardAt(c.Latitude, c.Longitude)
+		return nil
+	}
+	// Reverse resolved the ward of the position along with the address
+	c.Street, c.Locality, c.WardID = res.Street, res.Locality, res.WardID
+	return nil
+}
+
+// Backfill geocodes stored reports and constructions, either all of them or
+// only those without a street yet. It is run after a gazetteer import.
+func Backfill(all bool) (int, int, error) {
+	reportQuery := utils.DB.Model(&models.Report{})
+	constructionQuery := utils.DB.Model(&models.Construction{})
+	if !all {
+		reportQuery = reportQuery.Where("street = '' OR street IS NULL")
+		constructionQuery = constructionQuery.Where("street = '' OR street IS NULL")
+	}
+
+	var reports []models.Report
+	if err := reportQuery.Find(&reports).Error; err != nil {
+		return 0, 0, err
+	}
+	geocodedReports := 0
+	for _, r := range reports {
+		if err := ApplyReport(&r); err != nil {
+			return 0, 0, err
+		}
+		if r.Street != "" {
+			geocodedReports++
+		}
+		if err := saveReport(r); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	var constructions []models.Construction
+	if err := constructionQuery.Find(&constructions).Error; err != nil {
+		return 0, 0, err
+	}
+	geocodedConstructions := 0
+	for _, c := range constructions {
+		if err := ApplyConstruction(&c); err != nil {
+			return 0, 0, err
+		}
+		if c.Street != "" {
+			geocodedConstructions++
+		}
+		err := utils.DB.Model(&models.Construction{}).Where("id = ?", c.ID).Updates(map[string]interface{}{
+			"street":   c.Street,
+			"locality": c.Locality,
+			"ward_id":  c.WardID,
+		}).Error
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+	return geocodedReports, geocodedConstructions, nil
+}
+
+// saveReport stores the fields ApplyReport fills
+func saveReport(r models.Report) error {
+	return utils.DB.Model(&models.Report{}).Where("id = ?", r.ID).Updates(map[string]interface{}{
+		"street":      r.Street,
+		"locality":    r.Locality,
+		"ward_id":     r.WardID,
+		"coordinates": r.Coordinates,
+		"geocoded":    r.Geocoded,
+	}).Error
+}
+
+// Background job types
+const (
+	JobReport   = "geocode.report"   // payload {"report_id": n}
+	JobBackfill = "geocode.backfill" // payload {"all": bool}
+)
+
+func init() {
+	jobs.Register(JobReport, func(ctx context.Context, job *models.Job) error {
+		var p struct {
+			ReportID uint `json:"report_id"`
+		}
+		if err := jobs.Decode(job, &p); err != nil {
+			return err
+		}
+		var r models.Report
+		if err := utils.DB.Where("id = ?", p.ReportID).Limit(1).Find(&r).Error; err != nil || r.ID == 0 {
+			return err
+		}
+		if err := ApplyReport(&r); err != nil {
+			return err
+		}
+		if err := saveReport(r); err != nil {
+			return err
+		}
+		// the report is located now, so rules on its position can be checked
+		return alerts.Enqueue(nil, alerts.EventReport, r.ID)
+	})
+	jobs.Register(JobBackfill, func(ctx context.Context, job *models.Job) error {
+		var p struct {
+			All bool `json:"all"`
+		}
+		if err := jobs.Decode(job, &p); err != nil {
+			return err
+		}
+		_, _, err := Backfill(p.All)
+		return err
+	})
+}
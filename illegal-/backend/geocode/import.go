@@ -0,0 +1,211 @@
+package geocode
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"backend/models"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// csvColumns lists the accepted header names for each field, so municipal
+// registers and ogr2ogr/osmium CSV exports import without renaming
+var csvColumns = map[string][]string{
+	"lat":      {"lat", "latitude", "y"},
+	"lng":      {"lng", "lon", "long", "longitude", "x"},
+	"house":    {"house_number", "housenumber", "addr:housenumber", "number", "door_no"},
+	"street":   {"street", "addr:street", "road", "street_name"},
+	"locality": {"locality", "addr:suburb", "suburb", "neighbourhood", "addr:city", "city", "village"},
+	"ward":     {"ward", "ward_name", "addr:ward"},
+	"postcode": {"postcode", "addr:postcode", "pincode", "zip"},
+}
+
+// ParseCSV reads address points from a CSV file with a header row. Rows
+// without valid coordinates or a street are skipped and counted.
+func ParseCSV(r io.Reader) ([]models.GazetteerEntry, int, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, 0, errors.New("csv file has no header row")
+	}
+	index := map[string]int{}
+	for field, names := range csvColumns {
+		index[field] = -1
+		for i, h := range header {
+			h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+			for _, name := range names {
+				if h == name && index[field] < 0 {
+					index[field] = i
+				}
+			}
+		}
+	}
+	if index["lat"] < 0 || index["lng"] < 0 || index["street"] < 0 {
+		return nil, 0, errors.New("csv must have latitude, longitude and street columns")
+	}
+
+	var entries []models.GazetteerEntry
+	skipped := 0
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		get := func(field string) string {
+			i := index[field]
+			if i < 0 || i >= len(row) {
+				return ""
+			}
+			return strings.TrimSpace(row[i])
+		}
+		lat, errLat := strconv.ParseFloat(get("lat"), 64)
+		lng, errLng := strconv.ParseFloat(get("lng"), 64)
+		e, ok := newEntry(lat, lng, errLat == nil && errLng == nil, get("house"), get("street"), get("locality"), get("ward"), get("postcode"))
+		if !ok {
+			skipped++
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, skipped, nil
+}
+
+// osmTag and osmElement mirror the parts of the OSM XML format we read
+type osmTag struct {
+	Key   string `xml:"k,attr"`
+	Value string `xml:"v,attr"`
+}
+
+type osmElement struct {
+	ID   int64    `xml:"id,attr"`
+	Lat  *float64 `xml:"lat,attr"`
+	Lon  *float64 `xml:"lon,attr"`
+	Tags []osmTag `xml:"tag"`
+	Refs []struct {
+		Ref int64 `xml:"ref,attr"`
+	} `xml:"nd"`
+}
+
+func (e osmElement) tag(keys ...string) string {
+	for _, k := range keys {
+		for _, t := range e.Tags {
+			if t.Key == k {
+				return strings.TrimSpace(t.Value)
+			}
+		}
+	}
+	return ""
+}
+
+// ParseOSM reads addr:* tagged nodes and ways from an OSM XML extract. Ways
+// (typically building outlines) are placed at the mean of their nodes, so
+// the extract must include the referenced nodes.
+func ParseOSM(r io.Reader) ([]models.GazetteerEntry, int, error) {
+	decoder := xml.NewDecoder(r)
+	nodes := map[int64][2]float64{}
+	var entries []models.GazetteerEntry
+	skipped := 0
+
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, errors.New("invalid OSM XML: " + err.Error())
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || (start.Name.Local != "node" && start.Name.Local != "way") {
+			continue
+		}
+		var el osmElement
+		if err := decoder.DecodeElement(&el, &start); err != nil {
+			return nil, 0, errors.New("invalid OSM XML: " + err.Error())
+		}
+
+		var lat, lng float64
+		located := false
+		if start.Name.Local == "node" {
+			if el.Lat == nil || el.Lon == nil {
+				continue
+			}
+			lat, lng, located = *el.Lat, *el.Lon, true
+			nodes[el.ID] = [2]float64{lat, lng}
+		} else {
+			n := 0
+			for _, ref := range el.Refs {
+				if p, ok := nodes[ref.Ref]; ok {
+					lat += p[0]
+					lng += p[1]
+					n++
+				}
+			}
+			if n > 0 {
+				lat, lng, located = lat/float64(n), lng/float64(n), true
+			}
+		}
+
+		street := el.tag("addr:street", "addr:place")
+		if street == "" {
+			continue
+		}
+		e, ok := newEntry(lat, lng, located,
+			el.tag("addr:housenumber"), street,
+			el.tag("addr:suburb", "addr:neighbourhood", "addr:city", "addr:village"),
+			el.tag("addr:ward", "addr:district"),
+			el.tag("addr:postcode"))
+		if !ok {
+			skipped++
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, skipped, nil
+}
+
+// newEntry validates and tidies one address point
+func newEntry(lat, lng float64, located bool, house, street, locality, ward, postcode string) (models.GazetteerEntry, bool) {
+	if !located || lat < -90 || lat > 90 || lng < -180 || lng > 180 || (lat == 0 && lng == 0) || strings.TrimSpace(street) == "" {
+		return models.GazetteerEntry{}, false
+	}
+	e := models.GazetteerEntry{
+		HouseNumber: strings.TrimSpace(house),
+		Street:      tidy(street),
+		Locality:    tidy(locality),
+		Ward:        tidy(ward),
+		Postcode:    strings.TrimSpace(postcode),
+		Latitude:    lat,
+		Longitude:   lng,
+	}
+	e.SearchText = searchText(e.HouseNumber, e.Street, e.Locality)
+	return e, true
+}
+
+// Save stores entries under source, replacing any earlier import with the
+// same source name
+func Save(source string, entries []models.GazetteerEntry) error {
+	for i := range entries {
+		entries[i].Source = source
+	}
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("source = ?", source).Delete(&models.GazetteerEntry{}).Error; err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		return tx.CreateInBatches(entries, 1000).Error
+	})
+}
@@ -0,0 +1,86 @@
+package geocode
+
+import (
+	"strings"
+	"unicode"
+)
+
+// abbreviations expands the street-type shorthand users and registers mix
+var abbreviations = map[string]string{
+	"st":   "street",
+	"str":  "street",
+	"rd":   "road",
+	"ave":  "avenue",
+	"av":   "avenue",
+	"ln":   "lane",
+	"blvd": "boulevard",
+	"hwy":  "highway",
+	"mkt":  "market",
+	"nr":   "near",
+	"opp":  "opposite",
+	"no":   "",
+}
+
+// fillers carry no address information and are ignored when matching
+var fillers = map[string]bool{
+	"near":     true,
+	"opposite": true,
+	"behind":   true,
+	"beside":   true,
+	"plot":     true,
+	"the":      true,
+	"of":       true,
+	"and":      true,
+}
+
+// Normalize lowercases s, strips punctuation and expands abbreviations
+func Normalize(s string) string {
+	return strings.Join(tokens(s), " ")
+}
+
+// joiners are dropped rather than treated as word breaks, so "M.G." and
+// "MG" match
+var joiners = strings.NewReplacer(".", "", "'", "", "’", "")
+
+// tokens splits s into normalised words
+func tokens(s string) []string {
+	fields := strings.FieldsFunc(joiners.Replace(strings.ToLower(s)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	out := fields[:0]
+	for _, f := range fields {
+		if full, ok := abbreviations[f]; ok {
+			f = full
+		}
+		if f != "" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// searchText is the stored form of an entry used by forward lookups, which
+// match its words through the full-text index
+func searchText(houseNumber, street, locality string) string {
+	return Normalize(houseNumber + " " + street + " " + locality)
+}
+
+// tidy normalises whitespace, street-type abbreviations and the
+// capitalisation of a display value. Mixed-case words and short all-caps
+// initialisms such as "MG" are kept as written.
+func tidy(s string) string {
+	words := strings.Fields(s)
+	for i, w := range words {
+		if full, ok := abbreviations[strings.ToLower(strings.TrimSuffix(w, "."))]; ok && full != "" {
+			w = full
+		}
+		lower, upper := strings.ToLower(w), strings.ToUpper(w)
+		if w != lower && (w != upper || len(joiners.Replace(w)) <= 3) {
+			continue
+		}
+		r := []rune(lower)
+		r[0] = unicode.ToUpper(r[0])
+		words[i] = string(r)
+	}
+	return strings.Join(words, " ")
+}
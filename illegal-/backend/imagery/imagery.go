@@ -0,0 +1,152 @@
+// Package imagery stores dated captures of constructions and areas of
+// interest and scores the change between consecutive captures
+package imagery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"backend/change"
+	"backend/models"
+	"backend/storage"
+)
+
+// Capture kinds
+const (
+	KindSatellite  = "satellite"
+	KindDrone      = "drone"
+	KindFieldPhoto = "field_photo"
+)
+
+// ValidKind reports whether kind is a known capture kind
+func ValidKind(kind string) bool {
+	switch kind {
+	case KindSatellite, KindDrone, KindFieldPhoto:
+		return true
+	}
+	return false
+}
+
+// maxRemoteImage bounds the size of images fetched from registered URLs
+const maxRemoteImage = 64 << 20
+
+// ProviderHosts are the imagery provider hosts remote URLs may point at,
+// set from IMAGERY_HOSTS at startup; a host also allows its subdomains.
+// With none configured, only stored files can be referenced.
+var ProviderHosts []string
+
+// Errors for remote references that are not fetched
+var (
+	ErrHostNotAllowed = errors.New("URL is not on an allowed imagery provider host")
+	ErrFetch          = errors.New("image could not be fetched from the provider")
+)
+
+// httpClient fetches provider images. It never follows redirects and its
+// dialer refuses loopback, private and link-local addresses, so a provider
+// name resolving to an internal address cannot be used to reach it.
+var httpClient = &http.Client{
+	Timeout: 30 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 10 * time.Second,
+			Control: func(network, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip, err := netip.ParseAddr(host); err != nil || !publicAddr(ip) {
+					return ErrHostNotAllowed
+				}
+				return nil
+			},
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+	},
+}
+
+// publicAddr reports whether ip may be dialled for a provider image
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !ip.IsLoopback() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsUnspecified() && !ip.IsMulticast() &&
+		!sharedAddressSpace.Contains(ip)
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, private in practice
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// AllowedURL reports whether ref is an http(s) URL on a provider host
+func AllowedURL(ref string) bool {
+	u, err := url.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range ProviderHosts {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed != "" && (host == allowed || strings.HasSuffix(host, "."+allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open decodes the image behind a reference: either a file in the blob
+// store or a remote http(s) URL on one of the ProviderHosts
+func Open(ref string) (image.Image, error) {
+	var r io.Reader
+	if storage.External(ref) {
+		if !AllowedURL(ref) {
+			return nil, ErrHostNotAllowed
+		}
+		resp, err := httpClient.Get(ref)
+		if err != nil {
+			if errors.Is(err, ErrHostNotAllowed) {
+				return nil, ErrHostNotAllowed
+			}
+			return nil, ErrFetch
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, ErrFetch
+		}
+		r = io.LimitReader(resp.Body, maxRemoteImage)
+	} else {
+		f, err := storage.Open(context.Background(), ref)
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, errors.New("file not found")
+		}
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return change.Decode(r)
+}
+
+// SavePNG stores img in the blob store under dir and returns its reference
+func SavePNG(img image.Image, dir string) (models.BlobRef, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	key, err := storage.SaveBytes(context.Background(), dir, buf.Bytes(), ".png")
+	return models.BlobRef(key), err
+}
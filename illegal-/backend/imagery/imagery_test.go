@@ -0,0 +1,71 @@
+package imagery
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestAllowedURL(t *testing.T) {
+	defer func(hosts []string) { ProviderHosts = hosts }(ProviderHosts)
+	ProviderHosts = []string{"tiles.example.com"}
+
+	for ref, want := range map[string]bool{
+		"https://tiles.example.com/a.png":        true,
+		"http://eu.tiles.example.com/a.png":      true,
+		"https://TILES.example.com/a.png":        true,
+		"https://example.com/a.png":              false,
+		"https://tiles.example.com.evil.io/a":    false,
+		"https://eviltiles.example.com/a.png":    false,
+		"https://user@tiles.example.com/a.png":   false,
+		"ftp://tiles.example.com/a.png":          false,
+		"http://169.254.169.254/latest/metadata": false,
+	} {
+		if got := AllowedURL(ref); got != want {
+			t.Errorf("AllowedURL(%q) = %v, want %v", ref, got, want)
+		}
+	}
+}
+
+func TestPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := publicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestOpenRefusesInternalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("internal server was reached")
+	}))
+	defer srv.Close()
+	defer func(hosts []string) { ProviderHosts = hosts }(ProviderHosts)
+
+	// not an allowed host
+	ProviderHosts = nil
+	if _, err := Open(srv.URL + "/a.png"); !errors.Is(err, ErrHostNotAllowed) {
+		t.Errorf("unlisted host: err = %v, want ErrHostNotAllowed", err)
+	}
+	// allowed by name but resolving to loopback
+	ProviderHosts = []string{"127.0.0.1"}
+	if _, err := Open(srv.URL + "/a.png"); !errors.Is(err, ErrHostNotAllowed) {
+		t.Errorf("loopback host: err = %v, want ErrHostNotAllowed", err)
+	}
+}
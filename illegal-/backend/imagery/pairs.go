@@ -0,0 +1,307 @@
+package imagery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"backend/change"
+	"backend/jobs"
+	"backend/models"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// ComparisonsDir is the blob store prefix of comparison heatmaps and masks
+const ComparisonsDir = "comparisons"
+
+// JobCompare scores a pair of captures in the background
+const JobCompare = "imagery.compare"
+
+// comparePayload names the pair a JobCompare job scores
+type comparePayload struct {
+	BeforeID uint `json:"before_id"`
+	AfterID  uint `json:"after_id"`
+}
+
+func init() {
+	jobs.Register(JobCompare, runCompare)
+}
+
+// runCompare scores a queued pair, skipping pairs whose captures were
+// deleted or re-paired since the job was queued
+func runCompare(ctx context.Context, job *models.Job) error {
+	var p comparePayload
+	if err := jobs.Decode(job, &p); err != nil {
+		return err
+	}
+	var before, after models.ImageryCapture
+	if err := utils.DB.Where("id = ?", p.BeforeID).Limit(1).Find(&before).Error; err != nil {
+		return err
+	}
+	if err := utils.DB.Where("id = ?", p.AfterID).Limit(1).Find(&after).Error; err != nil {
+		return err
+	}
+	if before.ID == 0 || after.ID == 0 {
+		return nil
+	}
+	if next, err := neighbour(before, false); err != nil {
+		return err
+	} else if next == nil || next.ID != after.ID {
+		return nil
+	}
+
+	if _, err := Compare(before, after); err != nil {
+		return err
+	}
+	if after.ConstructionID != nil {
+		return RefreshConstruction(*after.ConstructionID)
+	}
+	return nil
+}
+
+// queueCompare queues scoring of a pair
+func queueCompare(before, after models.ImageryCapture) (*models.Job, error) {
+	return jobs.Enqueue(JobCompare, comparePayload{BeforeID: before.ID, AfterID: after.ID})
+}
+
+// subject returns the column and id identifying what a capture shows
+func subject(c models.ImageryCapture) (string, uint, error) {
+	switch {
+	case c.ConstructionID != nil && c.AreaID == nil:
+		return "construction_id", *c.ConstructionID, nil
+	case c.AreaID != nil && c.ConstructionID == nil:
+		return "area_id", *c.AreaID, nil
+	}
+	return "", 0, errors.New("a capture needs exactly one of construction_id or area_id")
+}
+
+// neighbour finds the closest earlier (before=true) or later capture of the
+// same kind of the same subject
+func neighbour(c models.ImageryCapture, before bool) (*models.ImageryCapture, error) {
+	col, id, err := subject(c)
+	if err != nil {
+		return nil, err
+	}
+	q := utils.DB.Where(col+" = ? AND kind = ? AND id <> ?", id, c.Kind, c.ID)
+	if before {
+		q = q.Where("captured_at < ? OR (captured_at = ? AND id < ?)", c.CapturedAt, c.CapturedAt, c.ID).Order("captured_at desc, id desc")
+	} else {
+		q = q.Where("captured_at > ? OR (captured_at = ? AND id > ?)", c.CapturedAt, c.CapturedAt, c.ID).Order("captured_at, id")
+	}
+	var n models.ImageryCapture
+	if err := q.Limit(1).Find(&n).Error; err != nil || n.ID == 0 {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// AddCapture stores a capture and queues its comparison with the previous
+// capture of the same kind and, when it was taken before an existing capture,
+// the re-pairing of that later capture with it. It returns the job scoring
+// the pair with the previous capture, if there is one.
+func AddCapture(c *models.ImageryCapture) (*models.Job, error) {
+	if err := InsertCapture(utils.DB, c); err != nil {
+		return nil, err
+	}
+	return PairCapture(c)
+}
+
+// InsertCapture validates and stores a capture within tx, for callers that
+// store it along with other changes. PairCapture must follow once tx is
+// committed.
+func InsertCapture(tx *gorm.DB, c *models.ImageryCapture) error {
+	if !ValidKind(c.Kind) {
+		return errors.New("kind must be one of satellite, drone, field_photo")
+	}
+	if _, _, err := subject(*c); err != nil {
+		return err
+	}
+	if c.CapturedAt.IsZero() {
+		return errors.New("captured_at is required")
+	}
+	return tx.Create(c).Error
+}
+
+// PairCapture queues the comparisons of a stored capture with its
+// neighbours, as AddCapture does
+func PairCapture(c *models.ImageryCapture) (*models.Job, error) {
+	prev, err := neighbour(*c, true)
+	if err != nil {
+		return nil, err
+	}
+	next, err := neighbour(*c, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var job *models.Job
+	if prev != nil {
+		if next != nil {
+			if err := utils.DB.Where("before_id = ? AND after_id = ?", prev.ID, next.ID).Delete(&models.ImageryComparison{}).Error; err != nil {
+				return nil, err
+			}
+		}
+		if job, err = queueCompare(*prev, *c); err != nil {
+			return nil, err
+		}
+	}
+	if next != nil {
+		if _, err := queueCompare(*c, *next); err != nil {
+			return nil, err
+		}
+	}
+	if c.ConstructionID != nil {
+		if err := RefreshConstruction(*c.ConstructionID); err != nil {
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+// DeleteCapture removes a capture and its comparisons and queues the pairing
+// of its former neighbours with each other
+func DeleteCapture(c models.ImageryCapture) error {
+	prev, err := neighbour(c, true)
+	if err != nil {
+		return err
+	}
+	next, err := neighbour(c, false)
+	if err != nil {
+		return err
+	}
+	err = utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("before_id = ? OR after_id = ?", c.ID, c.ID).Delete(&models.ImageryComparison{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&c).Error
+	})
+	if err != nil {
+		return err
+	}
+	if prev != nil && next != nil {
+		if _, err := queueCompare(*prev, *next); err != nil {
+			return err
+		}
+	}
+	if c.ConstructionID != nil {
+		return RefreshConstruction(*c.ConstructionID)
+	}
+	return nil
+}
+
+// Compare scores the change between two captures and stores the result,
+// replacing any earlier comparison of the pair. Images that cannot be read
+// or compared produce a failed comparison rather than an error.
+func Compare(before, after models.ImageryCapture) (*models.ImageryComparison, error) {
+	cmp := &models.ImageryComparison{
+		BeforeID:       before.ID,
+		AfterID:        after.ID,
+		ConstructionID: after.ConstructionID,
+		AreaID:         after.AreaID,
+		Status:         "done",
+		CreatedAt:      time.Now(),
+	}
+	if err := score(cmp, before, after); err != nil {
+		cmp.Status, cmp.Error = "failed", err.Error()
+	}
+
+	err := utils.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("before_id = ? AND after_id = ?", before.ID, after.ID).Delete(&models.ImageryComparison{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(cmp).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cmp, nil
+}
+
+// score runs change detection for a pair and writes the heatmap and mask
+func score(cmp *models.ImageryComparison, before, after models.ImageryCapture) error {
+	a, err := Open(string(before.ImageURL))
+	if err != nil {
+		return fmt.Errorf("before image: %v", err)
+	}
+	b, err := Open(string(after.ImageURL))
+	if err != nil {
+		return fmt.Errorf("after image: %v", err)
+	}
+	res, err := change.Compare(a, b, change.Options{Align: true})
+	if err != nil {
+		return err
+	}
+
+	if cmp.HeatmapURL, err = SavePNG(res.Heatmap, ComparisonsDir); err != nil {
+		return err
+	}
+	if cmp.MaskURL, err = SavePNG(res.Mask, ComparisonsDir); err != nil {
+		return err
+	}
+	cmp.Score, cmp.ChangedRatio, cmp.Verdict = res.Score, res.ChangedRatio, res.Verdict
+	cmp.AlignmentQuality = res.Alignment.Quality
+	cmp.Alignment, err = json.Marshal(res.Alignment)
+	return err
+}
+
+// RefreshConstruction copies the latest overhead capture and the latest
+// successful comparison onto the construction
+func RefreshConstruction(id uint) error {
+	updates := map[string]interface{}{}
+
+	var latest models.ImageryCapture
+	err := utils.DB.Where("construction_id = ? AND kind IN ?", id, []string{KindSatellite, KindDrone}).
+		Order("captured_at desc, id desc").Limit(1).Find(&latest).Error
+	if err != nil {
+		return err
+	}
+	updates["satellite_image_url"] = latest.ImageURL
+
+	var cmp models.ImageryComparison
+	err = utils.DB.Joins("JOIN imagery_captures AS later ON later.id = imagery_comparisons.after_id").
+		Where("imagery_comparisons.construction_id = ? AND imagery_comparisons.status = ?", id, "done").
+		Order("later.captured_at desc, imagery_comparisons.id desc").Limit(1).Find(&cmp).Error
+	if err != nil {
+		return err
+	}
+	if cmp.ID != 0 {
+		score := cmp.Score
+		updates["comparison_image_url"] = cmp.HeatmapURL
+		updates["change_score"] = &score
+	}
+	return utils.DB.Model(&models.Construction{}).Where("id = ?", id).Updates(updates).Error
+}
+
+// TimelineEntry is a capture with its comparison against the previous
+// capture of the same kind
+type TimelineEntry struct {
+	models.ImageryCapture
+	Comparison *models.ImageryComparison `json:"comparison"`
+}
+
+// Timeline returns the captures of a construction (column "construction_id")
+// or area of interest ("area_id") in capture order
+func Timeline(col string, id uint) ([]TimelineEntry, error) {
+	var captures []models.ImageryCapture
+	if err := utils.DB.Where(col+" = ?", id).Order("captured_at, id").Find(&captures).Error; err != nil {
+		return nil, err
+	}
+	var comparisons []models.ImageryComparison
+	if err := utils.DB.Where(col+" = ?", id).Find(&comparisons).Error; err != nil {
+		return nil, err
+	}
+	byAfter := map[uint]*models.ImageryComparison{}
+	for i := range comparisons {
+		byAfter[comparisons[i].AfterID] = &comparisons[i]
+	}
+
+	entries := make([]TimelineEntry, len(captures))
+	for i, c := range captures {
+		entries[i] = TimelineEntry{ImageryCapture: c, Comparison: byAfter[c.ID]}
+	}
+	return entries, nil
+}
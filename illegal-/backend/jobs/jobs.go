@@ -0,0 +1,162 @@
+// Package jobs is a durable background job queue stored in Postgres. Workers
+// claim jobs with SELECT ... FOR UPDATE SKIP LOCKED, so any number of
+// workers and replicas can share the queue without running a job twice.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"backend/models"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// Job statuses
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusDead      = "dead" // out of attempts; requeue by hand once fixed
+)
+
+// DefaultMaxAttempts applies to jobs enqueued without their own limit
+const DefaultMaxAttempts = 5
+
+// Handler runs one job. Returning an error schedules a retry with backoff
+// until the job runs out of attempts.
+type Handler func(ctx context.Context, job *models.Job) error
+
+var (
+	mu       sync.RWMutex
+	handlers = map[string]Handler{}
+)
+
+// Register sets the handler for a job type. Packages register their job
+// types from init so workers know them before they start.
+func Register(jobType string, h Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	handlers[jobType] = h
+}
+
+func handlerFor(jobType string) (Handler, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	h, ok := handlers[jobType]
+	return h, ok
+}
+
+// Enqueue adds a job to run as soon as a worker is free
+func Enqueue(jobType string, payload interface{}) (*models.Job, error) {
+	return EnqueueAt(jobType, payload, time.Now())
+}
+
+// EnqueueAt adds a job that will not run before runAt
+func EnqueueAt(jobType string, payload interface{}, runAt time.Time) (*models.Job, error) {
+	return enqueue(utils.DB, jobType, payload, runAt)
+}
+
+// EnqueueTx adds a job inside an open transaction, so it only exists if the
+// transaction commits
+func EnqueueTx(tx *gorm.DB, jobType string, payload interface{}) (*models.Job, error) {
+	return enqueue(tx, jobType, payload, time.Now())
+}
+
+func enqueue(db *gorm.DB, jobType string, payload interface{}, runAt time.Time) (*models.Job, error) {
+	if _, ok := handlerFor(jobType); !ok {
+		return nil, fmt.Errorf("unknown job type %q", jobType)
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	job := &models.Job{
+		Type:        jobType,
+		Payload:     data,
+		Status:      StatusQueued,
+		RunAt:       runAt,
+		MaxAttempts: DefaultMaxAttempts,
+	}
+	if err := db.Create(job).Error; err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Decode unmarshals a job's payload
+func Decode(job *models.Job, v interface{}) error {
+	return json.Unmarshal(job.Payload, v)
+}
+
+// ErrNotRequeueable is returned for jobs that are queued or running
+var ErrNotRequeueable = errors.New("only succeeded or dead jobs can be requeued")
+
+// Requeue puts a finished or dead job back in the queue with a fresh set of
+// attempts and returns it as stored
+func Requeue(id uint) (*models.Job, error) {
+	var job models.Job
+	if err := utils.DB.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	res := utils.DB.Model(&models.Job{}).
+		Where("id = ? AND status IN ?", id, []string{StatusDead, StatusSucceeded}).
+		Updates(map[string]interface{}{
+			"status":      StatusQueued,
+			"run_at":      time.Now(),
+			"attempts":    0,
+			"last_error":  "",
+			"locked_by":   "",
+			"locked_at":   nil,
+			"finished_at": nil,
+		})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrNotRequeueable
+	}
+	if err := utils.DB.First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// RequeueDead requeues every dead job, or those of one type, returning how
+// many were requeued
+func RequeueDead(jobType string) (int64, error) {
+	q := utils.DB.Model(&models.Job{}).Where("status = ?", StatusDead)
+	if jobType != "" {
+		q = q.Where("type = ?", jobType)
+	}
+	res := q.Updates(map[string]interface{}{
+		"status":      StatusQueued,
+		"run_at":      time.Now(),
+		"attempts":    0,
+		"last_error":  "",
+		"finished_at": nil,
+	})
+	return res.RowsAffected, res.Error
+}
+
+// Stat counts the jobs of one type in one status
+type Stat struct {
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Count  int    `json:"count"`
+}
+
+// Stats counts jobs by type and status
+func Stats() ([]Stat, error) {
+	var stats []Stat
+	err := utils.DB.Model(&models.Job{}).
+		Select("type, status, COUNT(*) AS count").
+		Group("type, status").Order("type, status").
+		Scan(&stats).Error
+	return stats, err
+}
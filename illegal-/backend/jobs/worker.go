@@ -0,0 +1,190 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"backend/models"
+	"backend/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Worker settings, adjustable before Start
+var (
+	PollInterval = time.Second      // idle wait between claims
+	JobTimeout   = 15 * time.Minute // a job running longer is cancelled
+	StaleAfter   = 30 * time.Minute // running jobs locked longer are assumed crashed; keep above JobTimeout
+	BaseBackoff  = 30 * time.Second // first retry delay, doubled per attempt
+	MaxBackoff   = time.Hour
+)
+
+// Start launches concurrency workers that run until ctx is cancelled
+func Start(ctx context.Context, concurrency int) {
+	host, _ := os.Hostname()
+	for i := 0; i < concurrency; i++ {
+		name := fmt.Sprintf("%s/%d/%d", host, os.Getpid(), i)
+		go work(ctx, name, i == 0)
+	}
+}
+
+// work claims and runs jobs; the first worker also recovers stale jobs
+func work(ctx context.Context, name string, reaper bool) {
+	lastReap := time.Time{}
+	for ctx.Err() == nil {
+		if reaper && time.Since(lastReap) > time.Minute {
+			if n, err := requeueStale(); err != nil {
+				log.Printf("jobs: requeueing stale jobs: %v", err)
+			} else if n > 0 {
+				log.Printf("jobs: requeued %d stale job(s)", n)
+			}
+			lastReap = time.Now()
+		}
+
+		job, err := claim(name)
+		if err != nil {
+			log.Printf("jobs: claim failed: %v", err)
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+			case <-time.After(PollInterval):
+			}
+			continue
+		}
+		run(ctx, job)
+	}
+}
+
+// claim locks the next due job for this worker
+func claim(worker string) (*models.Job, error) {
+	var job models.Job
+	err := utils.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND run_at <= ?", StatusQueued, time.Now()).
+			Order("run_at, id").Limit(1).Find(&job).Error
+		if err != nil || job.ID == 0 {
+			return err
+		}
+		now := time.Now()
+		job.Status, job.LockedBy, job.LockedAt = StatusRunning, worker, &now
+		job.Attempts++
+		return tx.Model(&job).Updates(map[string]interface{}{
+			"status":    job.Status,
+			"locked_by": job.LockedBy,
+			"locked_at": job.LockedAt,
+			"attempts":  job.Attempts,
+		}).Error
+	})
+	if err != nil || job.ID == 0 {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// run executes a claimed job and records the outcome, unless the job was
+// taken from this worker as stale in the meantime
+func run(ctx context.Context, job *models.Job) {
+	err := execute(ctx, job)
+	res := utils.DB.Model(&models.Job{}).
+		Where("id = ? AND status = ? AND locked_by = ?", job.ID, StatusRunning, job.LockedBy).
+		Updates(outcome(job, err, time.Now()))
+	if res.Error != nil {
+		log.Printf("jobs: recording result of #%d: %v", job.ID, res.Error)
+	} else if res.RowsAffected == 0 {
+		log.Printf("jobs: %s #%d finished after it was requeued as stale; result dropped", job.Type, job.ID)
+	}
+}
+
+// outcome is the update recording the result of an attempt at job
+func outcome(job *models.Job, err error, now time.Time) map[string]interface{} {
+	updates := map[string]interface{}{"locked_by": "", "locked_at": nil}
+	switch {
+	case err == nil:
+		updates["status"] = StatusSucceeded
+		updates["last_error"] = ""
+		updates["finished_at"] = &now
+	case job.Attempts >= job.MaxAttempts:
+		log.Printf("jobs: %s #%d failed for good after %d attempts: %v", job.Type, job.ID, job.Attempts, err)
+		updates["status"] = StatusDead
+		updates["last_error"] = err.Error()
+		updates["finished_at"] = &now
+	default:
+		updates["status"] = StatusQueued
+		updates["last_error"] = err.Error()
+		updates["run_at"] = now.Add(backoff(job.Attempts))
+	}
+	return updates
+}
+
+// execute calls the job's handler with a timeout, turning panics into errors
+func execute(ctx context.Context, job *models.Job) (err error) {
+	h, ok := handlerFor(job.Type)
+	if !ok {
+		return fmt.Errorf("no handler for job type %q", job.Type)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v\n%s", p, debug.Stack())
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, JobTimeout)
+	defer cancel()
+	return h(ctx, job)
+}
+
+// backoff is the delay before retry n, exponential with ±20% jitter
+func backoff(attempt int) time.Duration {
+	d := BaseBackoff
+	for i := 1; i < attempt && d < MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > MaxBackoff {
+		d = MaxBackoff
+	}
+	jitter := 0.8 + 0.4*rand.Float64()
+	return time.Duration(float64(d) * jitter)
+}
+
+// requeueStale returns jobs locked for longer than StaleAfter to the queue.
+// A live worker would have cancelled them at JobTimeout, so their worker is
+// gone, e.g. because the process crashed. The crashed run counts as an
+// attempt: jobs that have used them all are marked dead instead, so a job
+// that kills its worker cannot loop forever.
+func requeueStale() (int64, error) {
+	var n int64
+	now := time.Now()
+	err := utils.DB.Transaction(func(tx *gorm.DB) error {
+		stale := func() *gorm.DB {
+			return tx.Model(&models.Job{}).Where("status = ? AND locked_at < ?", StatusRunning, now.Add(-StaleAfter))
+		}
+		const lost = "worker stopped before finishing"
+		res := stale().Where("attempts >= max_attempts").Updates(map[string]interface{}{
+			"status":      StatusDead,
+			"locked_by":   "",
+			"locked_at":   nil,
+			"last_error":  lost,
+			"finished_at": now,
+		})
+		if res.Error != nil {
+			return res.Error
+		}
+		n = res.RowsAffected
+		res = stale().Updates(map[string]interface{}{
+			"status":     StatusQueued,
+			"run_at":     now,
+			"locked_by":  "",
+			"locked_at":  nil,
+			"last_error": lost,
+		})
+		n += res.RowsAffected
+		return res.Error
+	})
+	return n, err
+}
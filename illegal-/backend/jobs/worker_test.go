@@ -0,0 +1,183 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"backend/models"
+	"backend/utils"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestBackoffDoublesWithinJitter(t *testing.T) {
+	for attempt, base := range map[int]time.Duration{
+		1:  BaseBackoff,
+		2:  2 * BaseBackoff,
+		3:  4 * BaseBackoff,
+		20: MaxBackoff,
+	} {
+		for i := 0; i < 50; i++ {
+			d := backoff(attempt)
+			if d < base*8/10 || d > base*12/10 {
+				t.Fatalf("backoff(%d) = %v, want within 20%% of %v", attempt, d, base)
+			}
+		}
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	now := time.Now()
+	job := &models.Job{Attempts: 2, MaxAttempts: 3}
+
+	ok := outcome(job, nil, now)
+	if ok["status"] != StatusSucceeded || ok["locked_by"] != "" {
+		t.Errorf("success = %v", ok)
+	}
+
+	retry := outcome(job, errors.New("boom"), now)
+	if retry["status"] != StatusQueued || retry["last_error"] != "boom" {
+		t.Errorf("retry = %v", retry)
+	}
+	if at, _ := retry["run_at"].(time.Time); !at.After(now) {
+		t.Errorf("retry run_at = %v, want after %v", retry["run_at"], now)
+	}
+
+	job.Attempts = 3
+	if dead := outcome(job, errors.New("boom"), now); dead["status"] != StatusDead || dead["finished_at"] == nil {
+		t.Errorf("last attempt = %v, want dead", dead)
+	}
+}
+
+// testDB connects to TEST_DATABASE_URL, skipping the test when it is unset.
+// Jobs are created with a type of their own and removed afterwards.
+func testDB(t *testing.T) string {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Job{}); err != nil {
+		t.Fatal(err)
+	}
+	prev := utils.DB
+	utils.DB = db
+	jobType := "test." + t.Name()
+	Register(jobType, func(ctx context.Context, job *models.Job) error { return nil })
+	t.Cleanup(func() {
+		db.Where("type = ?", jobType).Delete(&models.Job{})
+		utils.DB = prev
+	})
+	db.Where("type = ?", jobType).Delete(&models.Job{})
+	return jobType
+}
+
+func TestClaimTakesEachJobOnce(t *testing.T) {
+	jobType := testDB(t)
+	queued, err := Enqueue(jobType, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EnqueueAt(jobType, nil, time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed := map[uint]bool{}
+	for i := 0; i < 10; i++ {
+		job, err := claim("test-worker")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job == nil {
+			break
+		}
+		if job.Type != jobType {
+			// a job from elsewhere in the shared database; leave it queued
+			utils.DB.Model(job).Updates(map[string]interface{}{"status": StatusQueued, "attempts": job.Attempts - 1})
+			continue
+		}
+		if claimed[job.ID] {
+			t.Fatalf("job %d claimed twice", job.ID)
+		}
+		claimed[job.ID] = true
+	}
+	if len(claimed) != 1 || !claimed[queued.ID] {
+		t.Errorf("claimed %v, want only the due job %d", claimed, queued.ID)
+	}
+	var stored models.Job
+	utils.DB.First(&stored, queued.ID)
+	if stored.Status != StatusRunning || stored.LockedBy != "test-worker" || stored.Attempts != 1 {
+		t.Errorf("claimed job = %s locked by %q after %d attempt(s), want running with one attempt",
+			stored.Status, stored.LockedBy, stored.Attempts)
+	}
+}
+
+func TestRequeueStaleCountsAttempts(t *testing.T) {
+	jobType := testDB(t)
+	long := time.Now().Add(-2 * StaleAfter)
+	retry := models.Job{Type: jobType, Status: StatusRunning, Attempts: 1, MaxAttempts: 3, LockedBy: "gone", LockedAt: &long, RunAt: long}
+	spent := models.Job{Type: jobType, Status: StatusRunning, Attempts: 3, MaxAttempts: 3, LockedBy: "gone", LockedAt: &long, RunAt: long}
+	if err := utils.DB.Create(&retry).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.DB.Create(&spent).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := requeueStale(); err != nil {
+		t.Fatal(err)
+	}
+	utils.DB.First(&retry, retry.ID)
+	utils.DB.First(&spent, spent.ID)
+	if retry.Status != StatusQueued || retry.LockedBy != "" {
+		t.Errorf("job with attempts left = %s locked by %q, want queued", retry.Status, retry.LockedBy)
+	}
+	if spent.Status != StatusDead || spent.FinishedAt == nil {
+		t.Errorf("job out of attempts = %s, want dead", spent.Status)
+	}
+}
+
+func TestRunDropsResultOfRequeuedJob(t *testing.T) {
+	jobType := testDB(t)
+	now := time.Now()
+	job := models.Job{Type: jobType, Status: StatusRunning, Attempts: 1, MaxAttempts: 3, LockedBy: "old", LockedAt: &now, RunAt: now}
+	if err := utils.DB.Create(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+	// another worker took the job over after it went stale
+	utils.DB.Model(&models.Job{}).Where("id = ?", job.ID).Update("locked_by", "new")
+
+	run(context.Background(), &job)
+	var stored models.Job
+	utils.DB.First(&stored, job.ID)
+	if stored.Status != StatusRunning || stored.LockedBy != "new" {
+		t.Errorf("job = %s locked by %q, want still running for the new worker", stored.Status, stored.LockedBy)
+	}
+}
+
+func TestRequeueReturnsStoredJob(t *testing.T) {
+	jobType := testDB(t)
+	now := time.Now()
+	job := models.Job{Type: jobType, Status: StatusDead, Attempts: 5, MaxAttempts: 5, LastError: "boom", FinishedAt: &now, RunAt: now}
+	if err := utils.DB.Create(&job).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := Requeue(job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != StatusQueued || got.Attempts != 0 || got.LastError != "" || got.FinishedAt != nil {
+		t.Errorf("requeued job = %+v, want queued with fresh attempts", got)
+	}
+	if _, err := Requeue(job.ID); !errors.Is(err, ErrNotRequeueable) {
+		t.Errorf("second requeue err = %v, want ErrNotRequeueable", err)
+	}
+}
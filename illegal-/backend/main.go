@@ -1,12 +1,30 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"backend/alerts"
+	"backend/controllers"
+	"backend/detect"
+	"backend/geo"
+	"backend/geocode"
+	"backend/imagery"
+	"backend/jobs"
+	"backend/media"
+	"backend/models"
 	"backend/routes"
+	"backend/schedule"
+	"backend/storage"
+	"backend/tasks"
 	"backend/utils"
+	"backend/zoning"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
@@ -16,6 +34,107 @@ func main() {
 	// Connect to PostgreSQL
 	utils.ConnectDB()
 
+	// Create tables and columns added since the initial schema
+	if err := utils.DB.AutoMigrate(
+		&models.Construction{},
+		&models.Report{},
+		&models.Property{},
+		&models.Layer{},
+		&models.LayerFeature{},
+		&models.StatusOverride{},
+		&models.Permit{},
+		&models.GazetteerEntry{},
+		&models.AreaOfInterest{},
+		&models.ImageryCapture{},
+		&models.ImageryComparison{},
+		&models.Scene{},
+		&models.SceneTile{},
+		&models.Detection{},
+		&models.Job{},
+		&models.ScheduledTask{},
+		&models.TaskRun{},
+		&models.DailyStat{},
+		&models.ImageHash{},
+		&models.ReportDuplicate{},
+		&models.Upload{},
+		&models.Alert{},
+		&models.AlertRule{},
+		&models.AlertRecipient{},
+		&models.AlertState{},
+	); err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+	if err := geo.CreateIndexes(utils.DB); err != nil {
+		log.Fatalf("Failed to create indexes: %v", err)
+	}
+	if err := geocode.CreateIndexes(utils.DB); err != nil {
+		log.Fatalf("Failed to create indexes: %v", err)
+	}
+	if err := zoning.BackfillPermitBounds(utils.DB); err != nil {
+		log.Fatalf("Failed to backfill permit bounds: %v", err)
+	}
+	if err := alerts.MigrateReadFlags(utils.DB); err != nil {
+		log.Fatalf("Failed to migrate alert read flags: %v", err)
+	}
+
+	// Store uploads locally or in an S3-compatible bucket (STORAGE_BACKEND);
+	// FILE_URL_SECRET signs the URLs files are served under
+	store, err := storage.FromEnv(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to configure upload storage: %v", err)
+	}
+	storage.Default = store
+	storage.SetSigningKey(os.Getenv("FILE_URL_SECRET"))
+	if err := media.QueueLegacyOriginals(); err != nil {
+		log.Printf("media: queueing the move of public originals: %v", err)
+	}
+
+	// Flag constructions without a permit only from the date the permit
+	// registry started, PERMITS_REQUIRED_SINCE (YYYY-MM-DD)
+	if v := os.Getenv("PERMITS_REQUIRED_SINCE"); v != "" {
+		since, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			log.Fatalf("Invalid PERMITS_REQUIRED_SINCE %q: %v", v, err)
+		}
+		zoning.PermitsRequiredSince = &since
+	}
+
+	// Remote imagery URLs may only point at these provider hosts
+	for _, host := range strings.Split(os.Getenv("IMAGERY_HOSTS"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			imagery.ProviderHosts = append(imagery.ProviderHosts, host)
+		}
+	}
+
+	// Register the external footprint model, if one is configured
+	if cmd := strings.Fields(os.Getenv("DETECTOR_COMMAND")); len(cmd) > 0 {
+		name := os.Getenv("DETECTOR_NAME")
+		if name == "" {
+			name = "model"
+		}
+		detect.Register(detect.ProcessDetector{ModelName: name, Command: cmd})
+	}
+
+	// Blur faces and licence plates in published photos, if a model is configured
+	if cmd := strings.Fields(os.Getenv("REDACT_COMMAND")); len(cmd) > 0 {
+		media.Redact = media.ProcessRedactor{Command: cmd}
+	}
+
+	// Run queued background jobs; JOB_WORKERS sets how many at once
+	workers := 4
+	if n, err := strconv.Atoi(os.Getenv("JOB_WORKERS")); err == nil && n >= 0 {
+		workers = n
+	}
+	jobs.Start(context.Background(), workers)
+
+	// Run recurring tasks; only the replica holding the leader lock fires them
+	if err := tasks.Register(); err != nil {
+		log.Fatalf("Failed to register scheduled tasks: %v", err)
+	}
+	if err := schedule.Start(context.Background()); err != nil {
+		log.Fatalf("Failed to start scheduler: %v", err)
+	}
+
 	// Initialize router
 	r := mux.NewRouter()
 
@@ -23,6 +142,9 @@ func main() {
 	apiRouter := r.PathPrefix("/api").Subrouter()
 	routes.RegisterRoutes(apiRouter)
 
+	// Serve stored files at /uploads/ to signed URLs and allowed callers
+	r.PathPrefix(storage.FilesPath).HandlerFunc(controllers.ServeFile)
+
 	// Handle preflight requests for all routes
 	r.PathPrefix("/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method == "OPTIONS" {
@@ -37,14 +159,14 @@ func main() {
 		fmt.Fprintln(w, "✅ API is running and connected to PostgreSQL!")
 	}).Methods("GET")
 
-	// Serve uploaded files at /uploads/
-	r.PathPrefix("/uploads/").Handler(http.StripPrefix("/uploads/", http.FileServer(http.Dir("./uploads/"))))
-
 	// Add CORS middleware and wrap the router
 	corsMiddleware := handlers.CORS(
 		handlers.AllowedOrigins([]string{"http://localhost:8081", "http://localhost:3000", "http://localhost:4173"}),
-		handlers.AllowedMethods([]string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"}),
-		handlers.AllowedHeaders([]string{"Content-Type", "Authorization", "X-Requested-With"}),
+		handlers.AllowedMethods([]string{"GET", "HEAD", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"}),
+		handlers.AllowedHeaders([]string{"Content-Type", "Authorization", "X-Requested-With", "Range",
+			"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Checksum"}),
+		handlers.ExposedHeaders([]string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Max-Size",
+			"Tus-Checksum-Algorithm", "Upload-Offset", "Upload-Length", "Upload-Expires", "Content-Range"}),
 		handlers.AllowCredentials(),
 	)
 
@@ -0,0 +1,51 @@
+package media
+
+import (
+	"bytes"
+	"math"
+
+	"backend/models"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// readMetadata extracts the GPS position, capture time and orientation from
+// an image's EXIF. It returns nil when the image has none of them; EXIF is
+// evidence, never a reason to reject an upload.
+func readMetadata(data []byte) *models.PhotoMetadata {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	meta := &models.PhotoMetadata{}
+	found := false
+	if lat, lng, err := x.LatLong(); err == nil && validPosition(lat, lng) {
+		meta.Lat, meta.Lng = &lat, &lng
+		found = true
+	}
+	// DateTimeOriginal carries no zone unless the camera wrote an offset,
+	// in which case goexif reads it in the server's zone
+	if t, err := x.DateTime(); err == nil && !t.IsZero() {
+		meta.CapturedAt = &t
+		found = true
+	}
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if o, err := tag.Int(0); err == nil && o >= 1 && o <= 8 {
+			meta.Orientation = o
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return meta
+}
+
+// validPosition rejects out of range values and the 0,0 that some cameras
+// write before they have a fix
+func validPosition(lat, lng float64) bool {
+	if math.IsNaN(lat) || math.IsNaN(lng) || math.Abs(lat) > 90 || math.Abs(lng) > 180 {
+		return false
+	}
+	return lat != 0 || lng != 0
+}
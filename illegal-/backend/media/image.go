@@ -0,0 +1,108 @@
+// Package media validates and processes uploaded evidence files
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+
+	"backend/models"
+
+	_ "image/jpeg" // register decoders for validation
+	_ "image/png"
+
+	"github.com/gabriel-vasile/mimetype"
+	_ "golang.org/x/image/webp"
+)
+
+// Upload limits, adjustable at startup
+var (
+	MaxImageBytes  int64 = 15 << 20   // largest accepted image file
+	MaxImagePixels       = 60_000_000 // largest accepted width × height, against decompression bombs
+)
+
+// imageTypes are the accepted image types and the extension stored with each
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/heic": ".heic",
+	"image/heif": ".heif",
+}
+
+// Image is an uploaded image that passed validation
+type Image struct {
+	Data   []byte
+	MIME   string
+	Ext    string // extension matching MIME, used for the stored key
+	Width  int
+	Height int
+
+	Metadata *models.PhotoMetadata // from EXIF; nil when the image has none
+}
+
+// ReadImage reads an upload and checks that it is a single well-formed
+// JPEG, PNG, WebP or HEIC image. Errors are worded for the uploader.
+func ReadImage(r io.Reader) (*Image, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxImageBytes+1))
+	if err != nil {
+		return nil, errors.New("could not read file")
+	}
+	if int64(len(data)) > MaxImageBytes {
+		return nil, fmt.Errorf("file is larger than %d MB", MaxImageBytes>>20)
+	}
+	if len(data) == 0 {
+		return nil, errors.New("file is empty")
+	}
+
+	mime, _, _ := strings.Cut(mimetype.Detect(data).String(), ";")
+	ext, ok := imageTypes[mime]
+	if !ok {
+		return nil, fmt.Errorf("file type %s is not allowed; use JPEG, PNG, WebP or HEIC", mime)
+	}
+	img := &Image{Data: data, MIME: mime, Ext: ext, Metadata: readMetadata(data)}
+
+	switch mime {
+	case "image/heic", "image/heif":
+		// no pure Go HEIC decoder exists, so the container is checked
+		// structurally instead
+		if img.Width, img.Height, err = checkHEIF(data); err != nil {
+			return nil, err
+		}
+	default:
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.New("file is not a valid image")
+		}
+		img.Width, img.Height = cfg.Width, cfg.Height
+		if err := CheckPixels(img.Width, img.Height); err != nil {
+			return nil, err
+		}
+		if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+			return nil, errors.New("image data is corrupt")
+		}
+		if err := checkTrailing(mime, data); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// CheckPixels rejects images with no pixels or more than MaxImagePixels,
+// before they are decoded
+func CheckPixels(w, h int) error {
+	if w <= 0 || h <= 0 {
+		return errors.New("image has no pixels")
+	}
+	if w*h > MaxImagePixels {
+		return fmt.Errorf("image is %d×%d pixels, more than the %d megapixel limit", w, h, MaxImagePixels/1_000_000)
+	}
+	return nil
+}
+
+// errTrailing rejects polyglots: an image with a second file, such as a ZIP
+// archive or a script, appended after its end
+var errTrailing = errors.New("file has unexpected data after the image")
@@ -0,0 +1,245 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/jobs"
+	"backend/models"
+	"backend/storage"
+	"backend/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Keyframe extraction settings, adjustable at startup
+var (
+	FFmpegPath       = "ffmpeg"         // binary used to decode videos
+	KeyframeInterval = 10.0             // least seconds between extracted frames
+	MaxKeyframes     = 12               // most frames taken from one video
+	KeyframeTimeout  = 10 * time.Minute // per video
+)
+
+// Frame is a keyframe taken from a video
+type Frame struct {
+	At   float64 // seconds from the start
+	Data []byte  // JPEG
+}
+
+// errNoFFmpeg marks videos whose frames cannot be extracted on this server
+var errNoFFmpeg = errors.New("ffmpeg is not installed")
+
+// ptsTime reads frame times from ffmpeg's showinfo filter
+var ptsTime = regexp.MustCompile(`pts_time:\s*([0-9.]+)`)
+
+// Keyframes extracts up to MaxKeyframes keyframes from the video file at
+// path, at least KeyframeInterval seconds apart. Only keyframes are decoded,
+// so it is quick even for long clips.
+func Keyframes(ctx context.Context, path string) ([]Frame, error) {
+	if _, err := exec.LookPath(FFmpegPath); err != nil {
+		return nil, errNoFFmpeg
+	}
+	dir, err := os.MkdirTemp("", "keyframes-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	ctx, cancel := context.WithTimeout(ctx, KeyframeTimeout)
+	defer cancel()
+	sel := fmt.Sprintf("select='isnan(prev_selected_t)+gte(t-prev_selected_t\\,%g)',showinfo", KeyframeInterval)
+	cmd := exec.CommandContext(ctx, FFmpegPath,
+		"-hide_banner", "-nostdin", "-loglevel", "info",
+		"-skip_frame", "nokey", "-i", path,
+		"-an", "-sn", "-vf", sel, "-fps_mode", "vfr",
+		"-frames:v", strconv.Itoa(MaxKeyframes), "-q:v", "2",
+		filepath.Join(dir, "frame-%04d.jpg"))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 500 {
+			msg = msg[len(msg)-500:]
+		}
+		return nil, fmt.Errorf("ffmpeg failed: %v: %s", err, msg)
+	}
+
+	var times []float64
+	for _, m := range ptsTime.FindAllStringSubmatch(stderr.String(), -1) {
+		t, _ := strconv.ParseFloat(m[1], 64)
+		times = append(times, t)
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "frame-*.jpg"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	frames := make([]Frame, 0, len(names))
+	for i, name := range names {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		f := Frame{Data: data}
+		if i < len(times) {
+			f.At = times[i]
+		}
+		frames = append(frames, f)
+	}
+	return frames, nil
+}
+
+// NewEvidenceVideo describes a stored video whose keyframes are still to be
+// extracted
+func NewEvidenceVideo(v *Video, key string, size int64) models.EvidenceVideo {
+	return models.EvidenceVideo{
+		File: models.Rendition{
+			URL:    models.BlobRef(key),
+			Width:  v.Width,
+			Height: v.Height,
+			Bytes:  size,
+		},
+		MIME:     v.MIME,
+		Codec:    v.Codec,
+		Duration: v.Duration,
+		Status:   StatusPending,
+	}
+}
+
+// JobReportVideos extracts keyframes from a report's pending videos and adds
+// them to its images, which then go through JobReportImages
+const JobReportVideos = "media.report_videos"
+
+func init() {
+	jobs.Register(JobReportVideos, func(ctx context.Context, job *models.Job) error {
+		var p struct {
+			ReportID uint `json:"report_id"`
+		}
+		if err := jobs.Decode(job, &p); err != nil {
+			return err
+		}
+		var report models.Report
+		if err := utils.DB.Where("id = ?", p.ReportID).Limit(1).Find(&report).Error; err != nil || report.ID == 0 {
+			return err
+		}
+
+		videos := map[int]models.EvidenceVideo{}
+		var frames []models.EvidenceImage
+		for i, v := range report.Videos {
+			if v.Status != StatusPending {
+				continue
+			}
+			extracted, err := extractFrames(ctx, &v, i)
+			if err != nil {
+				return err
+			}
+			videos[i] = v
+			frames = append(frames, extracted...)
+		}
+		if len(videos) == 0 {
+			return nil
+		}
+
+		// images may be rendered meanwhile, so the frames are appended to
+		// the report as it is now
+		return utils.DB.Transaction(func(tx *gorm.DB) error {
+			var current models.Report
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, report.ID).Error; err != nil {
+				return err
+			}
+			for i, v := range videos {
+				if i < len(current.Videos) && current.Videos[i].File.URL == v.File.URL {
+					current.Videos[i] = v
+				}
+			}
+			current.Images = append(current.Images, frames...)
+			err := tx.Model(&models.Report{}).Where("id = ?", report.ID).Updates(map[string]interface{}{
+				"images": current.Images,
+				"videos": current.Videos,
+			}).Error
+			if err != nil || len(frames) == 0 {
+				return err
+			}
+			_, err = jobs.EnqueueTx(tx, JobReportImages, map[string]uint{"report_id": report.ID})
+			return err
+		})
+	})
+}
+
+// extractFrames takes the keyframes of the index-th video of a report and
+// stores them as private originals. Videos ffmpeg cannot read are marked
+// rather than returned as errors.
+func extractFrames(ctx context.Context, v *models.EvidenceVideo, index int) ([]models.EvidenceImage, error) {
+	path, cleanup, err := localCopy(ctx, string(v.File.URL))
+	if errors.Is(err, storage.ErrNotFound) {
+		v.Status, v.Error = StatusFailed, "video is missing"
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	frames, err := Keyframes(ctx, path)
+	if errors.Is(err, errNoFFmpeg) {
+		v.Status, v.Error = StatusUnsupported, err.Error()
+		return nil, nil
+	}
+	if err != nil {
+		v.Status, v.Error = StatusFailed, "keyframes could not be extracted"
+		return nil, nil
+	}
+
+	var images []models.EvidenceImage
+	for _, f := range frames {
+		img, err := ReadImage(bytes.NewReader(f.Data))
+		if err != nil {
+			continue
+		}
+		key, err := storage.SaveBytes(ctx, storage.PrivatePrefix+"reports", img.Data, img.Ext)
+		if err != nil {
+			return nil, err
+		}
+		e := NewEvidenceImage(img, key)
+		e.Video = &models.VideoFrame{Index: index, At: f.At}
+		images = append(images, e)
+	}
+	v.Frames, v.Status, v.Error = len(images), StatusReady, ""
+	return images, nil
+}
+
+// localCopy gives ffmpeg a file to read, copying the stored video to a
+// temporary file
+func localCopy(ctx context.Context, ref string) (string, func(), error) {
+	src, err := storage.Open(ctx, ref)
+	if err != nil {
+		return "", nil, err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp("", "video-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+	_, err = io.Copy(tmp, src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return tmp.Name(), cleanup, nil
+}
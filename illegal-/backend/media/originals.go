@@ -0,0 +1,134 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"log"
+	"path"
+
+	"backend/jobs"
+	"backend/models"
+	"backend/storage"
+	"backend/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JobPrivateOriginals moves report originals stored before redaction, which
+// sit under public keys with their EXIF intact, under storage.PrivatePrefix
+const JobPrivateOriginals = "media.private_originals"
+
+// publicOriginalsSQL matches reports with an original outside the private
+// prefix, including bare references from before renditions existed
+const publicOriginalsSQL = `jsonb_typeof(images) = 'array' AND EXISTS (
+	SELECT 1 FROM jsonb_array_elements(images) e
+	WHERE (jsonb_typeof(e) = 'string' AND e #>> '{}' NOT LIKE 'http%')
+		OR (e->'original'->>'key' <> '' AND e->'original'->>'key' NOT LIKE 'private/%'))`
+
+func init() {
+	jobs.Register(JobPrivateOriginals, func(ctx context.Context, job *models.Job) error {
+		var last uint
+		for {
+			var reports []models.Report
+			err := utils.DB.Select("id").Where(publicOriginalsSQL).Where("id > ?", last).
+				Order("id").Limit(100).Find(&reports).Error
+			if err != nil || len(reports) == 0 {
+				return err
+			}
+			for _, r := range reports {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if err := privateOriginals(ctx, r.ID); err != nil {
+					return err
+				}
+				last = r.ID
+			}
+		}
+	})
+}
+
+// QueueLegacyOriginals queues JobPrivateOriginals when a report still has a
+// public original and the job is not already waiting or running
+func QueueLegacyOriginals() error {
+	var pending int64
+	err := utils.DB.Model(&models.Job{}).
+		Where("type = ? AND status IN ?", JobPrivateOriginals, []string{jobs.StatusQueued, jobs.StatusRunning}).
+		Count(&pending).Error
+	if err != nil || pending > 0 {
+		return err
+	}
+	var legacy int64
+	if err := utils.DB.Model(&models.Report{}).Where(publicOriginalsSQL).Count(&legacy).Error; err != nil || legacy == 0 {
+		return err
+	}
+	log.Printf("media: %d report(s) have public originals; queueing their move", legacy)
+	_, err = jobs.Enqueue(JobPrivateOriginals, nil)
+	return err
+}
+
+// privateOriginals copies the public originals of one report under the
+// private prefix and queues renditions for images that never had any. The
+// old copies are left for the cleanup task once nothing refers to them.
+func privateOriginals(ctx context.Context, reportID uint) error {
+	var report models.Report
+	if err := utils.DB.Where("id = ?", reportID).Limit(1).Find(&report).Error; err != nil || report.ID == 0 {
+		return err
+	}
+	moved := map[models.BlobRef]models.EvidenceImage{}
+	for _, img := range report.Images {
+		old := img.Original.URL
+		key, err := storage.KeyOf(string(old))
+		if err != nil || storage.Private(key) {
+			continue
+		}
+		f, err := storage.Open(ctx, key)
+		if errors.Is(err, storage.ErrNotFound) {
+			img.Original.URL = ""
+			img.Status, img.Error = StatusFailed, "original is missing"
+			moved[old] = img
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		newKey, err := storage.Save(ctx, storage.PrivatePrefix+"reports", f, path.Ext(key))
+		f.Close()
+		if err != nil {
+			return err
+		}
+		img.Original.URL = models.BlobRef(newKey)
+		if img.Public == nil && img.Status == StatusReady {
+			img.Status = StatusPending
+		}
+		moved[old] = img
+	}
+	if len(moved) == 0 {
+		return nil
+	}
+
+	// images may have been rendered or appended meanwhile, so entries are
+	// matched on the original they had when read
+	return utils.DB.Transaction(func(tx *gorm.DB) error {
+		var current models.Report
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, reportID).Error; err != nil {
+			return err
+		}
+		pending := false
+		for i, img := range current.Images {
+			if m, ok := moved[img.Original.URL]; ok {
+				current.Images[i] = m
+				pending = pending || m.Status == StatusPending
+			}
+		}
+		if err := tx.Model(&models.Report{}).Where("id = ?", reportID).Update("images", current.Images).Error; err != nil {
+			return err
+		}
+		if !pending {
+			return nil
+		}
+		_, err := jobs.EnqueueTx(tx, JobReportImages, map[string]uint{"report_id": reportID})
+		return err
+	})
+}
@@ -0,0 +1,89 @@
+package media
+
+import (
+	"image"
+	"math"
+	"sort"
+
+	"golang.org/x/image/draw"
+)
+
+// Hashes are perceptual hashes of an image: copies of a photo that were
+// resized, recompressed or lightly edited differ from it in few bits
+type Hashes struct {
+	PHash uint64 // from the lowest frequencies of a DCT
+	DHash uint64 // from horizontal brightness gradients
+}
+
+// Hash computes both hashes of img
+func Hash(img image.Image) Hashes {
+	return Hashes{PHash: pHash(img), DHash: dHash(img)}
+}
+
+// grayscale scales img down to w × h grey levels
+func grayscale(img image.Image, w, h int) *image.Gray {
+	dst := image.NewGray(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// pHash sets a bit for each of the 8 × 8 lowest DCT frequencies of a
+// 32 × 32 thumbnail that lies above their median
+func pHash(img image.Image) uint64 {
+	const n, k = 32, 8
+	g := grayscale(img, n, n)
+
+	var cos [n][k]float64
+	for x := 0; x < n; x++ {
+		for u := 0; u < k; u++ {
+			cos[x][u] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * n))
+		}
+	}
+	// DCT of the rows, then of the columns, keeping only the low frequencies
+	var rows [n][k]float64
+	for y := 0; y < n; y++ {
+		for u := 0; u < k; u++ {
+			for x := 0; x < n; x++ {
+				rows[y][u] += float64(g.GrayAt(x, y).Y) * cos[x][u]
+			}
+		}
+	}
+	coeffs := make([]float64, 0, k*k)
+	for v := 0; v < k; v++ {
+		for u := 0; u < k; u++ {
+			sum := 0.0
+			for y := 0; y < n; y++ {
+				sum += rows[y][u] * cos[y][v]
+			}
+			coeffs = append(coeffs, sum)
+		}
+	}
+
+	// the first coefficient is the mean brightness and would skew the median
+	sorted := append([]float64(nil), coeffs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var h uint64
+	for i, c := range coeffs {
+		if c > median {
+			h |= 1 << uint(i)
+		}
+	}
+	return h
+}
+
+// dHash sets a bit for each pixel of a 9 × 8 thumbnail that is darker than
+// its right neighbour
+func dHash(img image.Image) uint64 {
+	g := grayscale(img, 9, 8)
+	var h uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if g.GrayAt(x, y).Y < g.GrayAt(x+1, y).Y {
+				h |= 1 << uint(y*8+x)
+			}
+		}
+	}
+	return h
+}
@@ -0,0 +1,133 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+)
+
+// Region is an area of a photo to obscure, in pixels with y pointing down
+type Region struct {
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Label  string `json:"label"` // e.g. face, plate
+}
+
+// Redactor finds faces, licence plates and other identifying details in a
+// photo
+type Redactor interface {
+	Find(ctx context.Context, img image.Image) ([]Region, error)
+}
+
+// Redact blurs what it finds in the public renditions of evidence photos;
+// nil publishes them unblurred. Set at startup.
+var Redact Redactor
+
+// ProcessRedactor runs an external model for every photo. The photo is
+// written to the command's stdin as PNG and the command must print
+//
+//	{"regions": [{"x": 10, "y": 20, "width": 64, "height": 64, "label": "face"}]}
+//
+// on stdout. Any CPU-only model can sit behind it, e.g. a script running an
+// OpenCV cascade for faces and plates.
+type ProcessRedactor struct {
+	Command []string
+	Timeout time.Duration // per photo, default 1 minute
+}
+
+// Find implements Redactor
+func (p ProcessRedactor) Find(ctx context.Context, img image.Image) ([]Region, error) {
+	if len(p.Command) == 0 {
+		return nil, fmt.Errorf("redactor has no command")
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var in, out, stderr bytes.Buffer
+	if err := png.Encode(&in, img); err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = &in, &out, &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if len(msg) > 500 {
+			msg = msg[len(msg)-500:]
+		}
+		return nil, fmt.Errorf("redactor failed: %v: %s", err, msg)
+	}
+
+	var resp struct {
+		Regions []Region `json:"regions"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		return nil, fmt.Errorf("redactor printed invalid JSON: %v", err)
+	}
+	return resp.Regions, nil
+}
+
+// scrub returns a copy of src with the regions found by Redact pixelated,
+// and how many there were. Errors are returned rather than publishing a
+// photo that should have been blurred.
+func scrub(ctx context.Context, src image.Image) (*image.RGBA, int, error) {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src) // flatten transparency
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Over)
+	if Redact == nil {
+		return dst, 0, nil
+	}
+
+	regions, err := Redact.Find(ctx, dst)
+	if err != nil {
+		return nil, 0, err
+	}
+	n := 0
+	for _, reg := range regions {
+		r := image.Rect(reg.X, reg.Y, reg.X+reg.Width, reg.Y+reg.Height)
+		// pad a little so edges of a face or plate are covered too
+		r = r.Inset(-max(r.Dx(), r.Dy()) / 10).Intersect(dst.Bounds())
+		if r.Empty() {
+			continue
+		}
+		pixelate(dst, r)
+		n++
+	}
+	return dst, n, nil
+}
+
+// pixelate replaces r with a coarse grid of averaged blocks, about six
+// across, which unlike a light blur cannot be sharpened back
+func pixelate(img *image.RGBA, r image.Rectangle) {
+	block := max(4, max(r.Dx(), r.Dy())/6)
+	for y := r.Min.Y; y < r.Max.Y; y += block {
+		for x := r.Min.X; x < r.Max.X; x += block {
+			cell := image.Rect(x, y, x+block, y+block).Intersect(r)
+			var sr, sg, sb, count uint64
+			for py := cell.Min.Y; py < cell.Max.Y; py++ {
+				for px := cell.Min.X; px < cell.Max.X; px++ {
+					c := img.RGBAAt(px, py)
+					sr, sg, sb = sr+uint64(c.R), sg+uint64(c.G), sb+uint64(c.B)
+					count++
+				}
+			}
+			avg := color.RGBA{uint8(sr / count), uint8(sg / count), uint8(sb / count), 255}
+			draw.Draw(img, cell, image.NewUniform(avg), image.Point{}, draw.Src)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+
+	"backend/dedup"
+	"backend/jobs"
+	"backend/models"
+	"backend/storage"
+	"backend/utils"
+
+	"golang.org/x/image/draw"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Image statuses
+const (
+	StatusPending     = "pending"
+	StatusReady       = "ready"
+	StatusUnsupported = "unsupported" // no decoder, e.g. HEIC; nothing is served publicly
+	StatusFailed      = "failed"
+)
+
+// Rendition sizes: the longest side in pixels
+var (
+	ThumbnailSize = 320
+	MediumSize    = 1280
+)
+
+// renditionQuality is the JPEG quality of generated renditions
+const renditionQuality = 82
+
+// NewEvidenceImage describes a stored original whose renditions are still
+// to be generated
+func NewEvidenceImage(img *Image, key string) models.EvidenceImage {
+	return models.EvidenceImage{
+		Original: models.Rendition{
+			URL:    models.BlobRef(key),
+			Width:  img.Width,
+			Height: img.Height,
+			Bytes:  int64(len(img.Data)),
+		},
+		MIME:     img.MIME,
+		Status:   StatusPending,
+		Metadata: img.Metadata,
+	}
+}
+
+// JobReportImages generates the renditions of a report's pending images
+const JobReportImages = "media.report_images"
+
+func init() {
+	jobs.Register(JobReportImages, func(ctx context.Context, job *models.Job) error {
+		var p struct {
+			ReportID uint `json:"report_id"`
+		}
+		if err := jobs.Decode(job, &p); err != nil {
+			return err
+		}
+		var report models.Report
+		if err := utils.DB.Where("id = ?", p.ReportID).Limit(1).Find(&report).Error; err != nil || report.ID == 0 {
+			return err
+		}
+		rendered := map[int]models.EvidenceImage{}
+		var hashes []models.ImageHash
+		for i, img := range report.Images {
+			if img.Status != StatusPending {
+				continue
+			}
+			h, err := Render(ctx, &img, "reports")
+			if err != nil {
+				return err
+			}
+			rendered[i] = img
+			if h != nil {
+				hashes = append(hashes, models.ImageHash{
+					ReportID:   report.ID,
+					ImageIndex: i,
+					PHash:      int64(h.PHash),
+					DHash:      int64(h.DHash),
+				})
+			}
+		}
+
+		// the renditions, hashes and duplicate check are stored together so
+		// a retry never finds images ready but unhashed. Video keyframes may
+		// have been appended meanwhile, so only the rendered entries of the
+		// report as it is now are replaced.
+		return utils.DB.Transaction(func(tx *gorm.DB) error {
+			var current models.Report
+			if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, report.ID).Error; err != nil {
+				return err
+			}
+			for i, img := range rendered {
+				if i < len(current.Images) && current.Images[i].Original.URL == img.Original.URL {
+					current.Images[i] = img
+				}
+			}
+			if err := tx.Model(&models.Report{}).Where("id = ?", report.ID).Update("images", current.Images).Error; err != nil {
+				return err
+			}
+			if len(hashes) == 0 {
+				return nil
+			}
+			if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&hashes).Error; err != nil {
+				return err
+			}
+			_, err := jobs.EnqueueTx(tx, dedup.JobReport, map[string]uint{"report_id": report.ID})
+			return err
+		})
+	})
+}
+
+// Render generates the public, medium and thumbnail renditions of an image
+// and stores them under prefix, returning the perceptual hashes of the
+// upright, unblurred image or nil when it could not be decoded. Every
+// rendition is re-encoded, which drops the original's metadata, and has the
+// regions found by Redact blurred; the original itself is kept private.
+// Images that cannot be decoded are marked rather than returned as errors;
+// errors are left for storage and redactor failures, which are worth
+// retrying.
+func Render(ctx context.Context, img *models.EvidenceImage, prefix string) (*Hashes, error) {
+	if img.MIME == "image/heic" || img.MIME == "image/heif" {
+		img.Status = StatusUnsupported
+		return nil, nil
+	}
+	f, err := storage.Open(ctx, string(img.Original.URL))
+	if errors.Is(err, storage.ErrNotFound) {
+		img.Status, img.Error = StatusFailed, "original is missing"
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		img.Status, img.Error = StatusFailed, "original could not be decoded"
+		return nil, nil
+	}
+	b := src.Bounds()
+	img.Original.Width, img.Original.Height, img.Original.Bytes = b.Dx(), b.Dy(), int64(len(data))
+	// renditions are served upright; the original keeps its EXIF tag
+	if img.Metadata != nil {
+		src = orient(src, img.Metadata.Orientation)
+	}
+	hashes := Hash(src)
+
+	public, redactions, err := scrub(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	img.Redactions = redactions
+	if img.Public, err = encodeRendition(ctx, public, prefix+"/public"); err != nil {
+		return nil, err
+	}
+	if img.Medium, err = rendition(ctx, public, MediumSize, prefix); err != nil {
+		return nil, err
+	}
+	if img.Thumbnail, err = rendition(ctx, public, ThumbnailSize, prefix); err != nil {
+		return nil, err
+	}
+	img.Status, img.Error = StatusReady, ""
+	return &hashes, nil
+}
+
+// orient turns an image as its EXIF orientation says it should be displayed
+func orient(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	transposed := orientation >= 5
+	dw, dh := w, h
+	if transposed {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // upside down
+				dx, dy = w-1-x, h-1-y
+			case 4: // upside down, mirrored
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise to display
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise to display
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, src.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// rendition scales src to fit within size × size and stores it. It returns
+// nil when src already fits, as the public rendition serves that size.
+func rendition(ctx context.Context, src *image.RGBA, size int, prefix string) (*models.Rendition, error) {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return nil, nil
+	}
+	if w >= h {
+		w, h = size, max(1, h*size/w)
+	} else {
+		w, h = max(1, w*size/h), size
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, b, draw.Src, nil)
+	return encodeRendition(ctx, dst, fmt.Sprintf("%s/%d", prefix, size))
+}
+
+// encodeRendition stores img as JPEG under prefix
+func encodeRendition(ctx context.Context, img *image.RGBA, prefix string) (*models.Rendition, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: renditionQuality}); err != nil {
+		return nil, err
+	}
+	key, err := storage.SaveBytes(ctx, prefix, buf.Bytes(), ".jpg")
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	return &models.Rendition{URL: models.BlobRef(key), Width: b.Dx(), Height: b.Dy(), Bytes: int64(buf.Len())}, nil
+}
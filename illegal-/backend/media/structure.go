@@ -0,0 +1,182 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// checkTrailing walks the container structure of a decoded image and fails
+// if bytes other than padding follow its end
+func checkTrailing(mime string, data []byte) error {
+	var end int
+	var err error
+	switch mime {
+	case "image/jpeg":
+		end, err = jpegEnd(data)
+		// phones append depth maps and previews as further JPEGs (MPF)
+		for err == nil && bytes.HasPrefix(data[end:], []byte{0xFF, 0xD8}) {
+			var n int
+			n, err = jpegEnd(data[end:])
+			end += n
+		}
+	case "image/png":
+		end, err = pngEnd(data)
+	case "image/webp":
+		end, err = webpEnd(data)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, b := range data[end:] {
+		if b != 0x00 && b != 0xFF { // some cameras pad files
+			return errTrailing
+		}
+	}
+	return nil
+}
+
+var errStructure = errors.New("image structure is invalid")
+
+// jpegEnd returns the offset just past the EOI marker
+func jpegEnd(data []byte) (int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 0, errStructure
+	}
+	i := 2
+	for i+4 <= len(data) {
+		if data[i] != 0xFF {
+			return 0, errStructure
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF: // fill byte
+			i++
+			continue
+		case marker == 0xD9: // EOI
+			return i + 2, nil
+		case marker >= 0xD0 && marker <= 0xD7, marker == 0x01: // no length
+			i += 2
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 0, errStructure
+		}
+		i += 2 + length
+		if marker != 0xDA { // SOS is followed by entropy-coded data
+			continue
+		}
+		for i+1 < len(data) {
+			if data[i] == 0xFF && data[i+1] != 0x00 && (data[i+1] < 0xD0 || data[i+1] > 0xD7) {
+				break
+			}
+			i++
+		}
+	}
+	if i+2 <= len(data) && data[i] == 0xFF && data[i+1] == 0xD9 {
+		return i + 2, nil
+	}
+	return 0, errStructure
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngEnd returns the offset just past the IEND chunk
+func pngEnd(data []byte) (int, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return 0, errStructure
+	}
+	i := len(pngSignature)
+	for i+12 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		if length < 0 || i+12+length > len(data) {
+			return 0, errStructure
+		}
+		kind := string(data[i+4 : i+8])
+		i += 12 + length
+		if kind == "IEND" {
+			return i, nil
+		}
+	}
+	return 0, errStructure
+}
+
+// webpEnd returns the end of the RIFF container
+func webpEnd(data []byte) (int, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 0, errStructure
+	}
+	size := int(binary.LittleEndian.Uint32(data[4:]))
+	if size < 4 || 8+size > len(data) {
+		return 0, errStructure
+	}
+	return 8 + size, nil
+}
+
+// checkHEIF validates the top-level ISO BMFF boxes of a HEIC/HEIF file: it
+// must start with ftyp, contain meta, and end exactly with its last box. It
+// returns the largest image size declared by an ispe property, and rejects
+// files without one, whose size could only be learnt by decoding them.
+func checkHEIF(data []byte) (int, int, error) {
+	i := 0
+	var meta []byte
+	first := true
+	for i < len(data) {
+		if i+8 > len(data) {
+			return 0, 0, errTrailing
+		}
+		size := uint64(binary.BigEndian.Uint32(data[i:]))
+		kind := string(data[i+4 : i+8])
+		header := uint64(8)
+		switch size {
+		case 0: // box runs to the end of the file
+			size = uint64(len(data) - i)
+		case 1:
+			if i+16 > len(data) {
+				return 0, 0, errStructure
+			}
+			size = binary.BigEndian.Uint64(data[i+8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)-i) {
+			return 0, 0, errStructure
+		}
+		if first && kind != "ftyp" {
+			return 0, 0, errStructure
+		}
+		first = false
+		if kind == "meta" {
+			meta = data[i+int(header) : i+int(size)]
+		}
+		i += int(size)
+	}
+	if meta == nil {
+		return 0, 0, errStructure
+	}
+
+	// ispe: 4 bytes version/flags, then width and height. Thumbnails, grid
+	// tiles and the grid image each have one, so every one is checked.
+	var width, height int
+	for rest := meta; ; {
+		at := bytes.Index(rest, []byte("ispe"))
+		if at < 0 || at+16 > len(rest) {
+			break
+		}
+		w := int(binary.BigEndian.Uint32(rest[at+8:]))
+		h := int(binary.BigEndian.Uint32(rest[at+12:]))
+		if err := CheckPixels(w, h); err != nil {
+			return 0, 0, err
+		}
+		if w*h > width*height {
+			width, height = w, h
+		}
+		rest = rest[at+16:]
+	}
+	if width == 0 {
+		return 0, 0, errors.New("HEIC file does not declare its image size")
+	}
+	return width, height, nil
+}
@@ -0,0 +1,234 @@
+package media
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+)
+
+// MaxVideoBytes is the largest accepted video file, adjustable at startup
+var MaxVideoBytes int64 = 4 << 30
+
+// videoTypes are the accepted video types and the extension stored with
+// each; both are ISO base media files
+var videoTypes = map[string]string{
+	"video/mp4":       ".mp4",
+	"video/x-m4v":     ".m4v",
+	"video/quicktime": ".mov",
+}
+
+// Video is an uploaded video that passed validation
+type Video struct {
+	MIME     string
+	Ext      string
+	Codec    string  // of the video track, e.g. h264, hevc
+	Duration float64 // seconds
+	Width    int
+	Height   int
+}
+
+// codecs names the sample entry types of common video codecs
+var codecs = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"av01": "av1",
+	"vp09": "vp9",
+	"mp4v": "mpeg4",
+	"apcn": "prores",
+	"apch": "prores",
+}
+
+var errVideoStructure = errors.New("file is not a valid MP4 or MOV video")
+
+// leadingBoxes may start a file; older QuickTime files lack ftyp
+var leadingBoxes = map[string]bool{"ftyp": true, "wide": true, "free": true, "skip": true, "mdat": true, "moov": true}
+
+// ProbeVideo checks that r holds a single well-formed MP4 or MOV file with a
+// video track and reads its duration, codec and frame size. It only reads
+// the box headers and the moov box, so it suits files of any size. Errors
+// are worded for the uploader.
+func ProbeVideo(r io.ReaderAt, size int64) (*Video, error) {
+	if size > MaxVideoBytes {
+		return nil, fmt.Errorf("video is larger than %d MB", MaxVideoBytes>>20)
+	}
+	head := make([]byte, 3072)
+	n, _ := r.ReadAt(head, 0)
+	if n == 0 {
+		return nil, errors.New("file is empty")
+	}
+	mime, _, _ := strings.Cut(mimetype.Detect(head[:n]).String(), ";")
+	ext, ok := videoTypes[mime]
+	if !ok {
+		return nil, fmt.Errorf("file type %s is not allowed; use MP4 or MOV", mime)
+	}
+
+	// the top-level boxes must cover the file exactly, so nothing can be
+	// appended after the video
+	var moov []byte
+	first := true
+	for off := int64(0); off < size; {
+		kind, start, end, err := readBox(r, off, size)
+		if err != nil {
+			return nil, err
+		}
+		if first && !leadingBoxes[kind] {
+			return nil, errVideoStructure
+		}
+		first = false
+		if kind == "moov" {
+			if moov != nil || end-start > 64<<20 {
+				return nil, errVideoStructure
+			}
+			moov = make([]byte, end-start)
+			if _, err := r.ReadAt(moov, start); err != nil {
+				return nil, errVideoStructure
+			}
+		}
+		off = end
+	}
+	if moov == nil {
+		return nil, errors.New("video has no index; it may not have finished recording")
+	}
+
+	v := &Video{MIME: mime, Ext: ext}
+	if err := v.readMoov(moov); err != nil {
+		return nil, err
+	}
+	if v.Codec == "" {
+		return nil, errors.New("file has no video track")
+	}
+	return v, nil
+}
+
+// readBox reads the header of the box at off, returning its type and the
+// extent of its payload
+func readBox(r io.ReaderAt, off, size int64) (string, int64, int64, error) {
+	var h [16]byte
+	if off+8 > size {
+		return "", 0, 0, errVideoStructure
+	}
+	if _, err := r.ReadAt(h[:8], off); err != nil {
+		return "", 0, 0, errVideoStructure
+	}
+	n := int64(binary.BigEndian.Uint32(h[:]))
+	kind := string(h[4:8])
+	header := int64(8)
+	switch n {
+	case 0: // box runs to the end of the file
+		n = size - off
+	case 1:
+		if off+16 > size {
+			return "", 0, 0, errVideoStructure
+		}
+		if _, err := r.ReadAt(h[8:16], off+8); err != nil {
+			return "", 0, 0, errVideoStructure
+		}
+		n = int64(binary.BigEndian.Uint64(h[8:]))
+		header = 16
+	}
+	if n < header || n > size-off {
+		return "", 0, 0, errVideoStructure
+	}
+	return kind, off + header, off + n, nil
+}
+
+// boxes splits a payload into its child boxes
+func boxes(data []byte, fn func(kind string, payload []byte) error) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return errVideoStructure
+		}
+		n := uint64(binary.BigEndian.Uint32(data))
+		header := uint64(8)
+		switch n {
+		case 0:
+			n = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return errVideoStructure
+			}
+			n, header = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if n < header || n > uint64(len(data)) {
+			return errVideoStructure
+		}
+		if err := fn(string(data[4:8]), data[header:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// readMoov reads the movie duration and the first video track
+func (v *Video) readMoov(moov []byte) error {
+	return boxes(moov, func(kind string, payload []byte) error {
+		switch kind {
+		case "mvhd":
+			// version 0 has 32-bit times, version 1 64-bit
+			if len(payload) >= 20 && payload[0] == 0 {
+				scale, dur := binary.BigEndian.Uint32(payload[12:]), binary.BigEndian.Uint32(payload[16:])
+				if scale > 0 {
+					v.Duration = float64(dur) / float64(scale)
+				}
+			} else if len(payload) >= 32 && payload[0] == 1 {
+				scale, dur := binary.BigEndian.Uint32(payload[20:]), binary.BigEndian.Uint64(payload[24:])
+				if scale > 0 {
+					v.Duration = float64(dur) / float64(scale)
+				}
+			}
+		case "trak":
+			if v.Codec == "" {
+				return v.readTrak(payload)
+			}
+		}
+		return nil
+	})
+}
+
+// readTrak fills codec and frame size when the track is a video track
+func (v *Video) readTrak(trak []byte) error {
+	var width, height int
+	var handler, codec string
+	var walk func(kind string, payload []byte) error
+	walk = func(kind string, payload []byte) error {
+		switch kind {
+		case "tkhd":
+			// width and height are the last 8 bytes, as 16.16 fixed point
+			if len(payload) >= 84 {
+				width = int(binary.BigEndian.Uint32(payload[len(payload)-8:]) >> 16)
+				height = int(binary.BigEndian.Uint32(payload[len(payload)-4:]) >> 16)
+			}
+		case "hdlr":
+			if len(payload) >= 12 {
+				handler = string(payload[8:12])
+			}
+		case "stsd":
+			// version/flags and entry count, then the first sample entry
+			if len(payload) >= 16 {
+				fourcc := string(payload[12:16])
+				if name, ok := codecs[fourcc]; ok {
+					codec = name
+				} else {
+					codec = strings.TrimSpace(fourcc)
+				}
+			}
+		case "mdia", "minf", "stbl":
+			return boxes(payload, walk)
+		}
+		return nil
+	}
+	if err := boxes(trak, walk); err != nil {
+		return err
+	}
+	if handler == "vide" {
+		v.Codec, v.Width, v.Height = codec, width, height
+	}
+	return nil
+}
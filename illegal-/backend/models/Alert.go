@@ -1,12 +1,31 @@
 package models
 
-import "gorm.io/gorm"
+import (
+    "time"
+
+    "gorm.io/datatypes"
+    "gorm.io/gorm"
+)
 
 type Alert struct {
     gorm.Model
-    Title       string `json:"title"`
-    Description string `json:"description"`
-    Location    string `json:"location"`
-    Status      string `json:"status"`
-    IsRead      bool   `json:"isRead" gorm:"default:false"`
+    Title       string           `json:"title"`
+    Description string           `json:"description"`
+    Location    string           `json:"location"`
+    Status      string           `json:"status"`
+    Severity    string           `json:"severity" gorm:"default:'medium'"` // low, medium, high, critical
+    RuleID      *uint            `json:"rule_id" gorm:"index"`             // rule that raised the alert, if any
+    SourceType  string           `json:"source_type"`                      // report, complaint, detection
+    SourceID    *uint            `json:"source_id"`                        // entity whose event raised the alert
+    Sources     datatypes.JSON   `json:"sources" gorm:"type:jsonb"`        // every [{type, id}] that matched since
+    DedupKey    *string          `json:"dedup_key" gorm:"uniqueIndex"`     // later matches with the same key join an open alert
+    AssigneeID  *uint            `json:"assignee_id" gorm:"index"`         // officer handling the alert
+    AssignedAt  *time.Time       `json:"assigned_at"`
+    Recipients  []AlertRecipient `json:"recipients" gorm:"foreignKey:AlertID"` // none means every officer and admin
+
+    // The caller's own state, filled per request from AlertState
+    IsRead         bool       `json:"isRead" gorm:"-"`
+    ReadAt         *time.Time `json:"read_at" gorm:"-"`
+    AcknowledgedAt *time.Time `json:"acknowledged_at" gorm:"-"`
+    SnoozedUntil   *time.Time `json:"snoozed_until" gorm:"-"`
 }
@@ -0,0 +1,22 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// AlertRule raises an alert when an event matches all of its conditions
+type AlertRule struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	Name        string         `json:"name"` // used as the alert title
+	Description string         `json:"description"`
+	Event       string         `json:"event" gorm:"index"`           // report, complaint, detection
+	Conditions  datatypes.JSON `json:"conditions" gorm:"type:jsonb"` // [{type, ...}], see package alerts
+	Severity    string         `json:"severity"`                     // low, medium, high, critical
+	Recipients  datatypes.JSON `json:"recipients" gorm:"type:jsonb"` // [{user_id} or {role}] alerts are addressed to; none for every officer and admin
+	Enabled     bool           `json:"enabled"`
+	CreatedBy   *uint          `json:"created_by"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// AlertRecipient addresses an alert to one user or to every user with a role
+type AlertRecipient struct {
+	ID      uint   `json:"id" gorm:"primaryKey"`
+	AlertID uint   `json:"alert_id" gorm:"index"`
+	UserID  *uint  `json:"user_id" gorm:"index"`
+	Role    string `json:"role"` // officer, admin; empty when UserID is set
+}
+
+// AlertState is one user's state of an alert; a missing row means unread
+type AlertState struct {
+	AlertID        uint       `json:"alert_id" gorm:"primaryKey;autoIncrement:false"`
+	UserID         uint       `json:"user_id" gorm:"primaryKey;autoIncrement:false;index"`
+	ReadAt         *time.Time `json:"read_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at"`
+	SnoozedUntil   *time.Time `json:"snoozed_until"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,297 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// BlobURL resolves a stored blob reference to a URL clients can fetch. The
+// storage package installs the resolver for the configured BlobStore.
+var BlobURL = func(ref string) string { return ref }
+
+// BlobRef is a stored file: a BlobStore key, a legacy /uploads/ path or an
+// external http(s) URL. It is encoded to JSON as a URL.
+type BlobRef string
+
+// MarshalJSON resolves the reference through the configured store
+func (r BlobRef) MarshalJSON() ([]byte, error) {
+	if r == "" {
+		return []byte(`""`), nil
+	}
+	return json.Marshal(BlobURL(string(r)))
+}
+
+// BlobList is a jsonb array of blob references
+type BlobList []BlobRef
+
+// Value stores the list as a JSON array of references
+func (l BlobList) Value() (driver.Value, error) {
+	refs := make([]string, len(l))
+	for i, r := range l {
+		refs[i] = string(r)
+	}
+	data, err := json.Marshal(refs)
+	return string(data), err
+}
+
+// Scan reads a JSON array of references; null reads as an empty list
+func (l *BlobList) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = BlobList{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("blob list must be JSON")
+	}
+	var refs []string
+	if err := json.Unmarshal(data, &refs); err != nil {
+		return err
+	}
+	*l = make(BlobList, len(refs))
+	for i, r := range refs {
+		(*l)[i] = BlobRef(r)
+	}
+	return nil
+}
+
+// Rendition is one stored size of an image
+type Rendition struct {
+	URL    BlobRef `json:"url"`
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Bytes  int64   `json:"bytes"`
+}
+
+// EvidenceImage is an uploaded image with its resized renditions, which are
+// generated in the background after upload
+type EvidenceImage struct {
+	Original   Rendition      `json:"original"`   // as uploaded; private since redaction, so its url is empty
+	Public     *Rendition     `json:"public"`     // full size, without metadata and with identifying details blurred
+	Medium     *Rendition     `json:"medium"`     // nil until generated, or when the public rendition is smaller
+	Thumbnail  *Rendition     `json:"thumbnail"`  // nil until generated, or when the public rendition is smaller
+	Redactions int            `json:"redactions"` // regions blurred in the public renditions
+	MIME       string         `json:"mime"`
+	Status     string         `json:"status"` // pending, ready, unsupported, failed
+	Error      string         `json:"error,omitempty"`
+	Metadata   *PhotoMetadata `json:"metadata,omitempty"` // from EXIF, when the photo carries any
+	Video      *VideoFrame    `json:"video,omitempty"`    // set for keyframes taken from a video
+}
+
+// MarshalJSON leaves out the original's URL, even for images stored before
+// originals were private: originals keep their metadata and are served to
+// officers only, through the report
+func (img EvidenceImage) MarshalJSON() ([]byte, error) {
+	type plain EvidenceImage
+	p := plain(img)
+	p.Original.URL = ""
+	return json.Marshal(p)
+}
+
+// VideoFrame places a keyframe image in the video it was taken from
+type VideoFrame struct {
+	Index int     `json:"index"` // position in Report.Videos
+	At    float64 `json:"at"`    // seconds from the start
+}
+
+// PhotoMetadata is what a photo's EXIF records about where, when and how it
+// was taken
+type PhotoMetadata struct {
+	Lat         *float64   `json:"lat,omitempty"`
+	Lng         *float64   `json:"lng,omitempty"`
+	CapturedAt  *time.Time `json:"captured_at,omitempty"`
+	Orientation int        `json:"orientation,omitempty"` // EXIF orientation, 1 (upright) to 8
+}
+
+// HasLocation reports whether the photo carries a GPS position
+func (m *PhotoMetadata) HasLocation() bool {
+	return m != nil && m.Lat != nil && m.Lng != nil
+}
+
+// EvidenceImages is a jsonb array of evidence images
+type EvidenceImages []EvidenceImage
+
+// storedRendition is the database form of a Rendition, keeping the key
+// rather than the resolved URL
+type storedRendition struct {
+	Key    string `json:"key"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Bytes  int64  `json:"bytes"`
+}
+
+type storedImage struct {
+	Original   storedRendition  `json:"original"`
+	Public     *storedRendition `json:"public,omitempty"`
+	Redactions int              `json:"redactions,omitempty"`
+	Medium     *storedRendition `json:"medium,omitempty"`
+	Thumbnail  *storedRendition `json:"thumbnail,omitempty"`
+	MIME       string           `json:"mime"`
+	Status     string           `json:"status"`
+	Error      string           `json:"error,omitempty"`
+	Metadata   *PhotoMetadata   `json:"metadata,omitempty"`
+	Video      *VideoFrame      `json:"video,omitempty"`
+}
+
+func storeRendition(r *Rendition) *storedRendition {
+	if r == nil {
+		return nil
+	}
+	return &storedRendition{Key: string(r.URL), Width: r.Width, Height: r.Height, Bytes: r.Bytes}
+}
+
+func loadRendition(s *storedRendition) *Rendition {
+	if s == nil {
+		return nil
+	}
+	return &Rendition{URL: BlobRef(s.Key), Width: s.Width, Height: s.Height, Bytes: s.Bytes}
+}
+
+// Value stores the images with blob keys
+func (l EvidenceImages) Value() (driver.Value, error) {
+	stored := make([]storedImage, len(l))
+	for i, img := range l {
+		stored[i] = storedImage{
+			Original:   *storeRendition(&img.Original),
+			Public:     storeRendition(img.Public),
+			Redactions: img.Redactions,
+			Medium:     storeRendition(img.Medium),
+			Thumbnail:  storeRendition(img.Thumbnail),
+			MIME:       img.MIME,
+			Status:     img.Status,
+			Error:      img.Error,
+			Metadata:   img.Metadata,
+			Video:      img.Video,
+		}
+	}
+	data, err := json.Marshal(stored)
+	return string(data), err
+}
+
+// Scan reads stored images. Reports saved before renditions existed hold
+// bare references, which read as originals without renditions.
+func (l *EvidenceImages) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = EvidenceImages{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("evidence images must be JSON")
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	*l = make(EvidenceImages, len(items))
+	for i, item := range items {
+		var ref string
+		if json.Unmarshal(item, &ref) == nil {
+			(*l)[i] = EvidenceImage{Original: Rendition{URL: BlobRef(ref)}, Status: "ready"}
+			continue
+		}
+		var s storedImage
+		if err := json.Unmarshal(item, &s); err != nil {
+			return err
+		}
+		(*l)[i] = EvidenceImage{
+			Original:   *loadRendition(&s.Original),
+			Public:     loadRendition(s.Public),
+			Redactions: s.Redactions,
+			Medium:     loadRendition(s.Medium),
+			Thumbnail:  loadRendition(s.Thumbnail),
+			MIME:       s.MIME,
+			Status:     s.Status,
+			Error:      s.Error,
+			Metadata:   s.Metadata,
+			Video:      s.Video,
+		}
+	}
+	return nil
+}
+
+// EvidenceVideo is an uploaded video. Keyframes are extracted from it in the
+// background and added to the report's images.
+type EvidenceVideo struct {
+	File     Rendition `json:"file"` // private; officers play it through the report
+	MIME     string    `json:"mime"`
+	Codec    string    `json:"codec"`
+	Duration float64   `json:"duration"` // seconds
+	Frames   int       `json:"frames"`   // keyframes added to the images
+	Status   string    `json:"status"`   // pending, ready, unsupported, failed
+	Error    string    `json:"error,omitempty"`
+}
+
+// EvidenceVideos is a jsonb array of evidence videos
+type EvidenceVideos []EvidenceVideo
+
+type storedVideo struct {
+	File     storedRendition `json:"file"`
+	MIME     string          `json:"mime"`
+	Codec    string          `json:"codec"`
+	Duration float64         `json:"duration"`
+	Frames   int             `json:"frames"`
+	Status   string          `json:"status"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// Value stores the videos with blob keys
+func (l EvidenceVideos) Value() (driver.Value, error) {
+	stored := make([]storedVideo, len(l))
+	for i, v := range l {
+		stored[i] = storedVideo{
+			File:     *storeRendition(&v.File),
+			MIME:     v.MIME,
+			Codec:    v.Codec,
+			Duration: v.Duration,
+			Frames:   v.Frames,
+			Status:   v.Status,
+			Error:    v.Error,
+		}
+	}
+	data, err := json.Marshal(stored)
+	return string(data), err
+}
+
+// Scan reads stored videos
+func (l *EvidenceVideos) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = EvidenceVideos{}
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return errors.New("evidence videos must be JSON")
+	}
+	var stored []storedVideo
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return err
+	}
+	*l = make(EvidenceVideos, len(stored))
+	for i, s := range stored {
+		(*l)[i] = EvidenceVideo{
+			File:     *loadRendition(&s.File),
+			MIME:     s.MIME,
+			Codec:    s.Codec,
+			Duration: s.Duration,
+			Frames:   s.Frames,
+			Status:   s.Status,
+			Error:    s.Error,
+		}
+	}
+	return nil
+}
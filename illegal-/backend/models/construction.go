@@ -1,16 +1,32 @@
 package models
 
-import "time"
+import (
+    "time"
+
+    "gorm.io/datatypes"
+)
 
 // Construction represents an illegal or legal construction entry
 type Construction struct {
-    ID               uint      `json:"id" gorm:"primaryKey"`
-    Location         string    `json:"location"`
-    Latitude         float64   `json:"latitude"`
-    Longitude        float64   `json:"longitude"`
-    Status           string    `json:"status"` // "illegal" or "legal"
-    DetectionSource  string    `json:"detection_source"` // "manual", "gis", "drone", "satellite", "citizen"
-    PropertyID       uint      `json:"property_id"`
-    CreatedAt        time.Time `json:"created_at"`
-    UpdatedAt        time.Time `json:"updated_at"`
-}
\ No newline at end of file
+    ID               uint           `json:"id" gorm:"primaryKey"`
+    Location         string         `json:"location"`
+    Street           string         `json:"street"` // normalised from the gazetteer
+    Locality         string         `json:"locality"` // normalised from the gazetteer
+    Latitude         float64        `json:"latitude" gorm:"index:idx_construction_position"`
+    Longitude        float64        `json:"longitude" gorm:"index:idx_construction_position"`
+    Footprint        datatypes.JSON `json:"footprint" gorm:"type:jsonb"` // optional detected GeoJSON polygon
+    Floors           int            `json:"floors"`
+    Status           string         `json:"status"` // "illegal" or "legal"
+    StatusOverridden bool           `json:"status_overridden"` // set by an officer rather than the zoning rules
+    Violations       datatypes.JSON `json:"violations" gorm:"type:jsonb"` // rules broken at the last evaluation
+    EvaluatedAt      *time.Time     `json:"evaluated_at"`
+    SatelliteImageURL BlobRef       `json:"satellite_image_url"` // latest satellite or drone capture
+    ComparisonImageURL BlobRef      `json:"comparison_image_url"` // latest change-detection heatmap
+    ChangeScore      *float64       `json:"change_score"` // SSIM of the latest comparison, 1 when unchanged
+    DetectionSource  string         `json:"detection_source"` // "manual", "gis", "drone", "satellite", "citizen"
+    PropertyID       uint           `json:"property_id"`
+    PermitID         *uint          `json:"permit_id"` // permit matched at the last evaluation
+    WardID           *uint          `json:"ward_id"` // ward layer feature containing the point
+    CreatedAt        time.Time      `json:"created_at"`
+    UpdatedAt        time.Time      `json:"updated_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// DailyStat is a precomputed count for the analytics pages, e.g. reports
+// created in one ward on one day
+type DailyStat struct {
+	ID     uint      `json:"id" gorm:"primaryKey"`
+	Day    time.Time `json:"day" gorm:"type:date;uniqueIndex:idx_daily_stat"`
+	Metric string    `json:"metric" gorm:"uniqueIndex:idx_daily_stat"`
+	WardID uint      `json:"ward_id" gorm:"uniqueIndex:idx_daily_stat"` // 0 outside every ward
+	Count  int       `json:"count"`
+}
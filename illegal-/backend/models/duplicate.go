@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// ImageHash holds the perceptual hashes of one report image, kept for
+// finding the same photo in other reports
+type ImageHash struct {
+	ReportID   uint      `json:"report_id" gorm:"primaryKey;autoIncrement:false"`
+	ImageIndex int       `json:"image_index" gorm:"primaryKey;autoIncrement:false"` // position in Report.Images
+	PHash      int64     `json:"phash"`                                             // 64-bit DCT hash, stored signed
+	DHash      int64     `json:"dhash"`                                             // 64-bit gradient hash, stored signed
+	CreatedAt  time.Time `json:"created_at" gorm:"index"`                           // bounds the hashes a check compares against
+}
+
+// ReportDuplicate links a report to an earlier report with a near-identical
+// photo, for an officer to confirm or dismiss
+type ReportDuplicate struct {
+	ID                  uint       `json:"id" gorm:"primaryKey"`
+	ReportID            uint       `json:"report_id" gorm:"uniqueIndex:idx_report_duplicate"` // the later report
+	DuplicateOfID       uint       `json:"duplicate_of_id" gorm:"uniqueIndex:idx_report_duplicate;index"`
+	ImageIndex          int        `json:"image_index"`
+	DuplicateImageIndex int        `json:"duplicate_image_index"`
+	PHashDistance       int        `json:"phash_distance"` // differing bits, 0 for the same photo
+	DHashDistance       int        `json:"dhash_distance"`
+	Status              string     `json:"status" gorm:"index"` // pending, confirmed, dismissed
+	ReviewedBy          *uint      `json:"reviewed_by"`
+	ReviewedAt          *time.Time `json:"reviewed_at"`
+	CreatedAt           time.Time  `json:"created_at"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// GazetteerEntry is an address point imported from an OSM extract or a
+// municipal address register, used for offline geocoding
+type GazetteerEntry struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	Source      string    `json:"source" gorm:"index"` // name given at import, e.g. "osm-2025-01"
+	HouseNumber string    `json:"house_number"`
+	Street      string    `json:"street"`
+	Locality    string    `json:"locality"`
+	Ward        string    `json:"ward"` // ward name as given by the source, if any
+	Postcode    string    `json:"postcode"`
+	Latitude    float64   `json:"latitude" gorm:"index:idx_gazetteer_position"`
+	Longitude   float64   `json:"longitude" gorm:"index:idx_gazetteer_position"`
+	SearchText  string    `json:"-"` // normalised "house street locality" for forward lookups, full-text indexed
+	CreatedAt   time.Time `json:"created_at"`
+}
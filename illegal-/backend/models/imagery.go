@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// AreaOfInterest is a monitored area that is not (yet) a known construction,
+// such as a vacant parcel or a stretch of river bank
+type AreaOfInterest struct {
+	ID        uint           `json:"id" gorm:"primaryKey"`
+	Name      string         `json:"name"`
+	Boundary  datatypes.JSON `json:"boundary" gorm:"type:jsonb"` // GeoJSON polygon
+	MinLat    float64        `json:"-" gorm:"index:idx_aoi_bbox"`
+	MinLng    float64        `json:"-" gorm:"index:idx_aoi_bbox"`
+	MaxLat    float64        `json:"-" gorm:"index:idx_aoi_bbox"`
+	MaxLng    float64        `json:"-" gorm:"index:idx_aoi_bbox"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// ImageryCapture is a dated image of a construction or area of interest
+type ImageryCapture struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	ConstructionID *uint     `json:"construction_id" gorm:"index"`
+	AreaID         *uint     `json:"area_id" gorm:"index"`
+	Kind           string    `json:"kind"` // satellite, drone, field_photo
+	CapturedAt     time.Time `json:"captured_at" gorm:"index"`
+	ImageURL       BlobRef   `json:"image_url"`
+	Source         string    `json:"source"` // provider, drone operator or officer
+	Notes          string    `json:"notes"`
+	UploadedBy     *uint     `json:"uploaded_by"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ImageryComparison is the change score between a capture and the previous
+// capture of the same kind of the same subject
+type ImageryComparison struct {
+	ID               uint           `json:"id" gorm:"primaryKey"`
+	BeforeID         uint           `json:"before_id" gorm:"uniqueIndex:idx_imagery_pair"`
+	AfterID          uint           `json:"after_id" gorm:"uniqueIndex:idx_imagery_pair"`
+	ConstructionID   *uint          `json:"construction_id" gorm:"index"`
+	AreaID           *uint          `json:"area_id" gorm:"index"`
+	Status           string         `json:"status"` // done, failed
+	Error            string         `json:"error,omitempty"`
+	Score            float64        `json:"score"` // SSIM, 1 when unchanged
+	ChangedRatio     float64        `json:"changed_ratio"`
+	Verdict          string         `json:"verdict"`
+	AlignmentQuality float64        `json:"alignment_quality"`           // 0-1, 0 when the images could not be registered
+	Alignment        datatypes.JSON `json:"alignment" gorm:"type:jsonb"` // full co-registration report
+	HeatmapURL       BlobRef        `json:"heatmap_url"`
+	MaskURL          BlobRef        `json:"mask_url"`
+	CreatedAt        time.Time      `json:"created_at"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// Job is a unit of background work claimed by the worker pool
+type Job struct {
+	ID          uint           `json:"id" gorm:"primaryKey"`
+	Type        string         `json:"type" gorm:"index"`
+	Payload     datatypes.JSON `json:"payload" gorm:"type:jsonb"`
+	Status      string         `json:"status" gorm:"default:'queued';index:idx_job_ready"` // queued, running, succeeded, dead
+	RunAt       time.Time      `json:"run_at" gorm:"index:idx_job_ready"`                  // not claimed before this time
+	Attempts    int            `json:"attempts"`
+	MaxAttempts int            `json:"max_attempts"`
+	LastError   string         `json:"last_error"`
+	LockedBy    string         `json:"locked_by"` // worker holding a running job
+	LockedAt    *time.Time     `json:"locked_at"`
+	FinishedAt  *time.Time     `json:"finished_at"`
+	CreatedAt   time.Time      `json:"created_at"`
+	UpdatedAt   time.Time      `json:"updated_at"`
+}
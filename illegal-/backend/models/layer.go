@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// Layer is a named set of boundaries imported from GeoJSON or a Shapefile
+type Layer struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	Name         string    `json:"name" gorm:"uniqueIndex"`
+	Kind         string    `json:"kind"` // "ward", "zone", "river_buffer", "heritage"
+	SourceFile   string    `json:"source_file"`
+	FeatureCount int       `json:"feature_count"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// LayerFeature is a single polygon of a layer, e.g. one ward
+type LayerFeature struct {
+	ID         uint           `json:"id" gorm:"primaryKey"`
+	LayerID    uint           `json:"layer_id" gorm:"index"`
+	Name       string         `json:"name"`
+	Properties datatypes.JSON `json:"properties" gorm:"type:jsonb"`
+	Geometry   datatypes.JSON `json:"geometry" gorm:"type:jsonb"` // GeoJSON geometry
+	MinLat     float64        `json:"min_lat" gorm:"index:idx_layer_feature_bbox"`
+	MinLng     float64        `json:"min_lng" gorm:"index:idx_layer_feature_bbox"`
+	MaxLat     float64        `json:"max_lat" gorm:"index:idx_layer_feature_bbox"`
+	MaxLng     float64        `json:"max_lng" gorm:"index:idx_layer_feature_bbox"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// Permit is a registered building permit for a parcel
+type Permit struct {
+	ID            uint           `json:"id" gorm:"primaryKey"`
+	Number        string         `json:"number" gorm:"uniqueIndex"`
+	PropertyID    uint           `json:"property_id" gorm:"index"`
+	Footprint     datatypes.JSON `json:"footprint" gorm:"type:jsonb"`    // optional GeoJSON polygon of the permitted footprint
+	FootprintArea float64        `json:"footprint_area"`                 // permitted built area in m²
+	MinLat        *float64       `json:"-" gorm:"index:idx_permit_bbox"` // bounding box of the footprint
+	MinLng        *float64       `json:"-" gorm:"index:idx_permit_bbox"`
+	MaxLat        *float64       `json:"-" gorm:"index:idx_permit_bbox"`
+	MaxLng        *float64       `json:"-" gorm:"index:idx_permit_bbox"`
+	Floors        int            `json:"floors"`
+	ValidFrom     time.Time      `json:"valid_from"`
+	ValidUntil    time.Time      `json:"valid_until"`
+	Status        string         `json:"status" gorm:"default:'active'"` // active, revoked
+	Attachments   BlobList       `json:"attachments" gorm:"type:jsonb"`  // stored files, encoded as URLs
+	CreatedAt     time.Time      `json:"created_at"`
+	UpdatedAt     time.Time      `json:"updated_at"`
+}
+
+// ActiveAt reports whether the permit authorises building at time t. The
+// permit holds through the whole of its last valid day.
+func (p Permit) ActiveAt(t time.Time) bool {
+	y, m, d := p.ValidUntil.Date()
+	end := time.Date(y, m, d+1, 0, 0, 0, 0, p.ValidUntil.Location())
+	return p.Status != "revoked" && !t.Before(p.ValidFrom) && t.Before(end)
+}
@@ -1,14 +1,23 @@
 package models
 
-import "time"
+import (
+    "time"
+
+    "gorm.io/datatypes"
+)
 
 // Property holds property record details
 type Property struct {
-    ID        uint      `json:"id" gorm:"primaryKey"`
-    OwnerName string    `json:"owner_name"`
-    Address   string    `json:"address"`
-    Area      float64   `json:"area"`
-    LandUse   string    `json:"land_use"` // "residential", "commercial", etc.
-    CreatedAt time.Time `json:"created_at"`
-    UpdatedAt time.Time `json:"updated_at"`
+    ID        uint           `json:"id" gorm:"primaryKey"`
+    OwnerName string         `json:"owner_name"`
+    Address   string         `json:"address"`
+    Area      float64        `json:"area"`
+    LandUse   string         `json:"land_use"` // "residential", "commercial", etc.
+    Boundary  datatypes.JSON `json:"boundary" gorm:"type:jsonb"` // optional GeoJSON parcel polygon
+    MinLat    *float64       `json:"-" gorm:"index:idx_property_bbox"`
+    MinLng    *float64       `json:"-" gorm:"index:idx_property_bbox"`
+    MaxLat    *float64       `json:"-" gorm:"index:idx_property_bbox"`
+    MaxLng    *float64       `json:"-" gorm:"index:idx_property_bbox"`
+    CreatedAt time.Time      `json:"created_at"`
+    UpdatedAt time.Time      `json:"updated_at"`
 }
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"gorm.io/datatypes"
@@ -8,13 +9,35 @@ import (
 
 // Report represents a citizen report submitted from the frontend
 type Report struct {
-	ID          uint           `json:"id" gorm:"primaryKey"`
-	Location    string         `json:"location"`
-	Description string         `json:"description"`
-	Priority    string         `json:"priority"`                        // low, medium, high
-	Coordinates datatypes.JSON `json:"coordinates" gorm:"type:jsonb"`   // optional { lat, lng }
-	Images      datatypes.JSON `json:"images" gorm:"type:jsonb"`        // array of image URLs
-	Status      string         `json:"status" gorm:"default:'pending'"` // pending, approved, rejected
-	CreatedAt   time.Time      `json:"created_at"`
-	UpdatedAt   time.Time      `json:"updated_at"`
+	ID                  uint           `json:"id" gorm:"primaryKey"`
+	Location            string         `json:"location"`
+	Street              string         `json:"street"`            // normalised from the gazetteer
+	Locality            string         `json:"locality"`          // normalised from the gazetteer
+	Geocoded            bool           `json:"geocoded"`          // coordinates were derived from the address
+	PhotoLocated        bool           `json:"photo_located"`     // coordinates were taken from a photo's GPS
+	PhotoDistance       *float64       `json:"photo_distance"`    // metres from the claimed coordinates to the farthest photo GPS
+	LocationMismatch    bool           `json:"location_mismatch"` // a photo was taken far from the claimed coordinates
+	Description         string         `json:"description"`
+	Priority            string         `json:"priority"`                        // low, medium, high
+	Coordinates         datatypes.JSON `json:"coordinates" gorm:"type:jsonb"`   // optional { lat, lng }
+	Images              EvidenceImages `json:"images" gorm:"type:jsonb"`        // uploaded images with their renditions
+	Videos              EvidenceVideos `json:"videos" gorm:"type:jsonb"`        // uploaded videos; their keyframes are added to the images
+	Status              string         `json:"status" gorm:"default:'pending'"` // pending, approved, rejected
+	WardID              *uint          `json:"ward_id"`                         // ward layer feature containing the coordinates
+	EscalatedAt         *time.Time     `json:"escalated_at"`                    // set when the report breached its review SLA
+	PossibleDuplicateOf []uint         `json:"possible_duplicate_of" gorm:"-"`  // earlier reports with a matching photo, not dismissed
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+}
+
+// LatLng decodes the optional coordinates field
+func (r Report) LatLng() (float64, float64, bool) {
+	var c struct {
+		Lat *float64 `json:"lat"`
+		Lng *float64 `json:"lng"`
+	}
+	if len(r.Coordinates) == 0 || json.Unmarshal(r.Coordinates, &c) != nil || c.Lat == nil || c.Lng == nil {
+		return 0, 0, false
+	}
+	return *c.Lat, *c.Lng, true
 }
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/datatypes"
+)
+
+// Scene is a georeferenced satellite or aerial image ingested from the
+// watched scenes directory
+type Scene struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	Path          string    `json:"path" gorm:"uniqueIndex"`
+	CapturedAt    time.Time `json:"captured_at" gorm:"index"`
+	Width         int       `json:"width"`
+	Height        int       `json:"height"`
+	MinLat        float64   `json:"min_lat"`
+	MinLng        float64   `json:"min_lng"`
+	MaxLat        float64   `json:"max_lat"`
+	MaxLng        float64   `json:"max_lng"`
+	Status        string    `json:"status"` // processing, done, failed
+	Error         string    `json:"error,omitempty"`
+	TileCount     int       `json:"tile_count"`
+	ComparedTiles int       `json:"compared_tiles"`
+	Detections    int       `json:"detections"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// SceneTile is one grid cell cut from a scene; tiles of different scenes
+// with the same GX, GY cover the same ground
+type SceneTile struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	SceneID    uint      `json:"scene_id" gorm:"index"`
+	GX         int       `json:"gx" gorm:"column:gx;index:idx_scene_tile_cell"`
+	GY         int       `json:"gy" gorm:"column:gy;index:idx_scene_tile_cell"`
+	CapturedAt time.Time `json:"captured_at"`
+	ImageURL   BlobRef   `json:"image_url"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Detection is a candidate construction found automatically, waiting for an
+// officer to confirm or dismiss it
+type Detection struct {
+	ID               uint           `json:"id" gorm:"primaryKey"`
+	Source           string         `json:"source"` // detection source given to confirmed constructions
+	SceneID          *uint          `json:"scene_id" gorm:"index"`
+	TileID           *uint          `json:"tile_id"`
+	Label            string         `json:"label"`                       // class reported by a detection model
+	Footprint        datatypes.JSON `json:"footprint" gorm:"type:jsonb"` // GeoJSON polygon, for model detections
+	Latitude         float64        `json:"latitude" gorm:"index:idx_detection_position"`
+	Longitude        float64        `json:"longitude" gorm:"index:idx_detection_position"`
+	Confidence       float64        `json:"confidence"`        // 0-1
+	ChangedArea      float64        `json:"changed_area"`      // changed ground area, or footprint area for model detections, in m²
+	Score            *float64       `json:"score"`             // SSIM against the previous capture, for change detections
+	AlignmentQuality *float64       `json:"alignment_quality"` // co-registration quality of that comparison
+	HeatmapURL       BlobRef        `json:"heatmap_url"`
+	Status           string         `json:"status" gorm:"default:'candidate';index"` // candidate, confirmed, dismissed
+	ConstructionID   *uint          `json:"construction_id"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+}
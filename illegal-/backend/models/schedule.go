@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// ScheduledTask is the shared state of a recurring task, so pausing it
+// applies to every replica
+type ScheduledTask struct {
+	Name      string     `json:"name" gorm:"primaryKey"`
+	Paused    bool       `json:"paused"`
+	PausedBy  *uint      `json:"paused_by"`
+	LastRunAt *time.Time `json:"last_run_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// TaskRun records one run of a scheduled task
+type TaskRun struct {
+	ID           uint       `json:"id" gorm:"primaryKey"`
+	Task         string     `json:"task" gorm:"index;uniqueIndex:idx_task_run_tick"`
+	ScheduledFor *time.Time `json:"scheduled_for" gorm:"uniqueIndex:idx_task_run_tick"` // cron tick; null for manual runs
+	Trigger      string     `json:"trigger"`                                            // schedule, manual
+	Status       string     `json:"status"`                                             // running, succeeded, failed
+	Error        string     `json:"error"`
+	Node         string     `json:"node"` // replica that ran it
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// StatusOverride records an officer overriding the rules-engine status of a construction
+type StatusOverride struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	ConstructionID uint      `json:"construction_id" gorm:"index"`
+	OfficerID      uint      `json:"officer_id"`
+	PreviousStatus string    `json:"previous_status"`
+	Status         string    `json:"status"` // "legal", "illegal", or "" when the override was cleared
+	Justification  string    `json:"justification"`
+	CreatedAt      time.Time `json:"created_at"`
+}
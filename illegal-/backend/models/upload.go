@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// Upload is a resumable upload session. Chunks are stored as they arrive and
+// joined into one file once the declared length has been received.
+type Upload struct {
+	ID        string    `json:"id" gorm:"primaryKey"` // random; knowing it allows writing to the upload
+	Filename  string    `json:"filename"`
+	MIME      string    `json:"mime"` // as declared by the client
+	Length    int64     `json:"length"`
+	Offset    int64     `json:"offset"` // bytes received so far
+	Parts     BlobList  `json:"-" gorm:"type:jsonb"`
+	Key       string    `json:"-"`                   // joined file, once complete
+	Status    string    `json:"status" gorm:"index"` // uploading, complete
+	CreatedBy *uint     `json:"created_by"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
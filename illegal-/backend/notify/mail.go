@@ -0,0 +1,83 @@
+// Package notify delivers email notifications through the job queue, so
+// SMTP latency and outages never reach request handlers or scheduled tasks
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"backend/jobs"
+	"backend/models"
+)
+
+// SMTP settings, read from the environment. With no SMTP_ADDR, email is
+// logged instead of sent.
+var (
+	SMTPAddr     = os.Getenv("SMTP_ADDR") // host:port
+	SMTPUser     = os.Getenv("SMTP_USER")
+	SMTPPassword = os.Getenv("SMTP_PASSWORD")
+	From         = envOr("SMTP_FROM", "sky@localhost")
+)
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// Email is a plain-text message
+type Email struct {
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	Body    string   `json:"body"`
+}
+
+// JobEmail sends one Email
+const JobEmail = "notify.email"
+
+func init() {
+	jobs.Register(JobEmail, func(ctx context.Context, job *models.Job) error {
+		var e Email
+		if err := jobs.Decode(job, &e); err != nil {
+			return err
+		}
+		return Send(e)
+	})
+}
+
+// QueueEmail queues a message for delivery
+func QueueEmail(e Email) (*models.Job, error) {
+	if len(e.To) == 0 {
+		return nil, fmt.Errorf("email has no recipients")
+	}
+	return jobs.Enqueue(JobEmail, e)
+}
+
+// Send delivers a message now. Use QueueEmail outside of jobs.
+func Send(e Email) error {
+	if SMTPAddr == "" {
+		log.Printf("notify: SMTP_ADDR not set; not sending %q to %s", e.Subject, strings.Join(e.To, ", "))
+		return nil
+	}
+	var auth smtp.Auth
+	if SMTPUser != "" {
+		host, _, _ := net.SplitHostPort(SMTPAddr)
+		auth = smtp.PlainAuth("", SMTPUser, SMTPPassword, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", e.Subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(e.Body, "\n", "\r\n"))
+	return smtp.SendMail(SMTPAddr, auth, From, e.To, []byte(msg.String()))
+}
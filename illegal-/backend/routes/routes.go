@@ -14,35 +14,121 @@ func RegisterRoutes(router *mux.Router) {
 
     // Reports routes (matching frontend expectations)
     router.HandleFunc("/reports", controllers.GetReports).Methods("GET")
+    router.HandleFunc("/reports/duplicates", controllers.GetReportDuplicates).Methods("GET")
+    router.HandleFunc("/reports/duplicates/{id}", controllers.ReviewReportDuplicate).Methods("PATCH")
     router.HandleFunc("/reports/{id}", controllers.GetReport).Methods("GET")
     router.HandleFunc("/reports", controllers.CreateReport).Methods("POST")
     router.HandleFunc("/reports/{id}/status", controllers.UpdateReportStatus).Methods("PATCH")
+    router.HandleFunc("/reports/{id}/images/{index}/original", controllers.GetReportImageOriginal).Methods("GET")
+    router.HandleFunc("/reports/{id}/videos/{index}/url", controllers.GetReportVideoURL).Methods("GET")
     router.HandleFunc("/reports/{id}", controllers.DeleteReport).Methods("DELETE")
 
+    // Resumable upload routes (tus 1.0)
+    router.HandleFunc("/uploads", controllers.CreateUpload).Methods("POST")
+    router.HandleFunc("/uploads/{id}", controllers.HeadUpload).Methods("HEAD")
+    router.HandleFunc("/uploads/{id}", controllers.GetUpload).Methods("GET")
+    router.HandleFunc("/uploads/{id}", controllers.PatchUpload).Methods("PATCH")
+    router.HandleFunc("/uploads/{id}", controllers.DeleteUpload).Methods("DELETE")
+    router.HandleFunc("/uploads/{id}/finalize", controllers.FinalizeUpload).Methods("POST")
+
     // Encroachments routes
     router.HandleFunc("/encroachments", controllers.GetEncroachments).Methods("GET")
     router.HandleFunc("/encroachments/{id}", controllers.GetEncroachment).Methods("GET")
     router.HandleFunc("/encroachments/{id}/status", controllers.UpdateEncroachmentStatus).Methods("PATCH")
     router.HandleFunc("/encroachments/area", controllers.GetEncroachmentsByArea).Methods("GET")
 
-    // Alerts routes
+    // Change detection routes
+    router.HandleFunc("/change/compare", controllers.CompareImages).Methods("POST")
+
+    // Imagery capture routes (before/after pairs and timelines)
+    router.HandleFunc("/imagery", controllers.CreateCapture).Methods("POST")
+    router.HandleFunc("/imagery/{id}", controllers.GetCapture).Methods("GET")
+    router.HandleFunc("/imagery/{id}", controllers.DeleteCapture).Methods("DELETE")
+    router.HandleFunc("/constructions/{id}/imagery", controllers.GetConstructionImagery).Methods("GET")
+    router.HandleFunc("/areas", controllers.GetAreas).Methods("GET")
+    router.HandleFunc("/areas", controllers.CreateArea).Methods("POST")
+    router.HandleFunc("/areas/{id}/imagery", controllers.GetAreaImagery).Methods("GET")
+
+    // Satellite scene ingestion and detection review routes
+    router.HandleFunc("/scenes", controllers.GetScenes).Methods("GET")
+    router.HandleFunc("/scenes/scan", controllers.ScanScenes).Methods("POST")
+    router.HandleFunc("/scenes/{id}", controllers.GetScene).Methods("GET")
+    router.HandleFunc("/detections", controllers.GetDetections).Methods("GET")
+    router.HandleFunc("/detections/run", controllers.RunDetectors).Methods("POST")
+    router.HandleFunc("/detectors", controllers.GetDetectors).Methods("GET")
+    router.HandleFunc("/detections/{id}/confirm", controllers.ConfirmDetection).Methods("POST")
+    router.HandleFunc("/detections/{id}/dismiss", controllers.DismissDetection).Methods("POST")
+
+    // Background job routes
+    router.HandleFunc("/jobs", controllers.GetJobs).Methods("GET")
+    router.HandleFunc("/jobs/stats", controllers.GetJobStats).Methods("GET")
+    router.HandleFunc("/jobs/{id}", controllers.GetJob).Methods("GET")
+    router.HandleFunc("/jobs/{id}/requeue", controllers.RequeueJob).Methods("POST")
+
+    // Scheduled task admin routes
+    router.HandleFunc("/schedule/tasks", controllers.GetScheduledTasks).Methods("GET")
+    router.HandleFunc("/schedule/tasks/{name}", controllers.GetScheduledTask).Methods("GET")
+    router.HandleFunc("/schedule/tasks/{name}/pause", controllers.PauseScheduledTask).Methods("POST")
+    router.HandleFunc("/schedule/tasks/{name}/resume", controllers.ResumeScheduledTask).Methods("POST")
+    router.HandleFunc("/schedule/tasks/{name}/run", controllers.RunScheduledTask).Methods("POST")
+
+    // Alerts routes; all of them, listing and the unread count included,
+    // need an officer or admin token
     router.HandleFunc("/alerts", controllers.GetAlerts).Methods("GET")
+    router.HandleFunc("/alerts", controllers.CreateAlert).Methods("POST")
+    router.HandleFunc("/alerts/rules", controllers.GetAlertRules).Methods("GET")
+    router.HandleFunc("/alerts/rules", controllers.CreateAlertRule).Methods("POST")
+    router.HandleFunc("/alerts/rules/{id}", controllers.UpdateAlertRule).Methods("PUT")
+    router.HandleFunc("/alerts/rules/{id}", controllers.DeleteAlertRule).Methods("DELETE")
+    router.HandleFunc("/alerts/{id}/status", controllers.UpdateAlertStatus).Methods("PATCH")
+    router.HandleFunc("/alerts/{id}/acknowledge", controllers.AcknowledgeAlert).Methods("PATCH")
+    router.HandleFunc("/alerts/{id}/snooze", controllers.SnoozeAlert).Methods("PATCH")
+    router.HandleFunc("/alerts/{id}/assign", controllers.AssignAlert).Methods("PATCH")
     router.HandleFunc("/alerts/{id}/read", controllers.MarkAlertRead).Methods("PATCH")
     router.HandleFunc("/alerts/read-all", controllers.MarkAllAlertsRead).Methods("PATCH")
     router.HandleFunc("/alerts/unread-count", controllers.GetUnreadAlertsCount).Methods("GET")
 
+    // Map vector tiles
+    router.HandleFunc("/tiles/{layer}/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.mvt", controllers.GetTile).Methods("GET")
+    router.HandleFunc("/map/clusters", controllers.GetMapClusters).Methods("GET")
+
     // Analytics routes
     router.HandleFunc("/analytics/dashboard", controllers.GetDashboardStats).Methods("GET")
     router.HandleFunc("/analytics/reports/timeline", controllers.GetReportsOverTime).Methods("GET")
     router.HandleFunc("/analytics/encroachments/regions", controllers.GetEncroachmentsByRegion).Methods("GET")
+    router.HandleFunc("/analytics/heatmap", controllers.GetHeatmap).Methods("GET")
+    router.HandleFunc("/analytics/hotspots", controllers.GetHotspots).Methods("GET")
+    router.HandleFunc("/analytics/daily", controllers.GetDailyStats).Methods("GET")
+
+    // Boundary layer routes (wards, zones, river buffers, heritage zones)
+    router.HandleFunc("/layers", controllers.GetLayers).Methods("GET")
+    router.HandleFunc("/layers", controllers.ImportLayer).Methods("POST")
+    router.HandleFunc("/layers/assign-wards", controllers.AssignWards).Methods("POST")
+    router.HandleFunc("/layers/{id}/features", controllers.GetLayerFeatures).Methods("GET")
+    router.HandleFunc("/layers/{id}", controllers.DeleteLayer).Methods("DELETE")
+
+    // Gazetteer (address points) and geocoding routes
+    router.HandleFunc("/gazetteer", controllers.GetGazetteerSources).Methods("GET")
+    router.HandleFunc("/gazetteer", controllers.ImportGazetteer).Methods("POST")
+    router.HandleFunc("/gazetteer/{source}", controllers.DeleteGazetteerSource).Methods("DELETE")
+    router.HandleFunc("/geocode/reverse", controllers.ReverseGeocode).Methods("GET")
+    router.HandleFunc("/geocode/forward", controllers.ForwardGeocode).Methods("GET")
+    router.HandleFunc("/geocode/backfill", controllers.BackfillGeocoding).Methods("POST")
 
     // Construction routes (existing)
     router.HandleFunc("/constructions", controllers.GetConstructions).Methods("GET")
+    router.HandleFunc("/constructions/evaluate", controllers.EvaluateAllConstructions).Methods("POST")
     router.HandleFunc("/constructions/{id}", controllers.GetConstruction).Methods("GET")
     router.HandleFunc("/constructions", controllers.CreateConstruction).Methods("POST")
     router.HandleFunc("/constructions/{id}", controllers.UpdateConstruction).Methods("PUT")
     router.HandleFunc("/constructions/{id}", controllers.DeleteConstruction).Methods("DELETE")
 
+    // Zoning evaluation and officer overrides
+    router.HandleFunc("/constructions/{id}/evaluate", controllers.EvaluateConstruction).Methods("POST")
+    router.HandleFunc("/constructions/{id}/override", controllers.OverrideConstructionStatus).Methods("POST")
+    router.HandleFunc("/constructions/{id}/override", controllers.ClearConstructionOverride).Methods("DELETE")
+    router.HandleFunc("/constructions/{id}/overrides", controllers.GetConstructionOverrides).Methods("GET")
+
     // User routes (existing)
     router.HandleFunc("/users", controllers.GetUsers).Methods("GET")
     router.HandleFunc("/users", controllers.CreateUser).Methods("POST")
@@ -51,6 +137,15 @@ func RegisterRoutes(router *mux.Router) {
     router.HandleFunc("/complaints", controllers.GetComplaints).Methods("GET")
     router.HandleFunc("/complaints", controllers.CreateComplaint).Methods("POST")
 
+    // Building permit routes
+    router.HandleFunc("/permits", controllers.GetPermits).Methods("GET")
+    router.HandleFunc("/permits", controllers.CreatePermit).Methods("POST")
+    router.HandleFunc("/permits/import", controllers.ImportPermitsCSV).Methods("POST")
+    router.HandleFunc("/permits/{id}", controllers.GetPermit).Methods("GET")
+    router.HandleFunc("/permits/{id}", controllers.UpdatePermit).Methods("PUT")
+    router.HandleFunc("/permits/{id}", controllers.DeletePermit).Methods("DELETE")
+    router.HandleFunc("/permits/{id}/attachments", controllers.UploadPermitAttachments).Methods("POST")
+
     // Property routes (existing)
     router.HandleFunc("/properties", controllers.GetProperties).Methods("GET")
     router.HandleFunc("/properties", controllers.CreateProperty).Methods("POST")
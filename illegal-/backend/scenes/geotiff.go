@@ -0,0 +1,339 @@
+package scenes
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/image/tiff"
+)
+
+// TIFF and GeoTIFF tags read from the first image directory
+const (
+	tagDateTime            = 306
+	tagModelPixelScale     = 33550
+	tagModelTiepoint       = 33922
+	tagModelTransformation = 34264
+	tagGeoKeyDirectory     = 34735
+)
+
+// GeoKeys and the values we support
+const (
+	geoKeyModelType       = 1024
+	geoKeyGeographicType  = 2048
+	geoKeyProjectedCSType = 3072
+	modelTypeProjected    = 1
+	modelTypeGeographic   = 2
+	epsgWGS84             = 4326
+	epsgWebMercator       = 3857
+	epsgWebMercatorLegacy = 900913
+)
+
+// TIFF field types
+const (
+	typeASCII  = 2
+	typeShort  = 3
+	typeLong   = 4
+	typeDouble = 12
+)
+
+const (
+	earthRadius        = 6378137.0 // EPSG:3857 sphere
+	maxMercatorLat     = 85.05112878
+	maxTagValues       = 1 << 16
+	tiffDateTimeLayout = "2006:01:02 15:04:05"
+)
+
+// GeoTIFF is an open scene with its pixel-to-map transform. Image reads
+// pixels from the file as they are needed; Close releases it.
+type GeoTIFF struct {
+	Image      image.Image
+	CapturedAt time.Time // from the TIFF DateTime tag, zero when absent
+	file       *os.File
+
+	// affine transform from pixel (col, row) to map (x, y):
+	// x = a*col + b*row + c, y = d*col + e*row + f
+	a, b, c, d, e, f float64
+	mercator         bool // map units are EPSG:3857 metres rather than degrees
+}
+
+// ReadGeoTIFF opens a GeoTIFF in EPSG:4326 or EPSG:3857. Strips and tiles
+// of 8-bit grey or RGB scenes are decoded as they are read; scenes in other
+// layouts are decoded whole, which is refused above maxDecodePixels.
+func ReadGeoTIFF(path string) (*GeoTIFF, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	g, err := readGeoTIFF(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return g, nil
+}
+
+func readGeoTIFF(file *os.File) (*GeoTIFF, error) {
+	tags, err := readTags(file)
+	if err != nil {
+		return nil, err
+	}
+	g := &GeoTIFF{file: file}
+	if err := g.setTransform(tags); err != nil {
+		return nil, err
+	}
+	if err := g.setProjection(tags); err != nil {
+		return nil, err
+	}
+	if s := strings.TrimRight(string(tags.ascii[tagDateTime]), "\x00 "); s != "" {
+		g.CapturedAt, _ = time.Parse(tiffDateTimeLayout, s)
+	}
+
+	rs, err := newRaster(file, tags)
+	if err == nil {
+		g.Image = rs
+		return g, nil
+	}
+	if !errors.Is(err, errLayout) {
+		return nil, err
+	}
+	if float64(tags.int(tagImageWidth, 0))*float64(tags.int(tagImageLength, 0)) > maxDecodePixels {
+		return nil, fmt.Errorf("%v; convert the scene to 8-bit grey or RGB", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if g.Image, err = tiff.Decode(file); err != nil {
+		return nil, fmt.Errorf("decoding raster: %v", err)
+	}
+	return g, nil
+}
+
+// Err returns the first error met reading pixels, which read as empty
+func (g *GeoTIFF) Err() error {
+	if rs, ok := g.Image.(*raster); ok {
+		return rs.err
+	}
+	return nil
+}
+
+// Close closes the scene file
+func (g *GeoTIFF) Close() error {
+	return g.file.Close()
+}
+
+func (g *GeoTIFF) setTransform(tags tiffTags) error {
+	if m := tags.doubles[tagModelTransformation]; len(m) >= 16 {
+		g.a, g.b, g.c = m[0], m[1], m[3]
+		g.d, g.e, g.f = m[4], m[5], m[7]
+		return nil
+	}
+	scale, tie := tags.doubles[tagModelPixelScale], tags.doubles[tagModelTiepoint]
+	if len(scale) < 2 || len(tie) < 6 || scale[0] == 0 || scale[1] == 0 {
+		return errors.New("file is not georeferenced (missing GeoTIFF pixel scale and tie point)")
+	}
+	// the tie point maps raster (i, j) to model (x, y); rows run southwards
+	g.a, g.b, g.c = scale[0], 0, tie[3]-tie[0]*scale[0]
+	g.d, g.e, g.f = 0, -scale[1], tie[4]+tie[1]*scale[1]
+	return nil
+}
+
+func (g *GeoTIFF) setProjection(tags tiffTags) error {
+	keys := geoKeys(tags.shorts[tagGeoKeyDirectory])
+	switch keys[geoKeyModelType] {
+	case modelTypeGeographic, 0:
+		if gcs := keys[geoKeyGeographicType]; gcs != 0 && gcs != epsgWGS84 {
+			return fmt.Errorf("unsupported geographic CRS EPSG:%d, reproject to EPSG:4326", gcs)
+		}
+	case modelTypeProjected:
+		pcs := keys[geoKeyProjectedCSType]
+		if pcs != epsgWebMercator && pcs != epsgWebMercatorLegacy {
+			return fmt.Errorf("unsupported projected CRS EPSG:%d, reproject to EPSG:4326 or EPSG:3857", pcs)
+		}
+		g.mercator = true
+	default:
+		return errors.New("unsupported GeoTIFF model type")
+	}
+	return nil
+}
+
+// LatLng returns the position of a pixel corner
+func (g *GeoTIFF) LatLng(col, row float64) (float64, float64) {
+	x := g.a*col + g.b*row + g.c
+	y := g.d*col + g.e*row + g.f
+	if !g.mercator {
+		return y, x
+	}
+	lng := x / earthRadius * 180 / math.Pi
+	lat := (2*math.Atan(math.Exp(y/earthRadius)) - math.Pi/2) * 180 / math.Pi
+	return lat, lng
+}
+
+// Pixel returns the fractional pixel position of a point
+func (g *GeoTIFF) Pixel(lat, lng float64) (float64, float64) {
+	x, y := lng, lat
+	if g.mercator {
+		lat = math.Max(-maxMercatorLat, math.Min(maxMercatorLat, lat))
+		x = lng * math.Pi / 180 * earthRadius
+		y = math.Log(math.Tan(math.Pi/4+lat*math.Pi/360)) * earthRadius
+	}
+	det := g.a*g.e - g.b*g.d
+	x, y = x-g.c, y-g.f
+	return (g.e*x - g.b*y) / det, (g.a*y - g.d*x) / det
+}
+
+// tiffTags holds the tag values ReadGeoTIFF needs, by type. SHORT and LONG
+// values are in ints as well as shorts, as writers use either for most tags.
+type tiffTags struct {
+	ascii   map[uint16][]byte
+	shorts  map[uint16][]uint16
+	ints    map[uint16][]uint32
+	doubles map[uint16][]float64
+}
+
+// int returns the first value of an integer tag, or def when it is absent
+func (t tiffTags) int(tag uint16, def int) int {
+	if v := t.ints[tag]; len(v) > 0 {
+		return int(v[0])
+	}
+	return def
+}
+
+// readTagSet lists the tags readTags keeps
+var readTagSet = map[uint16]bool{
+	tagImageWidth: true, tagImageLength: true, tagBitsPerSample: true, tagCompression: true,
+	tagPhotometric: true, tagStripOffsets: true, tagSamplesPerPixel: true, tagRowsPerStrip: true,
+	tagStripByteCounts: true, tagPlanarConfig: true, tagPredictor: true, tagTileWidth: true,
+	tagTileLength: true, tagTileOffsets: true, tagTileByteCounts: true, tagExtraSamples: true,
+	tagSampleFormat: true, tagDateTime: true, tagModelPixelScale: true, tagModelTiepoint: true,
+	tagModelTransformation: true, tagGeoKeyDirectory: true,
+}
+
+// readTags parses the first image file directory of a classic TIFF
+func readTags(r io.ReadSeeker) (tiffTags, error) {
+	tags := tiffTags{ascii: map[uint16][]byte{}, shorts: map[uint16][]uint16{}, ints: map[uint16][]uint32{}, doubles: map[uint16][]float64{}}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return tags, errors.New("not a TIFF file")
+	}
+	var order binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return tags, errors.New("not a TIFF file")
+	}
+	switch order.Uint16(header[2:4]) {
+	case 42:
+	case 43:
+		return tags, errors.New("BigTIFF files are not supported")
+	default:
+		return tags, errors.New("not a TIFF file")
+	}
+
+	if _, err := r.Seek(int64(order.Uint32(header[4:8])), io.SeekStart); err != nil {
+		return tags, err
+	}
+	var count uint16
+	if err := binary.Read(r, order, &count); err != nil {
+		return tags, err
+	}
+	entries := make([]byte, int(count)*12)
+	if _, err := io.ReadFull(r, entries); err != nil {
+		return tags, errors.New("truncated TIFF directory")
+	}
+
+	for i := 0; i < int(count); i++ {
+		e := entries[i*12 : (i+1)*12]
+		tag, typ, n := order.Uint16(e[0:2]), order.Uint16(e[2:4]), order.Uint32(e[4:8])
+		if !readTagSet[tag] {
+			continue
+		}
+		limit := uint32(maxTagValues)
+		switch tag {
+		case tagStripOffsets, tagStripByteCounts, tagTileOffsets, tagTileByteCounts:
+			limit = maxBlocks
+		}
+		if n > limit {
+			return tags, errors.New("TIFF tag too large")
+		}
+
+		var size int
+		switch typ {
+		case typeASCII:
+			size = 1
+		case typeShort:
+			size = 2
+		case typeLong:
+			size = 4
+		case typeDouble:
+			size = 8
+		default:
+			continue
+		}
+		raw := make([]byte, size*int(n))
+		if len(raw) <= 4 { // small values are stored in the entry itself
+			copy(raw, e[8:])
+		} else {
+			if _, err := r.Seek(int64(order.Uint32(e[8:12])), io.SeekStart); err != nil {
+				return tags, err
+			}
+			if _, err := io.ReadFull(r, raw); err != nil {
+				return tags, errors.New("truncated TIFF tag")
+			}
+		}
+
+		switch typ {
+		case typeASCII:
+			tags.ascii[tag] = raw
+		case typeShort:
+			v, w := make([]uint16, n), make([]uint32, n)
+			for j := range v {
+				v[j] = order.Uint16(raw[j*2:])
+				w[j] = uint32(v[j])
+			}
+			tags.shorts[tag], tags.ints[tag] = v, w
+		case typeLong:
+			// GeoKey directories are SHORT by spec, but accept LONG writers
+			v, w := make([]uint16, n), make([]uint32, n)
+			for j := range v {
+				w[j] = order.Uint32(raw[j*4:])
+				v[j] = uint16(w[j])
+			}
+			tags.shorts[tag], tags.ints[tag] = v, w
+		case typeDouble:
+			v := make([]float64, n)
+			for j := range v {
+				v[j] = math.Float64frombits(order.Uint64(raw[j*8:]))
+			}
+			tags.doubles[tag] = v
+		}
+	}
+	return tags, nil
+}
+
+// geoKeys decodes the SHORT-valued keys of a GeoKeyDirectory
+func geoKeys(dir []uint16) map[uint16]int {
+	keys := map[uint16]int{}
+	if len(dir) < 4 {
+		return keys
+	}
+	n := int(dir[3])
+	for i := 0; i < n && 4+i*4+3 < len(dir); i++ {
+		k := dir[4+i*4:]
+		// location 0 means the value is stored inline
+		if k[1] == 0 {
+			keys[k[0]] = int(k[3])
+		}
+	}
+	return keys
+}
@@ -0,0 +1,399 @@
+// Package scenes ingests georeferenced satellite scenes from a watched
+// directory, cuts them into grid tiles and compares every tile with the same
+// tile of the previous scene, recording candidate detections where the
+// ground has changed
+package scenes
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/alerts"
+	"backend/change"
+	"backend/detect"
+	"backend/geo"
+	"backend/imagery"
+	"backend/jobs"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/paulmach/orb"
+)
+
+// Settings, adjustable before the first scan
+var (
+	WatchDir        = "scenes" // directory polled for new .tif/.tiff files
+	TileDegrees     = 0.0025   // grid cell size, roughly 275 m at the equator
+	TileSize        = 512      // pixels per tile side
+	ScoreThreshold  = 0.7      // tiles with an SSIM below this are flagged
+	MinChangedRatio = 0.02     // ... if at least this share of the tile changed
+	MaxNoData       = 0.5      // tiles with more empty pixels than this are skipped
+)
+
+// settleTime is how long a file must be unmodified before it is ingested,
+// so scenes still being copied in are left alone
+const settleTime = 30 * time.Second
+
+// staleAfter is how long a scene may go without progress before an ingest
+// left in "processing", e.g. by a crash, is taken over by the next scan
+const staleAfter = 15 * time.Minute
+
+// DetectionSource marks detections and constructions found by scene
+// differencing
+const DetectionSource = "satellite"
+
+// scanMu keeps scheduled and manual scans from ingesting the same file
+var scanMu sync.Mutex
+
+// JobScan runs Scan over WatchDir in the background
+const JobScan = "scenes.scan"
+
+func init() {
+	jobs.Register(JobScan, func(ctx context.Context, job *models.Job) error {
+		n, err := Scan(WatchDir)
+		if n > 0 {
+			log.Printf("scenes: ingested %d scene(s)", n)
+		}
+		return err
+	})
+}
+
+// Scan ingests the GeoTIFFs in dir that have not been seen before and are
+// no longer being written, oldest first, and resumes stale ingests. It
+// returns the number ingested successfully; files that fail are recorded as
+// failed scenes and not retried.
+func Scan(dir string) (int, error) {
+	scanMu.Lock()
+	defer scanMu.Unlock()
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	type pending struct {
+		path string
+		date time.Time
+	}
+	var files []pending
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || (ext != ".tif" && ext != ".tiff") {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < settleTime {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		var scene models.Scene
+		if err := utils.DB.Where("path = ?", path).Limit(1).Find(&scene).Error; err != nil {
+			return 0, err
+		}
+		if scene.ID == 0 || stale(&scene) {
+			files = append(files, pending{path, dateFromName(e.Name(), info.ModTime())})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].date.Before(files[j].date) })
+
+	n := 0
+	for _, f := range files {
+		if _, err := Ingest(f.path); err != nil {
+			log.Printf("scenes: %s: %v", f.path, err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+// stale reports whether an ingest of scene has stopped making progress
+func stale(scene *models.Scene) bool {
+	return scene.Status == "processing" && time.Since(scene.UpdatedAt) > staleAfter
+}
+
+var nameDate = regexp.MustCompile(`(\d{4})-?(\d{2})-?(\d{2})`)
+
+// dateFromName reads a YYYYMMDD or YYYY-MM-DD date from a file name
+func dateFromName(name string, fallback time.Time) time.Time {
+	if m := nameDate.FindStringSubmatch(name); m != nil {
+		if t, err := time.Parse("20060102", m[1]+m[2]+m[3]); err == nil {
+			return t
+		}
+	}
+	return fallback
+}
+
+// Ingest reads one GeoTIFF and runs change detection over its tiles. The
+// scene row records the outcome even when ingestion fails; a stale ingest
+// of the same file is resumed from the cells it had not stored yet.
+func Ingest(path string) (*models.Scene, error) {
+	scene, err := claim(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := ingest(scene); err != nil {
+		scene.Status, scene.Error = "failed", err.Error()
+		utils.DB.Save(scene)
+		return scene, err
+	}
+	scene.Status = "done"
+	return scene, utils.DB.Save(scene).Error
+}
+
+// claim creates the scene row for a new file, or takes over a stale one.
+// The conditional update lets only one scan resume a given scene.
+func claim(path string) (*models.Scene, error) {
+	scene := &models.Scene{}
+	if err := utils.DB.Where("path = ?", path).Limit(1).Find(scene).Error; err != nil {
+		return nil, err
+	}
+	if scene.ID == 0 {
+		scene = &models.Scene{Path: path, Status: "processing"}
+		return scene, utils.DB.Create(scene).Error
+	}
+	if !stale(scene) {
+		return nil, fmt.Errorf("scene %d is already %s", scene.ID, scene.Status)
+	}
+	res := utils.DB.Model(&models.Scene{}).
+		Where("id = ? AND status = ? AND updated_at = ?", scene.ID, "processing", scene.UpdatedAt).
+		Update("updated_at", time.Now())
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, fmt.Errorf("scene %d is being resumed by another scan", scene.ID)
+	}
+	log.Printf("scenes: resuming stale ingest of scene %d", scene.ID)
+	return scene, utils.DB.First(scene, scene.ID).Error
+}
+
+func ingest(scene *models.Scene) error {
+	info, err := os.Stat(scene.Path)
+	if err != nil {
+		return err
+	}
+	g, err := ReadGeoTIFF(scene.Path)
+	if err != nil {
+		return err
+	}
+	defer g.Close()
+
+	// cells stored by an earlier, interrupted ingest are not cut again
+	var stored []models.SceneTile
+	if err := utils.DB.Select("gx, gy").Where("scene_id = ?", scene.ID).Find(&stored).Error; err != nil {
+		return err
+	}
+	done := map[[2]int]bool{}
+	for _, t := range stored {
+		done[[2]int{t.GX, t.GY}] = true
+	}
+	scene.TileCount = len(stored)
+
+	scene.CapturedAt = g.CapturedAt
+	if scene.CapturedAt.IsZero() {
+		scene.CapturedAt = dateFromName(filepath.Base(scene.Path), info.ModTime())
+	}
+	size := g.Image.Bounds().Size()
+	scene.Width, scene.Height = size.X, size.Y
+
+	bound := orb.Bound{Min: orb.Point{math.Inf(1), math.Inf(1)}, Max: orb.Point{math.Inf(-1), math.Inf(-1)}}
+	for _, corner := range [][2]float64{{0, 0}, {float64(size.X), 0}, {0, float64(size.Y)}, {float64(size.X), float64(size.Y)}} {
+		lat, lng := g.LatLng(corner[0], corner[1])
+		bound = bound.Extend(orb.Point{lng, lat})
+	}
+	scene.MinLat, scene.MinLng, scene.MaxLat, scene.MaxLng = bound.Min.Lat(), bound.Min.Lon(), bound.Max.Lat(), bound.Max.Lon()
+	if err := utils.DB.Save(scene).Error; err != nil {
+		return err
+	}
+
+	for gy := int(math.Floor(scene.MinLat / TileDegrees)); float64(gy)*TileDegrees < scene.MaxLat; gy++ {
+		for gx := int(math.Floor(scene.MinLng / TileDegrees)); float64(gx)*TileDegrees < scene.MaxLng; gx++ {
+			if done[[2]int{gx, gy}] {
+				continue
+			}
+			if err := processTile(scene, g, gx, gy); err != nil {
+				return err
+			}
+			if err := g.Err(); err != nil {
+				return fmt.Errorf("reading raster: %v", err)
+			}
+		}
+		// saving after each row of cells records progress, and the new
+		// updated_at tells scans the ingest is still alive
+		if err := utils.DB.Save(scene).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CellBound is the ground covered by grid cell (gx, gy)
+func CellBound(gx, gy int) orb.Bound {
+	return orb.Bound{
+		Min: orb.Point{float64(gx) * TileDegrees, float64(gy) * TileDegrees},
+		Max: orb.Point{float64(gx+1) * TileDegrees, float64(gy+1) * TileDegrees},
+	}
+}
+
+// processTile cuts one cell from the scene, stores it and compares it with
+// the latest earlier tile of the same cell
+func processTile(scene *models.Scene, g *GeoTIFF, gx, gy int) error {
+	cell := CellBound(gx, gy)
+	img, ok := cutTile(g, cell)
+	if !ok {
+		return nil
+	}
+
+	url, err := imagery.SavePNG(img, fmt.Sprintf("scenes/%d", scene.ID))
+	if err != nil {
+		return err
+	}
+	tile := models.SceneTile{SceneID: scene.ID, GX: gx, GY: gy, CapturedAt: scene.CapturedAt, ImageURL: url}
+	if err := utils.DB.Create(&tile).Error; err != nil {
+		return err
+	}
+	scene.TileCount++
+
+	var prev models.SceneTile
+	err = utils.DB.Where("gx = ? AND gy = ? AND scene_id <> ? AND captured_at < ?", gx, gy, scene.ID, scene.CapturedAt).
+		Order("captured_at desc, id desc").Limit(1).Find(&prev).Error
+	if err != nil || prev.ID == 0 {
+		return err
+	}
+	before, err := imagery.Open(string(prev.ImageURL))
+	if err != nil {
+		// a missing earlier tile should not stop the rest of the scene
+		log.Printf("scenes: tile %d: %v", prev.ID, err)
+		return nil
+	}
+	// tiles are already cut on the georeferenced grid; feature alignment
+	// corrects the residual registration error between scenes
+	res, err := change.Compare(before, img, change.Options{Size: TileSize, Align: true})
+	if err != nil {
+		return err
+	}
+	scene.ComparedTiles++
+	if res.Score >= ScoreThreshold || res.ChangedRatio < MinChangedRatio {
+		return nil
+	}
+
+	heatmapURL, err := imagery.SavePNG(res.Heatmap, fmt.Sprintf("scenes/%d", scene.ID))
+	if err != nil {
+		return err
+	}
+	lat, lng := maskCentroid(res, cell)
+	score, quality := res.Score, res.Alignment.Quality
+	detection := models.Detection{
+		Source:           DetectionSource,
+		SceneID:          &scene.ID,
+		TileID:           &tile.ID,
+		Latitude:         lat,
+		Longitude:        lng,
+		Confidence:       math.Max(0, math.Min(1, 1-res.Score)),
+		ChangedArea:      res.ChangedRatio * geo.Area(cell),
+		Score:            &score,
+		AlignmentQuality: &quality,
+		HeatmapURL:       heatmapURL,
+		Status:           "candidate",
+	}
+
+	// an unreviewed candidate in the same cell is refreshed rather than duplicated
+	var existing models.Detection
+	q := geo.WithinBound(utils.DB.Where("status = ? AND source = ?", "candidate", DetectionSource), "latitude", "longitude", cell)
+	if err := q.Limit(1).Find(&existing).Error; err != nil {
+		return err
+	}
+	if existing.ID != 0 {
+		detection.ID, detection.CreatedAt = existing.ID, existing.CreatedAt
+	}
+	if err := utils.DB.Save(&detection).Error; err != nil {
+		return err
+	}
+	if err := alerts.Enqueue(nil, alerts.EventDetection, detection.ID); err != nil {
+		return err
+	}
+	scene.Detections++
+
+	// let the footprint models look at what changed
+	found, err := detect.RunAll(context.Background(), img, detect.Options{Bound: cell, SceneID: &scene.ID, TileID: &tile.ID})
+	if err != nil {
+		log.Printf("scenes: tile %d: %v", tile.ID, err)
+	}
+	scene.Detections += len(found)
+	return nil
+}
+
+// cutTile resamples a grid cell from the scene to TileSize pixels. It
+// reports false when the cell is not fully covered or mostly empty.
+func cutTile(g *GeoTIFF, cell orb.Bound) (image.Image, bool) {
+	b := g.Image.Bounds()
+	inside := func(lat, lng float64) bool {
+		col, row := g.Pixel(lat, lng)
+		return col >= 0 && row >= 0 && col <= float64(b.Dx()) && row <= float64(b.Dy())
+	}
+	if !inside(cell.Min.Lat(), cell.Min.Lon()) || !inside(cell.Max.Lat(), cell.Max.Lon()) ||
+		!inside(cell.Min.Lat(), cell.Max.Lon()) || !inside(cell.Max.Lat(), cell.Min.Lon()) {
+		return nil, false
+	}
+
+	out := image.NewRGBA(image.Rect(0, 0, TileSize, TileSize))
+	empty := 0
+	step := TileDegrees / float64(TileSize)
+	for v := 0; v < TileSize; v++ {
+		lat := cell.Max.Lat() - (float64(v)+0.5)*step
+		for u := 0; u < TileSize; u++ {
+			lng := cell.Min.Lon() + (float64(u)+0.5)*step
+			col, row := g.Pixel(lat, lng)
+			c := color.RGBAModel.Convert(g.Image.At(b.Min.X+int(col), b.Min.Y+int(row))).(color.RGBA)
+			if c.A == 0 || (c.R == 0 && c.G == 0 && c.B == 0) {
+				empty++
+			}
+			out.SetRGBA(u, v, c)
+		}
+	}
+	if float64(empty) > MaxNoData*float64(TileSize*TileSize) {
+		return nil, false
+	}
+	return out, true
+}
+
+// maskCentroid returns the ground position of the centre of the changed
+// pixels, or of the cell when the mask is empty
+func maskCentroid(res *change.Result, cell orb.Bound) (float64, float64) {
+	mask, ok := res.Mask.(*image.NRGBA)
+	if !ok {
+		return cell.Center().Lat(), cell.Center().Lon()
+	}
+	var sx, sy float64
+	n := 0
+	for y := 0; y < res.Height; y++ {
+		for x := 0; x < res.Width; x++ {
+			if mask.NRGBAAt(x, y).A > 0 {
+				sx += float64(x) + 0.5
+				sy += float64(y) + 0.5
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		return cell.Center().Lat(), cell.Center().Lon()
+	}
+	lng := cell.Min.Lon() + sx/float64(n)/float64(res.Width)*(cell.Max.Lon()-cell.Min.Lon())
+	lat := cell.Max.Lat() - sy/float64(n)/float64(res.Height)*(cell.Max.Lat()-cell.Min.Lat())
+	return lat, lng
+}
@@ -0,0 +1,303 @@
+package scenes
+
+import (
+	"bytes"
+	"compress/zlib"
+	"container/list"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"golang.org/x/image/tiff/lzw"
+)
+
+// TIFF layout tags
+const (
+	tagImageWidth      = 256
+	tagImageLength     = 257
+	tagBitsPerSample   = 258
+	tagCompression     = 259
+	tagPhotometric     = 262
+	tagStripOffsets    = 273
+	tagSamplesPerPixel = 277
+	tagRowsPerStrip    = 278
+	tagStripByteCounts = 279
+	tagPlanarConfig    = 284
+	tagPredictor       = 317
+	tagTileWidth       = 322
+	tagTileLength      = 323
+	tagTileOffsets     = 324
+	tagTileByteCounts  = 325
+	tagExtraSamples    = 338
+	tagSampleFormat    = 339
+)
+
+// Compression schemes the block reader decodes
+const (
+	compressionNone     = 1
+	compressionLZW      = 5
+	compressionDeflate  = 8
+	compressionPackBits = 32773
+	compressionAdobe    = 32946 // old code for Deflate
+)
+
+const (
+	photometricBlackIsZero = 1
+	photometricRGB         = 2
+	predictorHorizontal    = 2
+	extraAssociatedAlpha   = 1
+	extraUnassociatedAlpha = 2
+
+	maxBlocks        = 1 << 22   // strips or tiles per image
+	maxBlockBytes    = 64 << 20  // decoded size of one strip or tile
+	rasterCacheBytes = 128 << 20 // decoded blocks kept in memory per scene
+	maxDecodePixels  = 100e6     // scenes in other layouts are decoded whole up to this size
+)
+
+// errLayout is returned by newRaster for TIFF layouts the block reader does
+// not handle
+var errLayout = errors.New("unsupported TIFF layout")
+
+// raster is an image.Image over the strips or tiles of a TIFF file, decoded
+// on first access and kept in a bounded LRU cache, so a scene never has to
+// fit in memory. Only 8-bit grey or RGB pixels, optionally with alpha, are
+// supported; a read error is kept in err and the pixel reads as empty.
+type raster struct {
+	r      io.ReaderAt
+	bounds image.Rectangle
+
+	blockW, blockH int // strip blocks are the image width wide
+	across         int // blocks per block row
+	offsets        []uint32
+	counts         []uint32
+
+	samples       int // per pixel
+	gray          bool
+	alpha         bool // the sample after the colour samples is alpha
+	premultiplied bool
+	compression   int
+	predictor     int
+
+	lru   *list.List // of *block, most recently used first
+	cache map[int]*list.Element
+	size  int // bytes held by cache
+	err   error
+}
+
+type block struct {
+	index int
+	data  []byte
+}
+
+// newRaster checks the layout described by tags and returns a lazy reader
+// over r, or errLayout when the layout is not supported
+func newRaster(r io.ReaderAt, tags tiffTags) (*raster, error) {
+	width, height := tags.int(tagImageWidth, 0), tags.int(tagImageLength, 0)
+	if width <= 0 || height <= 0 {
+		return nil, errors.New("TIFF has no image dimensions")
+	}
+	rs := &raster{
+		r:           r,
+		bounds:      image.Rect(0, 0, width, height),
+		samples:     tags.int(tagSamplesPerPixel, 1),
+		compression: tags.int(tagCompression, compressionNone),
+		predictor:   tags.int(tagPredictor, 1),
+		lru:         list.New(),
+		cache:       map[int]*list.Element{},
+	}
+
+	for _, bits := range tags.ints[tagBitsPerSample] {
+		if bits != 8 {
+			return nil, fmt.Errorf("%w: %d bits per sample", errLayout, bits)
+		}
+	}
+	if f := tags.int(tagSampleFormat, 1); f != 1 {
+		return nil, fmt.Errorf("%w: sample format %d", errLayout, f)
+	}
+	if p := tags.int(tagPlanarConfig, 1); p != 1 {
+		return nil, fmt.Errorf("%w: planar samples", errLayout)
+	}
+	colours := 3
+	switch tags.int(tagPhotometric, -1) {
+	case photometricBlackIsZero:
+		rs.gray, colours = true, 1
+	case photometricRGB:
+	default:
+		return nil, fmt.Errorf("%w: photometric interpretation %d", errLayout, tags.int(tagPhotometric, -1))
+	}
+	if rs.samples < colours {
+		return nil, fmt.Errorf("%w: %d samples per pixel", errLayout, rs.samples)
+	}
+	if rs.samples > colours {
+		switch tags.int(tagExtraSamples, 0) {
+		case extraAssociatedAlpha:
+			rs.alpha, rs.premultiplied = true, true
+		case extraUnassociatedAlpha:
+			rs.alpha = true
+		}
+	}
+	switch rs.compression {
+	case compressionNone, compressionLZW, compressionDeflate, compressionAdobe, compressionPackBits:
+	default:
+		return nil, fmt.Errorf("%w: compression %d", errLayout, rs.compression)
+	}
+	if rs.predictor != 1 && rs.predictor != predictorHorizontal {
+		return nil, fmt.Errorf("%w: predictor %d", errLayout, rs.predictor)
+	}
+
+	if tw := tags.int(tagTileWidth, 0); tw > 0 {
+		rs.blockW, rs.blockH = tw, tags.int(tagTileLength, 0)
+		rs.offsets, rs.counts = tags.ints[tagTileOffsets], tags.ints[tagTileByteCounts]
+	} else {
+		rs.blockW, rs.blockH = width, tags.int(tagRowsPerStrip, height)
+		rs.offsets, rs.counts = tags.ints[tagStripOffsets], tags.ints[tagStripByteCounts]
+	}
+	if rs.blockH <= 0 || rs.blockH > height {
+		rs.blockH = height
+	}
+	if rs.blockW <= 0 || rs.blockW*rs.blockH*rs.samples > maxBlockBytes {
+		return nil, fmt.Errorf("%w: strips or tiles too large", errLayout)
+	}
+	rs.across = (width + rs.blockW - 1) / rs.blockW
+	down := (height + rs.blockH - 1) / rs.blockH
+	if len(rs.offsets) < rs.across*down || len(rs.counts) < rs.across*down {
+		return nil, errors.New("TIFF is missing strip or tile offsets")
+	}
+	return rs, nil
+}
+
+func (rs *raster) ColorModel() color.Model { return color.RGBAModel }
+
+func (rs *raster) Bounds() image.Rectangle { return rs.bounds }
+
+func (rs *raster) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(rs.bounds)) {
+		return color.RGBA{}
+	}
+	i := (y/rs.blockH)*rs.across + x/rs.blockW
+	data, err := rs.block(i)
+	if err != nil {
+		if rs.err == nil {
+			rs.err = err
+		}
+		return color.RGBA{}
+	}
+	off := ((y%rs.blockH)*rs.blockW + x%rs.blockW) * rs.samples
+	if off+rs.samples > len(data) {
+		return color.RGBA{}
+	}
+	p := data[off : off+rs.samples]
+
+	var c color.NRGBA
+	if rs.gray {
+		c = color.NRGBA{p[0], p[0], p[0], 0xff}
+		if rs.alpha {
+			c.A = p[1]
+		}
+	} else {
+		c = color.NRGBA{p[0], p[1], p[2], 0xff}
+		if rs.alpha {
+			c.A = p[3]
+		}
+	}
+	if rs.premultiplied {
+		return color.RGBA(c)
+	}
+	return c
+}
+
+// block returns decoded block i, reading it from the file when not cached
+func (rs *raster) block(i int) ([]byte, error) {
+	if e, ok := rs.cache[i]; ok {
+		rs.lru.MoveToFront(e)
+		return e.Value.(*block).data, nil
+	}
+	data, err := rs.decode(i)
+	if err != nil {
+		return nil, err
+	}
+	rs.cache[i] = rs.lru.PushFront(&block{i, data})
+	rs.size += len(data)
+	for rs.size > rasterCacheBytes && rs.lru.Len() > 1 {
+		old := rs.lru.Remove(rs.lru.Back()).(*block)
+		delete(rs.cache, old.index)
+		rs.size -= len(old.data)
+	}
+	return data, nil
+}
+
+func (rs *raster) decode(i int) ([]byte, error) {
+	src := io.NewSectionReader(rs.r, int64(rs.offsets[i]), int64(rs.counts[i]))
+	var r io.Reader = src
+	switch rs.compression {
+	case compressionLZW:
+		lr := lzw.NewReader(src, lzw.MSB, 8)
+		defer lr.Close()
+		r = lr
+	case compressionDeflate, compressionAdobe:
+		zr, err := zlib.NewReader(src)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %v", i, err)
+		}
+		defer zr.Close()
+		r = zr
+	case compressionPackBits:
+		r = &packBits{r: src}
+	}
+
+	// the last strip may hold fewer rows than the others
+	rows := rs.blockH
+	if rs.blockW == rs.bounds.Dx() {
+		rows = min(rows, rs.bounds.Dy()-(i/rs.across)*rs.blockH)
+	}
+	data := make([]byte, rs.blockW*rows*rs.samples)
+	n, err := io.ReadFull(r, data)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("block %d: %v", i, err)
+	}
+	data = data[:n]
+
+	if rs.predictor == predictorHorizontal {
+		row := rs.blockW * rs.samples
+		for start := 0; start < len(data); start += row {
+			line := data[start:min(start+row, len(data))]
+			for j := rs.samples; j < len(line); j++ {
+				line[j] += line[j-rs.samples]
+			}
+		}
+	}
+	return data, nil
+}
+
+// packBits decodes the PackBits run-length encoding
+type packBits struct {
+	r   io.Reader
+	buf bytes.Buffer
+}
+
+func (p *packBits) Read(out []byte) (int, error) {
+	for p.buf.Len() < len(out) {
+		var h [1]byte
+		if _, err := io.ReadFull(p.r, h[:]); err != nil {
+			if p.buf.Len() > 0 {
+				break
+			}
+			return 0, io.EOF
+		}
+		switch n := int(int8(h[0])); {
+		case n >= 0:
+			if _, err := io.CopyN(&p.buf, p.r, int64(n+1)); err != nil {
+				return 0, err
+			}
+		case n > -128:
+			var v [1]byte
+			if _, err := io.ReadFull(p.r, v[:]); err != nil {
+				return 0, err
+			}
+			p.buf.Write(bytes.Repeat(v[:], 1-n))
+		}
+	}
+	return p.buf.Read(out)
+}
@@ -0,0 +1,66 @@
+package scenes
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+
+	"golang.org/x/image/tiff"
+)
+
+func TestRasterMatchesDecodedImage(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 37, 23))
+	for y := 0; y < 23; y++ {
+		for x := 0; x < 37; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x * 7), uint8(y * 11), uint8(x * y), uint8(128 + x)})
+		}
+	}
+
+	for name, opts := range map[string]*tiff.Options{
+		"uncompressed": nil,
+		"deflate":      {Compression: tiff.Deflate},
+	} {
+		var buf bytes.Buffer
+		if err := tiff.Encode(&buf, src, opts); err != nil {
+			t.Fatal(err)
+		}
+		r := bytes.NewReader(buf.Bytes())
+		tags, err := readTags(r)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		rs, err := newRaster(r, tags)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if rs.Bounds() != src.Bounds() {
+			t.Fatalf("%s: bounds = %v, want %v", name, rs.Bounds(), src.Bounds())
+		}
+		for y := 0; y < 23; y++ {
+			for x := 0; x < 37; x++ {
+				if got, want := rs.At(x, y), src.NRGBAAt(x, y); got != want {
+					t.Fatalf("%s: pixel (%d, %d) = %v, want %v", name, x, y, got, want)
+				}
+			}
+		}
+		if rs.err != nil {
+			t.Errorf("%s: %v", name, rs.err)
+		}
+	}
+}
+
+func TestPackBits(t *testing.T) {
+	// the example from the TIFF 6.0 specification
+	in := []byte{0xfe, 0xaa, 0x02, 0x80, 0x00, 0x2a, 0xfd, 0xaa, 0x03, 0x80, 0x00, 0x2a, 0x22, 0xf7, 0xaa}
+	want := []byte{0xaa, 0xaa, 0xaa, 0x80, 0x00, 0x2a, 0xaa, 0xaa, 0xaa, 0xaa, 0x80, 0x00, 0x2a, 0x22,
+		0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa, 0xaa}
+	got, err := io.ReadAll(&packBits{r: bytes.NewReader(in)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("decoded % x, want % x", got, want)
+	}
+}
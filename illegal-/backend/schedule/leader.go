@@ -0,0 +1,100 @@
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"hash/fnv"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"backend/utils"
+)
+
+// ElectionInterval is how often followers try to take over and the leader
+// checks that its lock connection is still alive
+var ElectionInterval = 15 * time.Second
+
+var leader atomic.Bool
+
+// IsLeader reports whether this replica currently fires scheduled tasks
+func IsLeader() bool {
+	return leader.Load()
+}
+
+// advisoryLock is a session-level Postgres advisory lock held on a dedicated
+// connection. It lives as long as the connection, so a replica that crashes
+// or loses the database releases it.
+type advisoryLock struct {
+	conn *sql.Conn
+	key  int64
+}
+
+// tryLock takes the named lock without waiting, returning nil when another
+// session holds it
+func tryLock(ctx context.Context, name string) (*advisoryLock, error) {
+	db, err := utils.DB.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	h := fnv.New64a()
+	h.Write([]byte("sky:" + name))
+	l := &advisoryLock{conn: conn, key: int64(h.Sum64())}
+
+	var ok bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&ok); err != nil || !ok {
+		conn.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// alive checks that the connection holding the lock still works
+func (l *advisoryLock) alive(ctx context.Context) error {
+	return l.conn.PingContext(ctx)
+}
+
+// release unlocks and returns the connection to the pool
+func (l *advisoryLock) release() {
+	l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", l.key)
+	l.conn.Close()
+}
+
+// elect keeps trying to become leader, and steps down when the connection
+// holding the leader lock is lost
+func elect(ctx context.Context) {
+	var held *advisoryLock
+	ticker := time.NewTicker(ElectionInterval)
+	defer ticker.Stop()
+	for {
+		if held == nil {
+			l, err := tryLock(ctx, "scheduler")
+			if err != nil {
+				log.Printf("schedule: leader election: %v", err)
+			} else if l != nil {
+				log.Printf("schedule: %s is now the scheduler leader", node)
+				held = l
+				leader.Store(true)
+			}
+		} else if err := held.alive(ctx); err != nil {
+			log.Printf("schedule: lost scheduler leadership: %v", err)
+			leader.Store(false)
+			held.release()
+			held = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			leader.Store(false)
+			if held != nil {
+				held.release()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
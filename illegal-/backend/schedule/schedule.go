@@ -0,0 +1,293 @@
+// Package schedule runs recurring platform tasks from cron expressions. Every
+// replica runs the scheduler, but only the one holding the Postgres advisory
+// leader lock fires scheduled runs; manual runs go through the job queue.
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"backend/jobs"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm/clause"
+)
+
+// Task is a recurring job. Spec is a standard five-field cron expression
+// evaluated in the server's local time.
+type Task struct {
+	Name        string
+	Spec        string
+	Description string
+	Run         func(ctx context.Context) error
+}
+
+// Run statuses
+const (
+	RunRunning   = "running"
+	RunSucceeded = "succeeded"
+	RunFailed    = "failed"
+)
+
+// Run triggers
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// TaskTimeout bounds a single run
+var TaskTimeout = 30 * time.Minute
+
+var (
+	mu    sync.RWMutex
+	tasks = map[string]Task{}
+)
+
+// Register adds a task. It must be called before Start.
+func Register(t Task) error {
+	if _, err := cron.ParseStandard(t.Spec); err != nil {
+		return fmt.Errorf("task %s: invalid schedule %q: %v", t.Name, t.Spec, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	tasks[t.Name] = t
+	return nil
+}
+
+// Get returns a registered task
+func Get(name string) (Task, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	t, ok := tasks[name]
+	return t, ok
+}
+
+// node identifies this replica in run history
+var node = func() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d", host, os.Getpid())
+}()
+
+// Start creates the state rows of registered tasks, joins the leader
+// election and fires tasks on their schedules until ctx is cancelled
+func Start(ctx context.Context) error {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	c := cron.New()
+	for _, t := range tasks {
+		state := models.ScheduledTask{Name: t.Name}
+		if err := utils.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&state).Error; err != nil {
+			return err
+		}
+		t := t
+		if _, err := c.AddFunc(t.Spec, func() { fire(ctx, t) }); err != nil {
+			return err
+		}
+	}
+
+	go elect(ctx)
+	c.Start()
+	go func() {
+		<-ctx.Done()
+		c.Stop()
+	}()
+	return nil
+}
+
+// fire runs a task for the current cron tick if this replica is the leader
+// and the task is not paused
+func fire(ctx context.Context, t Task) {
+	if !IsLeader() {
+		return
+	}
+	var state models.ScheduledTask
+	if err := utils.DB.Where("name = ?", t.Name).Limit(1).Find(&state).Error; err != nil {
+		log.Printf("schedule: loading %s: %v", t.Name, err)
+		return
+	}
+	if state.Paused {
+		return
+	}
+	tick := time.Now().Truncate(time.Minute)
+	if _, err := run(ctx, t, TriggerSchedule, &tick); err != nil && !errors.Is(err, ErrAlreadyRan) {
+		log.Printf("schedule: %s: %v", t.Name, err)
+	}
+}
+
+// ErrAlreadyRan means another replica already recorded a run for the tick,
+// e.g. just before leadership moved
+var ErrAlreadyRan = errors.New("task already ran for this tick")
+
+// ErrRunning means a run of the task is still in progress somewhere
+var ErrRunning = errors.New("task is already running")
+
+// run executes a task once and records it in the run history. The returned
+// run is nil when the run was skipped.
+func run(ctx context.Context, t Task, trigger string, tick *time.Time) (*models.TaskRun, error) {
+	held, err := tryLock(ctx, "task:"+t.Name)
+	if err != nil {
+		return nil, err
+	}
+	if held == nil {
+		return nil, ErrRunning
+	}
+	defer held.release()
+
+	rec := &models.TaskRun{
+		Task:         t.Name,
+		ScheduledFor: tick,
+		Trigger:      trigger,
+		Status:       RunRunning,
+		Node:         node,
+		StartedAt:    time.Now(),
+	}
+	res := utils.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(rec)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrAlreadyRan
+	}
+	utils.DB.Model(&models.ScheduledTask{}).Where("name = ?", t.Name).Update("last_run_at", rec.StartedAt)
+
+	runErr := execute(ctx, t)
+	now := time.Now()
+	rec.FinishedAt = &now
+	rec.Status = RunSucceeded
+	if runErr != nil {
+		rec.Status, rec.Error = RunFailed, runErr.Error()
+		log.Printf("schedule: %s failed: %v", t.Name, runErr)
+	}
+	if err := utils.DB.Save(rec).Error; err != nil {
+		return rec, err
+	}
+	return rec, nil
+}
+
+// execute calls the task with a timeout, turning panics into errors
+func execute(ctx context.Context, t Task) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v\n%s", p, debug.Stack())
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, TaskTimeout)
+	defer cancel()
+	return t.Run(ctx)
+}
+
+// JobRun runs a task on whichever replica's worker claims it
+const JobRun = "schedule.run"
+
+func init() {
+	jobs.Register(JobRun, func(ctx context.Context, job *models.Job) error {
+		var p struct {
+			Task string `json:"task"`
+		}
+		if err := jobs.Decode(job, &p); err != nil {
+			return err
+		}
+		t, ok := Get(p.Task)
+		if !ok {
+			return fmt.Errorf("unknown task %q", p.Task)
+		}
+		// a failed run is recorded in the history rather than retried
+		if _, err := run(ctx, t, TriggerManual, nil); err != nil && !errors.Is(err, ErrRunning) {
+			return err
+		}
+		return nil
+	})
+}
+
+// Trigger queues a manual run of a task, paused or not
+func Trigger(name string) (*models.Job, error) {
+	if _, ok := Get(name); !ok {
+		return nil, fmt.Errorf("unknown task %q", name)
+	}
+	return jobs.Enqueue(JobRun, map[string]string{"task": name})
+}
+
+// SetPaused pauses or resumes the scheduled runs of a task
+func SetPaused(name string, paused bool, by *uint) error {
+	if !paused {
+		by = nil
+	}
+	return utils.DB.Model(&models.ScheduledTask{}).Where("name = ?", name).
+		Updates(map[string]interface{}{"paused": paused, "paused_by": by}).Error
+}
+
+// Status describes a task for the admin endpoints
+type Status struct {
+	Name        string          `json:"name"`
+	Spec        string          `json:"spec"`
+	Description string          `json:"description"`
+	Paused      bool            `json:"paused"`
+	NextRunAt   *time.Time      `json:"next_run_at"`
+	LastRun     *models.TaskRun `json:"last_run"`
+}
+
+// Describe returns the status of one task
+func Describe(name string) (*Status, error) {
+	t, ok := Get(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown task %q", name)
+	}
+	st := &Status{Name: t.Name, Spec: t.Spec, Description: t.Description}
+
+	var state models.ScheduledTask
+	if err := utils.DB.Where("name = ?", name).Limit(1).Find(&state).Error; err != nil {
+		return nil, err
+	}
+	st.Paused = state.Paused
+	if sched, err := cron.ParseStandard(t.Spec); err == nil && !st.Paused {
+		next := sched.Next(time.Now())
+		st.NextRunAt = &next
+	}
+
+	var last models.TaskRun
+	if err := utils.DB.Where("task = ?", name).Order("started_at desc").Limit(1).Find(&last).Error; err != nil {
+		return nil, err
+	}
+	if last.ID != 0 {
+		st.LastRun = &last
+	}
+	return st, nil
+}
+
+// List returns the status of every registered task by name
+func List() ([]Status, error) {
+	mu.RLock()
+	names := make([]string, 0, len(tasks))
+	for name := range tasks {
+		names = append(names, name)
+	}
+	mu.RUnlock()
+	sort.Strings(names)
+
+	list := make([]Status, 0, len(names))
+	for _, name := range names {
+		st, err := Describe(name)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *st)
+	}
+	return list, nil
+}
+
+// History returns the most recent runs of a task
+func History(name string, limit int) ([]models.TaskRun, error) {
+	var runs []models.TaskRun
+	err := utils.DB.Where("task = ?", name).Order("started_at desc").Limit(limit).Find(&runs).Error
+	return runs, err
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// FromEnv builds the store selected by STORAGE_BACKEND: "local" (default)
+// keeps files in UPLOADS_DIR, "s3" uses S3_ENDPOINT, S3_BUCKET, S3_REGION,
+// S3_ACCESS_KEY, S3_SECRET_KEY and S3_USE_SSL
+func FromEnv(ctx context.Context) (BlobStore, error) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "local":
+		dir := os.Getenv("UPLOADS_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		return NewLocal(dir), nil
+	case "s3":
+		useSSL, _ := strconv.ParseBool(os.Getenv("S3_USE_SSL"))
+		cfg := S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			Region:    os.Getenv("S3_REGION"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			UseSSL:    useSSL,
+		}
+		if cfg.Endpoint == "" || cfg.Bucket == "" {
+			return nil, fmt.Errorf("S3_ENDPOINT and S3_BUCKET are required for the s3 backend")
+		}
+		return NewS3(ctx, cfg)
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// URLExpiry is how long the file URLs in API responses stay valid. Links
+// that must outlast a page view, such as in emails, are signed with a
+// longer expiry through SignedURL.
+var URLExpiry = time.Hour
+
+// signWindow rounds expiry times so the same file keeps the same URL for a
+// while and browsers can cache it
+const signWindow = 10 * time.Minute
+
+// signingKey is random until SetSigningKey is called
+var signingKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// SetSigningKey sets the secret file URLs are signed with. Without one the
+// random key stays, so URLs stop working on restart and are only valid on
+// the replica that signed them.
+func SetSigningKey(secret string) {
+	if secret == "" {
+		log.Println("storage: no signing secret set; file URLs are signed with a random key")
+		return
+	}
+	signingKey = []byte(secret)
+}
+
+func signature(key string, expires int64) string {
+	mac := hmac.New(sha256.New, signingKey)
+	io.WriteString(mac, key+"\n"+strconv.FormatInt(expires, 10))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignedURL returns a URL under FilesPath that serves key to anyone holding
+// it until at least ttl from now
+func SignedURL(key string, ttl time.Duration) string {
+	expires := time.Now().Add(ttl + signWindow).Truncate(signWindow).Unix()
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("sig", signature(key, expires))
+	return FilesPath + (&url.URL{Path: key}).EscapedPath() + "?" + q.Encode()
+}
+
+// Signature errors
+var (
+	ErrUnsigned     = errors.New("file URL is not signed")
+	ErrBadSignature = errors.New("file URL signature is invalid")
+	ErrExpired      = errors.New("file URL has expired")
+)
+
+// VerifySigned checks the expires and sig parameters of a request for key
+func VerifySigned(key string, q url.Values) error {
+	sig, exp := q.Get("sig"), q.Get("expires")
+	if sig == "" && exp == "" {
+		return ErrUnsigned
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || !hmac.Equal([]byte(sig), []byte(signature(key, expires))) {
+		return ErrBadSignature
+	}
+	if time.Now().Unix() > expires {
+		return ErrExpired
+	}
+	return nil
+}
+
+// Serve writes a stored file, answering Range and conditional requests.
+// Keys are content addresses, so the file name doubles as its ETag. Only
+// raster images are shown inline; anything else, such as an HTML or SVG
+// file, is sent as a download so it never runs in the API's origin.
+func Serve(w http.ResponseWriter, r *http.Request, key, contentType string) {
+	f, err := Default.Get(r.Context(), key)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("storage: reading %s: %v", key, err)
+		http.Error(w, "failed to read file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	name := path.Base(key)
+	if contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("ETag", `"`+strings.TrimSuffix(name, path.Ext(name))+`"`)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	served := contentType
+	if served == "" {
+		served = mime.TypeByExtension(path.Ext(name))
+	}
+	if !strings.HasPrefix(served, "image/") || strings.HasPrefix(served, "image/svg") {
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	if rs, ok := f.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, name, time.Time{}, rs)
+		return
+	}
+	io.Copy(w, f)
+}
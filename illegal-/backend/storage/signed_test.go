@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parse splits a signed URL into the key it serves and its query
+func parse(t *testing.T, signed string) (string, url.Values) {
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := KeyOf(u.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, u.Query()
+}
+
+func TestSignedURLVerifies(t *testing.T) {
+	for _, key := range []string{"reports/ab/abcdef.jpg", "scenes/12/a b+c.png"} {
+		got, q := parse(t, SignedURL(key, time.Hour))
+		if got != key {
+			t.Errorf("signed URL serves %q, want %q", got, key)
+		}
+		if err := VerifySigned(key, q); err != nil {
+			t.Errorf("%s: %v", key, err)
+		}
+		exp, _ := strconv.ParseInt(q.Get("expires"), 10, 64)
+		if until := time.Until(time.Unix(exp, 0)); until < time.Hour || until > time.Hour+signWindow {
+			t.Errorf("%s: valid for %v, want between 1h and 1h%v", key, until, signWindow)
+		}
+	}
+}
+
+func TestVerifySignedRejects(t *testing.T) {
+	key := "reports/ab/abcdef.jpg"
+	_, q := parse(t, SignedURL(key, time.Hour))
+
+	if err := VerifySigned("reports/ab/other.jpg", q); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("signature for another key: err = %v, want ErrBadSignature", err)
+	}
+
+	tampered := url.Values{"sig": {q.Get("sig")}, "expires": {q.Get("expires") + "0"}}
+	if err := VerifySigned(key, tampered); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("extended expiry: err = %v, want ErrBadSignature", err)
+	}
+
+	forged := url.Values{"sig": {strings.Repeat("0", 64)}, "expires": {q.Get("expires")}}
+	if err := VerifySigned(key, forged); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("forged signature: err = %v, want ErrBadSignature", err)
+	}
+
+	if err := VerifySigned(key, url.Values{"sig": {q.Get("sig")}}); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("missing expiry: err = %v, want ErrBadSignature", err)
+	}
+
+	past := time.Now().Add(-time.Minute).Unix()
+	expired := url.Values{"sig": {signature(key, past)}, "expires": {strconv.FormatInt(past, 10)}}
+	if err := VerifySigned(key, expired); !errors.Is(err, ErrExpired) {
+		t.Errorf("expired URL: err = %v, want ErrExpired", err)
+	}
+
+	if err := VerifySigned(key, url.Values{}); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("unsigned URL: err = %v, want ErrUnsigned", err)
+	}
+}
+
+func TestSigningKeyChangesSignatures(t *testing.T) {
+	defer func(k []byte) { signingKey = k }(signingKey)
+	key := "reports/ab/abcdef.jpg"
+	_, q := parse(t, SignedURL(key, time.Hour))
+
+	SetSigningKey("another secret")
+	if err := VerifySigned(key, q); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("URL signed with the old key: err = %v, want ErrBadSignature", err)
+	}
+}
+
+func TestURLHidesPrivateKeys(t *testing.T) {
+	if got := URL(PrivatePrefix + "reports/ab/abcdef.jpg"); got != "" {
+		t.Errorf("private key resolved to %q", got)
+	}
+	if got := URL("https://tiles.example.com/a.png"); got != "https://tiles.example.com/a.png" {
+		t.Errorf("external URL resolved to %q", got)
+	}
+	if got := URL("/uploads/reports/ab/abcdef.jpg"); !strings.HasPrefix(got, FilesPath+"reports/ab/abcdef.jpg?") {
+		t.Errorf("legacy path resolved to %q", got)
+	}
+}
+
+func TestKeyOfRejectsEscapes(t *testing.T) {
+	for _, ref := range []string{"", "../secret", "/etc/passwd", "a/../../b", "/uploads/../main.go", "a//b", "http://example.com/a"} {
+		if key, err := KeyOf(ref); err == nil {
+			t.Errorf("KeyOf(%q) = %q, want error", ref, key)
+		}
+	}
+}
+
+func TestServeAnswersRanges(t *testing.T) {
+	defer func(s BlobStore) { Default = s }(Default)
+	Default = NewLocal(t.TempDir())
+	key := "reports/ab/abcdef.txt"
+	if err := Default.Put(context.Background(), key, strings.NewReader("0123456789"), 10, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/uploads/"+key, nil)
+	req.Header.Set("Range", "bytes=2-4")
+	rec := httptest.NewRecorder()
+	Serve(rec, req, key, "text/plain")
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "234" {
+		t.Errorf("range request = %d %q, want 206 \"234\"", rec.Code, rec.Body.String())
+	}
+	if etag := rec.Header().Get("ETag"); etag != `"abcdef"` {
+		t.Errorf("ETag = %s, want \"abcdef\"", etag)
+	}
+
+	rec = httptest.NewRecorder()
+	Serve(rec, httptest.NewRequest(http.MethodGet, "/uploads/reports/ab/missing.txt", nil), "reports/ab/missing.txt", "")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("missing file = %d, want 404", rec.Code)
+	}
+}
+
+func TestServeDownloadsAnythingButImages(t *testing.T) {
+	defer func(s BlobStore) { Default = s }(Default)
+	Default = NewLocal(t.TempDir())
+	for key, want := range map[string]string{
+		"permits/ab/abcdef.png":  "",
+		"permits/ab/abcdef.pdf":  "attachment",
+		"permits/ab/abcdef.html": "attachment",
+		"permits/ab/abcdef.svg":  "attachment",
+	} {
+		if err := Default.Put(context.Background(), key, strings.NewReader("x"), 1, ""); err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		Serve(rec, httptest.NewRequest(http.MethodGet, "/uploads/"+key, nil), key, "")
+		if got := rec.Header().Get("Content-Disposition"); got != want {
+			t.Errorf("%s: Content-Disposition = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Package storage keeps uploaded and generated files in a BlobStore. Files
+// are stored under content-addressed keys, and records hold the key rather
+// than a URL, so the same data works whichever store serves it.
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"backend/models"
+)
+
+// BlobStore holds files by key. Keys are slash-separated relative paths.
+type BlobStore interface {
+	// Put stores size bytes from r under key, replacing any existing object
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get opens a stored object; it returns ErrNotFound for missing keys
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Exists reports whether key is stored
+	Exists(ctx context.Context, key string) (bool, error)
+	// Touch sets the modification time of key to now, reporting whether it
+	// is stored
+	Touch(ctx context.Context, key string) (bool, error)
+	// Delete removes key; deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// Walk calls fn for every stored object
+	Walk(ctx context.Context, fn func(key string, modTime time.Time) error) error
+}
+
+// ErrNotFound is returned for keys that are not stored
+var ErrNotFound = errors.New("blob not found")
+
+// Default is the store used for uploads, set from the environment at startup
+var Default BlobStore = NewLocal("uploads")
+
+func init() {
+	models.BlobURL = URL
+}
+
+// FilesPath is the URL path files are served under. Releases that wrote
+// straight to the uploads directory stored URLs with this prefix, the rest
+// of which is the key.
+const FilesPath = "/uploads/"
+
+// PrivatePrefix marks keys that never get a URL in API responses, such as
+// original evidence photos with their EXIF intact; they are read only
+// through handlers that check the caller.
+const PrivatePrefix = "private/"
+
+// Private reports whether a key is kept from public URLs
+func Private(key string) bool {
+	return strings.HasPrefix(key, PrivatePrefix)
+}
+
+// External reports whether ref is an http(s) URL outside the store, such as
+// an imagery provider link
+func External(ref string) bool {
+	return strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://")
+}
+
+// KeyOf returns the store key of a reference, accepting /uploads/ URLs,
+// signed or not, and refusing keys that would escape the store
+func KeyOf(ref string) (string, error) {
+	if External(ref) {
+		return "", errors.New("not a stored file")
+	}
+	key := ref
+	if strings.HasPrefix(ref, FilesPath) {
+		key, _, _ = strings.Cut(strings.TrimPrefix(ref, FilesPath), "?")
+	}
+	clean := path.Clean(key)
+	if key == "" || clean != key || strings.HasPrefix(clean, "/") || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", errors.New("invalid file reference")
+	}
+	return key, nil
+}
+
+// URL resolves a reference to a signed URL valid for URLExpiry, so whoever
+// was shown the record can fetch its files. External URLs are returned
+// unchanged and private keys resolve to nothing.
+func URL(ref string) string {
+	if ref == "" || External(ref) {
+		return ref
+	}
+	key, err := KeyOf(ref)
+	if err != nil || Private(key) {
+		return ""
+	}
+	return SignedURL(key, URLExpiry)
+}
+
+// Open opens a stored reference from the default store
+func Open(ctx context.Context, ref string) (io.ReadCloser, error) {
+	key, err := KeyOf(ref)
+	if err != nil {
+		return nil, err
+	}
+	return Default.Get(ctx, key)
+}
+
+// Save stores r in the default store under prefix/<sha256><ext> and returns
+// the key. Identical content is stored once.
+func Save(ctx context.Context, prefix string, r io.Reader, ext string) (string, error) {
+	tmp, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(tmp, head)
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return put(ctx, contentKey(prefix, h.Sum(nil), ext), tmp, size, http.DetectContentType(head[:n]))
+}
+
+// SaveBytes is Save for data already in memory
+func SaveBytes(ctx context.Context, prefix string, data []byte, ext string) (string, error) {
+	sum := sha256.Sum256(data)
+	return put(ctx, contentKey(prefix, sum[:], ext), bytes.NewReader(data), int64(len(data)), http.DetectContentType(data))
+}
+
+// contentKey builds prefix/ab/abcdef...ext, sharding on the first byte so
+// local directories stay small
+func contentKey(prefix string, sum []byte, ext string) string {
+	digest := hex.EncodeToString(sum)
+	return fmt.Sprintf("%s/%s/%s%s", strings.Trim(prefix, "/"), digest[:2], digest, strings.ToLower(ext))
+}
+
+// put stores r under key unless the content is already there. An existing
+// blob is touched instead, so the cleanup task does not take it for an old
+// unreferenced file before the record using it is saved.
+func put(ctx context.Context, key string, r io.Reader, size int64, contentType string) (string, error) {
+	exists, err := Default.Touch(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		if err := Default.Put(ctx, key, r, size, contentType); err != nil {
+			return "", err
+		}
+	}
+	return key, nil
+}
@@ -0,0 +1,112 @@
+package tasks
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"backend/storage"
+	"backend/uploads"
+	"backend/utils"
+)
+
+// UploadRetention is how old an unreferenced upload must be before it is
+// deleted, leaving time for uploads whose records are still being saved
+var UploadRetention = 7 * 24 * time.Hour
+
+// referencedSQL lists every file reference stored in the database
+var referencedSQL = []string{
+	"SELECT jsonb_path_query(images, '$[*].*.key') #>> '{}' FROM reports WHERE jsonb_typeof(images) = 'array'",
+	"SELECT e #>> '{}' FROM reports, jsonb_array_elements(images) e WHERE jsonb_typeof(images) = 'array' AND jsonb_typeof(e) = 'string'",
+	"SELECT jsonb_path_query(videos, '$[*].file.key') #>> '{}' FROM reports WHERE jsonb_typeof(videos) = 'array'",
+	"SELECT key FROM uploads WHERE key <> ''",
+	"SELECT jsonb_array_elements_text(parts) FROM uploads WHERE jsonb_typeof(parts) = 'array'",
+	"SELECT jsonb_array_elements_text(attachments) FROM permits WHERE jsonb_typeof(attachments) = 'array'",
+	"SELECT satellite_image_url FROM constructions",
+	"SELECT comparison_image_url FROM constructions",
+	"SELECT image_url FROM imagery_captures",
+	"SELECT heatmap_url FROM imagery_comparisons",
+	"SELECT mask_url FROM imagery_comparisons",
+	"SELECT image_url FROM scene_tiles",
+	"SELECT heatmap_url FROM detections",
+}
+
+// referencedUploads returns the set of store keys still in use
+func referencedUploads() (map[string]bool, error) {
+	refs := map[string]bool{}
+	for _, q := range referencedSQL {
+		var stored []string
+		if err := utils.DB.Raw(q).Scan(&stored).Error; err != nil {
+			return nil, err
+		}
+		for _, ref := range stored {
+			if key, err := storage.KeyOf(ref); err == nil {
+				refs[key] = true
+			}
+		}
+	}
+	return refs, nil
+}
+
+// referencedKeySQL reports whether any record refers to one key, in any of
+// the forms storage.KeyOf accepts
+var referencedKeySQL = "SELECT EXISTS (SELECT 1 FROM (" + strings.Join(referencedSQL, " UNION ALL ") +
+	") refs(ref) WHERE ref = @key OR ref = @url OR ref LIKE @signed)"
+
+// referenced checks one key against the database as it is now
+func referenced(key string) (bool, error) {
+	var found bool
+	url := storage.FilesPath + key
+	err := utils.DB.Raw(referencedKeySQL, map[string]interface{}{
+		"key": key, "url": url, "signed": url + "?%",
+	}).Scan(&found).Error
+	return found, err
+}
+
+// cleanUploads deletes expired upload sessions and stored files that no
+// record refers to any more. Each file is checked again right before it is
+// deleted, since a record may have taken it up while the store was walked.
+func cleanUploads(ctx context.Context) error {
+	if n, err := uploads.Expire(ctx); err != nil {
+		return err
+	} else if n > 0 {
+		log.Printf("tasks: removed %d expired upload session(s)", n)
+	}
+	refs, err := referencedUploads()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-UploadRetention)
+	var stale []string
+	err = storage.Default.Walk(ctx, func(key string, modTime time.Time) error {
+		if modTime.Before(cutoff) && !refs[key] {
+			stale = append(stale, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	removed := 0
+	for _, key := range stale {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		inUse, err := referenced(key)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			continue
+		}
+		if err := storage.Default.Delete(ctx, key); err != nil {
+			return err
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("tasks: removed %d unreferenced upload(s)", removed)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"backend/jobs"
+	"backend/models"
+	"backend/notify"
+	"backend/utils"
+)
+
+// sendDigest emails officers and admins a summary of the last day
+func sendDigest(ctx context.Context) error {
+	since := time.Now().Add(-24 * time.Hour)
+	count := func(model interface{}, where string, args ...interface{}) (int64, error) {
+		var n int64
+		err := utils.DB.Model(model).Where(where, args...).Count(&n).Error
+		return n, err
+	}
+
+	lines := []struct {
+		label string
+		model interface{}
+		where string
+		args  []interface{}
+	}{
+		{"New reports", &models.Report{}, "created_at >= ?", []interface{}{since}},
+		{"Reports awaiting review", &models.Report{}, "status = ?", []interface{}{"pending"}},
+		{"Reports past their SLA", &models.Report{}, "status = ? AND escalated_at IS NOT NULL", []interface{}{"pending"}},
+		{"New constructions", &models.Construction{}, "created_at >= ?", []interface{}{since}},
+		{"Illegal constructions", &models.Construction{}, "status = ?", []interface{}{"illegal"}},
+		{"Detections awaiting review", &models.Detection{}, "status = ?", []interface{}{"candidate"}},
+		{"Failed background jobs", &models.Job{}, "status = ? AND updated_at >= ?", []interface{}{jobs.StatusDead, since}},
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Activity for the 24 hours to %s\n\n", time.Now().Format("2006-01-02 15:04"))
+	for _, l := range lines {
+		n, err := count(l.model, l.where, l.args...)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&body, "%-28s %d\n", l.label+":", n)
+	}
+
+	var officers []models.User
+	if err := utils.DB.Where("LOWER(role) IN ? AND email <> ''", []string{"officer", "admin"}).Find(&officers).Error; err != nil {
+		return err
+	}
+	subject := "Daily digest " + time.Now().Format("2006-01-02")
+	for _, u := range officers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if _, err := notify.QueueEmail(notify.Email{To: []string{u.Email}, Subject: subject, Body: body.String()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"backend/models"
+	"backend/utils"
+
+	"gorm.io/gorm"
+)
+
+// ReportSLA is how long a report may stay pending, by priority, before it
+// is escalated; reports without a known priority use the medium SLA
+var ReportSLA = map[string]time.Duration{
+	"high":   24 * time.Hour,
+	"medium": 72 * time.Hour,
+	"low":    7 * 24 * time.Hour,
+}
+
+// escalateReports raises an alert for every pending report past its SLA
+// that has not been escalated yet, marking the report in the same
+// transaction so the alert is raised exactly once
+func escalateReports(ctx context.Context) error {
+	now := time.Now()
+	var overdue []models.Report
+	for priority, sla := range ReportSLA {
+		q := utils.DB.Where("status = ? AND escalated_at IS NULL AND created_at < ?", "pending", now.Add(-sla))
+		if priority == "medium" {
+			q = q.Where("(priority NOT IN ? OR priority IS NULL)", []string{"high", "low"})
+		} else {
+			q = q.Where("priority = ?", priority)
+		}
+		var reports []models.Report
+		if err := q.Find(&reports).Error; err != nil {
+			return err
+		}
+		overdue = append(overdue, reports...)
+	}
+
+	for _, r := range overdue {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		priority := r.Priority
+		if _, ok := ReportSLA[priority]; !ok {
+			priority = "medium"
+		}
+		alert := models.Alert{
+			Title: fmt.Sprintf("Report #%d is overdue", r.ID),
+			Description: fmt.Sprintf("%s priority report pending since %s, past its %s review SLA: %s",
+				priority, r.CreatedAt.Format("2006-01-02 15:04"), ReportSLA[priority], r.Description),
+			Location:   r.Location,
+			Status:     "open",
+			Severity:   "medium",
+			SourceType: "report",
+			SourceID:   &r.ID,
+		}
+		if priority == "high" {
+			alert.Severity = "high"
+		}
+		// the report is marked first, so a concurrent run that got there
+		// before us leaves no second alert behind
+		err := utils.DB.Transaction(func(tx *gorm.DB) error {
+			res := tx.Model(&models.Report{}).
+				Where("id = ? AND status = ? AND escalated_at IS NULL", r.ID, "pending").
+				Update("escalated_at", now)
+			if res.Error != nil || res.RowsAffected == 0 {
+				return res.Error
+			}
+			return tx.Create(&alert).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Package tasks defines the recurring platform tasks run by the scheduler
+package tasks
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"backend/aggregate"
+	"backend/scenes"
+	"backend/schedule"
+)
+
+// All is every recurring task with its default schedule
+var All = []schedule.Task{
+	{
+		Name:        "sla_escalation",
+		Spec:        "*/15 * * * *",
+		Description: "Raise an alert for pending reports past their review SLA",
+		Run:         escalateReports,
+	},
+	{
+		Name:        "daily_digest",
+		Spec:        "0 7 * * *",
+		Description: "Email officers a summary of the last 24 hours",
+		Run:         sendDigest,
+	},
+	{
+		Name:        "scene_scan",
+		Spec:        "* * * * *",
+		Description: "Ingest new GeoTIFF scenes from the watched directory",
+		Run: func(ctx context.Context) error {
+			n, err := scenes.Scan(scenes.WatchDir)
+			if n > 0 {
+				log.Printf("scenes: ingested %d scene(s)", n)
+			}
+			return err
+		},
+	},
+	{
+		Name:        "analytics_rollup",
+		Spec:        "5 * * * *",
+		Description: "Recompute daily analytics counts for today and yesterday",
+		Run: func(ctx context.Context) error {
+			now := time.Now()
+			if err := aggregate.Rollup(now.AddDate(0, 0, -1)); err != nil {
+				return err
+			}
+			return aggregate.Rollup(now)
+		},
+	},
+	{
+		Name:        "upload_cleanup",
+		Spec:        "30 3 * * *",
+		Description: "Delete expired upload sessions and uploaded files no record refers to",
+		Run:         cleanUploads,
+	},
+}
+
+// Register adds every task to the scheduler
+func Register() error {
+	for _, t := range All {
+		if err := schedule.Register(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,367 @@
+package tiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"math"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/clip"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+	"github.com/paulmach/orb/simplify"
+)
+
+// This file is a small Mapbox Vector Tile 2.1 encoder. It projects, clips and
+// simplifies features in tile space and writes the protobuf by hand, which is
+// all the map needs and avoids a protobuf code generation dependency.
+
+// extent is the tile coordinate range
+const extent = 4096
+
+// clipBuffer keeps geometry slightly past the tile edge, matching tileBuffer
+const clipBuffer = 64
+
+// MVT geometry types and commands
+const (
+	geomPoint      = 1
+	geomLineString = 2
+	geomPolygon    = 3
+
+	cmdMoveTo    = 1
+	cmdLineTo    = 2
+	cmdClosePath = 7
+)
+
+// project converts a lng/lat geometry to tile pixel coordinates
+func project(g orb.Geometry, t maptile.Tile) orb.Geometry {
+	conv := func(p orb.Point) orb.Point {
+		f := maptile.Fraction(p, t.Z)
+		return orb.Point{(f[0] - float64(t.X)) * extent, (f[1] - float64(t.Y)) * extent}
+	}
+	ring := func(r orb.Ring) orb.Ring {
+		out := make(orb.Ring, len(r))
+		for i, p := range r {
+			out[i] = conv(p)
+		}
+		return out
+	}
+	line := func(l orb.LineString) orb.LineString {
+		out := make(orb.LineString, len(l))
+		for i, p := range l {
+			out[i] = conv(p)
+		}
+		return out
+	}
+	polygon := func(p orb.Polygon) orb.Polygon {
+		out := make(orb.Polygon, len(p))
+		for i, r := range p {
+			out[i] = ring(r)
+		}
+		return out
+	}
+
+	switch v := g.(type) {
+	case orb.Point:
+		return conv(v)
+	case orb.MultiPoint:
+		out := make(orb.MultiPoint, len(v))
+		for i, p := range v {
+			out[i] = conv(p)
+		}
+		return out
+	case orb.LineString:
+		return line(v)
+	case orb.MultiLineString:
+		out := make(orb.MultiLineString, len(v))
+		for i, l := range v {
+			out[i] = line(l)
+		}
+		return out
+	case orb.Polygon:
+		return polygon(v)
+	case orb.MultiPolygon:
+		out := make(orb.MultiPolygon, len(v))
+		for i, p := range v {
+			out[i] = polygon(p)
+		}
+		return out
+	}
+	return nil
+}
+
+// encodeTile writes a single-layer, gzip compressed tile
+func encodeTile(name string, fc *geojson.FeatureCollection, t maptile.Tile) ([]byte, error) {
+	bound := orb.Bound{Min: orb.Point{-clipBuffer, -clipBuffer}, Max: orb.Point{extent + clipBuffer, extent + clipBuffer}}
+	simplifier := simplify.DouglasPeucker(tolerance(t.Z))
+
+	var layer protoBuffer
+	layer.uint(15, 2) // version
+	layer.bytes(1, []byte(name))
+
+	keys := map[string]uint64{}
+	var keyList []string
+	values := map[string]uint64{}
+	var valueList [][]byte
+
+	for _, f := range fc.Features {
+		g := project(f.Geometry, t)
+		if g == nil {
+			continue
+		}
+		g = clip.Geometry(bound, g)
+		if g == nil {
+			continue
+		}
+		if g.Dimensions() > 0 {
+			g = simplifier.Simplify(g)
+		}
+		geomType, commands := encodeGeometry(g)
+		if len(commands) == 0 {
+			continue
+		}
+
+		var tags []uint64
+		for k, v := range f.Properties {
+			encoded, ok := encodeValue(v)
+			if !ok {
+				continue
+			}
+			ki, seen := keys[k]
+			if !seen {
+				ki = uint64(len(keyList))
+				keys[k] = ki
+				keyList = append(keyList, k)
+			}
+			vk := string(encoded)
+			vi, seen := values[vk]
+			if !seen {
+				vi = uint64(len(valueList))
+				values[vk] = vi
+				valueList = append(valueList, encoded)
+			}
+			tags = append(tags, ki, vi)
+		}
+
+		var feature protoBuffer
+		if id, ok := f.ID.(uint); ok {
+			feature.uint(1, uint64(id))
+		}
+		feature.packed(2, tags)
+		feature.uint(3, geomType)
+		feature.packed(4, commands)
+		layer.bytes(2, feature.Bytes())
+	}
+
+	for _, k := range keyList {
+		layer.bytes(3, []byte(k))
+	}
+	for _, v := range valueList {
+		layer.bytes(4, v)
+	}
+	layer.uint(5, extent)
+
+	var tile protoBuffer
+	tile.bytes(3, layer.Bytes())
+
+	var out bytes.Buffer
+	gz := gzip.NewWriter(&out)
+	if _, err := gz.Write(tile.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// encodeValue serialises a property as an MVT Value message
+func encodeValue(v interface{}) ([]byte, bool) {
+	var b protoBuffer
+	switch x := v.(type) {
+	case string:
+		b.bytes(1, []byte(x))
+	case bool:
+		n := uint64(0)
+		if x {
+			n = 1
+		}
+		b.uint(7, n)
+	case int:
+		b.uint(6, zigzag(int64(x)))
+	case int64:
+		b.uint(6, zigzag(x))
+	case uint:
+		b.uint(5, uint64(x))
+	case uint64:
+		b.uint(5, x)
+	case float64:
+		b.double(3, x)
+	case nil:
+		return nil, false
+	default:
+		b.bytes(1, []byte(fmt.Sprint(x)))
+	}
+	return b.Bytes(), true
+}
+
+// cursor tracks the pen position while emitting delta encoded commands
+type cursor struct {
+	x, y int64
+	out  []uint64
+}
+
+func (c *cursor) command(id, count int) {
+	c.out = append(c.out, uint64(id&0x7)|uint64(count)<<3)
+}
+
+func (c *cursor) point(p [2]int64) {
+	c.out = append(c.out, zigzag(p[0]-c.x), zigzag(p[1]-c.y))
+	c.x, c.y = p[0], p[1]
+}
+
+// round snaps a path to integer coordinates, dropping repeated points
+func round(points []orb.Point) [][2]int64 {
+	out := make([][2]int64, 0, len(points))
+	for _, p := range points {
+		q := [2]int64{int64(math.Round(p[0])), int64(math.Round(p[1]))}
+		if len(out) > 0 && out[len(out)-1] == q {
+			continue
+		}
+		out = append(out, q)
+	}
+	return out
+}
+
+func (c *cursor) line(points [][2]int64) {
+	c.command(cmdMoveTo, 1)
+	c.point(points[0])
+	c.command(cmdLineTo, len(points)-1)
+	for _, p := range points[1:] {
+		c.point(p)
+	}
+}
+
+// ring emits a closed ring wound clockwise in tile space for exteriors and
+// counter-clockwise for holes, as the spec requires
+func (c *cursor) ring(r orb.Ring, exterior bool) {
+	points := round(r)
+	if len(points) > 1 && points[0] == points[len(points)-1] {
+		points = points[:len(points)-1]
+	}
+	if len(points) < 3 {
+		return
+	}
+	area := 0.0
+	for i := range points {
+		j := (i + 1) % len(points)
+		area += float64(points[i][0]*points[j][1] - points[j][0]*points[i][1])
+	}
+	if area == 0 {
+		return
+	}
+	if (area > 0) != exterior {
+		for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+			points[i], points[j] = points[j], points[i]
+		}
+	}
+	c.line(points)
+	c.command(cmdClosePath, 1)
+}
+
+// encodeGeometry returns the MVT geometry type and command stream
+func encodeGeometry(g orb.Geometry) (uint64, []uint64) {
+	c := &cursor{}
+	switch v := g.(type) {
+	case orb.Point:
+		c.command(cmdMoveTo, 1)
+		c.point(round([]orb.Point{v})[0])
+		return geomPoint, c.out
+	case orb.MultiPoint:
+		points := round(v)
+		if len(points) == 0 {
+			return 0, nil
+		}
+		c.command(cmdMoveTo, len(points))
+		for _, p := range points {
+			c.point(p)
+		}
+		return geomPoint, c.out
+	case orb.LineString:
+		if points := round(v); len(points) > 1 {
+			c.line(points)
+		}
+		return geomLineString, c.out
+	case orb.MultiLineString:
+		for _, l := range v {
+			if points := round(l); len(points) > 1 {
+				c.line(points)
+			}
+		}
+		return geomLineString, c.out
+	case orb.Polygon:
+		polygon(c, v)
+		return geomPolygon, c.out
+	case orb.MultiPolygon:
+		for _, p := range v {
+			polygon(c, p)
+		}
+		return geomPolygon, c.out
+	}
+	return 0, nil
+}
+
+func polygon(c *cursor, p orb.Polygon) {
+	before := len(c.out)
+	for i, r := range p {
+		c.ring(r, i == 0)
+		if i == 0 && len(c.out) == before {
+			return // exterior collapsed, so its holes are meaningless
+		}
+	}
+}
+
+func zigzag(n int64) uint64 {
+	return uint64((n << 1) ^ (n >> 63))
+}
+
+// protoBuffer is a minimal protobuf writer for the fields MVT uses
+type protoBuffer struct {
+	bytes.Buffer
+}
+
+func (b *protoBuffer) varint(v uint64) {
+	for v >= 0x80 {
+		b.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+	b.WriteByte(byte(v))
+}
+
+func (b *protoBuffer) uint(field int, v uint64) {
+	b.varint(uint64(field) << 3)
+	b.varint(v)
+}
+
+func (b *protoBuffer) double(field int, v float64) {
+	b.varint(uint64(field)<<3 | 1)
+	bits := math.Float64bits(v)
+	for i := 0; i < 8; i++ {
+		b.WriteByte(byte(bits >> (8 * i)))
+	}
+}
+
+func (b *protoBuffer) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.Write(data)
+}
+
+func (b *protoBuffer) packed(field int, values []uint64) {
+	var inner protoBuffer
+	for _, v := range values {
+		inner.varint(v)
+	}
+	b.bytes(field, inner.Bytes())
+}
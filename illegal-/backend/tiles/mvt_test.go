@@ -0,0 +1,352 @@
+package tiles
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+)
+
+// field is one decoded protobuf field
+type field struct {
+	num  int
+	wire int
+	v    uint64 // varint and fixed64 values
+	data []byte // length-delimited values
+}
+
+func decodeVarint(t *testing.T, b []byte) (uint64, []byte) {
+	t.Helper()
+	var v uint64
+	for shift := 0; shift < 64; shift += 7 {
+		if len(b) == 0 {
+			t.Fatal("truncated varint")
+		}
+		c := b[0]
+		b = b[1:]
+		v |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return v, b
+		}
+	}
+	t.Fatal("varint too long")
+	return 0, nil
+}
+
+func decodeFields(t *testing.T, b []byte) []field {
+	t.Helper()
+	var out []field
+	for len(b) > 0 {
+		var key uint64
+		key, b = decodeVarint(t, b)
+		f := field{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case 0:
+			f.v, b = decodeVarint(t, b)
+		case 1:
+			if len(b) < 8 {
+				t.Fatal("truncated fixed64")
+			}
+			for i := 0; i < 8; i++ {
+				f.v |= uint64(b[i]) << (8 * i)
+			}
+			b = b[8:]
+		case 2:
+			var n uint64
+			n, b = decodeVarint(t, b)
+			if uint64(len(b)) < n {
+				t.Fatal("truncated bytes")
+			}
+			f.data, b = b[:n], b[n:]
+		default:
+			t.Fatalf("unexpected wire type %d", f.wire)
+		}
+		out = append(out, f)
+	}
+	return out
+}
+
+func decodePacked(t *testing.T, b []byte) []uint64 {
+	t.Helper()
+	var out []uint64
+	for len(b) > 0 {
+		var v uint64
+		v, b = decodeVarint(t, b)
+		out = append(out, v)
+	}
+	return out
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+type decodedFeature struct {
+	id       uint64
+	geomType uint64
+	geometry []uint64
+	props    map[string]interface{}
+}
+
+type decodedLayer struct {
+	name     string
+	version  uint64
+	extent   uint64
+	features []decodedFeature
+}
+
+// decodeTile inflates and decodes a single-layer tile
+func decodeTile(t *testing.T, data []byte) decodedLayer {
+	t.Helper()
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("tile is not gzip compressed: %v", err)
+	}
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tile := decodeFields(t, raw)
+	if len(tile) != 1 || tile[0].num != 3 {
+		t.Fatalf("tile fields = %+v, want one layer", tile)
+	}
+
+	var layer decodedLayer
+	var keys []string
+	var values []interface{}
+	var features [][]field
+	for _, f := range decodeFields(t, tile[0].data) {
+		switch f.num {
+		case 1:
+			layer.name = string(f.data)
+		case 2:
+			features = append(features, decodeFields(t, f.data))
+		case 3:
+			keys = append(keys, string(f.data))
+		case 4:
+			v := decodeFields(t, f.data)[0]
+			switch v.num {
+			case 1:
+				values = append(values, string(v.data))
+			case 3:
+				values = append(values, math.Float64frombits(v.v))
+			case 5:
+				values = append(values, v.v)
+			case 6:
+				values = append(values, unzigzag(v.v))
+			case 7:
+				values = append(values, v.v == 1)
+			}
+		case 5:
+			layer.extent = f.v
+		case 15:
+			layer.version = f.v
+		}
+	}
+	for _, fields := range features {
+		feature := decodedFeature{props: map[string]interface{}{}}
+		for _, f := range fields {
+			switch f.num {
+			case 1:
+				feature.id = f.v
+			case 2:
+				tags := decodePacked(t, f.data)
+				for i := 0; i+1 < len(tags); i += 2 {
+					feature.props[keys[tags[i]]] = values[tags[i+1]]
+				}
+			case 3:
+				feature.geomType = f.v
+			case 4:
+				feature.geometry = decodePacked(t, f.data)
+			}
+		}
+		layer.features = append(layer.features, feature)
+	}
+	return layer
+}
+
+// rings decodes a polygon command stream into its rings
+func rings(t *testing.T, commands []uint64) [][][2]int64 {
+	t.Helper()
+	var out [][][2]int64
+	var current [][2]int64
+	var x, y int64
+	for i := 0; i < len(commands); {
+		id, count := commands[i]&7, int(commands[i]>>3)
+		i++
+		switch id {
+		case cmdMoveTo, cmdLineTo:
+			if id == cmdMoveTo {
+				current = nil
+			}
+			for n := 0; n < count; n++ {
+				x += unzigzag(commands[i])
+				y += unzigzag(commands[i+1])
+				i += 2
+				current = append(current, [2]int64{x, y})
+			}
+		case cmdClosePath:
+			out = append(out, current)
+		default:
+			t.Fatalf("unknown command %d", id)
+		}
+	}
+	return out
+}
+
+func shoelace(points [][2]int64) int64 {
+	var area int64
+	for i := range points {
+		j := (i + 1) % len(points)
+		area += points[i][0]*points[j][1] - points[j][0]*points[i][1]
+	}
+	return area
+}
+
+func TestEncodeTilePoint(t *testing.T) {
+	tile := maptile.New(0, 0, 1)
+	// the centre of the world is the bottom right corner of tile 1/0/0
+	fc := geojson.NewFeatureCollection()
+	f := geojson.NewFeature(orb.Point{0, 0})
+	f.ID = uint(7)
+	f.Properties["status"] = "illegal"
+	f.Properties["floors"] = 3
+	f.Properties["score"] = 0.5
+	f.Properties["ward_id"] = uint(4)
+	f.Properties["missing"] = nil
+	fc.Append(f)
+
+	data, err := encodeTile("constructions", fc, tile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := decodeTile(t, data)
+	if layer.name != "constructions" || layer.version != 2 || layer.extent != extent {
+		t.Fatalf("layer = %q v%d extent %d", layer.name, layer.version, layer.extent)
+	}
+	if len(layer.features) != 1 {
+		t.Fatalf("got %d features, want 1", len(layer.features))
+	}
+	got := layer.features[0]
+	if got.id != 7 || got.geomType != geomPoint {
+		t.Errorf("feature id %d type %d, want 7 and point", got.id, got.geomType)
+	}
+	want := []uint64{cmdMoveTo | 1<<3, zigzag(extent), zigzag(extent)}
+	if len(got.geometry) != len(want) {
+		t.Fatalf("geometry = %v, want %v", got.geometry, want)
+	}
+	for i := range want {
+		if got.geometry[i] != want[i] {
+			t.Fatalf("geometry = %v, want %v", got.geometry, want)
+		}
+	}
+	wantProps := map[string]interface{}{"status": "illegal", "floors": int64(3), "score": 0.5, "ward_id": uint64(4)}
+	if len(got.props) != len(wantProps) {
+		t.Fatalf("properties = %v, want %v", got.props, wantProps)
+	}
+	for k, v := range wantProps {
+		if got.props[k] != v {
+			t.Errorf("property %s = %#v, want %#v", k, got.props[k], v)
+		}
+	}
+}
+
+func TestEncodeTileSharesKeysAndValues(t *testing.T) {
+	tile := maptile.New(0, 0, 0)
+	fc := geojson.NewFeatureCollection()
+	for _, lng := range []float64{-10, 10} {
+		f := geojson.NewFeature(orb.Point{lng, 0})
+		f.Properties["status"] = "pending"
+		fc.Append(f)
+	}
+	data, err := encodeTile("reports", fc, tile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, _ := gzip.NewReader(bytes.NewReader(data))
+	raw, _ := io.ReadAll(zr)
+	var keys, values int
+	for _, f := range decodeFields(t, decodeFields(t, raw)[0].data) {
+		switch f.num {
+		case 3:
+			keys++
+		case 4:
+			values++
+		}
+	}
+	if keys != 1 || values != 1 {
+		t.Errorf("got %d keys and %d values, want one of each", keys, values)
+	}
+}
+
+func TestEncodeTilePolygonWinding(t *testing.T) {
+	tile := maptile.New(0, 0, 0)
+	// exterior given clockwise in lng/lat and a hole given clockwise too;
+	// the encoder must rewind both to the spec's orientation
+	outer := orb.Ring{{-90, -45}, {-90, 45}, {90, 45}, {90, -45}, {-90, -45}}
+	hole := orb.Ring{{-10, -10}, {-10, 10}, {10, 10}, {10, -10}, {-10, -10}}
+	fc := geojson.NewFeatureCollection()
+	fc.Append(geojson.NewFeature(orb.Polygon{outer, hole}))
+
+	data, err := encodeTile("parcels", fc, tile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := decodeTile(t, data)
+	if len(layer.features) != 1 || layer.features[0].geomType != geomPolygon {
+		t.Fatalf("features = %+v, want one polygon", layer.features)
+	}
+	rs := rings(t, layer.features[0].geometry)
+	if len(rs) != 2 {
+		t.Fatalf("got %d rings, want 2", len(rs))
+	}
+	if shoelace(rs[0]) <= 0 {
+		t.Errorf("exterior area %d, want positive", shoelace(rs[0]))
+	}
+	if shoelace(rs[1]) >= 0 {
+		t.Errorf("hole area %d, want negative", shoelace(rs[1]))
+	}
+	for _, r := range rs {
+		if r[0] == r[len(r)-1] {
+			t.Errorf("ring %v repeats its first point; ClosePath closes it", r)
+		}
+	}
+}
+
+func TestEncodeTileDropsFeaturesOutsideTile(t *testing.T) {
+	tile := maptile.New(0, 0, 1) // north west quarter of the world
+	fc := geojson.NewFeatureCollection()
+	fc.Append(geojson.NewFeature(orb.Point{120, -60}))
+	fc.Append(geojson.NewFeature(orb.Polygon{{{100, -70}, {110, -70}, {110, -60}, {100, -60}, {100, -70}}}))
+
+	data, err := encodeTile("constructions", fc, tile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer := decodeTile(t, data); len(layer.features) != 0 {
+		t.Errorf("got %d features, want none", len(layer.features))
+	}
+}
+
+func TestEncodeGeometryDropsCollapsedRings(t *testing.T) {
+	degenerate := orb.Polygon{{{0, 0}, {0.2, 0.1}, {0.1, 0.2}, {0, 0}}}
+	if _, commands := encodeGeometry(degenerate); len(commands) != 0 {
+		t.Errorf("commands = %v, want none for a ring that rounds to a point", commands)
+	}
+}
+
+func TestZigzag(t *testing.T) {
+	for n, want := range map[int64]uint64{0: 0, -1: 1, 1: 2, -2: 3, 2: 4, 2048: 4096} {
+		if got := zigzag(n); got != want {
+			t.Errorf("zigzag(%d) = %d, want %d", n, got, want)
+		}
+		if back := unzigzag(zigzag(n)); back != n {
+			t.Errorf("unzigzag(zigzag(%d)) = %d", n, back)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package tiles
+
+import (
+	"time"
+
+	"backend/geo"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"gorm.io/gorm"
+)
+
+// versionRow receives the count/max(updated) summary of a bound
+type versionRow struct {
+	Count   int64
+	Updated *time.Time
+}
+
+func summarize(q *gorm.DB, updatedCol string) (int64, time.Time, error) {
+	var row versionRow
+	if err := q.Select("COUNT(*) AS count, MAX(" + updatedCol + ") AS updated").Scan(&row).Error; err != nil {
+		return 0, time.Time{}, err
+	}
+	if row.Updated == nil {
+		return row.Count, time.Time{}, nil
+	}
+	return row.Count, *row.Updated, nil
+}
+
+// constructionSource serves constructions as points
+type constructionSource struct{}
+
+func (constructionSource) query(b orb.Bound, f Filter) *gorm.DB {
+	q := geo.WithinBound(utils.DB.Model(&models.Construction{}), "latitude", "longitude", b)
+	if len(f.Statuses) > 0 {
+		q = q.Where("status IN ?", f.Statuses)
+	}
+	return q
+}
+
+func (s constructionSource) version(b orb.Bound, f Filter) (int64, time.Time, error) {
+	return summarize(s.query(b, f), "updated_at")
+}
+
+func (s constructionSource) features(b orb.Bound, f Filter) (*geojson.FeatureCollection, error) {
+	var rows []models.Construction
+	if err := s.query(b, f).Order("id").Limit(MaxFeatures).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	fc := geojson.NewFeatureCollection()
+	for _, c := range rows {
+		feature := geojson.NewFeature(orb.Point{c.Longitude, c.Latitude})
+		feature.ID = c.ID
+		feature.Properties["id"] = c.ID
+		feature.Properties["status"] = c.Status
+		feature.Properties["detection_source"] = c.DetectionSource
+		feature.Properties["location"] = c.Location
+		if c.WardID != nil {
+			feature.Properties["ward_id"] = *c.WardID
+		}
+		fc.Append(feature)
+	}
+	return fc, nil
+}
+
+// reportSource serves reports that carry coordinates as points
+type reportSource struct{}
+
+func (reportSource) query(b orb.Bound, f Filter) *gorm.DB {
+	q := geo.WithinBound(utils.DB.Model(&models.Report{}), geo.ReportLatSQL, geo.ReportLngSQL, b)
+	if len(f.Statuses) > 0 {
+		q = q.Where("status IN ?", f.Statuses)
+	}
+	if len(f.Priorities) > 0 {
+		q = q.Where("priority IN ?", f.Priorities)
+	}
+	return q
+}
+
+func (s reportSource) version(b orb.Bound, f Filter) (int64, time.Time, error) {
+	return summarize(s.query(b, f), "updated_at")
+}
+
+func (s reportSource) features(b orb.Bound, f Filter) (*geojson.FeatureCollection, error) {
+	var rows []models.Report
+	if err := s.query(b, f).Order("id").Limit(MaxFeatures).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	fc := geojson.NewFeatureCollection()
+	for _, r := range rows {
+		lat, lng, ok := r.LatLng()
+		if !ok {
+			continue
+		}
+		feature := geojson.NewFeature(orb.Point{lng, lat})
+		feature.ID = r.ID
+		feature.Properties["id"] = r.ID
+		feature.Properties["status"] = r.Status
+		feature.Properties["priority"] = r.Priority
+		feature.Properties["location"] = r.Location
+		fc.Append(feature)
+	}
+	return fc, nil
+}
+
+// parcelSource serves properties that have a boundary polygon
+type parcelSource struct{}
+
+func (parcelSource) query(b orb.Bound) *gorm.DB {
+	return geo.IntersectsBound(utils.DB.Model(&models.Property{}), "properties", b)
+}
+
+func (s parcelSource) version(b orb.Bound, f Filter) (int64, time.Time, error) {
+	return summarize(s.query(b), "updated_at")
+}
+
+func (s parcelSource) features(b orb.Bound, f Filter) (*geojson.FeatureCollection, error) {
+	var rows []models.Property
+	if err := s.query(b).Order("id").Limit(MaxFeatures).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	fc := geojson.NewFeatureCollection()
+	for _, p := range rows {
+		g, err := geo.DecodeGeometry(p.Boundary)
+		if err != nil {
+			continue
+		}
+		feature := geojson.NewFeature(g)
+		feature.ID = p.ID
+		feature.Properties["id"] = p.ID
+		feature.Properties["land_use"] = p.LandUse
+		feature.Properties["address"] = p.Address
+		fc.Append(feature)
+	}
+	return fc, nil
+}
+
+// layerSource serves the features of every imported layer of one kind
+type layerSource struct {
+	kind string
+}
+
+func (s layerSource) query(b orb.Bound) *gorm.DB {
+	q := utils.DB.Model(&models.LayerFeature{}).
+		Joins("JOIN layers ON layers.id = layer_features.layer_id").
+		Where("layers.kind = ?", s.kind)
+	return geo.IntersectsBound(q, "layer_features", b)
+}
+
+func (s layerSource) version(b orb.Bound, f Filter) (int64, time.Time, error) {
+	return summarize(s.query(b), "layers.updated_at")
+}
+
+func (s layerSource) features(b orb.Bound, f Filter) (*geojson.FeatureCollection, error) {
+	shapes, err := geo.LoadShapes(s.kind, &b)
+	if err != nil {
+		return nil, err
+	}
+	fc := geojson.NewFeatureCollection()
+	for i, shape := range shapes {
+		if i == MaxFeatures {
+			break
+		}
+		feature := geojson.NewFeature(shape.Geom)
+		feature.ID = shape.ID
+		feature.Properties["id"] = shape.ID
+		feature.Properties["name"] = shape.Name
+		feature.Properties["layer_id"] = shape.LayerID
+		fc.Append(feature)
+	}
+	return fc, nil
+}
@@ -0,0 +1,103 @@
+package tiles
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/maptile"
+)
+
+// MaxFeatures caps the features written to a single tile so low zooms stay
+// small; clients should switch to clustered data when zoomed out
+const MaxFeatures = 20000
+
+// tileBuffer is how far beyond the tile edge (as a fraction of the tile)
+// features are fetched, so symbols and outlines do not clip at tile borders
+const tileBuffer = 64.0 / 4096.0
+
+// Filter restricts which features a tile contains
+type Filter struct {
+	Statuses   []string
+	Priorities []string
+}
+
+// key renders the filter canonically for cache keys
+func (f Filter) key() string {
+	statuses := append([]string(nil), f.Statuses...)
+	priorities := append([]string(nil), f.Priorities...)
+	sort.Strings(statuses)
+	sort.Strings(priorities)
+	return strings.Join(statuses, ",") + "|" + strings.Join(priorities, ",")
+}
+
+// source loads one named layer for a bound
+type source interface {
+	// version summarises the rows in the bound so unchanged tiles keep their ETag
+	version(b orb.Bound, f Filter) (count int64, updated time.Time, err error)
+	features(b orb.Bound, f Filter) (*geojson.FeatureCollection, error)
+}
+
+// sources maps the {layer} path segment to its data
+var sources = map[string]source{
+	"constructions": constructionSource{},
+	"reports":       reportSource{},
+	"parcels":       parcelSource{},
+	"wards":         layerSource{kind: "ward"},
+	"zones":         layerSource{kind: "zone"},
+	"river_buffers": layerSource{kind: "river_buffer"},
+	"heritage":      layerSource{kind: "heritage"},
+}
+
+// Known reports whether layer can be served as tiles
+func Known(layer string) bool {
+	_, ok := sources[layer]
+	return ok
+}
+
+// Layers lists the servable layer names
+func Layers() []string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ETag identifies the content of a tile without rendering it
+func ETag(layer string, t maptile.Tile, f Filter) (string, error) {
+	count, updated, err := sources[layer].version(t.Bound(tileBuffer), f)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s/%d/%d/%d|%s|%d|%d", layer, t.Z, t.X, t.Y, f.key(), count, updated.UnixNano())))
+	return `"` + hex.EncodeToString(sum[:10]) + `"`, nil
+}
+
+// tolerance is the Douglas-Peucker tolerance in tile units (4096 per tile);
+// zoomed-out tiles drop more detail
+func tolerance(z maptile.Zoom) float64 {
+	switch {
+	case z < 10:
+		return 8
+	case z < 14:
+		return 4
+	default:
+		return 1
+	}
+}
+
+// Render builds the Mapbox Vector Tile for one layer, gzip compressed
+func Render(layer string, t maptile.Tile, f Filter) ([]byte, error) {
+	fc, err := sources[layer].features(t.Bound(tileBuffer), f)
+	if err != nil {
+		return nil, err
+	}
+	return encodeTile(layer, fc, t)
+}
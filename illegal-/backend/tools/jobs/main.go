@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"backend/jobs"
+	"backend/models"
+	"backend/utils"
+)
+
+const usage = `usage: go run ./tools/jobs <command> [args]
+
+commands:
+  list [-status s] [-type t] [-limit n]   list recent jobs
+  show <id>                               print one job with its last error
+  stats                                   count jobs by type and status
+  requeue <id>                            requeue a dead or finished job
+  requeue-dead [-type t]                  requeue every dead job`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+	utils.ConnectDB()
+
+	cmd, args := os.Args[1], os.Args[2:]
+	switch cmd {
+	case "list":
+		fs := flag.NewFlagSet("list", flag.ExitOnError)
+		status := fs.String("status", "", "only jobs in this status")
+		jobType := fs.String("type", "", "only jobs of this type")
+		limit := fs.Int("limit", 50, "maximum jobs to show")
+		fs.Parse(args)
+
+		q := utils.DB.Order("id desc").Limit(*limit)
+		if *status != "" {
+			q = q.Where("status = ?", *status)
+		}
+		if *jobType != "" {
+			q = q.Where("type = ?", *jobType)
+		}
+		var list []models.Job
+		if err := q.Find(&list).Error; err != nil {
+			log.Fatalf("failed to list jobs: %v", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTYPE\tSTATUS\tATTEMPTS\tRUN AT\tLAST ERROR")
+		for _, j := range list {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%d/%d\t%s\t%s\n", j.ID, j.Type, j.Status,
+				j.Attempts, j.MaxAttempts, j.RunAt.Format(time.RFC3339), firstLine(j.LastError))
+		}
+		tw.Flush()
+
+	case "show":
+		job := load(args)
+		fmt.Printf("Job #%d (%s)\n", job.ID, job.Type)
+		fmt.Printf("  status:   %s\n", job.Status)
+		fmt.Printf("  attempts: %d/%d\n", job.Attempts, job.MaxAttempts)
+		fmt.Printf("  run at:   %s\n", job.RunAt.Format(time.RFC3339))
+		fmt.Printf("  created:  %s\n", job.CreatedAt.Format(time.RFC3339))
+		if job.LockedBy != "" {
+			fmt.Printf("  worker:   %s\n", job.LockedBy)
+		}
+		if job.FinishedAt != nil {
+			fmt.Printf("  finished: %s\n", job.FinishedAt.Format(time.RFC3339))
+		}
+		fmt.Printf("  payload:  %s\n", job.Payload)
+		if job.LastError != "" {
+			fmt.Printf("  last error:\n%s\n", job.LastError)
+		}
+
+	case "stats":
+		stats, err := jobs.Stats()
+		if err != nil {
+			log.Fatalf("failed to count jobs: %v", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "TYPE\tSTATUS\tCOUNT")
+		for _, s := range stats {
+			fmt.Fprintf(tw, "%s\t%s\t%d\n", s.Type, s.Status, s.Count)
+		}
+		tw.Flush()
+
+	case "requeue":
+		job := load(args)
+		if _, err := jobs.Requeue(job.ID); err != nil {
+			log.Fatalf("failed to requeue job #%d: %v", job.ID, err)
+		}
+		fmt.Printf("✅ Requeued job #%d (%s)\n", job.ID, job.Type)
+
+	case "requeue-dead":
+		fs := flag.NewFlagSet("requeue-dead", flag.ExitOnError)
+		jobType := fs.String("type", "", "only dead jobs of this type")
+		fs.Parse(args)
+		n, err := jobs.RequeueDead(*jobType)
+		if err != nil {
+			log.Fatalf("failed to requeue dead jobs: %v", err)
+		}
+		fmt.Printf("✅ Requeued %d dead job(s)\n", n)
+
+	default:
+		fmt.Println(usage)
+		os.Exit(2)
+	}
+}
+
+// load fetches the job whose id is the first argument
+func load(args []string) models.Job {
+	if len(args) < 1 {
+		log.Fatal("job id is required")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		log.Fatalf("invalid job id %q", args[0])
+	}
+	var job models.Job
+	if err := utils.DB.First(&job, id).Error; err != nil {
+		log.Fatalf("job #%d not found", id)
+	}
+	return job
+}
+
+// firstLine shortens multi-line errors such as panic traces for the table
+func firstLine(s string) string {
+	for i, r := range s {
+		if r == '\n' {
+			return s[:i] + " …"
+		}
+	}
+	return s
+}
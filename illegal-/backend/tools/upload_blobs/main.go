@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"backend/storage"
+)
+
+// Copies files written to the local uploads directory by earlier releases
+// into the store configured by STORAGE_BACKEND, keeping their paths as keys
+// so stored /uploads/... references keep resolving.
+//
+// usage: STORAGE_BACKEND=s3 S3_ENDPOINT=... go run ./tools/upload_blobs [dir]
+func main() {
+	dir := "uploads"
+	if len(os.Args) > 1 {
+		dir = os.Args[1]
+	}
+	ctx := context.Background()
+	store, err := storage.FromEnv(ctx)
+	if err != nil {
+		log.Fatalf("failed to configure storage: %v", err)
+	}
+
+	copied, skipped := 0, 0
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if ok, err := store.Exists(ctx, key); err != nil {
+			return err
+		} else if ok {
+			skipped++
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if err := store.Put(ctx, key, f, info.Size(), mime.TypeByExtension(filepath.Ext(key))); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		copied++
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("❌ Copy failed: %v", err)
+	}
+	fmt.Printf("✅ Copied %d file(s), %d already present\n", copied, skipped)
+}
@@ -0,0 +1,418 @@
+// Package uploads receives large files in chunks over several requests, so
+// an interrupted transfer resumes where it stopped instead of starting over.
+// The HTTP side follows the tus 1.0 protocol with the creation, checksum,
+// termination and expiration extensions. A finished upload stays private
+// until a record takes its file over.
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"backend/models"
+	"backend/storage"
+	"backend/utils"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Limits, adjustable at startup. Uploads count against them until they
+// are attached or expire.
+var (
+	MaxLength     int64 = 512 << 20      // largest upload
+	MaxChunk      int64 = 32 << 20       // most stored from one request; clients continue from the returned offset
+	Expiry              = 24 * time.Hour // after the last chunk received
+	MaxPerUser          = 5              // uploads one user may have open
+	MaxUserBytes  int64 = 2 << 30        // declared bytes one user may have open
+	MaxTotalBytes int64 = 20 << 30       // declared bytes open across all users
+)
+
+// Upload statuses
+const (
+	StatusUploading = "uploading"
+	StatusComplete  = "complete"
+)
+
+// Errors returned to clients
+var (
+	ErrNotFound   = errors.New("upload not found")
+	ErrTooLarge   = errors.New("upload is larger than allowed")
+	ErrOffset     = errors.New("offset does not match the bytes received")
+	ErrComplete   = errors.New("upload is already complete")
+	ErrIncomplete = errors.New("upload is not complete")
+	ErrChecksum   = errors.New("chunk checksum does not match")
+	ErrAlgorithm  = errors.New("checksum algorithm is not supported")
+	ErrUserLimit  = errors.New("too many uploads in progress")
+	ErrFull       = errors.New("upload space is full, try again later")
+)
+
+// algorithms are the supported chunk checksums, by their tus names
+var algorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"md5":    md5.New,
+}
+
+// Algorithms lists the supported chunk checksums
+func Algorithms() []string {
+	return []string{"sha1", "sha256", "md5"}
+}
+
+// Checksum is the expected checksum of a chunk
+type Checksum struct {
+	Algorithm string
+	Sum       []byte
+}
+
+// ParseChecksum reads an Upload-Checksum header: the algorithm, a space and
+// the base64 checksum
+func ParseChecksum(header string) (*Checksum, error) {
+	name, value, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok {
+		return nil, errors.New("Upload-Checksum must be an algorithm and a base64 checksum")
+	}
+	if _, ok := algorithms[name]; !ok {
+		return nil, ErrAlgorithm
+	}
+	sum, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("Upload-Checksum must be an algorithm and a base64 checksum")
+	}
+	return &Checksum{Algorithm: name, Sum: sum}, nil
+}
+
+// matches tells whether data has the checksum
+func (c *Checksum) matches(data []byte) bool {
+	h := algorithms[c.Algorithm]()
+	h.Write(data)
+	return subtle.ConstantTimeCompare(h.Sum(nil), c.Sum) == 1
+}
+
+// prefix keeps uploaded files and their parts private until a record
+// takes them over
+const prefix = storage.PrivatePrefix + "uploads"
+
+// Create starts an upload of length bytes for a user, unless it would take
+// them or everyone together past the limits
+func Create(filename, mime string, length int64, createdBy uint) (*models.Upload, error) {
+	if length <= 0 {
+		return nil, errors.New("length must be positive")
+	}
+	if length > MaxLength {
+		return nil, ErrTooLarge
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	u := &models.Upload{
+		ID:        hex.EncodeToString(id),
+		Filename:  filepath.Base(filename),
+		MIME:      mime,
+		Length:    length,
+		Parts:     models.BlobList{},
+		Status:    StatusUploading,
+		CreatedBy: &createdBy,
+		ExpiresAt: time.Now().Add(Expiry),
+	}
+	err := utils.DB.Transaction(func(tx *gorm.DB) error {
+		// uploads are created one at a time, so two requests cannot both
+		// pass the limits with the room left for one
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('uploads.create'))").Error; err != nil {
+			return err
+		}
+		var open struct {
+			Count int64
+			Bytes int64
+		}
+		err := tx.Model(&models.Upload{}).Select("COUNT(*) AS count, COALESCE(SUM(length), 0) AS bytes").
+			Where("created_by = ? AND expires_at > ?", createdBy, time.Now()).Scan(&open).Error
+		if err != nil {
+			return err
+		}
+		if open.Count >= int64(MaxPerUser) || open.Bytes+length > MaxUserBytes {
+			return ErrUserLimit
+		}
+		var total int64
+		err = tx.Model(&models.Upload{}).Select("COALESCE(SUM(length), 0)").
+			Where("expires_at > ?", time.Now()).Scan(&total).Error
+		if err != nil {
+			return err
+		}
+		if total+length > MaxTotalBytes {
+			return ErrFull
+		}
+		return tx.Create(u).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Get returns an upload that has not expired
+func Get(id string) (*models.Upload, error) {
+	var u models.Upload
+	err := utils.DB.Where("id = ? AND expires_at > ?", id, time.Now()).First(&u).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// locked runs fn with the upload row locked, so chunks of one upload are
+// stored one at a time
+func locked(id string, fn func(tx *gorm.DB, u *models.Upload) error) (*models.Upload, error) {
+	var u models.Upload
+	err := utils.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND expires_at > ?", id, time.Now()).First(&u).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		return fn(tx, &u)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// Write stores the bytes read from r at offset, which must be the number of
+// bytes received so far. At most MaxChunk bytes are taken per call, and
+// whatever arrived before a dropped connection is kept. A chunk sent with a
+// checksum is kept only if it arrived whole and matches. The upload is
+// joined once its last byte is stored, or retried by a later call if that
+// failed, and every stored chunk pushes its expiry back.
+func Write(ctx context.Context, id string, offset int64, r io.Reader, sum *Checksum) (*models.Upload, error) {
+	u, err := Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Status == StatusComplete {
+		return nil, ErrComplete
+	}
+	if offset != u.Offset {
+		return nil, ErrOffset
+	}
+
+	// read before taking the lock, so a slow client never holds it
+	data, readErr := io.ReadAll(io.LimitReader(r, min(MaxChunk, u.Length-offset)))
+	if sum != nil && (readErr != nil || !sum.matches(data)) {
+		return nil, ErrChecksum
+	}
+	if len(data) == 0 {
+		if readErr != nil {
+			return nil, readErr
+		}
+		if u.Offset == u.Length {
+			return complete(ctx, u)
+		}
+		return u, nil
+	}
+
+	u, err = locked(id, func(tx *gorm.DB, u *models.Upload) error {
+		if u.Status == StatusComplete {
+			return ErrComplete
+		}
+		if offset != u.Offset {
+			return ErrOffset
+		}
+		key := fmt.Sprintf("%s/parts/%s/%016d", prefix, u.ID, offset)
+		if err := storage.Default.Put(ctx, key, bytes.NewReader(data), int64(len(data)), "application/octet-stream"); err != nil {
+			return err
+		}
+		u.Parts = append(u.Parts, models.BlobRef(key))
+		u.Offset += int64(len(data))
+		u.ExpiresAt = time.Now().Add(Expiry)
+		return tx.Save(u).Error
+	})
+	if err != nil || u.Offset < u.Length {
+		return u, err
+	}
+	return complete(ctx, u)
+}
+
+// complete joins the parts of a fully received upload into one file and
+// marks it complete. The join runs without the row lock, as it may copy the
+// whole upload; should another request finish or terminate the upload
+// meanwhile, its state is returned instead.
+func complete(ctx context.Context, u *models.Upload) (*models.Upload, error) {
+	key, err := join(ctx, u)
+	if err != nil {
+		if done, gerr := Get(u.ID); gerr == nil && done.Status == StatusComplete {
+			return done, nil
+		}
+		return nil, err
+	}
+	res := utils.DB.Model(&models.Upload{}).Where("id = ? AND status = ?", u.ID, StatusUploading).
+		Updates(map[string]interface{}{"key": key, "parts": models.BlobList{}, "status": StatusComplete})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return Get(u.ID)
+	}
+	for _, p := range u.Parts {
+		storage.Default.Delete(ctx, string(p))
+	}
+	u.Key, u.Parts, u.Status = key, models.BlobList{}, StatusComplete
+	return u, nil
+}
+
+// join stores the parts of an upload as one file and returns its key
+func join(ctx context.Context, u *models.Upload) (string, error) {
+	keys := make([]string, len(u.Parts))
+	for i, p := range u.Parts {
+		keys[i] = string(p)
+	}
+	parts := &partsReader{ctx: ctx, keys: keys}
+	defer parts.Close()
+	return storage.Save(ctx, prefix, parts, extension(u.Filename))
+}
+
+var safeExt = regexp.MustCompile(`^\.[a-z0-9]{1,8}$`)
+
+// extension keeps a file name's extension when it is a plain one
+func extension(filename string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if !safeExt.MatchString(ext) {
+		return ""
+	}
+	return ext
+}
+
+// partsReader reads stored parts one after another
+type partsReader struct {
+	ctx  context.Context
+	keys []string
+	cur  io.ReadCloser
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		if p.cur == nil {
+			if len(p.keys) == 0 {
+				return 0, io.EOF
+			}
+			f, err := storage.Default.Get(p.ctx, p.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			p.cur, p.keys = f, p.keys[1:]
+		}
+		n, err := p.cur.Read(b)
+		if err == io.EOF {
+			p.cur.Close()
+			p.cur = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (p *partsReader) Close() error {
+	if p.cur != nil {
+		return p.cur.Close()
+	}
+	return nil
+}
+
+// Completed returns an upload whose file has been fully received, joining
+// it first if that failed when its last chunk arrived
+func Completed(ctx context.Context, id string) (*models.Upload, error) {
+	u, err := Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Status == StatusUploading && u.Offset == u.Length {
+		if u, err = complete(ctx, u); err != nil {
+			return nil, err
+		}
+	}
+	if u.Status != StatusComplete {
+		return nil, ErrIncomplete
+	}
+	return u, nil
+}
+
+// Finish removes completed uploads within tx once a record has taken their
+// files over, so none is attached twice. It returns ErrNotFound, leaving tx
+// to be rolled back, when another request has already taken one of them.
+func Finish(tx *gorm.DB, ids ...string) error {
+	distinct := map[string]bool{}
+	for _, id := range ids {
+		distinct[id] = true
+	}
+	if len(distinct) == 0 {
+		return nil
+	}
+	res := tx.Where("id IN ? AND status = ?", ids, StatusComplete).Delete(&models.Upload{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected < int64(len(distinct)) {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Terminate abandons an upload, deleting the chunks received so far. A
+// joined file is left to the orphaned file cleanup, as a record may already
+// refer to it.
+func Terminate(ctx context.Context, id string) error {
+	_, err := locked(id, func(tx *gorm.DB, u *models.Upload) error {
+		for _, p := range u.Parts {
+			if err := storage.Default.Delete(ctx, string(p)); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(u).Error
+	})
+	return err
+}
+
+// Expire deletes expired uploads and their parts. It returns how many were
+// removed.
+func Expire(ctx context.Context) (int, error) {
+	var expired []models.Upload
+	if err := utils.DB.Where("expires_at <= ?", time.Now()).Find(&expired).Error; err != nil {
+		return 0, err
+	}
+	for _, u := range expired {
+		for _, p := range u.Parts {
+			if err := storage.Default.Delete(ctx, string(p)); err != nil {
+				return 0, err
+			}
+		}
+		if err := utils.DB.Delete(&models.Upload{}, "id = ?", u.ID).Error; err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), nil
+}
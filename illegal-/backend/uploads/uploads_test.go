@@ -0,0 +1,219 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"backend/models"
+	"backend/storage"
+	"backend/utils"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestParseChecksum(t *testing.T) {
+	data := []byte("chunk")
+	sum := sha256.Sum256(data)
+	c, err := ParseChecksum("sha256 " + base64.StdEncoding.EncodeToString(sum[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.matches(data) || c.matches([]byte("chunk!")) {
+		t.Error("checksum matches the wrong data")
+	}
+	if _, err := ParseChecksum("crc32 AAAA"); !errors.Is(err, ErrAlgorithm) {
+		t.Errorf("unknown algorithm: err = %v, want ErrAlgorithm", err)
+	}
+	for _, header := range []string{"", "sha256", "sha256 not-base64!"} {
+		if _, err := ParseChecksum(header); err == nil {
+			t.Errorf("ParseChecksum(%q) accepted", header)
+		}
+	}
+}
+
+func TestExtensionKeepsPlainOnes(t *testing.T) {
+	for name, want := range map[string]string{
+		"photo.JPG":          ".jpg",
+		"clip.mp4":           ".mp4",
+		"noext":              "",
+		"evil.php%00.jpg":    ".jpg",
+		"weird.j p g":        "",
+		"long.abcdefghijklm": "",
+	} {
+		if got := extension(name); got != want {
+			t.Errorf("extension(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+// localStore points storage.Default at a temporary directory for one test
+func localStore(t *testing.T) {
+	prev := storage.Default
+	storage.Default = storage.NewLocal(t.TempDir())
+	t.Cleanup(func() { storage.Default = prev })
+}
+
+func TestPartsReaderJoinsInOrder(t *testing.T) {
+	localStore(t)
+	ctx := context.Background()
+	var keys []string
+	for i, part := range []string{"ab", "", "cde", "f"} {
+		key := prefix + "/parts/test/" + string(rune('0'+i))
+		if err := storage.Default.Put(ctx, key, strings.NewReader(part), int64(len(part)), "application/octet-stream"); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	parts := &partsReader{ctx: ctx, keys: keys}
+	defer parts.Close()
+	got, err := io.ReadAll(parts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "abcdef" {
+		t.Errorf("joined parts = %q, want abcdef", got)
+	}
+}
+
+// testDB connects to TEST_DATABASE_URL, skipping the test when it is unset,
+// and stores files in a temporary directory
+func testDB(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&models.Upload{}); err != nil {
+		t.Fatal(err)
+	}
+	prev := utils.DB
+	utils.DB = db
+	t.Cleanup(func() { utils.DB = prev })
+	localStore(t)
+}
+
+// testUser is a user id no real account has, so limits start from zero
+const testUser = 1<<31 - 1
+
+func create(t *testing.T, length int64) *models.Upload {
+	u, err := Create("file.bin", "application/octet-stream", length, testUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { utils.DB.Delete(&models.Upload{}, "id = ?", u.ID) })
+	return u
+}
+
+func TestWriteFollowsOffset(t *testing.T) {
+	testDB(t)
+	ctx := context.Background()
+	u := create(t, 6)
+
+	got, err := Write(ctx, u.ID, 0, strings.NewReader("abc"), nil)
+	if err != nil || got.Offset != 3 || got.Status != StatusUploading {
+		t.Fatalf("first chunk = %+v, %v; want offset 3", got, err)
+	}
+	if _, err := Write(ctx, u.ID, 0, strings.NewReader("abc"), nil); !errors.Is(err, ErrOffset) {
+		t.Errorf("repeated chunk: err = %v, want ErrOffset", err)
+	}
+	if _, err := Write(ctx, u.ID, 5, strings.NewReader("f"), nil); !errors.Is(err, ErrOffset) {
+		t.Errorf("chunk past the offset: err = %v, want ErrOffset", err)
+	}
+
+	// bytes past the declared length are not taken
+	got, err = Write(ctx, u.ID, 3, strings.NewReader("defghi"), nil)
+	if err != nil || got.Offset != 6 || got.Status != StatusComplete {
+		t.Fatalf("last chunk = %+v, %v; want complete at 6", got, err)
+	}
+	if _, err := Write(ctx, u.ID, 6, strings.NewReader("x"), nil); !errors.Is(err, ErrComplete) {
+		t.Errorf("chunk after completion: err = %v, want ErrComplete", err)
+	}
+
+	done, err := Completed(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := storage.Open(ctx, done.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if data, _ := io.ReadAll(f); string(data) != "abcdef" {
+		t.Errorf("joined file = %q, want abcdef", data)
+	}
+}
+
+// failingReader returns some bytes and then a dropped connection
+type failingReader struct{ data []byte }
+
+func (f *failingReader) Read(b []byte) (int, error) {
+	if len(f.data) == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(b, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+func TestWriteKeepsPartialChunk(t *testing.T) {
+	testDB(t)
+	ctx := context.Background()
+	u := create(t, 10)
+
+	got, err := Write(ctx, u.ID, 0, &failingReader{data: []byte("abcd")}, nil)
+	if err != nil || got.Offset != 4 {
+		t.Fatalf("interrupted chunk = %+v, %v; want offset 4", got, err)
+	}
+
+	sum := sha256.Sum256([]byte("efghij"))
+	checked := &Checksum{Algorithm: "sha256", Sum: sum[:]}
+	if _, err := Write(ctx, u.ID, 4, &failingReader{data: []byte("efg")}, checked); !errors.Is(err, ErrChecksum) {
+		t.Errorf("interrupted checksummed chunk: err = %v, want ErrChecksum", err)
+	}
+	if got, _ := Get(u.ID); got.Offset != 4 {
+		t.Errorf("offset after a failed checksum = %d, want 4", got.Offset)
+	}
+	if got, err := Write(ctx, u.ID, 4, bytes.NewReader([]byte("efghij")), checked); err != nil || got.Status != StatusComplete {
+		t.Errorf("checksummed last chunk = %+v, %v; want complete", got, err)
+	}
+}
+
+func TestCreateLimitsOpenUploads(t *testing.T) {
+	testDB(t)
+	defer func(n int) { MaxPerUser = n }(MaxPerUser)
+	MaxPerUser = 2
+	create(t, 1)
+	create(t, 1)
+	if _, err := Create("file.bin", "", 1, testUser); !errors.Is(err, ErrUserLimit) {
+		t.Errorf("upload past the per-user limit: err = %v, want ErrUserLimit", err)
+	}
+	if _, err := Create("file.bin", "", MaxLength+1, testUser); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("oversized upload: err = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestFinishTakesUploadOnce(t *testing.T) {
+	testDB(t)
+	ctx := context.Background()
+	u := create(t, 1)
+	if _, err := Write(ctx, u.ID, 0, strings.NewReader("a"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Finish(utils.DB, u.ID, u.ID); err != nil {
+		t.Fatalf("first finish: %v", err)
+	}
+	if err := Finish(utils.DB, u.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second finish: err = %v, want ErrNotFound", err)
+	}
+}
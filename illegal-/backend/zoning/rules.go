@@ -0,0 +1,236 @@
+package zoning
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/geo"
+	"backend/models"
+	"backend/utils"
+)
+
+// DefaultSetbacks is the minimum distance in metres a construction must keep
+// from protected features of each kind. A feature's "setback_m" attribute
+// overrides the default.
+var DefaultSetbacks = map[string]float64{
+	geo.KindRiverBuffer: 30,
+	geo.KindHeritage:    100,
+}
+
+// layerLabels are used in violation messages
+var layerLabels = map[string]string{
+	geo.KindZone:        "zone",
+	geo.KindRiverBuffer: "river buffer",
+	geo.KindHeritage:    "heritage zone",
+}
+
+// searchRadius is the radius searched for protected features of a kind
+// around a construction: the default setback, or the largest setback_m of
+// a feature of that kind when one asks for more
+func searchRadius(kind string) (float64, error) {
+	radius := DefaultSetbacks[kind]
+	var rows []models.LayerFeature
+	err := utils.DB.Model(&models.LayerFeature{}).
+		Select("layer_features.id, layer_features.properties").
+		Joins("JOIN layers ON layers.id = layer_features.layer_id").
+		Where("layers.kind = ? AND layer_features.properties::text ILIKE ?", kind, "%setback_m%").
+		Find(&rows).Error
+	if err != nil {
+		return 0, err
+	}
+	for _, row := range rows {
+		if v := setbackFor(kind, geo.Shape{LayerFeature: row}); v > radius {
+			radius = v
+		}
+	}
+	return radius, nil
+}
+
+// setbackFor returns the required setback around a protected feature
+func setbackFor(kind string, s geo.Shape) float64 {
+	if v, err := strconv.ParseFloat(s.Prop("setback_m"), 64); err == nil && v >= 0 {
+		return v
+	}
+	return DefaultSetbacks[kind]
+}
+
+func truthy(v string) bool {
+	switch strings.ToLower(v) {
+	case "1", "true", "yes", "y":
+		return true
+	}
+	return false
+}
+
+// noBuildRule flags constructions inside river buffers, heritage zones or
+// zones marked no_build
+type noBuildRule struct{}
+
+func (noBuildRule) Name() string { return "no_build_zone" }
+
+func (r noBuildRule) Check(ctx *Context) []Violation {
+	var out []Violation
+	c := ctx.Construction
+	for _, s := range ctx.Protected {
+		if geo.Contains(s.Geom, c.Latitude, c.Longitude) {
+			out = append(out, Violation{
+				Rule:           r.Name(),
+				Message:        fmt.Sprintf("Inside %s %q", layerLabels[s.Kind], s.Name),
+				LayerFeatureID: s.ID,
+			})
+		}
+	}
+	for _, s := range ctx.Zones {
+		if truthy(s.Prop("no_build")) {
+			out = append(out, Violation{
+				Rule:           r.Name(),
+				Message:        fmt.Sprintf("Inside no-build zone %q", s.Name),
+				LayerFeatureID: s.ID,
+			})
+		}
+	}
+	return out
+}
+
+// landUseRule checks the parcel's land use against the zone's allowed_uses
+// attribute (a comma separated list)
+type landUseRule struct{}
+
+func (landUseRule) Name() string { return "zone_land_use" }
+
+func (r landUseRule) Check(ctx *Context) []Violation {
+	if ctx.Property == nil || ctx.Property.LandUse == "" {
+		return nil
+	}
+	landUse := strings.ToLower(strings.TrimSpace(ctx.Property.LandUse))
+
+	var out []Violation
+	for _, s := range ctx.Zones {
+		allowed := s.Prop("allowed_uses")
+		if allowed == "" {
+			continue
+		}
+		permitted := false
+		for _, use := range strings.Split(allowed, ",") {
+			if strings.ToLower(strings.TrimSpace(use)) == landUse {
+				permitted = true
+				break
+			}
+		}
+		if !permitted {
+			out = append(out, Violation{
+				Rule:           r.Name(),
+				Message:        fmt.Sprintf("Land use %q is not permitted in zone %q (allowed: %s)", ctx.Property.LandUse, s.Name, allowed),
+				LayerFeatureID: s.ID,
+			})
+		}
+	}
+	return out
+}
+
+// setbackRule flags constructions outside but too close to a protected feature
+type setbackRule struct{}
+
+func (setbackRule) Name() string { return "setback" }
+
+func (r setbackRule) Check(ctx *Context) []Violation {
+	var out []Violation
+	c := ctx.Construction
+	for _, s := range ctx.Protected {
+		if geo.Contains(s.Geom, c.Latitude, c.Longitude) {
+			continue // already reported by noBuildRule
+		}
+		setback := setbackFor(s.Kind, s)
+		if setback <= 0 {
+			continue
+		}
+		if d := geo.DistanceToBoundary(s.Geom, c.Latitude, c.Longitude); d < setback {
+			out = append(out, Violation{
+				Rule:           r.Name(),
+				Message:        fmt.Sprintf("%.0f m from %s %q, setback is %.0f m", d, layerLabels[s.Kind], s.Name, setback),
+				LayerFeatureID: s.ID,
+			})
+		}
+	}
+	return out
+}
+
+// OverbuildTolerance is how much a construction may exceed its permitted
+// footprint area before it is flagged as over-built
+const OverbuildTolerance = 0.10
+
+// PermitsRequiredSince is when permits started being registered. Only
+// constructions recorded since then are flagged for having no permit; nil
+// turns the check off, so constructions that predate the registry are not
+// all made illegal.
+var PermitsRequiredSince *time.Time
+
+// permitRule flags constructions without an active permit and constructions
+// that exceed what their permit allows
+type permitRule struct{}
+
+func (permitRule) Name() string { return "permit" }
+
+func (r permitRule) Check(ctx *Context) []Violation {
+	c := ctx.Construction
+	if ctx.Permit == nil {
+		if !permitRequired(c) {
+			return nil
+		}
+		msg := "No building permit registered for this location"
+		if len(ctx.Permits) > 0 {
+			msg = fmt.Sprintf("No permit was active on %s (latest is %s)", detectedOn(c), ctx.Permits[0].Number)
+		} else if c.PropertyID != 0 {
+			msg = fmt.Sprintf("No building permit registered for parcel #%d", c.PropertyID)
+		}
+		return []Violation{{Rule: "unpermitted", Message: msg}}
+	}
+
+	p := ctx.Permit
+	var out []Violation
+	if p.Floors > 0 && c.Floors > p.Floors {
+		out = append(out, Violation{
+			Rule:    "over_built",
+			Message: fmt.Sprintf("%d floors built, permit %s allows %d", c.Floors, p.Number, p.Floors),
+		})
+	}
+
+	footprint, err := geo.DecodeGeometry(c.Footprint)
+	if err != nil || footprint == nil {
+		return out
+	}
+	if area := geo.Area(footprint); p.FootprintArea > 0 && area > p.FootprintArea*(1+OverbuildTolerance) {
+		out = append(out, Violation{
+			Rule:    "over_built",
+			Message: fmt.Sprintf("Footprint is %.0f m², permit %s allows %.0f m²", area, p.Number, p.FootprintArea),
+		})
+	}
+	if permitted, err := geo.DecodeGeometry(p.Footprint); err == nil && permitted != nil {
+		lat, lng := geo.Centroid(footprint)
+		if !geo.Contains(permitted, lat, lng) {
+			out = append(out, Violation{
+				Rule:    "over_built",
+				Message: fmt.Sprintf("Footprint lies outside the area permitted by %s", p.Number),
+			})
+		}
+	}
+	return out
+}
+
+// permitRequired reports whether the construction was recorded after
+// permits became required; one not saved yet counts as recorded now
+func permitRequired(c models.Construction) bool {
+	if PermitsRequiredSince == nil {
+		return false
+	}
+	return c.CreatedAt.IsZero() || !c.CreatedAt.Before(*PermitsRequiredSince)
+}
+
+func detectedOn(c models.Construction) string {
+	if c.CreatedAt.IsZero() {
+		return time.Now().Format("2006-01-02")
+	}
+	return c.CreatedAt.Format("2006-01-02")
+}
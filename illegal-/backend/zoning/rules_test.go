@@ -0,0 +1,139 @@
+package zoning
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"backend/geo"
+	"backend/models"
+
+	"github.com/paulmach/orb"
+	"gorm.io/datatypes"
+)
+
+// square is a feature about 110 m across with its south-west corner at
+// lat 10, lng 20
+func square(kind, name, props string) geo.Shape {
+	return geo.Shape{
+		LayerFeature: models.LayerFeature{ID: 7, Name: name, Properties: datatypes.JSON(props)},
+		Kind:         kind,
+		Geom:         orb.Polygon{{{20, 10}, {20.001, 10}, {20.001, 10.001}, {20, 10.001}, {20, 10}}},
+	}
+}
+
+// east is a construction the given distance east of the square's east edge
+func east(meters float64) models.Construction {
+	lng := geo.BoundAround(10.0005, 20.001, meters).Max.Lon()
+	return models.Construction{Latitude: 10.0005, Longitude: lng}
+}
+
+var inside = models.Construction{Latitude: 10.0005, Longitude: 20.0005}
+
+// rules returns the rule names of violations
+func rules(violations []Violation) string {
+	names := make([]string, len(violations))
+	for i, v := range violations {
+		names[i] = v.Rule
+	}
+	return strings.Join(names, ",")
+}
+
+func TestNoBuildRule(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ctx  Context
+		want string
+	}{
+		{"inside river buffer", Context{Construction: inside, Protected: []geo.Shape{square(geo.KindRiverBuffer, "Kosi", `{}`)}}, "no_build_zone"},
+		{"outside river buffer", Context{Construction: east(10), Protected: []geo.Shape{square(geo.KindRiverBuffer, "Kosi", `{}`)}}, ""},
+		{"inside no_build zone", Context{Construction: inside, Zones: []geo.Shape{square(geo.KindZone, "Park", `{"no_build": "yes"}`)}}, "no_build_zone"},
+		{"inside ordinary zone", Context{Construction: inside, Zones: []geo.Shape{square(geo.KindZone, "R1", `{"no_build": "no"}`)}}, ""},
+	} {
+		if got := rules(noBuildRule{}.Check(&tc.ctx)); got != tc.want {
+			t.Errorf("%s: violations %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLandUseRule(t *testing.T) {
+	zone := square(geo.KindZone, "R1", `{"allowed_uses": "Residential, mixed"}`)
+	for _, tc := range []struct {
+		name string
+		ctx  Context
+		want string
+	}{
+		{"allowed use", Context{Property: &models.Property{LandUse: "residential"}, Zones: []geo.Shape{zone}}, ""},
+		{"forbidden use", Context{Property: &models.Property{LandUse: "industrial"}, Zones: []geo.Shape{zone}}, "zone_land_use"},
+		{"outside every zone", Context{Property: &models.Property{LandUse: "industrial"}}, ""},
+		{"zone without uses", Context{Property: &models.Property{LandUse: "industrial"}, Zones: []geo.Shape{square(geo.KindZone, "Any", `{}`)}}, ""},
+		{"no parcel", Context{Zones: []geo.Shape{zone}}, ""},
+	} {
+		if got := rules(landUseRule{}.Check(&tc.ctx)); got != tc.want {
+			t.Errorf("%s: violations %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSetbackRule(t *testing.T) {
+	river := square(geo.KindRiverBuffer, "Kosi", `{}`) // default setback 30 m
+	heritage := square(geo.KindHeritage, "Fort", `{"setback_m": 10}`)
+	for _, tc := range []struct {
+		name  string
+		c     models.Construction
+		shape geo.Shape
+		want  string
+	}{
+		{"inside is left to no_build_zone", inside, river, ""},
+		{"just within the setback", east(29), river, "setback"},
+		{"just beyond the setback", east(31), river, ""},
+		{"setback_m overrides the default", east(15), heritage, ""},
+		{"within setback_m", east(9), heritage, "setback"},
+	} {
+		ctx := Context{Construction: tc.c, Protected: []geo.Shape{tc.shape}}
+		if got := rules(setbackRule{}.Check(&ctx)); got != tc.want {
+			t.Errorf("%s: violations %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPermitRule(t *testing.T) {
+	defer func(t *time.Time) { PermitsRequiredSince = t }(PermitsRequiredSince)
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	PermitsRequiredSince = &since
+
+	recent := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	expired := models.Permit{Number: "P-1", Status: "active", ValidFrom: since, ValidUntil: since.AddDate(0, 6, 0)}
+	active := models.Permit{Number: "P-2", Status: "active", Floors: 2, FootprintArea: 1000, ValidFrom: since, ValidUntil: recent.AddDate(1, 0, 0)}
+
+	for _, tc := range []struct {
+		name string
+		ctx  Context
+		want string
+		msg  string // part of the first message
+	}{
+		{"no permit", Context{Construction: models.Construction{CreatedAt: recent}}, "unpermitted", "No building permit registered"},
+		{"no permit on the parcel", Context{Construction: models.Construction{CreatedAt: recent, PropertyID: 4}}, "unpermitted", "parcel #4"},
+		{"only an expired permit", Context{Construction: models.Construction{CreatedAt: recent, PropertyID: 4}, Permits: []models.Permit{expired}}, "unpermitted", "latest is P-1"},
+		{"built before permits were required", Context{Construction: models.Construction{CreatedAt: since.AddDate(0, 0, -1)}}, "", ""},
+		{"within the permit", Context{Construction: models.Construction{CreatedAt: recent, Floors: 2}, Permit: &active}, "", ""},
+		{"more floors than permitted", Context{Construction: models.Construction{CreatedAt: recent, Floors: 3}, Permit: &active}, "over_built", "3 floors built"},
+	} {
+		v := permitRule{}.Check(&tc.ctx)
+		if got := rules(v); got != tc.want {
+			t.Errorf("%s: violations %q, want %q", tc.name, got, tc.want)
+			continue
+		}
+		if tc.msg != "" && !strings.Contains(v[0].Message, tc.msg) {
+			t.Errorf("%s: message %q, want it to mention %q", tc.name, v[0].Message, tc.msg)
+		}
+	}
+
+	if expired.ActiveAt(recent) {
+		t.Error("expired permit counts as active")
+	}
+	PermitsRequiredSince = nil
+	if v := (permitRule{}).Check(&Context{Construction: models.Construction{CreatedAt: recent}}); len(v) != 0 {
+		t.Errorf("without a registry start date: violations %v, want none", v)
+	}
+}
@@ -0,0 +1,293 @@
+package zoning
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"backend/geo"
+	"backend/jobs"
+	"backend/models"
+	"backend/utils"
+
+	"github.com/paulmach/orb"
+	"gorm.io/datatypes"
+	"gorm.io/gorm"
+)
+
+// Construction statuses produced by the engine
+const (
+	StatusLegal   = "legal"
+	StatusIllegal = "illegal"
+)
+
+// Violation is one rule a construction breaks, with a message officers can read
+type Violation struct {
+	Rule           string `json:"rule"`
+	Message        string `json:"message"`
+	LayerFeatureID uint   `json:"layer_feature_id,omitempty"`
+}
+
+// Context holds everything the rules may look at for one construction
+type Context struct {
+	Construction models.Construction
+	Property     *models.Property // nil when the construction has no parcel
+	Zones        []geo.Shape      // zone features containing the point
+	Protected    []geo.Shape      // river buffer and heritage features near the point
+	Permits      []models.Permit  // permits registered for the parcel or covering the point
+	Permit       *models.Permit   // the permit active when the construction was detected
+}
+
+// Rule checks a construction and returns the violations it finds
+type Rule interface {
+	Name() string
+	Check(ctx *Context) []Violation
+}
+
+// Rules are evaluated in order for every construction
+var Rules = []Rule{
+	noBuildRule{},
+	landUseRule{},
+	setbackRule{},
+	permitRule{},
+}
+
+// Register adds a rule to the engine
+func Register(rule Rule) {
+	Rules = append(Rules, rule)
+}
+
+// protectedKinds are the layer kinds nothing may be built in or close to
+var protectedKinds = []string{geo.KindRiverBuffer, geo.KindHeritage}
+
+// evaluator holds what is shared by every construction of one run
+type evaluator struct {
+	radius map[string]float64 // searched around a construction, by protected kind
+}
+
+// newEvaluator reads the search radius of each protected kind once, so a
+// run does not scan the layer features again for every construction
+func newEvaluator() (*evaluator, error) {
+	e := &evaluator{radius: map[string]float64{}}
+	for _, kind := range protectedKinds {
+		radius, err := searchRadius(kind)
+		if err != nil {
+			return nil, err
+		}
+		e.radius[kind] = radius
+	}
+	return e, nil
+}
+
+// buildContext loads the parcel and nearby layer features for a construction
+func (e *evaluator) buildContext(c models.Construction) (*Context, error) {
+	ctx := &Context{Construction: c}
+
+	if c.PropertyID != 0 {
+		var property models.Property
+		if err := utils.DB.First(&property, c.PropertyID).Error; err == nil {
+			ctx.Property = &property
+		}
+	}
+
+	if err := loadPermits(ctx); err != nil {
+		return nil, err
+	}
+
+	if c.Latitude == 0 && c.Longitude == 0 {
+		return ctx, nil
+	}
+
+	zones, err := geo.FeaturesAt(geo.KindZone, c.Latitude, c.Longitude)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Zones = zones
+
+	for _, kind := range protectedKinds {
+		shapes, err := geo.ShapesNear(kind, c.Latitude, c.Longitude, e.radius[kind])
+		if err != nil {
+			return nil, err
+		}
+		ctx.Protected = append(ctx.Protected, shapes...)
+	}
+	return ctx, nil
+}
+
+// loadPermits finds the permits that could authorise the construction: those
+// of its parcel, or when it has no parcel those whose footprint contains it
+func loadPermits(ctx *Context) error {
+	c := ctx.Construction
+	if c.PropertyID != 0 {
+		if err := utils.DB.Where("property_id = ?", c.PropertyID).Order("valid_from desc").Find(&ctx.Permits).Error; err != nil {
+			return err
+		}
+	} else if c.Latitude != 0 || c.Longitude != 0 {
+		var permits []models.Permit
+		q := geo.IntersectsBound(utils.DB.Where("footprint IS NOT NULL"), "permits", orb.Point{c.Longitude, c.Latitude}.Bound())
+		if err := q.Order("valid_from desc").Find(&permits).Error; err != nil {
+			return err
+		}
+		for _, p := range permits {
+			if g, err := geo.DecodeGeometry(p.Footprint); err == nil && geo.Contains(g, c.Latitude, c.Longitude) {
+				ctx.Permits = append(ctx.Permits, p)
+			}
+		}
+	}
+
+	detected := c.CreatedAt
+	if detected.IsZero() {
+		detected = time.Now()
+	}
+	for i := range ctx.Permits {
+		if ctx.Permits[i].ActiveAt(detected) {
+			ctx.Permit = &ctx.Permits[i]
+			break
+		}
+	}
+	return nil
+}
+
+// run builds the context and evaluates every rule
+func (e *evaluator) run(c models.Construction) (*Context, []Violation, error) {
+	ctx, err := e.buildContext(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	violations := []Violation{}
+	for _, rule := range Rules {
+		violations = append(violations, rule.Check(ctx)...)
+	}
+	return ctx, violations, nil
+}
+
+// Evaluate runs every rule against the construction
+func Evaluate(c models.Construction) ([]Violation, error) {
+	e, err := newEvaluator()
+	if err != nil {
+		return nil, err
+	}
+	_, violations, err := e.run(c)
+	return violations, err
+}
+
+// Apply evaluates the construction and stores the result on it. An officer
+// override keeps its status; the violations are still refreshed.
+func Apply(c *models.Construction) error {
+	e, err := newEvaluator()
+	if err != nil {
+		return err
+	}
+	return e.apply(c)
+}
+
+func (e *evaluator) apply(c *models.Construction) error {
+	ctx, violations, err := e.run(*c)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(violations)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	c.Violations = datatypes.JSON(data)
+	c.EvaluatedAt = &now
+	c.PermitID = nil
+	if ctx.Permit != nil {
+		id := ctx.Permit.ID
+		c.PermitID = &id
+	}
+	if !c.StatusOverridden {
+		c.Status = StatusLegal
+		if len(violations) > 0 {
+			c.Status = StatusIllegal
+		}
+	}
+	return nil
+}
+
+// EvaluateProperty re-evaluates the constructions on one parcel, e.g. after
+// its permits changed
+func EvaluateProperty(propertyID uint) (int, error) {
+	return evaluate(utils.DB.Where("property_id = ?", propertyID))
+}
+
+// EvaluateAll re-evaluates and saves every construction, returning how many
+// are illegal afterwards
+func EvaluateAll() (int, error) {
+	return evaluate(utils.DB)
+}
+
+// JobEvaluateAll runs EvaluateAll in the background
+const JobEvaluateAll = "zoning.evaluate_all"
+
+func init() {
+	jobs.Register(JobEvaluateAll, func(ctx context.Context, job *models.Job) error {
+		_, err := EvaluateAll()
+		return err
+	})
+}
+
+func evaluate(q *gorm.DB) (int, error) {
+	var constructions []models.Construction
+	if err := q.Find(&constructions).Error; err != nil {
+		return 0, err
+	}
+	e, err := newEvaluator()
+	if err != nil {
+		return 0, err
+	}
+	illegal := 0
+	for i := range constructions {
+		c := &constructions[i]
+		if err := e.apply(c); err != nil {
+			return illegal, err
+		}
+		if err := utils.DB.Model(c).Select("status", "violations", "evaluated_at", "permit_id").Updates(c).Error; err != nil {
+			return illegal, err
+		}
+		if c.Status == StatusIllegal {
+			illegal++
+		}
+	}
+	return illegal, nil
+}
+
+// SetPermitBound stores the bounding box of a permit's footprint, which
+// finds the permits covering a construction without a parcel
+func SetPermitBound(p *models.Permit) {
+	p.MinLat, p.MinLng, p.MaxLat, p.MaxLng = nil, nil, nil, nil
+	if len(p.Footprint) == 0 || string(p.Footprint) == "null" {
+		return
+	}
+	g, err := geo.DecodeGeometry(p.Footprint)
+	if err != nil || g == nil {
+		return
+	}
+	b := g.Bound()
+	minLat, minLng, maxLat, maxLng := b.Min.Lat(), b.Min.Lon(), b.Max.Lat(), b.Max.Lon()
+	p.MinLat, p.MinLng, p.MaxLat, p.MaxLng = &minLat, &minLng, &maxLat, &maxLng
+}
+
+// BackfillPermitBounds sets the bounding box of permits saved with a
+// footprint before permits had one
+func BackfillPermitBounds(db *gorm.DB) error {
+	var permits []models.Permit
+	if err := db.Where("footprint IS NOT NULL AND min_lat IS NULL").Find(&permits).Error; err != nil {
+		return err
+	}
+	for i := range permits {
+		p := &permits[i]
+		SetPermitBound(p)
+		if p.MinLat == nil {
+			continue
+		}
+		err := db.Model(p).Select("min_lat", "min_lng", "max_lat", "max_lng").Updates(p).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}